@@ -1,38 +1,50 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
 	"encoding/base32"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
-	"flag"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"math"
 	"net/http"
+	"net/http/httptest"
 	"os"
 	"reflect"
+	"regexp"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/go-sql-driver/mysql"
+	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
 	"golang.org/x/crypto/argon2"
 
 	"github.com/very-amused/CSplan-API/core"
+	"github.com/very-amused/CSplan-API/openapi"
+	"github.com/very-amused/CSplan-API/ratelimit"
+	"github.com/very-amused/CSplan-API/routes"
 	"github.com/very-amused/CSplan-API/routes/auth"
+	"github.com/very-amused/CSplan-API/routes/changes"
 	"github.com/very-amused/CSplan-API/routes/crypto"
 	"github.com/very-amused/CSplan-API/routes/profile"
 	"github.com/very-amused/CSplan-API/routes/tags"
 	"github.com/very-amused/CSplan-API/routes/todo"
+	"github.com/very-amused/CSplan-API/routes/trash"
+	"github.com/very-amused/CSplan-API/sql/migrations"
 )
 
 type HTTPHeaders map[string]string
 
-const port = 3000
 const badDataErr = "Data retrieved is not equal to data expected"
 
 // Helper function for managing base64 encoding
@@ -41,19 +53,15 @@ func encode(s string) *string {
 	return &encoded
 }
 
-// Flags
-var leaveData = flag.Bool("leave-data", false, "If true, tests will exit without removing any data from the test user from the DB. This is helpful to perform manual inspection of this data.")
+// If set, each test is run against its own freshly migrated MariaDB database (created using this DSN) instead of an in-memory store
+var mariadbDSN = os.Getenv("CSPLAN_TEST_MARIADB_DSN")
 
 var (
-	client  *http.Client
-	session = auth.Session{}
-	user    = auth.User{
-		Email: "user@test.com"}
 	password = []byte("correcthorsebatterystaple")
 
-	// Argon2 variables
-	timeCost    uint32 = 10
-	memCost     uint32 = 1024 * 128
+	// Argon2 variables (the minimum allowed costs, so that logging in doesn't dominate test time)
+	timeCost    uint32 = 1
+	memCost     uint32 = 1024
 	parallelism uint8  = 1
 	saltLen     uint8  = 16
 
@@ -119,9 +127,142 @@ var (
 		CryptoKey: encode("New Key")}
 )
 
-func DoRequest(
+// testAPI - An isolated in-process instance of the API, with its own store and a logged in user
+type testAPI struct {
+	t       *testing.T
+	server  *httptest.Server
+	store   core.Store
+	user    auth.User
+	session auth.Session
+}
+
+// newTestAPI - Start an API instance for t, then register and log in a user through the challenge auth flow
+// The instance is shut down (and its database dropped) once t completes
+func newTestAPI(t *testing.T) *testAPI {
+	t.Helper()
+	return newLimitedTestAPI(t, ratelimit.NewMemory())
+}
+
+// newLimitedTestAPI - Start an API instance for t using limiter, or without rate limiting if limiter is nil
+func newLimitedTestAPI(t *testing.T, limiter ratelimit.Limiter) *testAPI {
+	t.Helper()
+	api := &testAPI{
+		t:     t,
+		store: newTestStore(t),
+		user: auth.User{
+			Email:      "user@test.com",
+			HashParams: &hashParams}}
+	r := mux.NewRouter()
+	loadMiddleware(r, api.store, limiter)
+	loadRoutes(r)
+	api.server = httptest.NewServer(r)
+	t.Cleanup(api.server.Close)
+
+	// Register and log in
+	salt := make([]byte, saltLen)
+	rand.Read(salt)
+	authKey := argon2.Key(password, salt, timeCost, memCost, parallelism, 32)
+	api.user.AuthKey = base64.StdEncoding.EncodeToString(append(salt, authKey...))
+	if _, err := api.DoRequest("POST", "/register", api.user, nil, 201); err != nil {
+		t.Fatalf("Failed to create test account: %s", err)
+	}
+	if err := api.login(); err != nil {
+		t.Fatalf("Failed to log in to test account: %s", err)
+	}
+	return api
+}
+
+// newTestStore - Return an in-memory store, or a store backed by a new MariaDB database if CSPLAN_TEST_MARIADB_DSN is set
+func newTestStore(t *testing.T) core.Store {
+	t.Helper()
+	if len(mariadbDSN) == 0 {
+		return core.NewMemoryStore()
+	}
+
+	cfg, err := mysql.ParseDSN(mariadbDSN)
+	if err != nil {
+		t.Fatalf("Invalid CSPLAN_TEST_MARIADB_DSN: %s", err)
+	}
+	server, err := sqlx.Connect("mysql", cfg.FormatDSN())
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := make([]byte, 6)
+	rand.Read(id)
+	cfg.DBName = "CSplanTest_" + hex.EncodeToString(id)
+	if _, err = server.Exec("CREATE DATABASE " + cfg.DBName); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		server.Exec("DROP DATABASE " + cfg.DBName)
+		server.Close()
+	})
+
+	db, err := sqlx.Connect("mysql", cfg.FormatDSN())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+	})
+	db.MapperFunc(func(s string) string {
+		return s
+	})
+	if _, err = migrations.Up(db); err != nil {
+		t.Fatal(err)
+	}
+	return core.NewMariaDB(db)
+}
+
+// solveChallenge - Decrypt a challenge using a key derived from password, setting its data to the decrypted result
+func solveChallenge(challenge *auth.Challenge, password []byte) {
+	ivAndEncryptedData, _ := base64.StdEncoding.DecodeString(challenge.EncodedData)
+	// Recreate the key derivation using the params sent by the API
+	salt, _ := base64.StdEncoding.DecodeString(challenge.Salt)
+	key := argon2.Key(password, salt, *challenge.HashParams.TimeCost, *challenge.HashParams.MemoryCost, *challenge.HashParams.Threads, 32)
+	iv := ivAndEncryptedData[0:16]
+
+	// Decrypt the challenge data
+	encrypted := ivAndEncryptedData[16:]
+	block, _ := aes.NewCipher(key)
+	ctr := cipher.NewCTR(block, iv)
+	decrypted := make([]byte, len(encrypted))
+	ctr.XORKeyStream(decrypted, encrypted)
+	challenge.EncodedData = base64.StdEncoding.EncodeToString(decrypted)
+}
+
+// challengeRequest - Build a request for a challenge for the test user
+func (api *testAPI) challengeRequest() auth.ChallengeRequest {
+	return auth.ChallengeRequest{
+		Email:    api.user.Email,
+		TOTPCode: api.user.TOTPCode}
+}
+
+// login - Request and solve an authentication challenge, storing the resulting session
+func (api *testAPI) login() error {
+	r, err := api.DoRequest("POST", "/challenge?action=request", api.challengeRequest(), nil, 201)
+	if err != nil {
+		return err
+	}
+	var challenge auth.Challenge
+	json.NewDecoder(r.Body).Decode(&challenge)
+	solveChallenge(&challenge, password)
+
+	r, err = api.DoRequest("POST", "/challenge/"+challenge.EncodedID+"?action=submit", challenge, nil, 200)
+	if err != nil {
+		return err
+	}
+	// Store auth tokens
+	cookieHeader := r.Header.Get("Set-Cookie")
+	cookie := strings.Split(cookieHeader, ";")[0]
+	api.session.Token = strings.Split(cookie, "=")[1]
+	return json.NewDecoder(r.Body).Decode(&api.session)
+}
+
+// DoRequest - Make a request to path as the logged in user, returning an error if the response status isn't expectedStatus
+func (api *testAPI) DoRequest(
 	method string,
-	url string,
+	path string,
 	body interface{},
 	headers HTTPHeaders,
 	expectedStatus int) (r *http.Response, e error) {
@@ -132,14 +273,13 @@ func DoRequest(
 		if err != nil {
 			return nil, err
 		} else if strings.Contains(string(marshalled), "null") {
-			fmt.Println(url)
-			fmt.Println("Null value in marshal found:\n", string(marshalled))
+			api.t.Logf("Null value in marshal found for %s:\n%s", path, string(marshalled))
 		}
 		buffer.Write(marshalled)
 	}
 
 	// Create request
-	req, err := http.NewRequest(method, url, &buffer)
+	req, err := http.NewRequest(method, api.server.URL+path, &buffer)
 	if err != nil {
 		return nil, err
 	}
@@ -148,96 +288,53 @@ func DoRequest(
 	if body != nil {
 		req.Header.Set("Content-Type", "application/json")
 	}
-	if len(session.Token) > 0 {
+	if len(api.session.Token) > 0 {
 		var cookie strings.Builder
 		cookie.WriteString("Authorization=")
-		cookie.WriteString(session.Token)
+		cookie.WriteString(api.session.Token)
 		req.Header.Set("Cookie", cookie.String())
-		req.Header.Set("CSRF-Token", session.CSRFtoken)
+		req.Header.Set("CSRF-Token", api.session.CSRFtoken)
 	}
 	for header, value := range headers {
 		req.Header.Set(header, value)
 	}
 
 	// Do the request
-	r, e = client.Do(req)
+	r, e = api.server.Client().Do(req)
 	if e == nil && r.StatusCode != expectedStatus {
 		var httpErr core.HTTPError
-		json.NewDecoder(r.Body).Decode(&httpErr)
+		// Read raw response body
+		raw, _ := ioutil.ReadAll(r.Body)
+		json.Unmarshal(raw, &httpErr)
 		// Format an error based on status if no response message is given
 		if len(httpErr.Message) == 0 {
 			httpErr.Status = r.StatusCode
-			// Read raw response body
-			body, _ := ioutil.ReadAll(r.Body)
-			httpErr.Message = fmt.Sprintf("Expected status %d, received status %d\n%s", expectedStatus, httpErr.Status, string(body))
+			httpErr.Message = fmt.Sprintf("Expected status %d, received status %d\n%s", expectedStatus, httpErr.Status, string(raw))
 		}
 		e = httpErr
 	}
 	return r, e
 }
 
-func route(path string) string {
-	return fmt.Sprintf("http://localhost:%d%s", port, path)
-}
-
 func TestMain(m *testing.M) {
-	client = &http.Client{}
-
-	// Create test account
-	authKey := make([]byte, 32)
-	rand.Read(authKey)
-	user.AuthKey = base64.StdEncoding.EncodeToString(authKey)
-	user.HashParams = &hashParams
-	r, err := DoRequest("POST", route("/register"), user, nil, 201)
-	if err != nil {
-		log.Fatalf("Failed to create test account: %s", err)
-	}
-
-	// Login to test account
-	r, err = DoRequest("POST", route("/login"), user, nil, 200)
-	if err != nil {
-		log.Fatalf("Failed to login to test account: %s", err)
-	}
-	// Store auth tokens
-	cookieHeader := r.Header.Get("Set-Cookie")
-	cookie := strings.Split(cookieHeader, ";")[0]
-	session.Token = strings.Split(cookie, "=")[1]
-	json.NewDecoder(r.Body).Decode(&session)
-
-	// Run tests
-	exit := m.Run()
-
-	// If side effects are requested, exit without deleting any data
-	if *leaveData == true {
-		return
-	}
-	// Delete test account
-	r, err = DoRequest("DELETE", route("/delete_my_account_please"), nil, nil, 200)
-	if err != nil {
-		log.Fatalf("Failed to delete test account: %s", err)
-	}
-	// Repeat the request with confirmation token
-	var dt auth.DeleteToken
-	json.NewDecoder(r.Body).Decode(&dt)
-	r, err = DoRequest("DELETE", route("/delete_my_account_please"), nil, HTTPHeaders{
-		"X-Confirm": dt.Token}, 200)
-	if err != nil {
-		log.Fatalf("Failed to delete test account: %s", err)
-	}
-
-	os.Exit(exit)
+	// Access logs would drown out test output
+	log.SetOutput(ioutil.Discard)
+	routes.LoadRoutes()
+	os.Exit(m.Run())
 }
 
 func TestKeys(t *testing.T) {
+	t.Parallel()
+	api := newTestAPI(t)
 	var rBody crypto.Keys
 	t.Run("Create Keypair", func(t *testing.T) {
-		_, err := DoRequest("POST", route("/keys"), keys, nil, 201)
+		_, err := api.DoRequest("POST", "/keys", keys, nil, 201)
 		if err != nil {
 			t.Fatal(err)
 		}
 	})
 	t.Run("Get Keypair", func(t *testing.T) {
-		r, err := DoRequest("GET", route("/keys"), nil, nil, 200)
+		r, err := api.DoRequest("GET", "/keys", nil, nil, 200)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -248,10 +345,15 @@ func TestKeys(t *testing.T) {
 	})
 	t.Run("Incomplete Patch", func(t *testing.T) {
 		// Can't update private key without also updating public key or hash params
-		_, err := DoRequest("PATCH", route("/keys"), crypto.KeysPatch{
+		r, err := api.DoRequest("PATCH", "/keys", crypto.KeysPatch{
 			PrivateKey: &keys.PrivateKey}, nil, 412)
 		if err != nil {
-			t.Error(err)
+			t.Fatal(err)
+		}
+		var problem core.HTTPError
+		json.NewDecoder(r.Body).Decode(&problem)
+		if problem.Code != core.CodeIncompletePatch {
+			t.Errorf("Expected error code %s, got '%s'", core.CodeIncompletePatch, problem.Code)
 		}
 	})
 	t.Run("Invalid Hash Parameters", func(t *testing.T) {
@@ -263,7 +365,7 @@ func TestKeys(t *testing.T) {
 			Threads:    new(uint8)}
 		*newHashParams.TimeCost = 15
 		*newHashParams.Threads = 2
-		_, err := DoRequest("PATCH", route("/keys"), crypto.KeysPatch{
+		_, err := api.DoRequest("PATCH", "/keys", crypto.KeysPatch{
 			HashParams: &newHashParams,
 			HashSalt:   encode("new salt"),
 			PrivateKey: &keys.PrivateKey}, nil, 400)
@@ -274,7 +376,7 @@ func TestKeys(t *testing.T) {
 	t.Run("Update Keypair", func(t *testing.T) {
 		keys.PublicKey = *encode("new public key")
 		keys.PrivateKey = *encode("new private key")
-		_, err := DoRequest("PATCH", route("/keys"), crypto.KeysPatch{
+		_, err := api.DoRequest("PATCH", "/keys", crypto.KeysPatch{
 			PublicKey:  &keys.PublicKey,
 			PrivateKey: &keys.PrivateKey}, nil, 200)
 		if err != nil {
@@ -282,7 +384,7 @@ func TestKeys(t *testing.T) {
 		}
 	})
 	t.Run("Updates Correctly Applied", func(t *testing.T) {
-		r, err := DoRequest("GET", route("/keys"), nil, nil, 200)
+		r, err := api.DoRequest("GET", "/keys", nil, nil, 200)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -294,10 +396,11 @@ func TestKeys(t *testing.T) {
 }
 
 func TestChallengeAuth(t *testing.T) {
+	t.Parallel()
+	api := newTestAPI(t)
 	var authKey []byte
 	var challenge auth.Challenge
 	var encoded string
-	var ivAndEncryptedData []byte
 	t.Run("Argon2", func(t *testing.T) {
 		salt := make([]byte, 16)
 		rand.Read(salt)
@@ -305,49 +408,49 @@ func TestChallengeAuth(t *testing.T) {
 		encoded = base64.StdEncoding.EncodeToString(append(salt, authKey...))
 	})
 	t.Run("Update AuthKey", func(t *testing.T) {
-		_, err := DoRequest("PUT", route("/authkey"), auth.KeyPatch{
+		_, err := api.DoRequest("PUT", "/authkey", auth.KeyPatch{
 			Key:        encoded,
-			HashParams: user.HashParams}, nil, 200)
+			HashParams: api.user.HashParams}, nil, 200)
 		if err != nil {
 			t.Fatal(err)
 		}
 	})
-	t.Run("Request Auth Challenge", func(t *testing.T) {
-		r, err := DoRequest("POST", route("/challenge?action=request"), user, nil, 201)
+	t.Run("Wrong Password Rejected", func(t *testing.T) {
+		r, err := api.DoRequest("POST", "/challenge?action=request", api.challengeRequest(), nil, 201)
+		if err != nil {
+			t.Fatal(err)
+		}
+		json.NewDecoder(r.Body).Decode(&challenge)
+		solveChallenge(&challenge, []byte("incorrect password"))
+		_, err = api.DoRequest("POST", "/challenge/"+challenge.EncodedID+"?action=submit", challenge, nil, 401)
 		if err != nil {
 			t.Error(err)
 		}
+	})
+	t.Run("Request Auth Challenge", func(t *testing.T) {
+		r, err := api.DoRequest("POST", "/challenge?action=request", api.challengeRequest(), nil, 201)
+		if err != nil {
+			t.Fatal(err)
+		}
 		json.NewDecoder(r.Body).Decode(&challenge)
-		ivAndEncryptedData, _ = base64.StdEncoding.DecodeString(challenge.EncodedData)
 	})
 	t.Run("Decrypt Challenge Data", func(t *testing.T) {
-		// Recreate the key derivation using the params sent by the API
-		salt, _ := base64.StdEncoding.DecodeString(challenge.Salt)
-		newKey := argon2.Key(password, salt, timeCost, memCost, parallelism, 32)
-		iv := ivAndEncryptedData[0:16]
-
-		// Decrypt the challenge data
-		encrypted := ivAndEncryptedData[16:]
-		block, _ := aes.NewCipher(newKey)
-		ctr := cipher.NewCTR(block, iv)
-		decrypted := make([]byte, len(encrypted))
-		ctr.XORKeyStream(decrypted, encrypted)
-		challenge.EncodedData = base64.StdEncoding.EncodeToString(decrypted)
+		solveChallenge(&challenge, password)
 	})
 	t.Run("Submit Challenge", func(t *testing.T) {
-		r, err := DoRequest("POST", route("/challenge/"+challenge.EncodedID+"?action=submit"), challenge, nil, 200)
+		_, err := api.DoRequest("POST", "/challenge/"+challenge.EncodedID+"?action=submit", challenge, nil, 200)
 		if err != nil {
 			t.Fatal(err)
 		}
-		var session auth.Session
-		json.NewDecoder(r.Body).Decode(&session)
 	})
 }
 
 func TestTOTP(t *testing.T) {
+	t.Parallel()
+	api := newTestAPI(t)
 	var totp auth.TOTPInfo
 	t.Run("Enable TOTP", func(t *testing.T) {
-		r, err := DoRequest("POST", route("/totp?action=enable"), nil, nil, 201)
+		r, err := api.DoRequest("POST", "/totp?action=enable", nil, nil, 201)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -356,29 +459,29 @@ func TestTOTP(t *testing.T) {
 	})
 	t.Run("TOTP Enforced", func(t *testing.T) {
 		// TOTP codes should be required
-		_, err := DoRequest("POST", route("/challenge?action=request"), user, nil, 412)
+		_, err := api.DoRequest("POST", "/challenge?action=request", api.challengeRequest(), nil, 412)
 		if err != nil {
 			t.Error(err)
 		}
 
 		// Run TOTP with an incorrect counter to yield an incorrect code, should be unauthorized to request challenges
 		counter := uint64(math.Floor(float64(time.Now().Unix()) / 30))
-		user.TOTPCode = new(uint64)
-		*user.TOTPCode = uint64(auth.RunTOTP(totp.Secret, counter-2))
-		_, err = DoRequest("POST", route("/challenge?action=request"), user, nil, 401)
+		api.user.TOTPCode = new(uint64)
+		*api.user.TOTPCode = uint64(auth.RunTOTP(totp.Secret, counter-2))
+		_, err = api.DoRequest("POST", "/challenge?action=request", api.challengeRequest(), nil, 401)
 		if err != nil {
 			t.Error(err)
 		}
 	})
 	t.Run("Authenticate with backup code", func(t *testing.T) {
-		*user.TOTPCode = totp.BackupCodes[0]
-		_, err := DoRequest("POST", route("/challenge?action=request"), user, nil, 201)
+		*api.user.TOTPCode = totp.BackupCodes[0]
+		_, err := api.DoRequest("POST", "/challenge?action=request", api.challengeRequest(), nil, 201)
 		if err != nil {
 			t.Fatal(err)
 		}
 	})
 	t.Run("Backup code invalidated", func(t *testing.T) {
-		_, err := DoRequest("POST", route("/challenge?action=request"), user, nil, 401)
+		_, err := api.DoRequest("POST", "/challenge?action=request", api.challengeRequest(), nil, 401)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -387,14 +490,14 @@ func TestTOTP(t *testing.T) {
 		now := time.Now().Unix()
 		counter := uint64(math.Floor(float64(now) / float64(30)))
 		code := auth.RunTOTP(totp.Secret, counter)
-		*user.TOTPCode = uint64(code)
-		_, err := DoRequest("POST", route("/challenge?action=request"), user, nil, 201)
+		*api.user.TOTPCode = uint64(code)
+		_, err := api.DoRequest("POST", "/challenge?action=request", api.challengeRequest(), nil, 201)
 		if err != nil {
 			t.Fatal(err)
 		}
 	})
 	t.Run("Disable TOTP", func(t *testing.T) {
-		_, err := DoRequest("POST", route("/totp?action=disable"), nil, nil, 204)
+		_, err := api.DoRequest("POST", "/totp?action=disable", nil, nil, 204)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -402,9 +505,11 @@ func TestTOTP(t *testing.T) {
 }
 
 func TestName(t *testing.T) {
+	t.Parallel()
+	api := newTestAPI(t)
 	var rBody profile.Name
 	t.Run("Create Name", func(t *testing.T) {
-		r, err := DoRequest("POST", route("/name"), name, nil, 201)
+		r, err := api.DoRequest("POST", "/name", name, nil, 201)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -413,7 +518,7 @@ func TestName(t *testing.T) {
 		name.Meta.Checksum = rBody.Meta.Checksum
 	})
 	t.Run("Get Name", func(t *testing.T) {
-		r, err := DoRequest("GET", route("/name"), nil, nil, 200)
+		r, err := api.DoRequest("GET", "/name", nil, nil, 200)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -423,14 +528,14 @@ func TestName(t *testing.T) {
 		}
 	})
 	t.Run("Update Name", func(t *testing.T) {
-		_, err := DoRequest("PATCH", route("/name"), namePatch, nil, 200)
+		_, err := api.DoRequest("PATCH", "/name", namePatch, nil, 200)
 		if err != nil {
 			t.Fatal(err)
 		}
 		name.Username = namePatch.Username
 	})
 	t.Run("Updates Correctly Applied", func(t *testing.T) {
-		r, err := DoRequest("GET", route("/name"), nil, nil, 200)
+		r, err := api.DoRequest("GET", "/name", nil, nil, 200)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -440,20 +545,35 @@ func TestName(t *testing.T) {
 		}
 	})
 	t.Run("Delete Name", func(t *testing.T) {
-		if *leaveData {
-			return
-		}
-		_, err := DoRequest("DELETE", route("/name"), nil, nil, 204)
+		_, err := api.DoRequest("DELETE", "/name", nil, nil, 204)
 		if err != nil {
 			t.Fatal(err)
 		}
 	})
 }
 
+// withItemIDs - Return sent items along with the IDs and meta assigned to them when they were stored
+func withItemIDs(t *testing.T, sent, stored []todo.Item) []todo.Item {
+	if len(stored) != len(sent) {
+		t.Fatalf("Expected %d items, got %d", len(sent), len(stored))
+	}
+	items := make([]todo.Item, len(sent))
+	for i, item := range stored {
+		if len(item.EncodedID) == 0 || item.Meta == nil || len(item.Meta.Checksum) == 0 {
+			t.Fatalf("Expected item %d to be assigned an ID and checksum", i)
+		}
+		items[i] = sent[i]
+		items[i].EncodedID, items[i].Meta = item.EncodedID, item.Meta
+	}
+	return items
+}
+
 func TestTodo(t *testing.T) {
+	t.Parallel()
+	api := newTestAPI(t)
 	var rBody todo.List
 	t.Run("Create Todo List", func(t *testing.T) {
-		r, err := DoRequest("POST", route("/todos"), list, nil, 201)
+		r, err := api.DoRequest("POST", "/todos", list, nil, 201)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -463,24 +583,25 @@ func TestTodo(t *testing.T) {
 		list.EncodedID = rBody.EncodedID
 	})
 	t.Run("Get Todo List", func(t *testing.T) {
-		r, err := DoRequest("GET", route("/todos/"+list.EncodedID), nil, nil, 200)
+		r, err := api.DoRequest("GET", "/todos/"+list.EncodedID, nil, nil, 200)
 		if err != nil {
 			t.Fatal(err)
 		}
 		json.NewDecoder(r.Body).Decode(&rBody)
+		list.Items = withItemIDs(t, list.Items, rBody.Items)
 		if !reflect.DeepEqual(rBody, list) {
 			t.Error(badDataErr)
 		}
 	})
 	t.Run("Update Todo List", func(t *testing.T) {
-		_, err := DoRequest("PATCH", route("/todos/"+list.EncodedID), listPatch, nil, 200)
+		_, err := api.DoRequest("PATCH", "/todos/"+list.EncodedID, listPatch, nil, 200)
 		if err != nil {
 			t.Fatal(err)
 		}
 		list.Title = *listPatch.Title
 	})
 	t.Run("Updates Correctly Applied", func(t *testing.T) {
-		r, err := DoRequest("GET", route("/todos/"+list.EncodedID), nil, nil, 200)
+		r, err := api.DoRequest("GET", "/todos/"+list.EncodedID, nil, nil, 200)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -490,10 +611,7 @@ func TestTodo(t *testing.T) {
 		}
 	})
 	t.Run("Delete Todo List", func(t *testing.T) {
-		if *leaveData {
-			return
-		}
-		_, err := DoRequest("DELETE", route("/todos/"+list.EncodedID), nil, nil, 204)
+		_, err := api.DoRequest("DELETE", "/todos/"+list.EncodedID, nil, nil, 204)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -501,9 +619,11 @@ func TestTodo(t *testing.T) {
 }
 
 func TestTags(t *testing.T) {
+	t.Parallel()
+	api := newTestAPI(t)
 	var rBody tags.Tag
 	t.Run("Create Tag", func(t *testing.T) {
-		r, err := DoRequest("POST", route("/tags"), tag, nil, 201)
+		r, err := api.DoRequest("POST", "/tags", tag, nil, 201)
 		if err != nil {
 			t.Error(err)
 		}
@@ -511,8 +631,27 @@ func TestTags(t *testing.T) {
 		tag.Meta.Checksum = rBody.Meta.Checksum
 		tag.EncodedID = rBody.EncodedID
 	})
+	t.Run("Invalid Tag", func(t *testing.T) {
+		r, err := api.DoRequest("POST", "/tags", tags.Tag{
+			Name:  "not base64",
+			Color: tag.Color}, nil, 400)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if contentType := r.Header.Get("Content-Type"); contentType != "application/problem+json" {
+			t.Errorf("Expected content type application/problem+json, got %s", contentType)
+		}
+		var problem core.HTTPError
+		json.NewDecoder(r.Body).Decode(&problem)
+		expected := []core.FieldError{
+			{Field: "name", Rule: "base64", Message: "must be base64 encoded"},
+			{Field: "meta.cryptoKey", Rule: "required", Message: "is required"}}
+		if problem.Code != core.CodeValidation || !reflect.DeepEqual(problem.Errors, expected) {
+			t.Errorf("Expected a validation error with field errors %+v, got %+v", expected, problem)
+		}
+	})
 	t.Run("Get Tag", func(t *testing.T) {
-		r, err := DoRequest("GET", route("/tags/"+tag.EncodedID), nil, nil, 200)
+		r, err := api.DoRequest("GET", "/tags/"+tag.EncodedID, nil, nil, 200)
 		if err != nil {
 			t.Error(err)
 		}
@@ -522,7 +661,7 @@ func TestTags(t *testing.T) {
 		}
 	})
 	t.Run("Update Tag", func(t *testing.T) {
-		r, err := DoRequest("PATCH", route("/tags/"+tag.EncodedID), tagPatch, nil, 200)
+		r, err := api.DoRequest("PATCH", "/tags/"+tag.EncodedID, tagPatch, nil, 200)
 		if err != nil {
 			t.Error(err)
 		}
@@ -532,7 +671,7 @@ func TestTags(t *testing.T) {
 		tag.Meta.Checksum = rBody.Meta.Checksum
 	})
 	t.Run("Updates Correctly Applied", func(t *testing.T) {
-		r, err := DoRequest("GET", route("/tags/"+tag.EncodedID), nil, nil, 200)
+		r, err := api.DoRequest("GET", "/tags/"+tag.EncodedID, nil, nil, 200)
 		if err != nil {
 			t.Error(err)
 		}
@@ -542,10 +681,7 @@ func TestTags(t *testing.T) {
 		}
 	})
 	t.Run("Delete Tag", func(t *testing.T) {
-		if *leaveData {
-			return
-		}
-		_, err := DoRequest("DELETE", route("/tags/"+tag.EncodedID), nil, nil, 204)
+		_, err := api.DoRequest("DELETE", "/tags/"+tag.EncodedID, nil, nil, 204)
 		if err != nil {
 			t.Error(err)
 		}
@@ -553,9 +689,11 @@ func TestTags(t *testing.T) {
 }
 
 func TestNoList(t *testing.T) {
+	t.Parallel()
+	api := newTestAPI(t)
 	var rBody todo.NoList
 	t.Run("Create NoList", func(t *testing.T) {
-		r, err := DoRequest("POST", route("/nolist"), nolist, nil, 201)
+		r, err := api.DoRequest("POST", "/nolist", nolist, nil, 201)
 		if err != nil {
 			t.Error(err)
 		}
@@ -563,17 +701,18 @@ func TestNoList(t *testing.T) {
 		nolist.Meta.Checksum = rBody.Meta.Checksum
 	})
 	t.Run("Get NoList", func(t *testing.T) {
-		r, err := DoRequest("GET", route("/nolist"), nil, nil, 200)
+		r, err := api.DoRequest("GET", "/nolist", nil, nil, 200)
 		if err != nil {
 			t.Error(err)
 		}
 		json.NewDecoder(r.Body).Decode(&rBody)
+		nolist.Items = withItemIDs(t, nolist.Items, rBody.Items)
 		if !reflect.DeepEqual(rBody, nolist) {
 			t.Error(badDataErr)
 		}
 	})
 	t.Run("Update Items", func(t *testing.T) {
-		_, err := DoRequest("PATCH", route("/nolist"), todo.NoListPatch{
+		_, err := api.DoRequest("PATCH", "/nolist", todo.NoListPatch{
 			Items: &nolistItemPatch}, nil, 200)
 		if err != nil {
 			t.Error(err)
@@ -582,7 +721,7 @@ func TestNoList(t *testing.T) {
 		}
 	})
 	t.Run("Update CryptoKey", func(t *testing.T) {
-		_, err := DoRequest("PATCH", route("/nolist"), todo.NoListPatch{
+		_, err := api.DoRequest("PATCH", "/nolist", todo.NoListPatch{
 			Meta: &nolistMetaPatch}, nil, 200)
 		if err != nil {
 			t.Error(err)
@@ -592,36 +731,302 @@ func TestNoList(t *testing.T) {
 		}
 	})
 	t.Run("Updates Correctly Applied", func(t *testing.T) {
-		r, err := DoRequest("GET", route("/nolist"), nil, nil, 200)
+		r, err := api.DoRequest("GET", "/nolist", nil, nil, 200)
 		if err != nil {
 			t.Error(err)
 		}
+		rBody = todo.NoList{}
 		json.NewDecoder(r.Body).Decode(&rBody)
 		nolist.Meta.Checksum = rBody.Meta.Checksum
+		nolist.Items = withItemIDs(t, nolist.Items, rBody.Items)
 		if !reflect.DeepEqual(rBody, nolist) {
 			t.Error(badDataErr)
 		}
 	})
 }
 
+func TestItems(t *testing.T) {
+	t.Parallel()
+	api := newTestAPI(t)
+	item := func(title string) todo.Item {
+		return todo.Item{
+			Title:       *encode(title),
+			Description: *encode("Description"),
+			Done:        *encode("false"),
+			Tags:        make([]string, 0)}
+	}
+	r, err := api.DoRequest("POST", "/todos", todo.List{
+		Title: *encode("List"),
+		Items: []todo.Item{item("First")},
+		Meta: core.IndexedMeta{
+			CryptoKey: *encode("EncryptedKey")}}, nil, 201)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var created todo.Response
+	json.NewDecoder(r.Body).Decode(&created)
+	path := "/todos/" + created.EncodedID
+
+	// getList - Retrieve the list, checking that its checksum matches expected
+	getList := func(t *testing.T, expected string) (list todo.List) {
+		t.Helper()
+		r, err := api.DoRequest("GET", path, nil, nil, 200)
+		if err != nil {
+			t.Fatal(err)
+		}
+		json.NewDecoder(r.Body).Decode(&list)
+		if list.Meta.Checksum != expected {
+			t.Errorf("Expected list checksum %s, got %s", expected, list.Meta.Checksum)
+		}
+		return list
+	}
+	first := getList(t, created.Meta.Checksum).Items[0]
+
+	var added todo.ItemResponse
+	t.Run("Add Item", func(t *testing.T) {
+		r, err := api.DoRequest("POST", path+"/items", item("Second"), nil, 201)
+		if err != nil {
+			t.Fatal(err)
+		}
+		json.NewDecoder(r.Body).Decode(&added)
+		if location := r.Header.Get("Location"); location != path+"/items/"+added.EncodedID {
+			t.Errorf("Unexpected location '%s'", location)
+		}
+		list := getList(t, added.List.Checksum)
+		if len(list.Items) != 2 || list.Items[1].EncodedID != added.EncodedID || list.Items[1].Meta.Checksum != added.Meta.Checksum {
+			t.Errorf("Expected the item to be added to the end of the list, got %+v", list.Items)
+		}
+	})
+	t.Run("Update Item", func(t *testing.T) {
+		done := *encode("true")
+		if _, err := api.DoRequest("PATCH", path+"/items/"+added.EncodedID, todo.ItemPatch{
+			Done: &done}, HTTPHeaders{
+			"If-Match": core.ETag(first.Meta.Checksum)}, 412); err != nil {
+			t.Error(err)
+		}
+		r, err := api.DoRequest("PATCH", path+"/items/"+added.EncodedID, todo.ItemPatch{
+			Done: &done}, HTTPHeaders{
+			"If-Match": core.ETag(added.Meta.Checksum)}, 200)
+		if err != nil {
+			t.Fatal(err)
+		}
+		json.NewDecoder(r.Body).Decode(&added)
+		list := getList(t, added.List.Checksum)
+		if list.Items[1].Done != done || list.Items[0].Title != first.Title {
+			t.Errorf("Expected only the patched item to be updated, got %+v", list.Items)
+		}
+	})
+	t.Run("Wrong List", func(t *testing.T) {
+		if _, err := api.DoRequest("PATCH", "/nolist/items/"+added.EncodedID, todo.ItemPatch{}, nil, 404); err != nil {
+			t.Error(err)
+		}
+	})
+	t.Run("Replace Items", func(t *testing.T) {
+		// Items sent with an ID keep it
+		replacement := []todo.Item{item("New"), first}
+		r, err := api.DoRequest("PATCH", path, todo.Patch{
+			Items: &replacement}, nil, 200)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var updated todo.Response
+		json.NewDecoder(r.Body).Decode(&updated)
+		list := getList(t, updated.Meta.Checksum)
+		if len(list.Items) != 2 || list.Items[1].EncodedID != first.EncodedID || list.Items[0].EncodedID == added.EncodedID {
+			t.Errorf("Expected the first item to be kept and the second replaced, got %+v", list.Items)
+		}
+	})
+	t.Run("Delete Item", func(t *testing.T) {
+		if _, err := api.DoRequest("DELETE", path+"/items/"+first.EncodedID, nil, nil, 204); err != nil {
+			t.Fatal(err)
+		}
+		r, err := api.DoRequest("GET", path, nil, nil, 200)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var list todo.List
+		json.NewDecoder(r.Body).Decode(&list)
+		if len(list.Items) != 1 || list.Items[0].Title != *encode("New") {
+			t.Errorf("Expected only the new item to remain, got %+v", list.Items)
+		}
+	})
+	t.Run("NoList Items", func(t *testing.T) {
+		if _, err := api.DoRequest("POST", "/nolist/items", item("Loose"), nil, 404); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := api.DoRequest("POST", "/nolist", todo.NoList{
+			Items: []todo.Item{},
+			Meta: core.Meta{
+				CryptoKey: *encode("EncryptedKey")}}, nil, 201); err != nil {
+			t.Fatal(err)
+		}
+		r, err := api.DoRequest("POST", "/nolist/items", item("Loose"), nil, 201)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var added todo.ItemResponse
+		json.NewDecoder(r.Body).Decode(&added)
+		r, err = api.DoRequest("GET", "/nolist", nil, nil, 200)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var nolist todo.NoList
+		json.NewDecoder(r.Body).Decode(&nolist)
+		if len(nolist.Items) != 1 || nolist.Items[0].EncodedID != added.EncodedID || nolist.Meta.Checksum != added.List.Checksum {
+			t.Errorf("Expected the item to be added to the nolist collection, got %+v", nolist)
+		}
+	})
+}
+
+func TestMoveItem(t *testing.T) {
+	t.Parallel()
+	api := newTestAPI(t)
+	item := func(title string) todo.Item {
+		return todo.Item{
+			Title:       *encode(title),
+			Description: *encode("Description"),
+			Tags:        make([]string, 0)}
+	}
+	if _, err := api.DoRequest("POST", "/nolist", todo.NoList{
+		Items: []todo.Item{item("Loose")},
+		Meta: core.Meta{
+			CryptoKey: *encode("EncryptedKey")}}, nil, 201); err != nil {
+		t.Fatal(err)
+	}
+	r, err := api.DoRequest("POST", "/todos", todo.List{
+		Title: *encode("List"),
+		Items: []todo.Item{item("First"), item("Second")},
+		Meta: core.IndexedMeta{
+			CryptoKey: *encode("EncryptedKey")}}, nil, 201)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var created todo.Response
+	json.NewDecoder(r.Body).Decode(&created)
+	path := "/todos/" + created.EncodedID
+
+	// titles - Retrieve the decoded titles of a list's items, along with its checksum
+	titles := func(t *testing.T, path string) (titles []string, checksum string) {
+		t.Helper()
+		r, err := api.DoRequest("GET", path, nil, nil, 200)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var list todo.NoList
+		json.NewDecoder(r.Body).Decode(&list)
+		for _, item := range list.Items {
+			title, _ := base64.StdEncoding.DecodeString(item.Title)
+			titles = append(titles, string(title))
+		}
+		return titles, r.Header.Get("ETag")
+	}
+	r, err = api.DoRequest("GET", "/nolist", nil, nil, 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var nolist todo.NoList
+	json.NewDecoder(r.Body).Decode(&nolist)
+	loose := nolist.Items[0].EncodedID
+
+	t.Run("Between Lists", func(t *testing.T) {
+		r, err := api.DoRequest("POST", "/nolist/items/"+loose+"/move", todo.Move{
+			To:       created.EncodedID,
+			Position: 1}, nil, 200)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var moved todo.MoveResponse
+		json.NewDecoder(r.Body).Decode(&moved)
+		items, checksum := titles(t, path)
+		if !reflect.DeepEqual(items, []string{"First", "Loose", "Second"}) || checksum != core.ETag(moved.To.Checksum) {
+			t.Errorf("Expected the item to be moved to position 1 of the list, got %v", items)
+		}
+		items, checksum = titles(t, "/nolist")
+		if len(items) != 0 || checksum != core.ETag(moved.From.Checksum) {
+			t.Errorf("Expected the item to be removed from the nolist collection, got %v", items)
+		}
+	})
+	t.Run("Within List", func(t *testing.T) {
+		if _, err := api.DoRequest("POST", path+"/items/"+loose+"/move", todo.Move{
+			To:       created.EncodedID,
+			Position: 10}, nil, 200); err != nil {
+			t.Fatal(err)
+		}
+		if items, _ := titles(t, path); !reflect.DeepEqual(items, []string{"First", "Second", "Loose"}) {
+			t.Errorf("Expected the item to be moved to the end of the list, got %v", items)
+		}
+	})
+	t.Run("Wrong Source", func(t *testing.T) {
+		if _, err := api.DoRequest("POST", "/nolist/items/"+loose+"/move", todo.Move{}, nil, 404); err != nil {
+			t.Error(err)
+		}
+	})
+	t.Run("Missing Destination", func(t *testing.T) {
+		if _, err := api.DoRequest("POST", path+"/items/"+loose+"/move", todo.Move{
+			To: core.EncodeID(1)}, nil, 404); err != nil {
+			t.Error(err)
+		}
+		if items, _ := titles(t, path); len(items) != 3 {
+			t.Errorf("Expected a failed move not to change the list, got %v", items)
+		}
+	})
+}
+
 func TestPreflight(t *testing.T) {
+	t.Parallel()
+	api := newTestAPI(t)
 	// Expect to succeed options requests for auth level 0 routes with the correct requested method
 	t.Run("Auth Level 0", func(t *testing.T) {
-		_, err := DoRequest("OPTIONS", route("/register"), nil, HTTPHeaders{
+		_, err := api.DoRequest("OPTIONS", "/register", nil, HTTPHeaders{
 			"Access-Control-Request-Method": "POST"}, 200)
 		if err != nil {
 			t.Error(err)
 		}
-		_, err = DoRequest("OPTIONS", route("/login"), nil, HTTPHeaders{
+		_, err = api.DoRequest("OPTIONS", "/challenge", nil, HTTPHeaders{
 			"Access-Control-Request-Method": "POST"}, 200)
 		if err != nil {
 			t.Error(err)
 		}
 	})
+	// Preflight requests are matched against route templates, including nested routes
+	t.Run("Nested Routes", func(t *testing.T) {
+		id := core.EncodeID(1)
+		for path, method := range map[string]string{
+			"/todos/" + id + "/items":                 "POST",
+			"/todos/" + id + "/items/" + id:           "PATCH",
+			"/nolist/items/" + id:                     "DELETE",
+			"/todos/" + id + "/items/" + id + "/move": "POST",
+			"/nolist/items/" + id + "/move":           "POST",
+			"/trash/todo/" + id + "/restore":          "POST",
+			"/trash/tag/" + id:                        "DELETE"} {
+			if _, err := api.DoRequest("OPTIONS", path, nil, HTTPHeaders{
+				"Access-Control-Request-Method": method}, 200); err != nil {
+				t.Errorf("%s %s: %s", method, path, err)
+			}
+		}
+		if _, err := api.DoRequest("OPTIONS", "/nolist/items/"+id+"/nonexistent", nil, nil, 404); err != nil {
+			t.Error(err)
+		}
+	})
+	// Allowed methods are derived from the routes matching the path, so PUT is allowed for the reorder route alone
+	t.Run("Reorder", func(t *testing.T) {
+		r, err := api.DoRequest("OPTIONS", "/todos/order", nil, HTTPHeaders{
+			"Access-Control-Request-Method": "PUT"}, 200)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if allowed := r.Header.Get("Access-Control-Allow-Methods"); allowed != "DELETE,GET,PATCH,PUT" {
+			t.Errorf("Expected the methods of /todos/order and /todos/{id} to be allowed, got '%s'", allowed)
+		}
+		if _, err = api.DoRequest("OPTIONS", "/todos/"+core.EncodeID(1), nil, HTTPHeaders{
+			"Access-Control-Request-Method": "PUT"}, 405); err != nil {
+			t.Error(err)
+		}
+	})
 	// If no method if specified in the preflight headers,
 	// the API must return a 200 response if the user is authorized for the route
 	t.Run("No Method Specified", func(t *testing.T) {
-		_, err := DoRequest("OPTIONS", route("/register"), nil, nil, 200)
+		_, err := api.DoRequest("OPTIONS", "/register", nil, nil, 200)
 		if err != nil {
 			t.Error(err)
 		}
@@ -629,10 +1034,1166 @@ func TestPreflight(t *testing.T) {
 	// If a method is specified in the preflight headers
 	// the API must return a 405 response if the method is invalid for the route
 	t.Run("Bad Method", func(t *testing.T) {
-		_, err := DoRequest("OPTIONS", route("/register"), nil, HTTPHeaders{
+		_, err := api.DoRequest("OPTIONS", "/register", nil, HTTPHeaders{
 			"Access-Control-Request-Method": "PUT"}, 405)
 		if err != nil {
 			t.Error(err)
 		}
 	})
 }
+
+func TestDeleteAccount(t *testing.T) {
+	t.Parallel()
+	api := newTestAPI(t)
+	var dt auth.DeleteToken
+	t.Run("Request Deletion", func(t *testing.T) {
+		r, err := api.DoRequest("DELETE", "/delete_my_account_please", nil, nil, 200)
+		if err != nil {
+			t.Fatal(err)
+		}
+		json.NewDecoder(r.Body).Decode(&dt)
+	})
+	t.Run("Invalid Confirmation", func(t *testing.T) {
+		_, err := api.DoRequest("DELETE", "/delete_my_account_please", nil, HTTPHeaders{
+			"X-Confirm": "not the token"}, 403)
+		if err != nil {
+			t.Error(err)
+		}
+	})
+	t.Run("Confirm Deletion", func(t *testing.T) {
+		_, err := api.DoRequest("DELETE", "/delete_my_account_please", nil, HTTPHeaders{
+			"X-Confirm": dt.Token}, 200)
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+	t.Run("Session Revoked", func(t *testing.T) {
+		_, err := api.DoRequest("GET", "/name", nil, nil, 401)
+		if err != nil {
+			t.Error(err)
+		}
+	})
+}
+
+func TestOpenAPI(t *testing.T) {
+	t.Parallel()
+	api := newTestAPI(t)
+	r, err := api.DoRequest("GET", "/openapi.json", nil, nil, 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc openapi.Document
+	if err = json.NewDecoder(r.Body).Decode(&doc); err != nil {
+		t.Fatal(err)
+	}
+	// Every route must be documented, and every referenced schema must exist
+	for key := range routes.Map {
+		slice := strings.SplitN(key, ":", 2)
+		if item := doc.Paths[slice[1]]; item == nil || (*item)[strings.ToLower(slice[0])] == nil {
+			t.Errorf("Route %s is missing from the OpenAPI document", key)
+		}
+	}
+	encoded, _ := json.Marshal(doc)
+	for _, match := range regexp.MustCompile(`"#/components/schemas/([\w.]+)"`).FindAllStringSubmatch(string(encoded), -1) {
+		if doc.Components.Schemas[match[1]] == nil {
+			t.Errorf("Referenced schema %s is missing from the OpenAPI document", match[1])
+		}
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	t.Parallel()
+	api := newTestAPI(t)
+	// Registration is limited to 5 requests per hour per IP, one of which was used to create the test account
+	for i := 0; i < 4; i++ {
+		user := api.user
+		user.Email = fmt.Sprintf("user%d@test.com", i)
+		r, err := api.DoRequest("POST", "/register", user, nil, 201)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if remaining := r.Header.Get("RateLimit-Remaining"); remaining != strconv.Itoa(3-i) {
+			t.Errorf("Expected %d remaining requests, got '%s'", 3-i, remaining)
+		}
+	}
+	r, err := api.DoRequest("POST", "/register", api.user, nil, 429)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var problem core.HTTPError
+	json.NewDecoder(r.Body).Decode(&problem)
+	if problem.Code != core.CodeRateLimited {
+		t.Errorf("Expected error code %s, got '%s'", core.CodeRateLimited, problem.Code)
+	}
+	// A token is refilled every 12 minutes
+	if retryAfter := r.Header.Get("Retry-After"); retryAfter != "720" {
+		t.Errorf("Expected to retry after 720s, got '%s'", retryAfter)
+	}
+	if limit := r.Header.Get("RateLimit-Limit"); limit != "5" {
+		t.Errorf("Expected a limit of 5 requests, got '%s'", limit)
+	}
+
+	// Other routes have their own buckets
+	if _, err = api.DoRequest("GET", "/whoami", nil, nil, 200); err != nil {
+		t.Error(err)
+	}
+
+	// Requests with invalid credentials are limited by IP before they're authenticated (600 per minute by default)
+	t.Run("Invalid Credentials", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", api.server.URL+"/whoami", nil)
+		req.Header.Set("Cookie", "Authorization=invalid")
+		// Tokens are refilled while the requests are made, so the exact number that are allowed varies
+		for i := 0; ; i++ {
+			r, err := api.server.Client().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r.Body.Close()
+			if r.StatusCode == 429 {
+				if i < 590 {
+					t.Errorf("Expected at least 590 requests to be allowed, %d were", i)
+				}
+				break
+			} else if r.StatusCode != 401 {
+				t.Fatalf("Expected status 401 or 429, received status %d", r.StatusCode)
+			} else if i >= 700 {
+				t.Fatal("Expected requests with invalid credentials to be rate limited")
+			}
+		}
+	})
+}
+
+func TestDecode(t *testing.T) {
+	t.Parallel()
+	api := newTestAPI(t)
+	// Bodies are sent raw, because DoRequest can only send valid JSON
+	post := func(body string) (problem core.HTTPError, status int) {
+		r, err := api.server.Client().Post(api.server.URL+"/challenge?action=request", "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Body.Close()
+		json.NewDecoder(r.Body).Decode(&problem)
+		return problem, r.StatusCode
+	}
+
+	t.Run("Malformed JSON", func(t *testing.T) {
+		problem, status := post(`{"email": "user@test.com",}`)
+		if status != 400 || problem.Code != core.CodeMalformedJSON {
+			t.Errorf("Expected a 400 %s error, got %d %s", core.CodeMalformedJSON, status, problem.Code)
+		}
+		if !strings.Contains(problem.Message, "line 1, column 27") {
+			t.Errorf("Expected the position of the error in the message, got '%s'", problem.Message)
+		}
+	})
+	t.Run("Trailing Data", func(t *testing.T) {
+		problem, status := post(fmt.Sprintf(`{"email": "%s"}{}`, api.user.Email))
+		if status != 400 || problem.Code != core.CodeMalformedJSON {
+			t.Errorf("Expected a 400 %s error, got %d %s", core.CodeMalformedJSON, status, problem.Code)
+		}
+	})
+	t.Run("Body Too Large", func(t *testing.T) {
+		// Authentication routes only accept 4KiB bodies
+		problem, status := post(fmt.Sprintf(`{"email": "%s"}`, strings.Repeat("a", 4<<10)))
+		if status != 413 || problem.Code != core.CodeBodyTooLarge {
+			t.Errorf("Expected a 413 %s error, got %d %s", core.CodeBodyTooLarge, status, problem.Code)
+		}
+	})
+	t.Run("Unknown Field", func(t *testing.T) {
+		r, err := api.DoRequest("PATCH", "/settings", map[string]interface{}{
+			"enableIPLogging": true,
+			"enableLogging":   true}, nil, 400)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var problem core.HTTPError
+		json.NewDecoder(r.Body).Decode(&problem)
+		if len(problem.Errors) != 1 || problem.Errors[0].Field != "enableLogging" {
+			t.Errorf("Expected the unknown field to be reported, got %+v", problem.Errors)
+		}
+		// Nothing should have been applied
+		var settings profile.Settings
+		r, err = api.DoRequest("GET", "/settings", nil, nil, 200)
+		if err != nil {
+			t.Fatal(err)
+		}
+		json.NewDecoder(r.Body).Decode(&settings)
+		if *settings.EnableIPLogging {
+			t.Error("Expected settings to be unchanged")
+		}
+	})
+}
+
+func TestIfMatch(t *testing.T) {
+	t.Parallel()
+	api := newTestAPI(t)
+	r, err := api.DoRequest("POST", "/todos", todo.List{
+		Title: *encode("Shared list"),
+		Items: []todo.Item{},
+		Meta: core.IndexedMeta{
+			CryptoKey: *encode("EncryptedKey")}}, nil, 201)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var created todo.Response
+	json.NewDecoder(r.Body).Decode(&created)
+	path := "/todos/" + created.EncodedID
+
+	// Two devices read the list
+	r, err = api.DoRequest("GET", path, nil, nil, 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	etag := r.Header.Get("ETag")
+	if etag != core.ETag(created.Meta.Checksum) {
+		t.Fatalf("Expected ETag %s, got '%s'", core.ETag(created.Meta.Checksum), etag)
+	}
+
+	t.Run("Matching Patch Applied", func(t *testing.T) {
+		r, err := api.DoRequest("PATCH", path, todo.Patch{
+			Title: encode("Edited on device 1")}, HTTPHeaders{
+			"If-Match": etag}, 200)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var updated todo.Response
+		json.NewDecoder(r.Body).Decode(&updated)
+		if r.Header.Get("ETag") != core.ETag(updated.Meta.Checksum) || updated.Meta.Checksum == created.Meta.Checksum {
+			t.Errorf("Expected a new ETag matching the checksum %s, got '%s'", updated.Meta.Checksum, r.Header.Get("ETag"))
+		}
+		created.Meta.Checksum = updated.Meta.Checksum
+	})
+	t.Run("Stale Patch Rejected", func(t *testing.T) {
+		r, err := api.DoRequest("PATCH", path, todo.Patch{
+			Title: encode("Edited on device 2")}, HTTPHeaders{
+			"If-Match": etag}, 412)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var problem core.HTTPError
+		json.NewDecoder(r.Body).Decode(&problem)
+		if problem.Code != core.CodePreconditionFailed || problem.Checksum != created.Meta.Checksum {
+			t.Errorf("Expected a %s problem with checksum %s, got %s with '%s'", core.CodePreconditionFailed, created.Meta.Checksum, problem.Code, problem.Checksum)
+		}
+		// The first device's edit must be kept
+		r, err = api.DoRequest("GET", path, nil, nil, 200)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var current todo.List
+		json.NewDecoder(r.Body).Decode(&current)
+		if current.Title != *encode("Edited on device 1") {
+			t.Errorf("Expected the stale patch not to be applied, got title '%s'", current.Title)
+		}
+	})
+	t.Run("Unconditional Patch Applied", func(t *testing.T) {
+		_, err := api.DoRequest("PATCH", path, todo.Patch{
+			Title: encode("Edited on device 2")}, nil, 200)
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+	t.Run("Nolist Stale Patch Rejected", func(t *testing.T) {
+		if _, err := api.DoRequest("POST", "/nolist", nolist, nil, 201); err != nil {
+			t.Fatal(err)
+		}
+		_, err := api.DoRequest("PATCH", "/nolist", todo.NoListPatch{
+			Items: &nolistItemPatch}, HTTPHeaders{
+			"If-Match": `"stale", W/"` + created.Meta.Checksum + `"`}, 412)
+		if err != nil {
+			t.Error(err)
+		}
+	})
+}
+
+func TestIfNoneMatch(t *testing.T) {
+	t.Parallel()
+	api := newTestAPI(t)
+	for _, body := range []todo.List{
+		{Title: *encode("First"), Items: []todo.Item{}, Meta: core.IndexedMeta{CryptoKey: *encode("Key")}},
+		{Title: *encode("Second"), Items: []todo.Item{}, Meta: core.IndexedMeta{CryptoKey: *encode("Key")}}} {
+		if _, err := api.DoRequest("POST", "/todos", body, nil, 201); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r, err := api.DoRequest("GET", "/todos", nil, nil, 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	etag := r.Header.Get("ETag")
+	var lists []todo.List
+	json.NewDecoder(r.Body).Decode(&lists)
+
+	t.Run("Unchanged Collection", func(t *testing.T) {
+		r, err := api.DoRequest("GET", "/todos", nil, HTTPHeaders{
+			"If-None-Match": etag}, 304)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if body, _ := ioutil.ReadAll(r.Body); len(body) > 0 {
+			t.Errorf("Expected an empty body, got '%s'", body)
+		}
+	})
+	t.Run("Unchanged Resource", func(t *testing.T) {
+		_, err := api.DoRequest("GET", "/todos/"+lists[0].EncodedID, nil, HTTPHeaders{
+			"If-None-Match": core.ETag(lists[0].Meta.Checksum)}, 304)
+		if err != nil {
+			t.Error(err)
+		}
+	})
+	t.Run("Reordered Collection", func(t *testing.T) {
+		// Moving a list doesn't change its checksum, but must change the collection's ETag
+		index := uint(1)
+		_, err := api.DoRequest("PATCH", "/todos/"+lists[0].EncodedID, todo.Patch{
+			Meta: &todo.IndexedMetaPatch{
+				Index: &index}}, nil, 200)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err := api.DoRequest("GET", "/todos", nil, HTTPHeaders{
+			"If-None-Match": etag}, 200)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.Header.Get("ETag") == etag {
+			t.Error("Expected the collection's ETag to change")
+		}
+	})
+	t.Run("Keys", func(t *testing.T) {
+		if _, err := api.DoRequest("POST", "/keys", keys, nil, 201); err != nil {
+			t.Fatal(err)
+		}
+		r, err := api.DoRequest("GET", "/keys", nil, nil, 200)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = api.DoRequest("GET", "/keys", nil, HTTPHeaders{
+			"If-None-Match": r.Header.Get("ETag")}, 304)
+		if err != nil {
+			t.Error(err)
+		}
+	})
+}
+
+func TestSync(t *testing.T) {
+	t.Parallel()
+	api := newTestAPI(t)
+	sync := func(t *testing.T, query string) (response changes.SyncResponse) {
+		t.Helper()
+		r, err := api.DoRequest("GET", "/sync"+query, nil, nil, 200)
+		if err != nil {
+			t.Fatal(err)
+		}
+		json.NewDecoder(r.Body).Decode(&response)
+		return response
+	}
+	create := func(t *testing.T, path string, body interface{}) (id string) {
+		t.Helper()
+		r, err := api.DoRequest("POST", path, body, nil, 201)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var rBody tags.Response
+		json.NewDecoder(r.Body).Decode(&rBody)
+		return rBody.EncodedID
+	}
+	tag := tags.Tag{
+		Name:  *encode("Tag"),
+		Color: *encode("#000000"),
+		Meta: core.Meta{
+			CryptoKey: *encode("Key")}}
+	tagID := create(t, "/tags", tag)
+	listID := create(t, "/todos", todo.List{
+		Title: *encode("List"),
+		Items: []todo.Item{},
+		Meta: core.IndexedMeta{
+			CryptoKey: *encode("Key")}})
+
+	var cursor uint64
+	t.Run("Snapshot", func(t *testing.T) {
+		response := sync(t, "")
+		if response.Cursor == 0 || response.HasMore {
+			t.Errorf("Expected a complete snapshot with a cursor, got %+v", response)
+		}
+		found := make(map[string]string)
+		for _, change := range response.Changes {
+			found[change.Type+":"+change.ID] = change.Action
+		}
+		if found["tag:"+tagID] != changes.ActionCreated || found["todo:"+listID] != changes.ActionCreated {
+			t.Errorf("Expected the tag and todo list in the snapshot, got %v", found)
+		}
+		cursor = response.Cursor
+	})
+	t.Run("Incremental", func(t *testing.T) {
+		if _, err := api.DoRequest("PATCH", "/tags/"+tagID, tags.Patch{
+			Name: encode("Renamed")}, nil, 200); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := api.DoRequest("DELETE", "/todos/"+listID, nil, nil, 204); err != nil {
+			t.Fatal(err)
+		}
+		// Resources created and deleted between syncs are never sent
+		tempID := create(t, "/tags", tag)
+		if _, err := api.DoRequest("DELETE", "/tags/"+tempID, nil, nil, 204); err != nil {
+			t.Fatal(err)
+		}
+
+		response := sync(t, "?cursor="+strconv.FormatUint(cursor, 10))
+		if len(response.Changes) != 2 {
+			t.Fatalf("Expected 2 changes, got %+v", response.Changes)
+		}
+		if change := response.Changes[0]; change.ID != tagID || change.Action != changes.ActionUpdated || change.Data == nil {
+			t.Errorf("Expected the tag to be updated, got %+v", change)
+		}
+		if change := response.Changes[1]; change.ID != listID || change.Action != changes.ActionDeleted || change.Data != nil {
+			t.Errorf("Expected a tombstone for the todo list, got %+v", change)
+		}
+		if response.Cursor <= cursor {
+			t.Error("Expected the cursor to advance")
+		}
+	})
+	t.Run("Pagination", func(t *testing.T) {
+		response := sync(t, "?limit=1&cursor="+strconv.FormatUint(cursor, 10))
+		if len(response.Changes) != 1 || !response.HasMore || response.Cursor != response.Changes[0].Seq {
+			t.Errorf("Expected 1 change with more available, got %+v", response)
+		}
+	})
+	t.Run("Invalid Cursor", func(t *testing.T) {
+		if _, err := api.DoRequest("GET", "/sync?cursor=1000000", nil, nil, 400); err != nil {
+			t.Error(err)
+		}
+	})
+	t.Run("Push", func(t *testing.T) {
+		r, err := api.DoRequest("GET", "/tags/"+tagID, nil, nil, 200)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var current tags.Tag
+		json.NewDecoder(r.Body).Decode(&current)
+
+		raw := func(v interface{}) json.RawMessage {
+			encoded, _ := json.Marshal(v)
+			return encoded
+		}
+		r, err = api.DoRequest("POST", "/sync", changes.PushRequest{
+			Changes: []changes.PushChange{{
+				Type:         core.KindTag,
+				ID:           tagID,
+				Action:       changes.ActionUpdate,
+				BaseChecksum: tag.Meta.Checksum + "stale",
+				Data:         raw(tags.Patch{Color: encode("#ffffff")})}, {
+				Type:   core.KindName,
+				Action: changes.ActionCreate,
+				Data: raw(profile.Name{
+					FirstName: *encode("First"),
+					Meta: core.Meta{
+						CryptoKey: *encode("Key")}})}, {
+				Type:         core.KindTag,
+				ID:           tagID,
+				Action:       changes.ActionUpdate,
+				BaseChecksum: current.Meta.Checksum,
+				Data:         raw(tags.Patch{Color: encode("#ffffff")})}, {
+				Type:         core.KindTodoList,
+				ID:           listID,
+				Action:       changes.ActionDelete,
+				BaseChecksum: current.Meta.Checksum}, {
+				Type:   core.KindTag,
+				Action: changes.ActionCreate,
+				Data:   json.RawMessage(`{"name": "not base64"}`)}}}, nil, 200)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var response changes.PushResponse
+		json.NewDecoder(r.Body).Decode(&response)
+		statuses := make([]string, len(response.Results))
+		for i, result := range response.Results {
+			statuses[i] = result.Status
+		}
+		expected := []string{changes.StatusConflict, changes.StatusApplied, changes.StatusApplied, changes.StatusNotFound, changes.StatusInvalid}
+		if !reflect.DeepEqual(statuses, expected) {
+			t.Fatalf("Expected statuses %v, got %v", expected, statuses)
+		}
+		if conflict := response.Results[0]; conflict.Checksum != current.Meta.Checksum || conflict.Data == nil {
+			t.Errorf("Expected the conflict to include the current tag, got %+v", conflict)
+		}
+		if response.Results[4].Error == nil {
+			t.Error("Expected the invalid change to include an error")
+		}
+
+		// Pushed changes are synced like any other
+		synced := sync(t, "?cursor="+strconv.FormatUint(cursor, 10))
+		found := make(map[string]bool)
+		for _, change := range synced.Changes {
+			found[change.Type] = true
+		}
+		if !found[core.KindName] || !found[core.KindTag] {
+			t.Errorf("Expected the pushed name and tag to be synced, got %+v", synced.Changes)
+		}
+	})
+}
+
+// sseEvent - A server-sent event read from an event stream
+type sseEvent struct {
+	id   string
+	name string
+	data string
+}
+
+// openEvents - Open an event stream, authenticating with the CSRF token in the query string as EventSource clients must
+func (api *testAPI) openEvents(lastEventID string, expectedStatus int) (r *http.Response, events <-chan sseEvent, e error) {
+	req, err := http.NewRequest("GET", api.server.URL+"/events?csrf="+api.session.CSRFtoken, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Cookie", "Authorization="+api.session.Token)
+	if len(lastEventID) > 0 {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	if r, e = api.server.Client().Do(req); e != nil {
+		return nil, nil, e
+	}
+	if r.StatusCode != expectedStatus {
+		r.Body.Close()
+		return nil, nil, fmt.Errorf("Expected status %d, received status %d", expectedStatus, r.StatusCode)
+	}
+	api.t.Cleanup(func() { r.Body.Close() })
+
+	ch := make(chan sseEvent)
+	go func() {
+		defer close(ch)
+		scanner := bufio.NewScanner(r.Body)
+		var event sseEvent
+		for scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case strings.HasPrefix(line, "id: "):
+				event.id = strings.TrimPrefix(line, "id: ")
+			case strings.HasPrefix(line, "event: "):
+				event.name = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				event.data = strings.TrimPrefix(line, "data: ")
+			case len(line) == 0 && len(event.name) > 0:
+				ch <- event
+				event = sseEvent{}
+			}
+		}
+	}()
+	return r, ch, nil
+}
+
+// nextEvent - Wait for the next event from a stream
+func nextEvent(t *testing.T, events <-chan sseEvent) sseEvent {
+	t.Helper()
+	select {
+	case event, ok := <-events:
+		if !ok {
+			t.Fatal("Event stream closed")
+		}
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for an event")
+	}
+	return sseEvent{}
+}
+
+func TestEvents(t *testing.T) {
+	t.Parallel()
+	api := newTestAPI(t)
+	r, events, err := api.openEvents("", 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contentType := r.Header.Get("Content-Type"); contentType != "text/event-stream" {
+		t.Fatalf("Expected an event stream, got %s", contentType)
+	}
+
+	var lastID string
+	t.Run("Live", func(t *testing.T) {
+		r, err := api.DoRequest("POST", "/tags", tags.Tag{
+			Name:  *encode("Tag"),
+			Color: *encode("#000000"),
+			Meta: core.Meta{
+				CryptoKey: *encode("Key")}}, nil, 201)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var tag tags.Response
+		json.NewDecoder(r.Body).Decode(&tag)
+		// Auth tokens are formatted as token:userID:sessionID
+		session := strings.Split(api.session.Token, ":")[2]
+
+		received := nextEvent(t, events)
+		var event changes.Event
+		json.Unmarshal([]byte(received.data), &event)
+		expected := changes.Event{
+			Type:     core.KindTag,
+			ID:       tag.EncodedID,
+			Action:   changes.ActionCreated,
+			Checksum: tag.Meta.Checksum,
+			Session:  session}
+		if received.name != "change" || event != expected {
+			t.Errorf("Expected change event %+v, got %s %+v", expected, received.name, event)
+		}
+		lastID = received.id
+	})
+	t.Run("Resume", func(t *testing.T) {
+		// Event IDs are sync cursors, so resuming from 0 replays every change
+		_, events, err := api.openEvents("0", 200)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if event := nextEvent(t, events); event.id != lastID {
+			t.Errorf("Expected the change with ID %s to be replayed, got %+v", lastID, event)
+		}
+	})
+	t.Run("Reset", func(t *testing.T) {
+		_, events, err := api.openEvents("1000000", 200)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if event := nextEvent(t, events); event.name != "reset" || event.id != lastID {
+			t.Errorf("Expected a reset event, got %+v", event)
+		}
+	})
+	t.Run("Connection Limit", func(t *testing.T) {
+		// 3 streams are already open
+		for i := 0; i < 2; i++ {
+			if _, _, err := api.openEvents("", 200); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, _, err := api.openEvents("", 429); err != nil {
+			t.Error(err)
+		}
+	})
+	t.Run("CSRF Required", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", api.server.URL+"/events", nil)
+		req.Header.Set("Cookie", "Authorization="+api.session.Token)
+		r, err := api.server.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Body.Close()
+		if r.StatusCode != 401 {
+			t.Errorf("Expected status 401, got %d", r.StatusCode)
+		}
+	})
+}
+
+func TestBatch(t *testing.T) {
+	t.Parallel()
+	api := newTestAPI(t)
+	tag := tags.Tag{
+		Name:  *encode("Tag"),
+		Color: *encode("#000000"),
+		Meta: core.Meta{
+			CryptoKey: *encode("Key")}}
+	raw := func(v interface{}) json.RawMessage {
+		encoded, _ := json.Marshal(v)
+		return encoded
+	}
+	batch := func(t *testing.T, operations ...routes.Operation) (response routes.BatchResponse) {
+		t.Helper()
+		r, err := api.DoRequest("POST", "/batch", routes.BatchRequest{
+			Operations: operations}, nil, 200)
+		if err != nil {
+			t.Fatal(err)
+		}
+		json.NewDecoder(r.Body).Decode(&response)
+		return response
+	}
+	statuses := func(response routes.BatchResponse) []int {
+		s := make([]int, len(response.Results))
+		for i, result := range response.Results {
+			s[i] = result.Status
+		}
+		return s
+	}
+	r, err := api.DoRequest("POST", "/tags", tag, nil, 201)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var existing tags.Response
+	json.NewDecoder(r.Body).Decode(&existing)
+
+	t.Run("Commit", func(t *testing.T) {
+		response := batch(t, routes.Operation{
+			Method: "POST",
+			Path:   "/nolist",
+			Body:   raw(todo.NoList{Items: []todo.Item{}, Meta: core.Meta{CryptoKey: *encode("Key")}})}, routes.Operation{
+			Method: "PATCH",
+			Path:   "/nolist",
+			Body:   raw(todo.NoListPatch{Meta: &core.MetaPatch{CryptoKey: encode("New Key")}})}, routes.Operation{
+			Method: "POST",
+			Path:   "/tags",
+			Body:   raw(tag)})
+		if !response.Committed || !reflect.DeepEqual(statuses(response), []int{201, 200, 201}) {
+			t.Fatalf("Expected every operation to be committed, got %+v", response)
+		}
+		if response.Results[1].Headers["ETag"] == "" {
+			t.Error("Expected the patch's ETag to be included in its result")
+		}
+		var nolist todo.NoList
+		r, err := api.DoRequest("GET", "/nolist", nil, nil, 200)
+		if err != nil {
+			t.Fatal(err)
+		}
+		json.NewDecoder(r.Body).Decode(&nolist)
+		if nolist.Meta.CryptoKey != *encode("New Key") {
+			t.Error("Expected the nolist to be patched")
+		}
+	})
+	t.Run("Rollback", func(t *testing.T) {
+		response := batch(t, routes.Operation{
+			Method: "DELETE",
+			Path:   "/tags/" + existing.EncodedID}, routes.Operation{
+			Method:  "PATCH",
+			Path:    "/nolist",
+			IfMatch: core.ETag("stale"),
+			Body:    raw(todo.NoListPatch{Meta: &core.MetaPatch{CryptoKey: encode("Newer Key")}})}, routes.Operation{
+			Method: "DELETE",
+			Path:   "/name"})
+		if response.Committed || !reflect.DeepEqual(statuses(response), []int{204, 412, 424}) {
+			t.Fatalf("Expected the batch to fail at the second operation, got %+v", response)
+		}
+		var httpErr core.HTTPError
+		json.Unmarshal(response.Results[1].Body, &httpErr)
+		if httpErr.Code != core.CodePreconditionFailed {
+			t.Errorf("Expected the failed operation's problem in its result, got %+v", httpErr)
+		}
+		if _, err := api.DoRequest("GET", "/tags/"+existing.EncodedID, nil, nil, 200); err != nil {
+			t.Errorf("Expected the tag's deletion to be rolled back: %s", err)
+		}
+	})
+	t.Run("Unbatchable", func(t *testing.T) {
+		for _, op := range []routes.Operation{
+			{Method: "GET", Path: "/events"},
+			{Method: "POST", Path: "/batch"},
+			{Method: "POST", Path: "/login"},
+			{Method: "GET", Path: "/nonexistent"}} {
+			response := batch(t, op)
+			if response.Committed || response.Results[0].Status < 400 {
+				t.Errorf("Expected %s:%s to be refused, got %+v", op.Method, op.Path, response)
+			}
+		}
+	})
+	t.Run("Rate Limited", func(t *testing.T) {
+		// Operations count against their route's rate limit (POST:/totp is limited to 10 requests per minute)
+		operations := make([]routes.Operation, 11)
+		for i := range operations {
+			operations[i] = routes.Operation{
+				Method: "POST",
+				Path:   "/totp?action=disable"}
+		}
+		// Rate limits are checked before any operation is run
+		response := batch(t, operations...)
+		if response.Committed || response.Results[9].Status != 424 || response.Results[10].Status != 429 {
+			t.Fatalf("Expected the 11th operation to be rate limited before the batch was run, got %v", statuses(response))
+		}
+		var httpErr core.HTTPError
+		json.Unmarshal(response.Results[10].Body, &httpErr)
+		if httpErr.Code != core.CodeRateLimited {
+			t.Errorf("Expected error code %s, got '%s'", core.CodeRateLimited, httpErr.Code)
+		}
+	})
+}
+
+func TestPagination(t *testing.T) {
+	t.Parallel()
+	api := newTestAPI(t)
+	for i := 0; i < 5; i++ {
+		if _, err := api.DoRequest("POST", "/tags", tags.Tag{
+			Name:  *encode("Tag " + strconv.Itoa(i)),
+			Color: *encode("#000000"),
+			Meta: core.Meta{
+				CryptoKey: *encode("Key")}}, nil, 201); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := api.DoRequest("POST", "/todos", todo.List{
+			Title: *encode("List " + strconv.Itoa(i)),
+			Items: []todo.Item{},
+			Meta: core.IndexedMeta{
+				CryptoKey: *encode("Key")}}, nil, 201); err != nil {
+			t.Fatal(err)
+		}
+	}
+	linkRegex := regexp.MustCompile(`^<([^>]+)>; rel="next"$`)
+
+	// paginate - Follow Link headers from path, returning the IDs of every member in order
+	paginate := func(t *testing.T, path string) (ids []string, pages int) {
+		t.Helper()
+		for len(path) > 0 {
+			r, err := api.DoRequest("GET", path, nil, nil, 200)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var members []struct {
+				ID string `json:"id"`
+			}
+			json.NewDecoder(r.Body).Decode(&members)
+			for _, member := range members {
+				ids = append(ids, member.ID)
+			}
+			pages++
+			path = ""
+			if match := linkRegex.FindStringSubmatch(r.Header.Get("Link")); match != nil {
+				path = match[1]
+			}
+		}
+		return ids, pages
+	}
+
+	for _, collection := range []string{"/todos", "/tags"} {
+		t.Run(strings.TrimPrefix(collection, "/"), func(t *testing.T) {
+			all, pages := paginate(t, collection)
+			if len(all) != 5 || pages != 1 {
+				t.Fatalf("Expected every member in 1 page without a limit, got %d in %d pages", len(all), pages)
+			}
+			paged, pages := paginate(t, collection+"?limit=2")
+			if !reflect.DeepEqual(paged, all) || pages != 3 {
+				t.Errorf("Expected the same members over 3 pages, got %v over %d pages (expected %v)", paged, pages, all)
+			}
+		})
+	}
+	t.Run("Invalid Cursor", func(t *testing.T) {
+		if _, err := api.DoRequest("GET", "/tags?cursor=invalid", nil, nil, 400); err != nil {
+			t.Error(err)
+		}
+	})
+}
+
+func TestListOrder(t *testing.T) {
+	t.Parallel()
+	// More lists than the old index limit (255) can be created, which would exceed the rate limit of POST:/todos
+	api := newLimitedTestAPI(t, nil)
+	const count = 300
+	body, _ := json.Marshal(todo.List{
+		Title: *encode("List"),
+		Items: []todo.Item{},
+		Meta: core.IndexedMeta{
+			CryptoKey: *encode("Key")}})
+	ids := make([]string, 0, count)
+	for len(ids) < count {
+		operations := make([]routes.Operation, 50)
+		for i := range operations {
+			operations[i] = routes.Operation{
+				Method: "POST",
+				Path:   "/todos",
+				Body:   body}
+		}
+		r, err := api.DoRequest("POST", "/batch", routes.BatchRequest{
+			Operations: operations}, nil, 200)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var response routes.BatchResponse
+		json.NewDecoder(r.Body).Decode(&response)
+		if !response.Committed {
+			t.Fatalf("Expected every list to be created, got %+v", response.Results[0])
+		}
+		for _, result := range response.Results {
+			var created todo.Response
+			json.Unmarshal(result.Body, &created)
+			if created.Meta.Index != uint(len(ids)) {
+				t.Fatalf("Expected list %d to be created at the end, got index %d", len(ids), created.Meta.Index)
+			}
+			ids = append(ids, created.EncodedID)
+		}
+	}
+
+	// order - Retrieve the IDs of every list in order, checking that their indexes are their positions
+	order := func(t *testing.T) []string {
+		t.Helper()
+		r, err := api.DoRequest("GET", "/todos", nil, nil, 200)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var lists []todo.List
+		json.NewDecoder(r.Body).Decode(&lists)
+		order := make([]string, len(lists))
+		for i, list := range lists {
+			if list.Meta.Index != uint(i) {
+				t.Fatalf("Expected list %d to have index %d, got %d", i, i, list.Meta.Index)
+			}
+			order[i] = list.EncodedID
+		}
+		return order
+	}
+
+	// Repeatedly move the last list between the first two, and the first list to the end
+	for i := 0; i < 20; i++ {
+		last, first := ids[len(ids)-1], ids[0]
+		index := uint(1)
+		r, err := api.DoRequest("PATCH", "/todos/"+last, todo.Patch{
+			Meta: &todo.IndexedMetaPatch{
+				Index: &index}}, nil, 200)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var moved todo.Response
+		json.NewDecoder(r.Body).Decode(&moved)
+		if moved.Meta.Index != 1 {
+			t.Fatalf("Expected the moved list to have index 1, got %d", moved.Meta.Index)
+		}
+		ids = append(ids[:1], append([]string{last}, ids[1:len(ids)-1]...)...)
+
+		end := uint(count + 10)
+		if _, err = api.DoRequest("PATCH", "/todos/"+first, todo.Patch{
+			Meta: &todo.IndexedMetaPatch{
+				Index: &end}}, nil, 200); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids[1:], first)
+	}
+	if got := order(t); !reflect.DeepEqual(got, ids) {
+		t.Error("Expected lists to be in the order they were moved to")
+	}
+
+	t.Run("Delete", func(t *testing.T) {
+		if _, err := api.DoRequest("DELETE", "/todos/"+ids[0], nil, nil, 204); err != nil {
+			t.Fatal(err)
+		}
+		if got := order(t); !reflect.DeepEqual(got, ids[1:]) {
+			t.Error("Expected the remaining lists to keep their order")
+		}
+	})
+}
+
+func TestReorder(t *testing.T) {
+	t.Parallel()
+	api := newTestAPI(t)
+	ids := make([]string, 4)
+	for i := range ids {
+		r, err := api.DoRequest("POST", "/todos", todo.List{
+			Title: *encode("List " + strconv.Itoa(i)),
+			Items: []todo.Item{},
+			Meta: core.IndexedMeta{
+				CryptoKey: *encode("Key")}}, nil, 201)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var created todo.Response
+		json.NewDecoder(r.Body).Decode(&created)
+		ids[i] = created.EncodedID
+	}
+
+	order := []string{ids[3], ids[1], ids[0], ids[2]}
+	r, err := api.DoRequest("PUT", "/todos/order", todo.Order{
+		Lists: order}, nil, 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var response []todo.Response
+	json.NewDecoder(r.Body).Decode(&response)
+	if len(response) != len(order) {
+		t.Fatalf("Expected %d lists in the response, got %d", len(order), len(response))
+	}
+	for i, list := range response {
+		if list.EncodedID != order[i] || list.Meta.Index != uint(i) || len(list.Meta.Checksum) == 0 {
+			t.Errorf("Expected list %s at index %d, got %+v", order[i], i, list)
+		}
+	}
+
+	r, err = api.DoRequest("GET", "/todos", nil, nil, 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lists []todo.List
+	json.NewDecoder(r.Body).Decode(&lists)
+	for i, list := range lists {
+		if list.EncodedID != order[i] || list.Meta.Index != uint(i) {
+			t.Errorf("Expected list %s at index %d, got %s at %d", order[i], i, list.EncodedID, list.Meta.Index)
+		}
+	}
+
+	t.Run("Mismatch", func(t *testing.T) {
+		for name, lists := range map[string][]string{
+			"Missing":   {ids[0], ids[1], ids[2]},
+			"Duplicate": {ids[0], ids[1], ids[2], ids[2]},
+			"Unknown":   {ids[0], ids[1], ids[2], core.EncodeID(1)}} {
+			r, err := api.DoRequest("PUT", "/todos/order", todo.Order{
+				Lists: lists}, nil, 409)
+			if err != nil {
+				t.Fatalf("%s: %s", name, err)
+			}
+			var problem core.HTTPError
+			json.NewDecoder(r.Body).Decode(&problem)
+			if problem.Code != core.CodeOrderMismatch {
+				t.Errorf("%s: Expected error code %s, got '%s'", name, core.CodeOrderMismatch, problem.Code)
+			}
+		}
+	})
+	t.Run("Malformed ID", func(t *testing.T) {
+		if _, err := api.DoRequest("PUT", "/todos/order", todo.Order{
+			Lists: []string{ids[0], "not an ID", ids[1], ids[2]}}, nil, 400); err != nil {
+			t.Error(err)
+		}
+	})
+}
+
+func TestTrash(t *testing.T) {
+	t.Parallel()
+	api := newTestAPI(t)
+	ids := make([]string, 3)
+	for i := range ids {
+		r, err := api.DoRequest("POST", "/todos", todo.List{
+			Title: *encode("List " + strconv.Itoa(i)),
+			Items: []todo.Item{{
+				Title:       *encode("Item"),
+				Description: *encode("Description"),
+				Done:        *encode("false"),
+				Tags:        []string{}}},
+			Meta: core.IndexedMeta{
+				CryptoKey: *encode("Key")}}, nil, 201)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var created todo.Response
+		json.NewDecoder(r.Body).Decode(&created)
+		ids[i] = created.EncodedID
+	}
+	r, err := api.DoRequest("POST", "/tags", tags.Tag{
+		Name:  *encode("Tag"),
+		Color: *encode("#000000"),
+		Meta: core.Meta{
+			CryptoKey: *encode("Key")}}, nil, 201)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tag tags.Response
+	json.NewDecoder(r.Body).Decode(&tag)
+
+	// getTrash - Retrieve the user's trash
+	getTrash := func(t *testing.T) (entries []trash.Entry) {
+		t.Helper()
+		r, err := api.DoRequest("GET", "/trash", nil, nil, 200)
+		if err != nil {
+			t.Fatal(err)
+		}
+		json.NewDecoder(r.Body).Decode(&entries)
+		return entries
+	}
+	// order - Retrieve the IDs of the user's lists in order
+	order := func(t *testing.T) (order []string) {
+		t.Helper()
+		r, err := api.DoRequest("GET", "/todos", nil, nil, 200)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var lists []todo.List
+		json.NewDecoder(r.Body).Decode(&lists)
+		for _, list := range lists {
+			order = append(order, list.EncodedID)
+		}
+		return order
+	}
+
+	t.Run("Delete", func(t *testing.T) {
+		if _, err := api.DoRequest("DELETE", "/todos/"+ids[1], nil, nil, 204); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := api.DoRequest("DELETE", "/tags/"+tag.EncodedID, nil, nil, 204); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := api.DoRequest("GET", "/todos/"+ids[1], nil, nil, 404); err != nil {
+			t.Error(err)
+		}
+		if _, err := api.DoRequest("GET", "/tags/"+tag.EncodedID, nil, nil, 404); err != nil {
+			t.Error(err)
+		}
+
+		entries := getTrash(t)
+		if len(entries) != 2 {
+			t.Fatalf("Expected 2 resources in the trash, got %d", len(entries))
+		}
+		for _, entry := range entries {
+			switch {
+			case entry.Trashed == 0 || entry.Expires != entry.Trashed+30*24*60*60:
+				t.Errorf("Expected %s to expire 30 days after it was trashed, got %+v", entry.EncodedID, entry)
+			case entry.Type == core.KindTodoList && (entry.EncodedID != ids[1] || entry.List == nil || len(entry.List.Items) != 1):
+				t.Errorf("Expected the trashed list to be sent along with its items, got %+v", entry)
+			case entry.Type == core.KindTag && (entry.EncodedID != tag.EncodedID || entry.Tag == nil || entry.Tag.Meta.Checksum != tag.Meta.Checksum):
+				t.Errorf("Expected the trashed tag to be sent, got %+v", entry)
+			case entry.Type == core.KindTodoList:
+				// Items of trashed lists can't be changed
+				path := "/todos/" + ids[1] + "/items/" + entry.List.Items[0].EncodedID
+				if _, err := api.DoRequest("PATCH", path, todo.ItemPatch{
+					Title: encode("New Title")}, nil, 404); err != nil {
+					t.Error(err)
+				}
+			}
+		}
+	})
+	t.Run("Restore", func(t *testing.T) {
+		// Lists created while a list is in the trash don't affect where it's restored to
+		r, err := api.DoRequest("POST", "/todos", todo.List{
+			Title: *encode("List 3"),
+			Items: []todo.Item{},
+			Meta: core.IndexedMeta{
+				CryptoKey: *encode("Key")}}, nil, 201)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var created todo.Response
+		json.NewDecoder(r.Body).Decode(&created)
+		ids = append(ids, created.EncodedID)
+
+		r, err = api.DoRequest("POST", "/trash/todo/"+ids[1]+"/restore", nil, nil, 200)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var restored trash.RestoreResponse
+		json.NewDecoder(r.Body).Decode(&restored)
+		if restored.Type != core.KindTodoList || restored.Meta.Index == nil || *restored.Meta.Index != 1 {
+			t.Errorf("Expected the list to be restored at index 1, got %+v", restored)
+		}
+		if got := order(t); !reflect.DeepEqual(got, ids) {
+			t.Errorf("Expected the list to be restored to its previous position, got %v", got)
+		}
+		r, err = api.DoRequest("GET", "/todos/"+ids[1], nil, nil, 200)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var list todo.List
+		json.NewDecoder(r.Body).Decode(&list)
+		if len(list.Items) != 1 || list.Meta.Checksum != restored.Meta.Checksum {
+			t.Errorf("Expected the list to be restored along with its items, got %+v", list)
+		}
+		if _, err = api.DoRequest("POST", "/trash/todo/"+ids[1]+"/restore", nil, nil, 404); err != nil {
+			t.Error(err)
+		}
+	})
+	t.Run("Purge", func(t *testing.T) {
+		if _, err := api.DoRequest("DELETE", "/trash/tag/"+tag.EncodedID, nil, nil, 204); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := api.DoRequest("POST", "/trash/tag/"+tag.EncodedID+"/restore", nil, nil, 404); err != nil {
+			t.Error(err)
+		}
+		// Resources that aren't in the trash can't be purged
+		if _, err := api.DoRequest("DELETE", "/trash/todo/"+ids[0], nil, nil, 404); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := api.DoRequest("DELETE", "/trash/list/"+ids[0], nil, nil, 400); err != nil {
+			t.Error(err)
+		}
+		if _, err := api.DoRequest("GET", "/todos/"+ids[0], nil, nil, 200); err != nil {
+			t.Error(err)
+		}
+
+		for _, id := range ids[:2] {
+			if _, err := api.DoRequest("DELETE", "/todos/"+id, nil, nil, 204); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err := api.DoRequest("DELETE", "/trash", nil, nil, 204); err != nil {
+			t.Fatal(err)
+		}
+		if entries := getTrash(t); len(entries) != 0 {
+			t.Errorf("Expected the trash to be empty, got %d resources", len(entries))
+		}
+		if got := order(t); !reflect.DeepEqual(got, ids[2:]) {
+			t.Errorf("Expected only the lists that weren't deleted to be left, got %v", got)
+		}
+	})
+}
@@ -0,0 +1,119 @@
+// Package certs - TLS certificate loading, with hot reload when certificates change on disk
+package certs
+
+import (
+	"context"
+	"crypto/tls"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/very-amused/CSplan-API/core"
+)
+
+// Filenames used when loading certificates from a directory (matching the layout used by certbot)
+const (
+	DirCertFile = "fullchain.pem"
+	DirKeyFile  = "privkey.pem"
+)
+
+// Reloader - Serve a certificate loaded from disk, reloading it whenever the certificate or key file is modified
+type Reloader struct {
+	certFile string
+	keyFile  string
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+// NewReloader - Load a certificate from certFile and keyFile, or from DirCertFile and DirKeyFile within dir if dir isn't empty
+func NewReloader(certFile, keyFile, dir string) (*Reloader, error) {
+	if len(dir) > 0 {
+		certFile = filepath.Join(dir, DirCertFile)
+		keyFile = filepath.Join(dir, DirKeyFile)
+	}
+	r := &Reloader{
+		certFile: certFile,
+		keyFile:  keyFile}
+	if _, err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// latestModTime - Return the most recent modification time of the certificate and key files
+func (r *Reloader) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, file := range []string{r.certFile, r.keyFile} {
+		stat, err := os.Stat(file)
+		if err != nil {
+			return latest, err
+		}
+		if stat.ModTime().After(latest) {
+			latest = stat.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// Reload - Load the certificate if either file has been modified since it was last loaded, reporting whether it was reloaded
+// The current certificate is kept if loading fails, so that a partially written renewal doesn't take the server down
+func (r *Reloader) Reload() (reloaded bool, e error) {
+	modTime, err := r.latestModTime()
+	if err != nil {
+		return false, err
+	}
+	r.mu.RLock()
+	unchanged := r.cert != nil && modTime.Equal(r.modTime)
+	r.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return false, err
+	}
+	r.mu.Lock()
+	r.cert = &cert
+	r.modTime = modTime
+	r.mu.Unlock()
+	return true, nil
+}
+
+// GetCertificate - Return the current certificate, for use as tls.Config.GetCertificate
+func (r *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+// Watch - Poll the certificate files for changes every interval until shutdown
+func (r *Reloader) Watch(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	core.Go(func(ctx context.Context) {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if reloaded, err := r.Reload(); err != nil {
+					core.Errorf("Failed to reload TLS certificate, continuing to use the previous one: %s", err)
+				} else if reloaded {
+					core.Infof("Reloaded TLS certificate from %s", r.certFile)
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	})
+}
+
+// TLSConfig - Return a TLS config serving the reloader's current certificate, with HTTP/2 enabled
+func (r *Reloader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: r.GetCertificate,
+		NextProtos:     []string{"h2", "http/1.1"}}
+}
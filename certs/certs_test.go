@@ -0,0 +1,94 @@
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeCert - Write a self signed certificate for commonName to dir, with modTime as the modification time of both files
+func writeCert(t *testing.T, dir, commonName string, modTime time.Time) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour)}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string][]byte{
+		DirCertFile: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		DirKeyFile:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})}
+	for name, contents := range files {
+		path := filepath.Join(dir, name)
+		if err = ioutil.WriteFile(path, contents, 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err = os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func commonName(t *testing.T, r *Reloader) string {
+	cert, _ := r.GetCertificate(nil)
+	parsed, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parsed.Subject.CommonName
+}
+
+func TestReloader(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	writeCert(t, dir, "first", now)
+	r, err := NewReloader("", "", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name := commonName(t, r); name != "first" {
+		t.Fatalf("Expected the initial certificate to be served, got %s", name)
+	}
+
+	if reloaded, err := r.Reload(); err != nil || reloaded {
+		t.Errorf("Expected an unmodified certificate not to be reloaded (err: %v)", err)
+	}
+
+	writeCert(t, dir, "second", now.Add(time.Minute))
+	if reloaded, err := r.Reload(); err != nil || !reloaded {
+		t.Fatalf("Expected a modified certificate to be reloaded (err: %v)", err)
+	}
+	if name := commonName(t, r); name != "second" {
+		t.Errorf("Expected the reloaded certificate to be served, got %s", name)
+	}
+
+	// A broken certificate must not replace a working one
+	ioutil.WriteFile(filepath.Join(dir, DirCertFile), []byte("garbage"), 0600)
+	os.Chtimes(filepath.Join(dir, DirCertFile), now.Add(time.Hour), now.Add(time.Hour))
+	if _, err := r.Reload(); err == nil {
+		t.Error("Expected an invalid certificate to fail to load")
+	}
+	if name := commonName(t, r); name != "second" {
+		t.Errorf("Expected the previous certificate to be kept after a failed reload, got %s", name)
+	}
+}
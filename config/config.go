@@ -0,0 +1,489 @@
+// Package config - Server configuration, loaded from a TOML file with environment variable overrides
+package config
+
+import (
+	"encoding"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/BurntSushi/toml"
+
+	"github.com/very-amused/CSplan-API/ratelimit"
+)
+
+// Config - Complete server configuration
+type Config struct {
+	Server    Server    `toml:"server"`
+	TLS       TLS       `toml:"tls"`
+	Database  Database  `toml:"database"`
+	CORS      CORS      `toml:"cors"`
+	Log       Log       `toml:"log"`
+	Limits    Limits    `toml:"limits"`
+	RateLimit RateLimit `toml:"rate_limit"`
+	Trash     Trash     `toml:"trash"`
+}
+
+// Server - HTTP server settings
+type Server struct {
+	Addr         string   `toml:"addr"`
+	ReadTimeout  Duration `toml:"read_timeout"`
+	WriteTimeout Duration `toml:"write_timeout"`
+	IdleTimeout  Duration `toml:"idle_timeout"`
+	// AdminAddr - Address of the admin listener serving /metrics (along with /healthz and /readyz), disabled if empty
+	// This listener has no authentication, and should only be reachable from trusted networks
+	AdminAddr string `toml:"admin_addr"`
+	// ShutdownTimeout - How long to wait for active requests and background tasks to finish after SIGINT/SIGTERM
+	ShutdownTimeout Duration `toml:"shutdown_timeout"`
+	// TrustedProxies - Addresses (or CIDR ranges) of reverse proxies whose X-Forwarded-For header is trusted
+	TrustedProxies Networks `toml:"trusted_proxies"`
+}
+
+// Networks - List of IP addresses or CIDR ranges
+type Networks []string
+
+// Contains - Report whether ip is one of, or within one of, the networks
+func (n Networks) Contains(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, network := range n {
+		if _, cidr, err := net.ParseCIDR(network); err == nil {
+			if cidr.Contains(parsed) {
+				return true
+			}
+		} else if addr := net.ParseIP(network); addr != nil && addr.Equal(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// TLS - TLS termination settings
+type TLS struct {
+	Enabled  bool   `toml:"enabled"`
+	CertFile string `toml:"cert_file"`
+	KeyFile  string `toml:"key_file"`
+	// Dir - Directory containing fullchain.pem and privkey.pem, used instead of cert_file and key_file
+	Dir string `toml:"dir"`
+	// ReloadInterval - How often certificate files are checked for changes
+	ReloadInterval Duration `toml:"reload_interval"`
+	// RedirectAddr - Address of a plain HTTP listener redirecting to HTTPS, disabled if empty
+	RedirectAddr string `toml:"redirect_addr"`
+}
+
+// Database - Storage backend settings
+type Database struct {
+	// Store - Storage backend, either 'mariadb' or 'memory'
+	Store    string `toml:"store"`
+	User     string `toml:"user"`
+	Password string `toml:"password"`
+	// Host - MariaDB address (host:port or an absolute unix socket path), the driver's default is used if empty
+	Host string `toml:"host"`
+	Name string `toml:"name"`
+}
+
+// CORS - Cross-origin resource sharing settings (reloadable)
+type CORS struct {
+	AllowedOrigins []string `toml:"allowed_origins"`
+}
+
+// Headers allowed in cross-origin requests, and response headers readable by cross-origin clients
+const (
+	CORSAllowHeaders  = "Content-Type, CSRF-Token, If-Match, If-None-Match, Last-Event-ID"
+	CORSExposeHeaders = "ETag, Link"
+)
+
+// Allows - Report whether requests from origin are allowed
+func (c CORS) Allows(origin string) bool {
+	for _, allowed := range c.AllowedOrigins {
+		if origin == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// Log - Logging settings (only the level is reloadable)
+type Log struct {
+	// File - File path for logging output, stdout is used if empty
+	File           string   `toml:"file"`
+	Level          string   `toml:"level"`
+	MaxFileSize    int64    `toml:"max_file_size"`
+	RotationPeriod Duration `toml:"rotation_period"`
+}
+
+// Limits - Per user limits (reloadable)
+type Limits struct {
+	MaxPendingChallenges uint `toml:"max_pending_challenges"`
+	MaxFailedChallenges  uint `toml:"max_failed_challenges"`
+	// MaxBodySize - Maximum size in bytes of request bodies, for routes that don't set their own limit
+	MaxBodySize int64 `toml:"max_body_size"`
+	// MaxEventStreams - Maximum number of /events streams each user can have open on an instance
+	MaxEventStreams int `toml:"max_event_streams"`
+}
+
+// RateLimit - Per route rate limiting settings (all reloadable except backend and redis_addr)
+// Policies are formatted as "<requests>/<period> <key>", where key is ip, user, or ip+user
+type RateLimit struct {
+	Enabled bool `toml:"enabled"`
+	// Backend - Where token buckets are stored, either 'memory' (per instance) or 'redis' (shared between instances)
+	Backend   string `toml:"backend"`
+	RedisAddr string `toml:"redis_addr"`
+	// Default - Policy for routes without their own policy
+	Default string `toml:"default"`
+	// Authenticate - Policy applied by IP to every request to an authenticated route before its credentials are checked,
+	// so that floods of invalid credentials are refused without a session lookup each
+	Authenticate string `toml:"authenticate"`
+	// Routes - Route specific policies, formatted as "<METHOD>:<path> <policy>" (e.g. "POST:/register 5/1h ip")
+	Routes []string `toml:"routes"`
+}
+
+// Trash - Settings for deleted todo lists and tags (reloadable)
+type Trash struct {
+	// RetentionDays - Days resources are kept in the trash before they're purged automatically, or 0 to keep them until they're purged explicitly
+	RetentionDays uint `toml:"retention_days"`
+}
+
+// Retention - Return how long resources are kept in the trash, or 0 if they aren't purged automatically
+func (t Trash) Retention() time.Duration {
+	return time.Duration(t.RetentionDays) * 24 * time.Hour
+}
+
+// PolicyFor - Return the policy for a route (formatted as in routes.Map, e.g. PATCH:/todos/{id})
+func (r RateLimit) PolicyFor(route string) string {
+	for _, entry := range r.Routes {
+		if fields := strings.SplitN(entry, " ", 2); len(fields) == 2 && fields[0] == route {
+			return strings.TrimSpace(fields[1])
+		}
+	}
+	return r.Default
+}
+
+// Duration - time.Duration that can be decoded from a string such as "10s" or "1h30m"
+type Duration struct {
+	time.Duration
+}
+
+// UnmarshalText - Parse a duration string
+func (d *Duration) UnmarshalText(text []byte) (e error) {
+	d.Duration, e = time.ParseDuration(string(text))
+	return e
+}
+
+// MarshalText - Format a duration as a string
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+// LogLevels - Valid log levels, from most to least verbose
+var LogLevels = []string{"debug", "info", "warn", "error"}
+
+// Prefix of every environment variable override, variables are named CSPLAN_<SECTION>_<KEY> (e.g CSPLAN_SERVER_ADDR)
+const envPrefix = "CSPLAN_"
+
+// Defaults - Return the configuration used for any value not set by a file or environment variable
+func Defaults() *Config {
+	return &Config{
+		Server: Server{
+			Addr:            ":3000",
+			ReadTimeout:     Duration{time.Second},
+			WriteTimeout:    Duration{time.Second * 10},
+			IdleTimeout:     Duration{time.Minute},
+			AdminAddr:       "127.0.0.1:9090",
+			ShutdownTimeout: Duration{time.Second * 15},
+			TrustedProxies:  Networks{"127.0.0.1", "::1"}},
+		TLS: TLS{
+			ReloadInterval: Duration{time.Minute}},
+		Database: Database{
+			Store: "mariadb",
+			User:  "admin",
+			Name:  "CSplanGo"},
+		CORS: CORS{
+			AllowedOrigins: []string{"https://csplan.co", "https://localhost:3030", "http://localhost:3030"}},
+		Log: Log{
+			Level:          "info",
+			MaxFileSize:    500000,
+			RotationPeriod: Duration{time.Hour}},
+		Limits: Limits{
+			MaxPendingChallenges: 5,
+			MaxFailedChallenges:  10,
+			MaxBodySize:          1 << 20,
+			MaxEventStreams:      5},
+		RateLimit: RateLimit{
+			Enabled:      true,
+			Backend:      "memory",
+			RedisAddr:    "localhost:6379",
+			Default:      "120/1m ip+user",
+			Authenticate: "600/1m ip",
+			Routes: []string{
+				"POST:/register 5/1h ip",
+				"POST:/challenge 10/1m ip",
+				"POST:/challenge/{id} 10/1m ip",
+				"POST:/totp 10/1m user",
+				"DELETE:/delete_my_account_please 5/1h user"}},
+		Trash: Trash{
+			RetentionDays: 30}}
+}
+
+// Load - Build a configuration from defaults, the TOML file at path (if path isn't empty), and environment overrides
+// The result isn't validated, so that callers can apply their own overrides (such as flags) first
+func Load(path string) (*Config, error) {
+	c := Defaults()
+	if len(path) > 0 {
+		meta, err := toml.DecodeFile(path, c)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to parse config file '%s': %s", path, err)
+		}
+		if undecoded := meta.Undecoded(); len(undecoded) > 0 {
+			return nil, fmt.Errorf("Unknown key '%s' in config file '%s'", undecoded[0], path)
+		}
+	}
+	// Supported for compatibility with deployments that predate the config file
+	if password, ok := os.LookupEnv("MARIADB_PASSWORD"); ok {
+		c.Database.Password = password
+	}
+	if err := applyEnv(c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// applyEnv - Override each field of c that has a corresponding environment variable set
+func applyEnv(c *Config) error {
+	root := reflect.ValueOf(c).Elem()
+	return eachField(func(section, key string, index []int) error {
+		name := envPrefix + strings.ToUpper(section+"_"+key)
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			return nil
+		}
+		if err := setField(root.FieldByIndex(index), value); err != nil {
+			return fmt.Errorf("Invalid value for %s: %s", name, err)
+		}
+		return nil
+	})
+}
+
+// eachField - Call fn with the TOML section, key, and reflect index of every configurable field
+func eachField(fn func(section, key string, index []int) error) error {
+	root := reflect.TypeOf(Config{})
+	for i := 0; i < root.NumField(); i++ {
+		section := root.Field(i)
+		for j := 0; j < section.Type.NumField(); j++ {
+			key := section.Type.Field(j).Tag.Get("toml")
+			if err := fn(section.Tag.Get("toml"), key, []int{i, j}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// setField - Parse value into field according to its type
+func setField(field reflect.Value, value string) error {
+	if unmarshaler, ok := field.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return unmarshaler.UnmarshalText([]byte(value))
+	}
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Int, reflect.Int64:
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		field.SetInt(parsed)
+	case reflect.Uint, reflect.Uint64:
+		parsed, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		field.SetUint(parsed)
+	case reflect.Bool:
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(parsed)
+	case reflect.Slice:
+		// Lists are comma separated
+		var items []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); len(item) > 0 {
+				items = append(items, item)
+			}
+		}
+		field.Set(reflect.ValueOf(items).Convert(field.Type()))
+	default:
+		return fmt.Errorf("unsupported type %s", field.Type())
+	}
+	return nil
+}
+
+// Validate - Check that every value in c is usable, returning an error describing every invalid value
+func (c *Config) Validate() error {
+	var problems []string
+	invalid := func(format string, a ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, a...))
+	}
+
+	if len(c.Server.Addr) == 0 {
+		invalid("server.addr must not be empty")
+	}
+	if len(c.Server.AdminAddr) > 0 && c.Server.AdminAddr == c.Server.Addr {
+		invalid("server.admin_addr must be different from server.addr")
+	}
+	if c.Server.ReadTimeout.Duration <= 0 || c.Server.WriteTimeout.Duration <= 0 || c.Server.IdleTimeout.Duration <= 0 ||
+		c.Server.ShutdownTimeout.Duration <= 0 {
+		invalid("server timeouts must be positive")
+	}
+	for _, network := range c.Server.TrustedProxies {
+		if _, _, err := net.ParseCIDR(network); err != nil && net.ParseIP(network) == nil {
+			invalid("server.trusted_proxies contains an invalid address or CIDR range '%s'", network)
+		}
+	}
+
+	if c.TLS.Enabled {
+		switch {
+		case len(c.TLS.Dir) > 0 && len(c.TLS.CertFile)+len(c.TLS.KeyFile) > 0:
+			invalid("tls.dir can't be used along with tls.cert_file or tls.key_file")
+		case len(c.TLS.Dir) == 0 && (len(c.TLS.CertFile) == 0 || len(c.TLS.KeyFile) == 0):
+			invalid("tls requires either both cert_file and key_file, or dir")
+		}
+		if c.TLS.ReloadInterval.Duration <= 0 {
+			invalid("tls.reload_interval must be positive")
+		}
+		if c.TLS.RedirectAddr == c.Server.Addr {
+			invalid("tls.redirect_addr must be different from server.addr")
+		}
+	}
+
+	switch c.Database.Store {
+	case "mariadb":
+		if len(c.Database.User) == 0 || len(c.Database.Name) == 0 {
+			invalid("database.user and database.name are required when using the mariadb store")
+		}
+	case "memory":
+	default:
+		invalid("database.store must be either 'mariadb' or 'memory', got '%s'", c.Database.Store)
+	}
+
+	for _, origin := range c.CORS.AllowedOrigins {
+		u, err := url.Parse(origin)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 || len(u.Path) > 0 {
+			invalid("cors.allowed_origins contains an invalid origin '%s' (expected scheme://host[:port])", origin)
+		}
+	}
+
+	if !validLevel(c.Log.Level) {
+		invalid("log.level must be one of %s, got '%s'", strings.Join(LogLevels, ", "), c.Log.Level)
+	}
+	if c.Log.MaxFileSize <= 0 || c.Log.RotationPeriod.Duration <= 0 {
+		invalid("log.max_file_size and log.rotation_period must be positive")
+	}
+
+	if c.Limits.MaxPendingChallenges == 0 || c.Limits.MaxFailedChallenges == 0 {
+		invalid("challenge limits must be positive")
+	}
+	if c.Limits.MaxBodySize <= 0 {
+		invalid("limits.max_body_size must be positive")
+	}
+	if c.Limits.MaxEventStreams <= 0 {
+		invalid("limits.max_event_streams must be positive")
+	}
+
+	switch c.RateLimit.Backend {
+	case "memory":
+	case "redis":
+		if len(c.RateLimit.RedisAddr) == 0 {
+			invalid("rate_limit.redis_addr is required when using the redis backend")
+		}
+	default:
+		invalid("rate_limit.backend must be either 'memory' or 'redis', got '%s'", c.RateLimit.Backend)
+	}
+	if _, err := ratelimit.ParsePolicy(c.RateLimit.Default); err != nil {
+		invalid("rate_limit.default is invalid: %s", err)
+	}
+	if policy, err := ratelimit.ParsePolicy(c.RateLimit.Authenticate); err != nil {
+		invalid("rate_limit.authenticate is invalid: %s", err)
+	} else if policy.Key != ratelimit.ByIP {
+		invalid("rate_limit.authenticate must be keyed by ip, since it's applied before the user is known")
+	}
+	for _, entry := range c.RateLimit.Routes {
+		fields := strings.SplitN(entry, " ", 2)
+		if len(fields) != 2 || !strings.Contains(fields[0], ":/") {
+			invalid("rate_limit.routes entry '%s' must be formatted as '<METHOD>:<path> <policy>'", entry)
+		} else if _, err := ratelimit.ParsePolicy(fields[1]); err != nil {
+			invalid("rate_limit.routes entry '%s' is invalid: %s", entry, err)
+		}
+	}
+
+	if len(problems) > 0 {
+		return errors.New("Invalid configuration:\n\t" + strings.Join(problems, "\n\t"))
+	}
+	return nil
+}
+
+func validLevel(level string) bool {
+	for _, l := range LogLevels {
+		if level == l {
+			return true
+		}
+	}
+	return false
+}
+
+var current atomic.Value
+
+// Current - Return the active configuration, which must not be modified
+func Current() *Config {
+	if c, ok := current.Load().(*Config); ok {
+		return c
+	}
+	return Defaults()
+}
+
+// Set - Validate and activate c
+func Set(c *Config) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	current.Store(c)
+	return nil
+}
+
+// Reload - Validate c and activate its reloadable fields (cors, log.level, limits, rate_limit policies, and trash)
+// The keys of any other changed fields are returned, as they can't take effect without a restart
+func Reload(c *Config) (ignored []string, e error) {
+	if e = c.Validate(); e != nil {
+		return nil, e
+	}
+	next := *Current()
+	next.CORS = c.CORS
+	next.Log.Level = c.Log.Level
+	next.Limits = c.Limits
+	next.RateLimit.Enabled = c.RateLimit.Enabled
+	next.RateLimit.Default = c.RateLimit.Default
+	next.RateLimit.Routes = c.RateLimit.Routes
+	next.Trash = c.Trash
+
+	// Report changes that were left out
+	applied, requested := reflect.ValueOf(&next).Elem(), reflect.ValueOf(c).Elem()
+	eachField(func(section, key string, index []int) error {
+		if !reflect.DeepEqual(applied.FieldByIndex(index).Interface(), requested.FieldByIndex(index).Interface()) {
+			ignored = append(ignored, section+"."+key)
+		}
+		return nil
+	})
+	current.Store(&next)
+	return ignored, nil
+}
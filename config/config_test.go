@@ -0,0 +1,103 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func writeConfig(t *testing.T, contents string) string {
+	path := filepath.Join(t.TempDir(), "config.toml")
+	if err := ioutil.WriteFile(path, []byte(contents), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	t.Run("Example", func(t *testing.T) {
+		c, err := Load("../config.example.toml")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(c, Defaults()) {
+			t.Error("Expected config.example.toml to match the default config")
+		}
+	})
+	t.Run("File And Environment", func(t *testing.T) {
+		path := writeConfig(t, `
+[server]
+addr = ":4000"
+write_timeout = "30s"
+
+[database]
+name = "CSplanTest"`)
+		os.Setenv("CSPLAN_DATABASE_NAME", "CSplanEnv")
+		os.Setenv("CSPLAN_CORS_ALLOWED_ORIGINS", "https://a.test, https://b.test")
+		defer os.Unsetenv("CSPLAN_DATABASE_NAME")
+		defer os.Unsetenv("CSPLAN_CORS_ALLOWED_ORIGINS")
+
+		c, err := Load(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.Server.Addr != ":4000" || c.Server.WriteTimeout.Duration != time.Second*30 {
+			t.Error("Expected values from the config file to be used")
+		}
+		if c.Server.ReadTimeout.Duration != time.Second {
+			t.Error("Expected defaults to be used for values missing from the config file")
+		}
+		if c.Database.Name != "CSplanEnv" {
+			t.Error("Expected environment variables to override the config file")
+		}
+		if !reflect.DeepEqual(c.CORS.AllowedOrigins, []string{"https://a.test", "https://b.test"}) {
+			t.Errorf("Unexpected allowed origins %v", c.CORS.AllowedOrigins)
+		}
+	})
+	t.Run("Unknown Key", func(t *testing.T) {
+		if _, err := Load(writeConfig(t, "[server]\nadress = \":4000\"")); err == nil {
+			t.Error("Expected unknown keys to be rejected")
+		}
+	})
+}
+
+func TestValidate(t *testing.T) {
+	c := Defaults()
+	c.Database.Store = "postgres"
+	c.CORS.AllowedOrigins = []string{"csplan.co"}
+	c.Log.Level = "verbose"
+	c.RateLimit.Routes = []string{"POST:/register 5/hour ip"}
+	if err := c.Validate(); err == nil {
+		t.Error("Expected an invalid config to fail validation")
+	}
+	if err := Defaults().Validate(); err != nil {
+		t.Errorf("Expected the default config to be valid, got %s", err)
+	}
+}
+
+func TestReload(t *testing.T) {
+	if err := Set(Defaults()); err != nil {
+		t.Fatal(err)
+	}
+	c := Defaults()
+	c.Server.Addr = ":4000"
+	c.CORS.AllowedOrigins = []string{"https://a.test"}
+	c.Log.Level = "debug"
+	c.RateLimit.Backend = "redis"
+	c.RateLimit.Default = "1/1s ip"
+
+	ignored, err := Reload(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ignored, []string{"server.addr", "rate_limit.backend"}) {
+		t.Errorf("Expected only server.addr and rate_limit.backend to be ignored, got %v", ignored)
+	}
+	if current := Current(); current.Server.Addr != ":3000" || !current.CORS.Allows("https://a.test") || current.Log.Level != "debug" ||
+		current.RateLimit.Backend != "memory" || current.RateLimit.Default != "1/1s ip" {
+		t.Error("Expected only reloadable fields to be applied")
+	}
+}
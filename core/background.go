@@ -0,0 +1,52 @@
+package core
+
+import (
+	"context"
+	"sync"
+)
+
+// Background workers and tasks are tracked so that shutdown can wait for them to finish instead of killing them mid-write
+var background sync.WaitGroup
+var backgroundCtx, stopBackground = context.WithCancel(context.Background())
+
+// Closed once shutdown begins, so that long lived requests (such as event streams) can end instead of holding up draining
+var shuttingDown = make(chan struct{})
+var shutdownOnce sync.Once
+
+// BeginShutdown - Signal long lived requests to end
+func BeginShutdown() {
+	shutdownOnce.Do(func() {
+		close(shuttingDown)
+	})
+}
+
+// ShuttingDown - Return a channel that's closed once shutdown begins
+func ShuttingDown() <-chan struct{} {
+	return shuttingDown
+}
+
+// Go - Run fn in a tracked background goroutine
+// ctx is canceled once shutdown begins, long running workers must return when it's done
+func Go(fn func(ctx context.Context)) {
+	background.Add(1)
+	go func() {
+		defer background.Done()
+		fn(backgroundCtx)
+	}()
+}
+
+// StopBackground - Signal every background worker to stop, then wait for all of them to return or for ctx to be done
+func StopBackground(ctx context.Context) error {
+	stopBackground()
+	done := make(chan struct{})
+	go func() {
+		background.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,27 @@
+package core
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestStopBackground(t *testing.T) {
+	var worker, task bool
+	Go(func(ctx context.Context) {
+		<-ctx.Done()
+		worker = true
+	})
+	Go(func(context.Context) {
+		time.Sleep(time.Millisecond * 10)
+		task = true
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := StopBackground(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if !worker || !task {
+		t.Error("Expected StopBackground to wait for every background goroutine")
+	}
+}
@@ -0,0 +1,61 @@
+package core
+
+// Kinds of resources tracked in the change log
+const (
+	KindTodoList = "todo"
+	KindTag      = "tag"
+	KindNoList   = "nolist"
+	KindName     = "name"
+	KindKeys     = "keys"
+)
+
+// ChangeStore - Per user log of changes to synced resources (todo lists, tags, nolist, names, and keys), used for delta sync
+// Changes are logged by the store itself whenever a synced resource is created, modified, or deleted,
+// and only the latest change to each resource is kept (deletions are kept as tombstones)
+type ChangeStore interface {
+	// ListChanges - Retrieve up to limit of a user's changes with a sequence number greater than since, in sequence order
+	ListChanges(userID uint, since uint64, limit int) ([]ChangeRecord, error)
+	// ChangeSeq - Retrieve the sequence number of a user's latest change, or 0 if nothing has changed
+	ChangeSeq(userID uint) (uint64, error)
+	// AsSession - Return a view of the store that attributes the changes it logs to a session
+	AsSession(sessionID uint) Store
+	// SetNotifier - Set the notifier told about users with new changes once they're committed, must be called before the store is used
+	SetNotifier(n Notifier)
+}
+
+// Notifier - Told about each user with new changes after the transaction logging them commits
+// Notify is called once per transaction for each user with changes, and must not block
+type Notifier interface {
+	Notify(userID uint)
+}
+
+// ChangeRecord - The latest change to a resource
+type ChangeRecord struct {
+	UserID uint
+	Kind   string
+	// ResourceID - ID of the resource, or 0 for resources each user only has one of (nolist, name, and keys)
+	ResourceID uint
+	// Seq - Position of the change in the user's change log, sequence numbers increase monotonically in commit order
+	Seq uint64
+	// CreatedSeq - Sequence number of the change that created the resource, or 0 if it was created before changes were logged
+	CreatedSeq uint64
+	Deleted    bool
+	// SessionID - Session that made the change, or 0 if it wasn't made by a user's session
+	SessionID uint
+}
+
+type changeOp int
+
+const (
+	opCreate changeOp = iota
+	opUpdate
+	opDelete
+)
+
+// itemParentKind - Return the kind of the resource containing an item, changes to items are logged as updates to it
+func itemParentKind(item TodoItemRecord) string {
+	if item.ListID == 0 {
+		return KindNoList
+	}
+	return KindTodoList
+}
@@ -0,0 +1,104 @@
+package core
+
+// Error codes - Stable, machine readable identifiers for every kind of error the API sends
+// Codes must never be changed or reused once released, clients depend on them to decide how to handle errors
+const (
+	CodeInternal             = "internal_error"
+	CodeBadRequest           = "bad_request"
+	CodeValidation           = "validation_failed"
+	CodeMalformedJSON        = "malformed_json"
+	CodeBodyTooLarge         = "body_too_large"
+	CodeMalformedID          = "malformed_id"
+	CodeInvalidAction        = "invalid_action"
+	CodeUnsupportedMediaType = "unsupported_media_type"
+	CodeRouteNotFound        = "route_not_found"
+	CodeNotFound             = "not_found"
+	CodeConflict             = "already_exists"
+	CodeIncompletePatch      = "incomplete_patch"
+	CodePreconditionFailed   = "precondition_failed"
+	CodeInvalidCursor        = "invalid_cursor"
+	CodeRateLimited          = "rate_limited"
+	CodeStreamLimit          = "stream_limit"
+	CodeOrderMismatch        = "order_mismatch"
+
+	CodeUnauthorized       = "unauthorized"
+	CodeForbidden          = "forbidden"
+	CodeAuthBypassDisabled = "auth_bypass_disabled"
+	CodeUserExists         = "user_exists"
+	CodeUserNotFound       = "user_not_found"
+	CodeInvalidHashParams  = "invalid_hash_params"
+	CodeChallengeFailed    = "challenge_failed"
+	CodeChallengeLimit     = "challenge_limit"
+	CodeTOTPRequired       = "totp_required"
+	CodeTOTPInvalid        = "totp_invalid"
+	CodeTOTPNotEnabled     = "totp_not_enabled"
+	CodeTOTPEnabled        = "totp_already_enabled"
+	CodeDeleteTokenExists  = "delete_token_exists"
+	CodeDeleteTokenInvalid = "delete_token_invalid"
+)
+
+// ErrorInfo - Catalog entry describing an error code
+type ErrorInfo struct {
+	Code        string `json:"code"`
+	Type        string `json:"type"`
+	Title       string `json:"title"`
+	Status      int    `json:"status"`
+	Description string `json:"description"`
+}
+
+// ErrorTypeBase - Base of the URI reference sent as the type of each problem, the catalog entry for a code is served at ErrorTypeBase + code
+const ErrorTypeBase = "/errors/"
+
+func entry(code, title string, status int, description string) ErrorInfo {
+	return ErrorInfo{
+		Code:        code,
+		Type:        ErrorTypeBase + code,
+		Title:       title,
+		Status:      status,
+		Description: description}
+}
+
+// ErrorCatalog - Every error code the API can send
+var ErrorCatalog = []ErrorInfo{
+	entry(CodeInternal, "Internal Server Error", 500, "An unexpected server side error occurred. Include the requestId when reporting it."),
+	entry(CodeBadRequest, "Bad Request", 400, "The request couldn't be understood."),
+	entry(CodeValidation, "Validation Error", 400, "The request body failed validation. The errors array lists each invalid field."),
+	entry(CodeMalformedJSON, "Malformed JSON", 400, "The request body isn't valid JSON, contains fields the route doesn't accept, or has data after the JSON value. The detail gives the line and column of the problem."),
+	entry(CodeBodyTooLarge, "Payload Too Large", 413, "The request body exceeds the maximum size allowed for the route."),
+	entry(CodeMalformedID, "Bad Request", 400, "An ID in the request path is missing or malformed."),
+	entry(CodeInvalidAction, "Invalid Action Parameter", 422, "The ?action= query parameter is missing or not supported by the route."),
+	entry(CodeUnsupportedMediaType, "Unsupported Media Type", 415, "The request body must be sent as application/json."),
+	entry(CodeRouteNotFound, "Not Found", 404, "No route matches the requested method and path."),
+	entry(CodeNotFound, "Not Found", 404, "The requested resource doesn't exist."),
+	entry(CodeConflict, "Resource Conflict", 409, "The resource being created already exists, and must be updated instead."),
+	entry(CodeIncompletePatch, "Precondition Failed", 412, "The patch doesn't contain every field that must be updated together."),
+	entry(CodePreconditionFailed, "Precondition Failed", 412, "The resource has been modified since the checksum sent in If-Match was retrieved. The current checksum is sent in the checksum member and the ETag header."),
+	entry(CodeInvalidCursor, "Invalid Cursor", 400, "A pagination cursor is malformed, or a sync cursor is malformed or ahead of the account's change log. Request the first page again, or sync again without a cursor to retrieve every resource."),
+	entry(CodeRateLimited, "Too Many Requests", 429, "The route's rate limit has been exceeded. Retry after the number of seconds in the Retry-After header."),
+	entry(CodeStreamLimit, "Too Many Requests", 429, "The account already has the maximum number of event streams open. Close one before opening another."),
+	entry(CodeOrderMismatch, "Order Mismatch", 409, "The order doesn't contain every one of the account's resources exactly once. Retrieve them again and send their complete order."),
+
+	entry(CodeUnauthorized, "Unauthorized", 401, "Authorization tokens are missing or invalid. Log in again."),
+	entry(CodeForbidden, "Forbidden", 403, "The session's authentication level is insufficient for the route."),
+	entry(CodeAuthBypassDisabled, "Unauthorized", 401, "Logging in without a challenge isn't enabled on this server."),
+	entry(CodeUserExists, "Resource Conflict", 409, "An account with the email already exists."),
+	entry(CodeUserNotFound, "Not Found", 404, "No account with the email exists."),
+	entry(CodeInvalidHashParams, "Validation Error", 400, "The hash parameters are unsupported or exceed their limits."),
+	entry(CodeChallengeFailed, "Challenge Failed", 401, "The challenge was decrypted incorrectly, most likely due to an incorrect password."),
+	entry(CodeChallengeLimit, "Too Many Requests", 429, "Too many pending or failed challenges exist for the account."),
+	entry(CodeTOTPRequired, "Precondition Failed", 412, "TOTP is enabled for the account, and a TOTP or backup code must be sent to log in."),
+	entry(CodeTOTPInvalid, "Unauthorized", 401, "The TOTP or backup code is incorrect."),
+	entry(CodeTOTPNotEnabled, "Precondition Failed", 412, "TOTP isn't enabled for the account."),
+	entry(CodeTOTPEnabled, "Resource Conflict", 409, "TOTP is already enabled for the account."),
+	entry(CodeDeleteTokenExists, "Resource Conflict", 409, "A deletion token has already been issued, and expires after 5 minutes."),
+	entry(CodeDeleteTokenInvalid, "Forbidden", 403, "The deletion confirmation token is incorrect.")}
+
+// LookupError - Find the catalog entry for an error code
+func LookupError(code string) (info ErrorInfo, ok bool) {
+	for _, info = range ErrorCatalog {
+		if info.Code == code {
+			return info, true
+		}
+	}
+	return ErrorInfo{}, false
+}
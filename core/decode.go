@@ -0,0 +1,130 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// DecodeJSON - Strictly decode the JSON body of r into v, then validate v
+// Unknown fields, trailing data, and bodies exceeding the limit set by http.MaxBytesReader are rejected
+func DecodeJSON(r *http.Request, v interface{}) *HTTPError {
+	// The body is read in full so that decode errors can be reported by line and column
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		// MaxBytesReader's error isn't exported until go1.19
+		if strings.Contains(err.Error(), "request body too large") {
+			return &HTTPError{
+				Code:    CodeBodyTooLarge,
+				Title:   "Payload Too Large",
+				Message: "The request body exceeds the maximum size allowed for this route",
+				Status:  413}
+		}
+		return &HTTPError{
+			Code:    CodeBadRequest,
+			Title:   "Bad Request",
+			Message: "Failed to read the request body",
+			Status:  400}
+	}
+	if len(bytes.TrimSpace(body)) == 0 {
+		return malformedJSON("The request body is empty")
+	}
+	return DecodeJSONBytes(body, v)
+}
+
+// DecodeJSONBytes - Strictly decode body into v, then validate v
+// Used for JSON embedded in a request body, such as the data of each change pushed to /sync
+func DecodeJSONBytes(body []byte, v interface{}) *HTTPError {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return decodeError(body, err)
+	}
+	// Only whitespace is allowed after the value
+	if _, err := decoder.Token(); err != io.EOF {
+		line, col := position(body, decoder.InputOffset())
+		return malformedJSON(fmt.Sprintf("Unexpected data after the JSON value at line %d, column %d", line, col))
+	}
+	return ValidateStruct(v)
+}
+
+func malformedJSON(msg string) *HTTPError {
+	return &HTTPError{
+		Code:    CodeMalformedJSON,
+		Title:   "Malformed JSON",
+		Message: msg,
+		Status:  400}
+}
+
+// decodeError - Describe an error returned by json.Decoder
+func decodeError(body []byte, err error) *HTTPError {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxErr):
+		// The offset of syntax errors is just past the offending byte
+		line, col := position(body, syntaxErr.Offset-1)
+		return malformedJSON(fmt.Sprintf("Invalid JSON at line %d, column %d: %s", line, col, strings.TrimPrefix(syntaxErr.Error(), "json: ")))
+
+	case errors.As(err, &typeErr):
+		line, col := position(body, typeErr.Offset)
+		httpErr := malformedJSON(fmt.Sprintf("Wrong type at line %d, column %d", line, col))
+		httpErr.Errors = []FieldError{{
+			Field:   typeErr.Field,
+			Rule:    "type",
+			Param:   typeErr.Type.String(),
+			Message: fmt.Sprintf("must be %s, got %s", typeName(typeErr.Type.Kind().String()), typeErr.Value)}}
+		return httpErr
+
+	case err == io.ErrUnexpectedEOF:
+		return malformedJSON("The request body ends in the middle of a JSON value")
+
+	// Unknown field errors have no dedicated type
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		field := strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`)
+		httpErr := malformedJSON(fmt.Sprintf("Unknown field '%s'", field))
+		httpErr.Errors = []FieldError{{
+			Field:   field,
+			Rule:    "unknown",
+			Message: "isn't accepted by this route"}}
+		return httpErr
+	}
+	return malformedJSON(strings.TrimPrefix(err.Error(), "json: "))
+}
+
+// typeName - Describe a Go kind as a JSON type
+func typeName(kind string) string {
+	switch {
+	case kind == "string":
+		return "a string"
+	case kind == "bool":
+		return "a boolean"
+	case kind == "slice" || kind == "array":
+		return "an array"
+	case kind == "struct" || kind == "map":
+		return "an object"
+	case strings.HasPrefix(kind, "int") || strings.HasPrefix(kind, "uint"):
+		return "an integer"
+	case strings.HasPrefix(kind, "float"):
+		return "a number"
+	}
+	return kind
+}
+
+// position - Convert a byte offset in body to a 1-indexed line and column
+func position(body []byte, offset int64) (line, col int) {
+	if offset > int64(len(body)) {
+		offset = int64(len(body))
+	} else if offset < 0 {
+		offset = 0
+	}
+	before := body[:offset]
+	line = bytes.Count(before, []byte("\n")) + 1
+	col = int(offset) - bytes.LastIndexByte(before, '\n')
+	return line, col
+}
@@ -0,0 +1,116 @@
+package core
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDecodeJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		limit   int64
+		status  int
+		code    string
+		message string
+		errors  []FieldError
+	}{
+		{
+			name: "Valid",
+			body: `{"title": "dGl0bGU=", "tags": []}` + "\n"},
+		{
+			name:    "Empty",
+			body:    " ",
+			status:  400,
+			code:    CodeMalformedJSON,
+			message: "The request body is empty"},
+		{
+			name:    "Syntax Error",
+			body:    "{\n  \"title\": \"dGl0bGU=\",\n  \"tags\": [,]\n}",
+			status:  400,
+			code:    CodeMalformedJSON,
+			message: "Invalid JSON at line 3, column 12: invalid character ',' looking for beginning of value"},
+		{
+			name:    "Truncated",
+			body:    `{"title": "dGl0bGU="`,
+			status:  400,
+			code:    CodeMalformedJSON,
+			message: "The request body ends in the middle of a JSON value"},
+		{
+			name:    "Wrong Type",
+			body:    `{"title": 5}`,
+			status:  400,
+			code:    CodeMalformedJSON,
+			message: "Wrong type at line 1, column 12",
+			errors: []FieldError{{
+				Field:   "title",
+				Rule:    "type",
+				Param:   "string",
+				Message: "must be a string, got number"}}},
+		{
+			name:    "Unknown Field",
+			body:    `{"title": "dGl0bGU=", "color": "#fff"}`,
+			status:  400,
+			code:    CodeMalformedJSON,
+			message: "Unknown field 'color'",
+			errors: []FieldError{{
+				Field:   "color",
+				Rule:    "unknown",
+				Message: "isn't accepted by this route"}}},
+		{
+			name:    "Trailing Data",
+			body:    `{"title": "dGl0bGU="} {}`,
+			status:  400,
+			code:    CodeMalformedJSON,
+			message: "Unexpected data after the JSON value at line 1, column 24"},
+		{
+			name:   "Too Large",
+			body:   `{"title": "dGl0bGU="}`,
+			limit:  8,
+			status: 413,
+			code:   CodeBodyTooLarge},
+		{
+			name:   "Invalid",
+			body:   `{"title": "!"}`,
+			status: 400,
+			code:   CodeValidation,
+			errors: []FieldError{{
+				Field:   "title",
+				Rule:    "base64",
+				Message: "must be base64 encoded"}}}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/", strings.NewReader(test.body))
+			if test.limit > 0 {
+				r.Body = http.MaxBytesReader(httptest.NewRecorder(), r.Body, test.limit)
+			}
+			var v validated
+			err := DecodeJSON(r, &v)
+			if test.status == 0 {
+				if err != nil {
+					t.Fatalf("Expected the body to be decoded, got %v", err)
+				}
+				if v.Title != "dGl0bGU=" {
+					t.Errorf("Expected the title to be decoded, got '%s'", v.Title)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("Expected the body to be rejected")
+			}
+			if err.Status != test.status || err.Code != test.code {
+				t.Errorf("Expected a %d %s error, got %d %s", test.status, test.code, err.Status, err.Code)
+			}
+			if len(test.message) > 0 && err.Message != test.message {
+				t.Errorf("Expected message '%s', got '%s'", test.message, err.Message)
+			}
+			if !reflect.DeepEqual(err.Errors, test.errors) {
+				t.Errorf("Expected field errors %+v, got %+v", test.errors, err.Errors)
+			}
+		})
+	}
+}
@@ -3,17 +3,38 @@ package core
 import (
 	"encoding/json"
 	"fmt"
-	"log"
 	"net/http"
+	"reflect"
+	"strings"
 
 	"github.com/go-playground/validator"
 )
 
-// HTTPError - Error to be sent as JSON over HTTP
+// HTTPError - Error to be sent over HTTP as an RFC 7807 problem (application/problem+json)
 type HTTPError struct {
-	Title   string `json:"title"`
+	// Type - URI reference identifying the kind of problem, derived from Code
+	Type  string `json:"type"`
+	Code  string `json:"code"`
+	Title string `json:"title"`
+	// Message - Human readable explanation of this occurrence of the problem, also sent as detail
 	Message string `json:"message"`
+	Detail  string `json:"detail"`
 	Status  int    `json:"status"`
+	// Errors - Each invalid field, for validation errors
+	Errors []FieldError `json:"errors,omitempty"`
+	// Checksum - Current checksum of the resource, for precondition failures
+	Checksum string `json:"checksum,omitempty"`
+	// RequestID - ID of the request that caused the error, for correlation with server logs
+	RequestID string `json:"requestId,omitempty"`
+}
+
+// FieldError - A single field that failed validation
+type FieldError struct {
+	// Field - Path to the field using JSON names, e.g. items[0].title
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Param   string `json:"param,omitempty"`
+	Message string `json:"message"`
 }
 
 func (e HTTPError) Error() string {
@@ -24,36 +45,123 @@ var validate *validator.Validate
 
 func init() {
 	validate = validator.New()
+	// Report fields by the names clients send them as
+	validate.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			return ""
+		} else if len(name) == 0 {
+			return field.Name
+		}
+		return name
+	})
 }
 
 func ServerErrorFrom(e error) HTTPError {
 	return HTTPError{
+		Code:    CodeInternal,
 		Title:   "Internal Server Error",
 		Message: e.Error(),
 		Status:  500}
 }
 
-// WriteError - Write a JSON formatted error to w
+// WriteError - Write an application/problem+json formatted error to w
+// The type, title, and status are filled in from the error catalog if not set
 func WriteError(w http.ResponseWriter, e HTTPError) {
+	if len(e.Code) == 0 {
+		e.Code = CodeBadRequest
+		if e.Status >= 500 {
+			e.Code = CodeInternal
+		}
+	}
+	if info, ok := LookupError(e.Code); ok {
+		e.Type = info.Type
+		if len(e.Title) == 0 {
+			e.Title = info.Title
+		}
+		if e.Status == 0 {
+			e.Status = info.Status
+		}
+	}
+	if len(e.Message) == 0 {
+		e.Message = e.Title
+	}
+	e.Detail = e.Message
+	if len(e.Checksum) > 0 {
+		w.Header().Set("ETag", ETag(e.Checksum))
+	}
+	// The request ID header is set by middleware before any handler runs
+	e.RequestID = w.Header().Get("X-Request-ID")
+	w.Header().Set("Content-Type", "application/problem+json")
 	w.WriteHeader(e.Status)
 	json.NewEncoder(w).Encode(e)
 }
 
-// ValidateStruct - Validate struct s and write a JSON formatted error to w if validation fails
+// ValidateStruct - Validate struct s, returning a validation error listing each invalid field if validation fails
 func ValidateStruct(s interface{}) *HTTPError {
 	err := validate.Struct(s)
-	if err != nil {
-		return &HTTPError{
-			Title:   "Validation Error",
-			Message: err.Error(),
-			Status:  400}
+	if err == nil {
+		return nil
+	}
+	httpErr := &HTTPError{
+		Code:    CodeValidation,
+		Title:   "Validation Error",
+		Message: "One or more fields are invalid",
+		Status:  400}
+	validationErrors, ok := err.(validator.ValidationErrors)
+	if !ok {
+		httpErr.Message = err.Error()
+		return httpErr
+	}
+	for _, fe := range validationErrors {
+		httpErr.Errors = append(httpErr.Errors, FieldError{
+			Field:   fieldPath(fe.Namespace()),
+			Rule:    fe.Tag(),
+			Param:   fe.Param(),
+			Message: fieldMessage(fe)})
+	}
+	return httpErr
+}
+
+// fieldPath - Remove the top level struct name from a validator namespace (List.items[0].title becomes items[0].title)
+func fieldPath(namespace string) string {
+	if i := strings.Index(namespace, "."); i >= 0 {
+		return namespace[i+1:]
+	}
+	return namespace
+}
+
+// fieldMessage - Describe why a field failed validation
+func fieldMessage(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required":
+		return "is required"
+	case "base64":
+		return "must be base64 encoded"
+	case "email":
+		return "must be an email address"
+	case "oneof":
+		return "must be one of: " + fe.Param()
+	case "max", "min", "len":
+		comparison := map[string]string{
+			"max": "at most",
+			"min": "at least",
+			"len": "exactly"}[fe.Tag()]
+		switch fe.Kind() {
+		case reflect.String:
+			return fmt.Sprintf("must be %s %s characters long", comparison, fe.Param())
+		case reflect.Slice, reflect.Array, reflect.Map:
+			return fmt.Sprintf("must contain %s %s items", comparison, fe.Param())
+		}
+		return fmt.Sprintf("must be %s %s", comparison, fe.Param())
 	}
-	return nil
+	return fmt.Sprintf("failed the %s rule", fe.Tag())
 }
 
 // WriteError400 - Write a JSON formatted bad request error with msg to w
 func WriteError400(w http.ResponseWriter, msg string) {
 	WriteError(w, HTTPError{
+		Code:    CodeBadRequest,
 		Title:   "Bad Request",
 		Message: msg,
 		Status:  400})
@@ -62,13 +170,14 @@ func WriteError400(w http.ResponseWriter, msg string) {
 // WriteError500 - Write a JSON formatted internal server error based on error e to w
 func WriteError500(w http.ResponseWriter, e error) {
 	// Because these errors are at the server's fault, it is important to log their messages to gain an idea of where errors are frequently occuring
-	log.Println(e)
+	Logger{}.With("request_id", w.Header().Get("X-Request-ID")).Errorf("%s", e)
 	WriteError(w, ServerErrorFrom(e))
 }
 
 // WriteError404 - Write a JSON formatted 404 error to w
 func WriteError404(w http.ResponseWriter) {
 	WriteError(w, HTTPError{
+		Code:    CodeNotFound,
 		Title:   "Not Found",
 		Message: "The requested resource was unable to be found",
 		Status:  404})
@@ -0,0 +1,85 @@
+package core
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+type validated struct {
+	Title string   `json:"title" validate:"required,base64,max=8"`
+	Tags  []string `json:"tags" validate:"max=1,dive,base64"`
+}
+
+func TestValidateStruct(t *testing.T) {
+	if err := ValidateStruct(validated{Title: "dGl0bGU="}); err != nil {
+		t.Fatalf("Expected a valid struct to pass validation, got %v", err)
+	}
+
+	err := ValidateStruct(validated{
+		Title: "not base64 and too long",
+		Tags:  []string{"dGFn", "!"}})
+	if err == nil {
+		t.Fatal("Expected an invalid struct to fail validation")
+	}
+	if err.Code != CodeValidation || err.Status != 400 {
+		t.Errorf("Expected a 400 %s error, got %d %s", CodeValidation, err.Status, err.Code)
+	}
+	expected := []FieldError{
+		{Field: "title", Rule: "base64", Message: "must be base64 encoded"},
+		{Field: "tags", Rule: "max", Param: "1", Message: "must contain at most 1 items"}}
+	if !reflect.DeepEqual(err.Errors, expected) {
+		t.Errorf("Expected field errors %+v, got %+v", expected, err.Errors)
+	}
+
+	err = ValidateStruct(validated{
+		Tags: []string{"!"}})
+	expected = []FieldError{
+		{Field: "title", Rule: "required", Message: "is required"},
+		{Field: "tags[0]", Rule: "base64", Message: "must be base64 encoded"}}
+	if !reflect.DeepEqual(err.Errors, expected) {
+		t.Errorf("Expected field errors %+v, got %+v", expected, err.Errors)
+	}
+}
+
+func TestWriteError(t *testing.T) {
+	w := httptest.NewRecorder()
+	w.Header().Set("X-Request-ID", "abc")
+	WriteError(w, HTTPError{
+		Code:    CodeTOTPRequired,
+		Message: "TOTP code required to log in."})
+
+	if w.Code != 412 {
+		t.Errorf("Expected the status to be filled in from the catalog, got %d", w.Code)
+	}
+	if contentType := w.Header().Get("Content-Type"); contentType != "application/problem+json" {
+		t.Errorf("Expected content type application/problem+json, got %s", contentType)
+	}
+	var problem map[string]interface{}
+	json.NewDecoder(w.Body).Decode(&problem)
+	expected := map[string]interface{}{
+		"type":      ErrorTypeBase + CodeTOTPRequired,
+		"code":      CodeTOTPRequired,
+		"title":     "Precondition Failed",
+		"status":    float64(412),
+		"detail":    "TOTP code required to log in.",
+		"message":   "TOTP code required to log in.",
+		"requestId": "abc"}
+	if !reflect.DeepEqual(problem, expected) {
+		t.Errorf("Expected problem %v, got %v", expected, problem)
+	}
+}
+
+func TestErrorCatalog(t *testing.T) {
+	seen := make(map[string]bool)
+	for _, info := range ErrorCatalog {
+		if seen[info.Code] {
+			t.Errorf("Duplicate error code %s", info.Code)
+		}
+		seen[info.Code] = true
+		if len(info.Title) == 0 || len(info.Description) == 0 || info.Status < 400 {
+			t.Errorf("Incomplete catalog entry for %s", info.Code)
+		}
+	}
+}
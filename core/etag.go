@@ -0,0 +1,74 @@
+package core
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// ETag - Format a resource's checksum as a strong entity tag
+func ETag(checksum string) string {
+	return `"` + checksum + `"`
+}
+
+// matchETag - Report whether an If-Match/If-None-Match header value matches etag
+// Weak tags only match when weak comparison is used (RFC 7232 section 2.3.2)
+func matchETag(header, etag string, weak bool) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" {
+			return true
+		}
+		if strings.HasPrefix(tag, "W/") {
+			if !weak {
+				continue
+			}
+			tag = tag[2:]
+		}
+		if tag == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// IfMatch - Check a request's If-Match header against the current checksum of the resource it modifies,
+// returning a precondition failure (as an HTTPError) if the resource has been modified since the client retrieved it
+// Requests without the header are always allowed, sending it is how clients opt in to optimistic concurrency
+func IfMatch(r *http.Request, checksum string) error {
+	header := strings.Join(r.Header.Values("If-Match"), ",")
+	if len(header) == 0 || matchETag(header, ETag(checksum), false) {
+		return nil
+	}
+	return HTTPError{
+		Code:     CodePreconditionFailed,
+		Title:    "Precondition Failed",
+		Message:  "The resource has been modified since it was retrieved",
+		Status:   412,
+		Checksum: checksum}
+}
+
+// NotModified - Send checksum as the response's ETag, and report whether the client's cached copy (identified by If-None-Match) is current
+// If it is, a 304 has been sent and the handler must return without writing a body
+func NotModified(w http.ResponseWriter, r *http.Request, checksum string) bool {
+	etag := ETag(checksum)
+	w.Header().Set("ETag", etag)
+	// If-None-Match uses weak comparison
+	if header := strings.Join(r.Header.Values("If-None-Match"), ","); len(header) > 0 && matchETag(header, etag, true) {
+		w.WriteHeader(304)
+		return true
+	}
+	return false
+}
+
+// CollectionChecksum - Checksum of a collection, derived from the state of each member in order
+// Each member's state must include its ID and checksum, along with anything sent that its checksum doesn't cover (such as its index or key)
+func CollectionChecksum(members [][]string) string {
+	hash := sha1.New()
+	for _, member := range members {
+		// Fields are delimited so that different collections can't produce the same input
+		hash.Write([]byte(strings.Join(member, "\x00") + "\n"))
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
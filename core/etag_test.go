@@ -0,0 +1,79 @@
+package core
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIfMatch(t *testing.T) {
+	const checksum = "da39a3ee5e6b4b0d3255bfef95601890afd80709"
+	tests := []struct {
+		header string
+		match  bool
+	}{
+		{"", true},
+		{"*", true},
+		{`"` + checksum + `"`, true},
+		{`"other", "` + checksum + `"`, true},
+		{`"other"`, false},
+		{checksum, false},
+		// Weak tags never match If-Match
+		{`W/"` + checksum + `"`, false}}
+
+	for _, test := range tests {
+		r := httptest.NewRequest("PATCH", "/", nil)
+		if len(test.header) > 0 {
+			r.Header.Set("If-Match", test.header)
+		}
+		err := IfMatch(r, checksum)
+		if test.match && err != nil {
+			t.Errorf("Expected If-Match: %s to match, got %v", test.header, err)
+		} else if !test.match {
+			httpErr, ok := err.(HTTPError)
+			if !ok || httpErr.Status != 412 || httpErr.Checksum != checksum {
+				t.Errorf("Expected If-Match: %s to fail with a 412 containing the checksum, got %v", test.header, err)
+			}
+		}
+	}
+}
+
+func TestNotModified(t *testing.T) {
+	const checksum = "da39a3ee5e6b4b0d3255bfef95601890afd80709"
+	for header, notModified := range map[string]bool{
+		"":                            false,
+		`"other"`:                     false,
+		`"` + checksum + `"`:          true,
+		`W/"` + checksum + `"`:        true,
+		`"other", "` + checksum + `"`: true} {
+		r := httptest.NewRequest("GET", "/", nil)
+		if len(header) > 0 {
+			r.Header.Set("If-None-Match", header)
+		}
+		w := httptest.NewRecorder()
+		if NotModified(w, r, checksum) != notModified {
+			t.Errorf("Expected NotModified to return %t for If-None-Match: %s", notModified, header)
+		}
+		if w.Header().Get("ETag") != ETag(checksum) {
+			t.Errorf("Expected ETag %s, got '%s'", ETag(checksum), w.Header().Get("ETag"))
+		}
+		if notModified && w.Code != 304 {
+			t.Errorf("Expected a 304, got %d", w.Code)
+		}
+	}
+}
+
+func TestCollectionChecksum(t *testing.T) {
+	a := CollectionChecksum([][]string{{"a", "1"}, {"b", "2"}})
+	if a != CollectionChecksum([][]string{{"a", "1"}, {"b", "2"}}) {
+		t.Error("Expected equal collections to have equal checksums")
+	}
+	for _, other := range [][][]string{
+		{{"b", "2"}, {"a", "1"}},
+		{{"a", "1"}},
+		{{"a1"}, {"b", "2"}},
+		{{"a", "1", "b", "2"}}} {
+		if CollectionChecksum(other) == a {
+			t.Errorf("Expected %v to have a different checksum", other)
+		}
+	}
+}
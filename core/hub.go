@@ -0,0 +1,67 @@
+package core
+
+import (
+	"context"
+	"sync"
+)
+
+var _ Notifier = &Hub{}
+
+// Hub - Wakes up subscribers (such as event streams) when a user's changes are committed
+// Subscribers are only woken by changes committed through this instance's store, and should also poll the change log to see changes made through other instances
+type Hub struct {
+	mu          sync.Mutex
+	subscribers map[uint]map[chan struct{}]bool
+}
+
+// NewHub - Create a hub without any subscribers
+func NewHub() *Hub {
+	return &Hub{
+		subscribers: make(map[uint]map[chan struct{}]bool)}
+}
+
+// Notify - Wake up every subscriber for a user
+func (h *Hub) Notify(userID uint) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subscribers[userID] {
+		// Wake-ups are coalesced, a subscriber that hasn't handled its last wake-up doesn't need another
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Subscribe - Subscribe to wake-ups for a user, unless they already have limit subscribers
+// The returned function must be called to unsubscribe
+func (h *Hub) Subscribe(userID uint, limit int) (wake <-chan struct{}, unsubscribe func(), ok bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.subscribers[userID]) >= limit {
+		return nil, nil, false
+	}
+	ch := make(chan struct{}, 1)
+	if h.subscribers[userID] == nil {
+		h.subscribers[userID] = make(map[chan struct{}]bool)
+	}
+	h.subscribers[userID][ch] = true
+	return ch, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		delete(h.subscribers[userID], ch)
+		if len(h.subscribers[userID]) == 0 {
+			delete(h.subscribers, userID)
+		}
+	}, true
+}
+
+// HubFrom - Retrieve the hub attached to a request context
+func HubFrom(ctx context.Context) *Hub {
+	return ctx.Value(Key("hub")).(*Hub)
+}
+
+// WithHub - Attach a hub to a context
+func WithHub(ctx context.Context, h *Hub) context.Context {
+	return context.WithValue(ctx, Key("hub"), h)
+}
@@ -0,0 +1,33 @@
+package core
+
+import "testing"
+
+func TestHub(t *testing.T) {
+	hub := NewHub()
+	wake, unsubscribe, ok := hub.Subscribe(1, 2)
+	if !ok {
+		t.Fatal("Expected the first subscription to be allowed")
+	}
+	if _, _, ok = hub.Subscribe(1, 1); ok {
+		t.Error("Expected subscriptions over the limit to be refused")
+	}
+
+	t.Run("Notify", func(t *testing.T) {
+		// Wake-ups are coalesced until they're received
+		hub.Notify(1)
+		hub.Notify(1)
+		hub.Notify(2)
+		<-wake
+		select {
+		case <-wake:
+			t.Error("Expected a single wake-up")
+		default:
+		}
+	})
+	t.Run("Unsubscribe", func(t *testing.T) {
+		unsubscribe()
+		if _, _, ok := hub.Subscribe(1, 1); !ok {
+			t.Error("Expected unsubscribing to free up a subscription")
+		}
+	})
+}
@@ -4,7 +4,6 @@ import (
 	"crypto/rand"
 	"encoding/base64"
 	"encoding/binary"
-	"fmt"
 )
 
 // MakeID - Generate a uint ID of a specified number of digits
@@ -30,22 +29,16 @@ func DecodeID(encoded string) (id uint, e error) {
 	return uint(binary.LittleEndian.Uint64(bytes)), err
 }
 
-// MakeUniqueID - Return an ID that is unique at the time of calling, and any database related errors
-// DO NOT CALL THIS WITH USER PROVIDED DATA, THE PROVIDED TABLE NAME IS INTERPOLATED INTO A QUERY WITH NO ESCAPING
-func MakeUniqueID(tableName string) (id uint, e error) {
+// MakeUniqueID - Return an ID that is unique within the given table at the time of calling, and any storage related errors
+func MakeUniqueID(s Store, tableName string) (id uint, e error) {
 	id = MakeID()
 	for {
-		rows, e := DB.Query(fmt.Sprintf("SELECT 1 FROM %s WHERE ID = ?", tableName), id)
-		if rows == nil {
-			panic(fmt.Sprintf("MakeUniqueID call returned nil rows, it is likely the table '%s' doesn't exist.", tableName))
-		}
-		defer rows.Close()
-		if rows == nil || !rows.Next() {
+		exists, err := s.IDExists(tableName, id)
+		if err != nil {
+			return id, err
+		} else if !exists {
 			return id, nil
-		} else if e != nil {
-			return id, e
-		} else {
-			id++
 		}
+		id++
 	}
 }
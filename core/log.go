@@ -0,0 +1,194 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/very-amused/CSplan-API/config"
+)
+
+// Logger - Leveled logger writing JSON lines, attaching a set of fields to every message
+type Logger struct {
+	fields map[string]interface{}
+}
+
+// Serializes writes to the standard logger's output (which is swapped by log rotation)
+var logMu sync.Mutex
+
+// With - Return a copy of l that also attaches key to every message
+func (l Logger) With(key string, value interface{}) Logger {
+	fields := make(map[string]interface{}, len(l.fields)+1)
+	for k, v := range l.fields {
+		fields[k] = v
+	}
+	fields[key] = value
+	return Logger{fields}
+}
+
+// levelRank - Position of a log level in config.LogLevels (higher is more severe)
+func levelRank(level string) int {
+	for i, l := range config.LogLevels {
+		if l == level {
+			return i
+		}
+	}
+	return 0
+}
+
+// Log - Write a message with additional fields if level is at or above the configured log level
+// The level is read on every call so that it can be changed by reloading the config
+func (l Logger) Log(level string, msg string, fields map[string]interface{}) {
+	if levelRank(level) < levelRank(config.Current().Log.Level) {
+		return
+	}
+	var line bytes.Buffer
+	line.WriteString(`{"time":`)
+	writeJSON(&line, time.Now().UTC().Format(time.RFC3339Nano))
+	line.WriteString(`,"level":`)
+	writeJSON(&line, level)
+	line.WriteString(`,"msg":`)
+	writeJSON(&line, msg)
+
+	// Fields passed to Log take precedence over fields attached with With
+	merged := make(map[string]interface{}, len(l.fields)+len(fields))
+	for k, v := range l.fields {
+		merged[k] = v
+	}
+	for k, v := range fields {
+		merged[k] = v
+	}
+	keys := make([]string, 0, len(merged))
+	for k := range merged {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		line.WriteString(",")
+		writeJSON(&line, k)
+		line.WriteString(":")
+		writeJSON(&line, merged[k])
+	}
+	line.WriteString("}\n")
+
+	logMu.Lock()
+	defer logMu.Unlock()
+	log.Writer().Write(line.Bytes())
+}
+
+func writeJSON(buf *bytes.Buffer, v interface{}) {
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		encoded, _ = json.Marshal(fmt.Sprint(v))
+	}
+	buf.Write(encoded)
+}
+
+// Debugf - Log a debug message
+func (l Logger) Debugf(format string, a ...interface{}) {
+	l.Log("debug", fmt.Sprintf(format, a...), nil)
+}
+
+// Infof - Log an informational message
+func (l Logger) Infof(format string, a ...interface{}) {
+	l.Log("info", fmt.Sprintf(format, a...), nil)
+}
+
+// Warnf - Log a warning
+func (l Logger) Warnf(format string, a ...interface{}) {
+	l.Log("warn", fmt.Sprintf(format, a...), nil)
+}
+
+// Errorf - Log an error
+func (l Logger) Errorf(format string, a ...interface{}) {
+	l.Log("error", fmt.Sprintf(format, a...), nil)
+}
+
+// Debugf - Log a debug message without any request context
+func Debugf(format string, a ...interface{}) {
+	Logger{}.Debugf(format, a...)
+}
+
+// Infof - Log an informational message without any request context
+func Infof(format string, a ...interface{}) {
+	Logger{}.Infof(format, a...)
+}
+
+// Warnf - Log a warning without any request context
+func Warnf(format string, a ...interface{}) {
+	Logger{}.Warnf(format, a...)
+}
+
+// Errorf - Log an error without any request context
+func Errorf(format string, a ...interface{}) {
+	Logger{}.Errorf(format, a...)
+}
+
+// RequestInfo - Per-request state shared between middleware and route handlers
+// Handlers fill in details (such as the authenticated user) that middleware reads once the request has been handled
+type RequestInfo struct {
+	ID     string
+	UserID uint
+	// LogIP - Whether the authenticated user has consented to IP logging
+	LogIP  bool
+	Logger Logger
+}
+
+// SetUser - Record the authenticated user making the request, attaching their ID to the request's logger
+func (info *RequestInfo) SetUser(userID uint) {
+	info.UserID = userID
+	info.Logger = info.Logger.With("user_id", userID)
+}
+
+// WithRequestInfo - Attach request info to a context
+func WithRequestInfo(ctx context.Context, info *RequestInfo) context.Context {
+	return context.WithValue(ctx, Key("request"), info)
+}
+
+// RequestInfoFrom - Retrieve the request info attached to a context, or an empty placeholder if there is none
+func RequestInfoFrom(ctx context.Context) *RequestInfo {
+	if info, ok := ctx.Value(Key("request")).(*RequestInfo); ok {
+		return info
+	}
+	return &RequestInfo{}
+}
+
+// LoggerFrom - Retrieve the logger for the request a context belongs to
+func LoggerFrom(ctx context.Context) Logger {
+	return RequestInfoFrom(ctx).Logger
+}
+
+// ClientIP - Return the IP address a request was made from
+// X-Forwarded-For is only used for requests made through a proxy listed in server.trusted_proxies, so that clients can't spoof their address
+// This must only be logged or stored for users that have enabled IP logging
+func ClientIP(r *http.Request) string {
+	ip := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		ip = host
+	}
+	trusted := config.Current().Server.TrustedProxies
+	if !trusted.Contains(ip) {
+		return ip
+	}
+	// Proxies append the address they received the request from, so the client is the last address not added by a trusted proxy
+	forwarded := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		addr := strings.TrimSpace(forwarded[i])
+		if len(addr) == 0 {
+			continue
+		}
+		ip = addr
+		if !trusted.Contains(addr) {
+			break
+		}
+	}
+	return ip
+}
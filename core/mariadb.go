@@ -0,0 +1,777 @@
+package core
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+)
+
+var _ Store = &MariaDB{}
+
+// MariaDB - Store backed by a MariaDB connection pool
+type MariaDB struct {
+	db *sqlx.DB
+	tx *sqlx.Tx
+	// Changes logged by this view are attributed to session
+	session  uint
+	notifier Notifier
+	// changed - Users with changes logged in the transaction, notified after commit
+	changed map[uint]bool
+}
+
+// NewMariaDB - Create a store using an existing connection pool
+func NewMariaDB(db *sqlx.DB) *MariaDB {
+	return &MariaDB{db: db}
+}
+
+// ext - Return the transaction if one is open, otherwise the connection pool
+func (m *MariaDB) ext() sqlx.Ext {
+	if m.tx != nil {
+		return m.tx
+	}
+	return m.db
+}
+
+// forUpdate - Lock selected rows until the end of the transaction (if there is one)
+func (m *MariaDB) forUpdate() string {
+	if m.tx != nil {
+		return " FOR UPDATE"
+	}
+	return ""
+}
+
+func (m *MariaDB) get(dest interface{}, query string, args ...interface{}) error {
+	err := sqlx.Get(m.ext(), dest, query, args...)
+	if err == sql.ErrNoRows {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (m *MariaDB) selectAll(dest interface{}, query string, args ...interface{}) error {
+	return sqlx.Select(m.ext(), dest, query, args...)
+}
+
+func (m *MariaDB) exec(query string, args ...interface{}) error {
+	_, err := m.ext().Exec(query, args...)
+	return err
+}
+
+// execCount - Execute a query, returning the number of rows affected
+func (m *MariaDB) execCount(query string, args ...interface{}) (int64, error) {
+	result, err := m.ext().Exec(query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// execOne - Execute a query, returning ErrNotFound if no rows were affected
+func (m *MariaDB) execOne(query string, args ...interface{}) error {
+	result, err := m.ext().Exec(query, args...)
+	if err != nil {
+		return err
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Atomic - Run fn in a transaction
+func (m *MariaDB) Atomic(fn func(s Store) error) error {
+	if m.tx != nil {
+		return fn(m)
+	}
+	tx, err := m.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	view := &MariaDB{
+		db:       m.db,
+		tx:       tx,
+		session:  m.session,
+		notifier: m.notifier,
+		changed:  make(map[uint]bool)}
+	if err = fn(view); err != nil {
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+	if m.notifier != nil {
+		for userID := range view.changed {
+			m.notifier.Notify(userID)
+		}
+	}
+	return nil
+}
+
+// IDExists - Check whether an ID is in use
+func (m *MariaDB) IDExists(table string, id uint) (bool, error) {
+	switch table {
+	case "Users", "Sessions", "Challenges", "TodoLists", "TodoItems", "Tags":
+	default:
+		return false, fmt.Errorf("IDExists called with unknown table '%s'", table)
+	}
+	rows, err := m.ext().Query(fmt.Sprintf("SELECT 1 FROM %s WHERE ID = ?", table), id)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+	return rows.Next(), nil
+}
+
+// CreateUser - Insert a user and their auth key (settings are created by the CreateSettings trigger)
+func (m *MariaDB) CreateUser(user UserRecord, key AuthKeyRecord) error {
+	return m.Atomic(func(s Store) error {
+		tx := s.(*MariaDB)
+		err := tx.exec("INSERT INTO Users (ID, Email, Verified) VALUES (?, ?, ?)", user.ID, user.Email, user.Verified)
+		if err != nil {
+			return err
+		}
+		return tx.exec("INSERT INTO AuthKeys (UserID, AuthKey, HashParams) VALUES (?, ?, ?)",
+			user.ID, key.AuthKey, key.HashParams)
+	})
+}
+
+// GetUser - Retrieve a user by ID
+func (m *MariaDB) GetUser(id uint) (user UserRecord, e error) {
+	e = m.get(&user, "SELECT ID, Email, Verified FROM Users WHERE ID = ?", id)
+	return user, e
+}
+
+// GetUserByEmail - Retrieve a user by email
+func (m *MariaDB) GetUserByEmail(email string) (user UserRecord, e error) {
+	e = m.get(&user, "SELECT ID, Email, Verified FROM Users WHERE Email = ?", email)
+	return user, e
+}
+
+// DeleteUser - Delete a user and everything belonging to them
+func (m *MariaDB) DeleteUser(id uint) error {
+	// Tables are listed in an order that doesn't violate any foreign key constraints
+	queries := []string{
+		"DELETE FROM TodoItems WHERE UserID = ?",
+		"DELETE FROM TodoLists WHERE UserID = ?",
+		"DELETE FROM Tags WHERE UserID = ?",
+		"DELETE FROM Names WHERE UserID = ?",
+		"DELETE FROM AuthKeys WHERE UserID = ?",
+		"DELETE FROM TOTP WHERE UserID = ?",
+		"DELETE FROM CryptoKeys WHERE UserID = ?",
+		"DELETE FROM DeleteTokens WHERE UserID = ?",
+		"DELETE FROM Sessions WHERE UserID = ?",
+		"DELETE FROM Challenges WHERE UserID = ?",
+		"DELETE FROM NoList WHERE UserID = ?",
+		"DELETE FROM Settings WHERE UserID = ?",
+		"DELETE FROM Changes WHERE UserID = ?",
+		"DELETE FROM ChangeSeqs WHERE UserID = ?",
+		"DELETE FROM Users WHERE ID = ?"}
+	return m.Atomic(func(s Store) error {
+		tx := s.(*MariaDB)
+		for _, query := range queries {
+			if err := tx.exec(query, id); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// GetAuthKey - Retrieve a user's auth key
+func (m *MariaDB) GetAuthKey(userID uint) (key AuthKeyRecord, e error) {
+	e = m.get(&key, "SELECT UserID, AuthKey, HashParams FROM AuthKeys WHERE UserID = ?", userID)
+	return key, e
+}
+
+// UpdateAuthKey - Replace a user's auth key
+func (m *MariaDB) UpdateAuthKey(key AuthKeyRecord) error {
+	return m.exec("UPDATE AuthKeys SET AuthKey = ?, HashParams = ? WHERE UserID = ?", key.AuthKey, key.HashParams, key.UserID)
+}
+
+// GetTOTP - Retrieve a user's TOTP info
+func (m *MariaDB) GetTOTP(userID uint) (totp TOTPRecord, e error) {
+	e = m.get(&totp, "SELECT UserID, _Secret, BackupCodes FROM TOTP WHERE UserID = ?"+m.forUpdate(), userID)
+	return totp, e
+}
+
+// CreateTOTP - Enable TOTP for a user
+func (m *MariaDB) CreateTOTP(totp TOTPRecord) error {
+	return m.exec("INSERT INTO TOTP (UserID, _Secret, BackupCodes) VALUES (?, ?, ?)", totp.UserID, totp.Secret, totp.BackupCodes)
+}
+
+// UpdateTOTP - Update a user's remaining backup codes
+func (m *MariaDB) UpdateTOTP(totp TOTPRecord) error {
+	return m.exec("UPDATE TOTP SET BackupCodes = ? WHERE UserID = ?", totp.BackupCodes, totp.UserID)
+}
+
+// DeleteTOTP - Disable TOTP for a user
+func (m *MariaDB) DeleteTOTP(userID uint) error {
+	return m.exec("DELETE FROM TOTP WHERE UserID = ?", userID)
+}
+
+// GetDeleteToken - Retrieve a user's pending delete token
+func (m *MariaDB) GetDeleteToken(userID uint) (token DeleteTokenRecord, e error) {
+	e = m.get(&token, "SELECT UserID, Token, _Timestamp FROM DeleteTokens WHERE UserID = ?", userID)
+	return token, e
+}
+
+// CreateDeleteToken - Store a delete token for a user
+func (m *MariaDB) CreateDeleteToken(token DeleteTokenRecord) error {
+	return m.exec("INSERT INTO DeleteTokens (UserID, Token, _Timestamp) VALUES (?, ?, ?)", token.UserID, token.Token, token.Timestamp)
+}
+
+// DeleteExpiredDeleteTokens - Delete tokens created before the given time
+func (m *MariaDB) DeleteExpiredDeleteTokens(createdBefore uint) (int64, error) {
+	return m.execCount("DELETE FROM DeleteTokens WHERE _Timestamp < ?", createdBefore)
+}
+
+// CreateSession - Store a new session
+func (m *MariaDB) CreateSession(session SessionRecord) error {
+	return m.exec("INSERT INTO Sessions (ID, UserID, Token, CSRFtoken, Created, LastUsed, DeviceInfo) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		session.ID, session.UserID, session.Token, session.CSRFtoken, session.Created, session.LastUsed, session.DeviceInfo)
+}
+
+// GetSession - Retrieve a session belonging to a user
+func (m *MariaDB) GetSession(id, userID uint) (session SessionRecord, e error) {
+	e = m.get(&session, `SELECT Sessions.ID, Sessions.UserID, Token, CSRFtoken, Created, LastUsed, DeviceInfo, COALESCE(Settings.EnableIPLogging, FALSE) AS EnableIPLogging
+		FROM Sessions LEFT JOIN Settings ON Settings.UserID = Sessions.UserID
+		WHERE Sessions.ID = ? AND Sessions.UserID = ?`, id, userID)
+	return session, e
+}
+
+// ListSessions - Retrieve all of a user's sessions
+func (m *MariaDB) ListSessions(userID uint) (sessions []SessionRecord, e error) {
+	e = m.selectAll(&sessions, "SELECT ID, UserID, Token, CSRFtoken, Created, LastUsed, DeviceInfo FROM Sessions WHERE UserID = ?", userID)
+	return sessions, e
+}
+
+// TouchSession - Update a session's last use time
+func (m *MariaDB) TouchSession(id, lastUsed uint) error {
+	return m.exec("UPDATE Sessions SET LastUsed = ? WHERE ID = ?", lastUsed, id)
+}
+
+// CountSessions - Count the sessions that have been used since the given time
+func (m *MariaDB) CountSessions(usedSince uint) (count uint, e error) {
+	e = m.get(&count, "SELECT COUNT(*) FROM Sessions WHERE LastUsed >= ?", usedSince)
+	return count, e
+}
+
+// DeleteExpiredSessions - Delete sessions that are both old and not in use
+func (m *MariaDB) DeleteExpiredSessions(createdBefore, lastUsedBefore uint) (int64, error) {
+	return m.execCount("DELETE FROM Sessions WHERE Created <= ? AND LastUsed <= ?", createdBefore, lastUsedBefore)
+}
+
+// DeleteSession - Delete a session belonging to a user
+func (m *MariaDB) DeleteSession(id, userID uint) error {
+	return m.execOne("DELETE FROM Sessions WHERE ID = ? AND UserID = ?", id, userID)
+}
+
+// CreateChallenge - Store a new challenge
+func (m *MariaDB) CreateChallenge(challenge ChallengeRecord) error {
+	return m.exec("INSERT INTO Challenges (ID, UserID, _Data, Failed, _Timestamp) VALUES (?, ?, ?, ?, ?)",
+		challenge.ID, challenge.UserID, challenge.Data, challenge.Failed, challenge.Timestamp)
+}
+
+// GetChallenge - Retrieve a challenge
+func (m *MariaDB) GetChallenge(id uint) (challenge ChallengeRecord, e error) {
+	e = m.get(&challenge, "SELECT ID, UserID, _Data, Failed, _Timestamp FROM Challenges WHERE ID = ?", id)
+	return challenge, e
+}
+
+// CountChallenges - Count a user's pending and failed challenges
+func (m *MariaDB) CountChallenges(userID uint) (pending, failed uint, e error) {
+	if e = m.get(&pending, "SELECT COUNT(ID) FROM Challenges WHERE UserID = ? AND Failed = 0", userID); e != nil {
+		return 0, 0, e
+	}
+	e = m.get(&failed, "SELECT COUNT(ID) FROM Challenges WHERE UserID = ? AND Failed = 1", userID)
+	return pending, failed, e
+}
+
+// FailChallenge - Mark a challenge as failed
+func (m *MariaDB) FailChallenge(id uint) error {
+	return m.exec("UPDATE Challenges SET Failed = 1 WHERE ID = ?", id)
+}
+
+// DeleteChallenge - Delete a challenge
+func (m *MariaDB) DeleteChallenge(id uint) error {
+	return m.exec("DELETE FROM Challenges WHERE ID = ?", id)
+}
+
+// DeleteExpiredChallenges - Delete abandoned and old failed challenges
+func (m *MariaDB) DeleteExpiredChallenges(pendingBefore, failedBefore uint) (int64, error) {
+	return m.execCount("DELETE FROM Challenges WHERE (Failed = 0 AND _Timestamp < ?) OR (Failed = 1 AND _Timestamp < ?)",
+		pendingBefore, failedBefore)
+}
+
+const selectTodoItems = "SELECT ID, UserID, ListID, _Position, Title, Description, Done, Tags, CryptoKey FROM TodoItems"
+
+// createTodoItems - Insert items belonging to a newly created list (or nolist collection), logging no changes
+func (m *MariaDB) createTodoItems(userID, listID uint, items []TodoItemRecord) error {
+	for _, item := range items {
+		err := m.exec(`INSERT INTO TodoItems (ID, UserID, ListID, _Position, Title, Description, Done, Tags, CryptoKey)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			item.ID, userID, listID, item.Position, item.Title, item.Description, item.Done, item.Tags, item.CryptoKey)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getTodoItems - Retrieve the items of a list (or nolist collection) in position order
+func (m *MariaDB) getTodoItems(userID, listID uint) (items []TodoItemRecord, e error) {
+	e = m.selectAll(&items, selectTodoItems+" WHERE UserID = ? AND ListID = ? ORDER BY _Position, ID"+m.forUpdate(), userID, listID)
+	return items, e
+}
+
+// attachTodoItems - Retrieve the items of each of a user's lists
+func (m *MariaDB) attachTodoItems(userID uint, lists []TodoListRecord) error {
+	if len(lists) == 0 {
+		return nil
+	}
+	ids := make([]uint, len(lists))
+	byID := make(map[uint]*TodoListRecord, len(lists))
+	for i := range lists {
+		ids[i] = lists[i].ID
+		byID[lists[i].ID] = &lists[i]
+	}
+	query, args, err := sqlx.In(selectTodoItems+" WHERE UserID = ? AND ListID IN (?) ORDER BY _Position, ID"+m.forUpdate(), userID, ids)
+	if err != nil {
+		return err
+	}
+	var items []TodoItemRecord
+	if err = m.selectAll(&items, query, args...); err != nil {
+		return err
+	}
+	for _, item := range items {
+		list := byID[item.ListID]
+		list.Items = append(list.Items, item)
+	}
+	return nil
+}
+
+// todoListIndex - Select the position of each todo list in its user's lists
+const todoListIndex = `(SELECT COUNT(*) FROM TodoLists o WHERE o.UserID = l.UserID AND o.Trashed = 0 AND (o._Rank < l._Rank OR (o._Rank = l._Rank AND o.ID < l.ID))) AS _Index`
+
+// CreateTodoList - Store a new todo list and its items
+func (m *MariaDB) CreateTodoList(list TodoListRecord) error {
+	return m.Atomic(func(s Store) error {
+		tx := s.(*MariaDB)
+		_, err := tx.track(list.UserID, KindTodoList, list.ID, opCreate,
+			"INSERT INTO TodoLists (ID, UserID, Title, _Rank, CryptoKey) VALUES (?, ?, ?, ?, ?)",
+			list.ID, list.UserID, list.Title, list.Rank, list.CryptoKey)
+		if err != nil {
+			return err
+		}
+		return tx.createTodoItems(list.UserID, list.ID, list.Items)
+	})
+}
+
+// GetTodoList - Retrieve a todo list belonging to a user
+func (m *MariaDB) GetTodoList(id, userID uint) (list TodoListRecord, e error) {
+	if e = m.get(&list, "SELECT ID, UserID, Title, _Rank, CryptoKey, "+todoListIndex+" FROM TodoLists l WHERE ID = ? AND UserID = ? AND Trashed = 0"+m.forUpdate(), id, userID); e != nil {
+		return list, e
+	}
+	list.Items, e = m.getTodoItems(userID, id)
+	return list, e
+}
+
+// ListTodoLists - Retrieve all of a user's todo lists in rank order
+func (m *MariaDB) ListTodoLists(userID uint) (lists []TodoListRecord, e error) {
+	if e = m.selectAll(&lists, "SELECT ID, UserID, Title, _Rank, CryptoKey FROM TodoLists WHERE UserID = ? AND Trashed = 0 ORDER BY _Rank, ID"+m.forUpdate(), userID); e != nil {
+		return lists, e
+	}
+	for i := range lists {
+		lists[i].Index = uint(i)
+	}
+	return lists, m.attachTodoItems(userID, lists)
+}
+
+// PageTodoLists - Retrieve a page of a user's todo lists in rank order
+func (m *MariaDB) PageTodoLists(userID uint, afterRank string, afterID uint, limit int) (lists []TodoListRecord, e error) {
+	e = m.selectAll(&lists, `SELECT ID, UserID, Title, _Rank, CryptoKey, `+todoListIndex+` FROM TodoLists l
+		WHERE UserID = ? AND Trashed = 0 AND (_Rank > ? OR (_Rank = ? AND ID > ?)) ORDER BY _Rank, ID LIMIT ?`,
+		userID, afterRank, afterRank, afterID, limit)
+	if e != nil {
+		return lists, e
+	}
+	return lists, m.attachTodoItems(userID, lists)
+}
+
+// UpdateTodoList - Overwrite a todo list
+func (m *MariaDB) UpdateTodoList(list TodoListRecord) error {
+	_, err := m.track(list.UserID, KindTodoList, list.ID, opUpdate,
+		"UPDATE TodoLists SET Title = ?, _Rank = ?, CryptoKey = ? WHERE ID = ? AND UserID = ? AND Trashed = 0",
+		list.Title, list.Rank, list.CryptoKey, list.ID, list.UserID)
+	return err
+}
+
+// DeleteTodoList - Delete a todo list belonging to a user, along with its items
+func (m *MariaDB) DeleteTodoList(id, userID uint) error {
+	return m.Atomic(func(s Store) error {
+		tx := s.(*MariaDB)
+		if err := one(tx.track(userID, KindTodoList, id, opDelete, "DELETE FROM TodoLists WHERE ID = ? AND UserID = ? AND Trashed = 0", id, userID)); err != nil {
+			return err
+		}
+		return tx.exec("DELETE FROM TodoItems WHERE UserID = ? AND ListID = ?", userID, id)
+	})
+}
+
+// CreateTodoItem - Store a new item in an existing list (or nolist collection)
+func (m *MariaDB) CreateTodoItem(item TodoItemRecord) error {
+	_, err := m.track(item.UserID, itemParentKind(item), item.ListID, opUpdate,
+		`INSERT INTO TodoItems (ID, UserID, ListID, _Position, Title, Description, Done, Tags, CryptoKey)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		item.ID, item.UserID, item.ListID, item.Position, item.Title, item.Description, item.Done, item.Tags, item.CryptoKey)
+	return err
+}
+
+// GetTodoItem - Retrieve an item belonging to a user
+func (m *MariaDB) GetTodoItem(id, userID uint) (item TodoItemRecord, e error) {
+	e = m.get(&item, selectTodoItems+" WHERE ID = ? AND UserID = ?"+m.forUpdate(), id, userID)
+	return item, e
+}
+
+// UpdateTodoItem - Overwrite an item, logging an update to the list it was moved from (if it was moved)
+func (m *MariaDB) UpdateTodoItem(item TodoItemRecord) error {
+	return m.Atomic(func(s Store) error {
+		tx := s.(*MariaDB)
+		existing, err := tx.GetTodoItem(item.ID, item.UserID)
+		if err != nil {
+			return err
+		}
+		affected, err := tx.track(item.UserID, itemParentKind(item), item.ListID, opUpdate,
+			`UPDATE TodoItems SET ListID = ?, _Position = ?, Title = ?, Description = ?, Done = ?, Tags = ?, CryptoKey = ?
+			WHERE ID = ? AND UserID = ?`,
+			item.ListID, item.Position, item.Title, item.Description, item.Done, item.Tags, item.CryptoKey, item.ID, item.UserID)
+		if err != nil || affected == 0 || existing.ListID == item.ListID {
+			return err
+		}
+		return tx.logChange(item.UserID, itemParentKind(existing), existing.ListID, opUpdate)
+	})
+}
+
+// DeleteTodoItem - Delete an item belonging to a user
+func (m *MariaDB) DeleteTodoItem(id, userID uint) error {
+	return m.Atomic(func(s Store) error {
+		tx := s.(*MariaDB)
+		item, err := tx.GetTodoItem(id, userID)
+		if err != nil {
+			return err
+		}
+		return one(tx.track(userID, itemParentKind(item), item.ListID, opUpdate, "DELETE FROM TodoItems WHERE ID = ? AND UserID = ?", id, userID))
+	})
+}
+
+// CreateNoList - Store a user's nolist collection and its items
+func (m *MariaDB) CreateNoList(nolist NoListRecord) error {
+	return m.Atomic(func(s Store) error {
+		tx := s.(*MariaDB)
+		_, err := tx.track(nolist.UserID, KindNoList, 0, opCreate,
+			"INSERT INTO NoList (UserID, CryptoKey) VALUES (?, ?)", nolist.UserID, nolist.CryptoKey)
+		if err != nil {
+			return err
+		}
+		return tx.createTodoItems(nolist.UserID, 0, nolist.Items)
+	})
+}
+
+// GetNoList - Retrieve a user's nolist collection
+func (m *MariaDB) GetNoList(userID uint) (nolist NoListRecord, e error) {
+	if e = m.get(&nolist, "SELECT UserID, CryptoKey FROM NoList WHERE UserID = ?"+m.forUpdate(), userID); e != nil {
+		return nolist, e
+	}
+	nolist.Items, e = m.getTodoItems(userID, 0)
+	return nolist, e
+}
+
+// UpdateNoList - Overwrite a user's nolist collection
+func (m *MariaDB) UpdateNoList(nolist NoListRecord) error {
+	_, err := m.track(nolist.UserID, KindNoList, 0, opUpdate,
+		"UPDATE NoList SET CryptoKey = ? WHERE UserID = ?", nolist.CryptoKey, nolist.UserID)
+	return err
+}
+
+// CreateTag - Store a new tag
+func (m *MariaDB) CreateTag(tag TagRecord) error {
+	_, err := m.track(tag.UserID, KindTag, tag.ID, opCreate,
+		"INSERT INTO Tags (ID, UserID, Name, Color, CryptoKey) VALUES (?, ?, ?, ?, ?)",
+		tag.ID, tag.UserID, tag.Name, tag.Color, tag.CryptoKey)
+	return err
+}
+
+// GetTag - Retrieve a tag belonging to a user
+func (m *MariaDB) GetTag(id, userID uint) (tag TagRecord, e error) {
+	e = m.get(&tag, "SELECT ID, UserID, Name, Color, CryptoKey FROM Tags WHERE ID = ? AND UserID = ? AND Trashed = 0"+m.forUpdate(), id, userID)
+	return tag, e
+}
+
+// ListTags - Retrieve all of a user's tags
+func (m *MariaDB) ListTags(userID uint) (tags []TagRecord, e error) {
+	e = m.selectAll(&tags, "SELECT ID, UserID, Name, Color, CryptoKey FROM Tags WHERE UserID = ? AND Trashed = 0 ORDER BY ID", userID)
+	return tags, e
+}
+
+// PageTags - Retrieve a page of a user's tags in ID order
+func (m *MariaDB) PageTags(userID uint, afterID uint, limit int) (tags []TagRecord, e error) {
+	e = m.selectAll(&tags, "SELECT ID, UserID, Name, Color, CryptoKey FROM Tags WHERE UserID = ? AND Trashed = 0 AND ID > ? ORDER BY ID LIMIT ?", userID, afterID, limit)
+	return tags, e
+}
+
+// UpdateTag - Overwrite a tag
+func (m *MariaDB) UpdateTag(tag TagRecord) error {
+	_, err := m.track(tag.UserID, KindTag, tag.ID, opUpdate,
+		"UPDATE Tags SET Name = ?, Color = ?, CryptoKey = ? WHERE ID = ? AND UserID = ? AND Trashed = 0",
+		tag.Name, tag.Color, tag.CryptoKey, tag.ID, tag.UserID)
+	return err
+}
+
+// DeleteTag - Delete a tag belonging to a user
+func (m *MariaDB) DeleteTag(id, userID uint) error {
+	return one(m.track(userID, KindTag, id, opDelete, "DELETE FROM Tags WHERE ID = ? AND UserID = ? AND Trashed = 0", id, userID))
+}
+
+// TrashTodoList - Move a todo list to the trash, its items are kept along with it
+func (m *MariaDB) TrashTodoList(id, userID, trashed uint) error {
+	return one(m.track(userID, KindTodoList, id, opDelete,
+		"UPDATE TodoLists SET Trashed = ? WHERE ID = ? AND UserID = ? AND Trashed = 0", trashed, id, userID))
+}
+
+// ListTrashedTodoLists - Retrieve a user's trashed todo lists, most recently trashed first
+func (m *MariaDB) ListTrashedTodoLists(userID uint) (lists []TodoListRecord, e error) {
+	e = m.selectAll(&lists, "SELECT ID, UserID, Title, _Rank, CryptoKey, Trashed FROM TodoLists WHERE UserID = ? AND Trashed > 0 ORDER BY Trashed DESC, ID"+m.forUpdate(), userID)
+	if e != nil {
+		return lists, e
+	}
+	return lists, m.attachTodoItems(userID, lists)
+}
+
+// GetTrashedTodoList - Retrieve a trashed todo list belonging to a user, along with its items
+func (m *MariaDB) GetTrashedTodoList(id, userID uint) (list TodoListRecord, e error) {
+	if e = m.get(&list, "SELECT ID, UserID, Title, _Rank, CryptoKey, Trashed FROM TodoLists WHERE ID = ? AND UserID = ? AND Trashed > 0"+m.forUpdate(), id, userID); e != nil {
+		return list, e
+	}
+	list.Items, e = m.getTodoItems(userID, id)
+	return list, e
+}
+
+// RestoreTodoList - Move a todo list out of the trash with a new rank
+func (m *MariaDB) RestoreTodoList(id, userID uint, rank string) error {
+	return one(m.track(userID, KindTodoList, id, opCreate,
+		"UPDATE TodoLists SET Trashed = 0, _Rank = ? WHERE ID = ? AND UserID = ? AND Trashed > 0", rank, id, userID))
+}
+
+// PurgeTodoList - Permanently delete a trashed todo list and its items
+func (m *MariaDB) PurgeTodoList(id, userID uint) error {
+	return m.Atomic(func(s Store) error {
+		tx := s.(*MariaDB)
+		if err := tx.execOne("DELETE FROM TodoLists WHERE ID = ? AND UserID = ? AND Trashed > 0", id, userID); err != nil {
+			return err
+		}
+		return tx.exec("DELETE FROM TodoItems WHERE UserID = ? AND ListID = ?", userID, id)
+	})
+}
+
+// TrashTag - Move a tag to the trash
+func (m *MariaDB) TrashTag(id, userID, trashed uint) error {
+	return one(m.track(userID, KindTag, id, opDelete,
+		"UPDATE Tags SET Trashed = ? WHERE ID = ? AND UserID = ? AND Trashed = 0", trashed, id, userID))
+}
+
+// ListTrashedTags - Retrieve a user's trashed tags, most recently trashed first
+func (m *MariaDB) ListTrashedTags(userID uint) (tags []TagRecord, e error) {
+	e = m.selectAll(&tags, "SELECT ID, UserID, Name, Color, CryptoKey, Trashed FROM Tags WHERE UserID = ? AND Trashed > 0 ORDER BY Trashed DESC, ID"+m.forUpdate(), userID)
+	return tags, e
+}
+
+// GetTrashedTag - Retrieve a trashed tag belonging to a user
+func (m *MariaDB) GetTrashedTag(id, userID uint) (tag TagRecord, e error) {
+	e = m.get(&tag, "SELECT ID, UserID, Name, Color, CryptoKey, Trashed FROM Tags WHERE ID = ? AND UserID = ? AND Trashed > 0"+m.forUpdate(), id, userID)
+	return tag, e
+}
+
+// RestoreTag - Move a tag out of the trash
+func (m *MariaDB) RestoreTag(id, userID uint) error {
+	return one(m.track(userID, KindTag, id, opCreate, "UPDATE Tags SET Trashed = 0 WHERE ID = ? AND UserID = ? AND Trashed > 0", id, userID))
+}
+
+// PurgeTag - Permanently delete a trashed tag
+func (m *MariaDB) PurgeTag(id, userID uint) error {
+	return m.execOne("DELETE FROM Tags WHERE ID = ? AND UserID = ? AND Trashed > 0", id, userID)
+}
+
+// PurgeExpiredTrash - Permanently delete todo lists (along with their items) and tags trashed before trashedBefore
+func (m *MariaDB) PurgeExpiredTrash(trashedBefore uint) (purged int64, e error) {
+	e = m.Atomic(func(s Store) (e error) {
+		tx := s.(*MariaDB)
+		err := tx.exec(`DELETE i FROM TodoItems i JOIN TodoLists l ON l.ID = i.ListID AND l.UserID = i.UserID
+			WHERE l.Trashed > 0 AND l.Trashed < ?`, trashedBefore)
+		if err != nil {
+			return err
+		}
+		lists, err := tx.execCount("DELETE FROM TodoLists WHERE Trashed > 0 AND Trashed < ?", trashedBefore)
+		if err != nil {
+			return err
+		}
+		tags, err := tx.execCount("DELETE FROM Tags WHERE Trashed > 0 AND Trashed < ?", trashedBefore)
+		purged = lists + tags
+		return err
+	})
+	return purged, e
+}
+
+// CreateName - Store a user's name
+func (m *MariaDB) CreateName(name NameRecord) error {
+	_, err := m.track(name.UserID, KindName, 0, opCreate,
+		"INSERT INTO Names (UserID, FirstName, LastName, Username, CryptoKey) VALUES (?, ?, ?, ?, ?)",
+		name.UserID, name.FirstName, name.LastName, name.Username, name.CryptoKey)
+	return err
+}
+
+// GetName - Retrieve a user's name
+func (m *MariaDB) GetName(userID uint) (name NameRecord, e error) {
+	e = m.get(&name, "SELECT UserID, FirstName, LastName, Username, CryptoKey FROM Names WHERE UserID = ?"+m.forUpdate(), userID)
+	return name, e
+}
+
+// UpdateName - Overwrite a user's name
+func (m *MariaDB) UpdateName(name NameRecord) error {
+	_, err := m.track(name.UserID, KindName, 0, opUpdate,
+		"UPDATE Names SET FirstName = ?, LastName = ?, Username = ?, CryptoKey = ? WHERE UserID = ?",
+		name.FirstName, name.LastName, name.Username, name.CryptoKey, name.UserID)
+	return err
+}
+
+// DeleteName - Delete a user's name
+func (m *MariaDB) DeleteName(userID uint) error {
+	_, err := m.track(userID, KindName, 0, opDelete, "DELETE FROM Names WHERE UserID = ?", userID)
+	return err
+}
+
+// CreateKeys - Store a user's keypair
+func (m *MariaDB) CreateKeys(keys KeysRecord) error {
+	_, err := m.track(keys.UserID, KindKeys, 0, opCreate,
+		"INSERT INTO CryptoKeys (UserID, PublicKey, PrivateKey, HashSalt, HashParams) VALUES (?, ?, ?, ?, ?)",
+		keys.UserID, keys.PublicKey, keys.PrivateKey, keys.HashSalt, keys.HashParams)
+	return err
+}
+
+// GetKeys - Retrieve a user's keypair
+func (m *MariaDB) GetKeys(userID uint) (keys KeysRecord, e error) {
+	e = m.get(&keys, "SELECT UserID, PublicKey, PrivateKey, HashSalt, HashParams FROM CryptoKeys WHERE UserID = ?"+m.forUpdate(), userID)
+	return keys, e
+}
+
+// UpdateKeys - Overwrite a user's keypair
+func (m *MariaDB) UpdateKeys(keys KeysRecord) error {
+	_, err := m.track(keys.UserID, KindKeys, 0, opUpdate,
+		"UPDATE CryptoKeys SET PublicKey = ?, PrivateKey = ?, HashSalt = ?, HashParams = ? WHERE UserID = ?",
+		keys.PublicKey, keys.PrivateKey, keys.HashSalt, keys.HashParams, keys.UserID)
+	return err
+}
+
+// GetSettings - Retrieve a user's settings
+func (m *MariaDB) GetSettings(userID uint) (settings SettingsRecord, e error) {
+	e = m.get(&settings, "SELECT UserID, EnableIPLogging, EnableReminders FROM Settings WHERE UserID = ?", userID)
+	return settings, e
+}
+
+// UpdateSettings - Overwrite a user's settings
+func (m *MariaDB) UpdateSettings(settings SettingsRecord) error {
+	return m.exec("UPDATE Settings SET EnableIPLogging = ?, EnableReminders = ? WHERE UserID = ?",
+		settings.EnableIPLogging, settings.EnableReminders, settings.UserID)
+}
+
+// AcquireLease - Take or renew a job lease
+func (m *MariaDB) AcquireLease(name, holder string, now, expires uint) (acquired bool, e error) {
+	// Assignments are evaluated in order, so Expires is only updated if Holder was (or already was) set to holder
+	e = m.exec(`INSERT INTO JobLeases (Name, Holder, Expires) VALUES (?, ?, ?)
+		ON DUPLICATE KEY UPDATE
+			Holder = IF(Expires <= ? OR Holder = VALUES(Holder), VALUES(Holder), Holder),
+			Expires = IF(Holder = VALUES(Holder), VALUES(Expires), Expires)`,
+		name, holder, expires, now)
+	if e != nil {
+		return false, e
+	}
+	var current string
+	if e = m.get(&current, "SELECT Holder FROM JobLeases WHERE Name = ?", name); e != nil {
+		return false, e
+	}
+	return current == holder, nil
+}
+
+// track - Execute a query creating, modifying, or deleting a synced resource, logging the change in the same transaction
+// Nothing is logged if no rows were affected (MariaDB doesn't count rows updated to identical values as affected)
+func (m *MariaDB) track(userID uint, kind string, id uint, op changeOp, query string, args ...interface{}) (affected int64, e error) {
+	e = m.Atomic(func(s Store) (e error) {
+		tx := s.(*MariaDB)
+		if affected, e = tx.execCount(query, args...); e != nil || affected == 0 {
+			return e
+		}
+		return tx.logChange(userID, kind, id, op)
+	})
+	return affected, e
+}
+
+// logChange - Log a change to a resource, must be called within a transaction
+func (m *MariaDB) logChange(userID uint, kind string, id uint, op changeOp) error {
+	// Incrementing the sequence number locks the user's row until commit,
+	// so concurrent transactions logging changes for the same user are serialized and commit in sequence order
+	if err := m.exec("INSERT INTO ChangeSeqs (UserID, Seq) VALUES (?, 1) ON DUPLICATE KEY UPDATE Seq = Seq + 1", userID); err != nil {
+		return err
+	}
+	var seq uint64
+	if err := m.get(&seq, "SELECT Seq FROM ChangeSeqs WHERE UserID = ?", userID); err != nil {
+		return err
+	}
+	var createdSeq uint64
+	if op == opCreate {
+		createdSeq = seq
+	}
+	m.changed[userID] = true
+	return m.exec(`INSERT INTO Changes (UserID, Kind, ResourceID, Seq, CreatedSeq, Deleted, SessionID) VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE
+			CreatedSeq = IF(VALUES(CreatedSeq) > 0, VALUES(CreatedSeq), CreatedSeq),
+			Seq = VALUES(Seq),
+			Deleted = VALUES(Deleted),
+			SessionID = VALUES(SessionID)`,
+		userID, kind, id, seq, createdSeq, op == opDelete, m.session)
+}
+
+// one - Return ErrNotFound from track if no rows were affected
+func one(affected int64, e error) error {
+	if e == nil && affected == 0 {
+		return ErrNotFound
+	}
+	return e
+}
+
+// ListChanges - Retrieve a user's changes after a sequence number
+func (m *MariaDB) ListChanges(userID uint, since uint64, limit int) (changes []ChangeRecord, e error) {
+	e = m.selectAll(&changes, `SELECT UserID, Kind, ResourceID, Seq, CreatedSeq, Deleted, SessionID FROM Changes
+		WHERE UserID = ? AND Seq > ? ORDER BY Seq LIMIT ?`, userID, since, limit)
+	return changes, e
+}
+
+// ChangeSeq - Retrieve the sequence number of a user's latest change
+func (m *MariaDB) ChangeSeq(userID uint) (seq uint64, e error) {
+	e = m.get(&seq, "SELECT Seq FROM ChangeSeqs WHERE UserID = ?", userID)
+	if e == ErrNotFound {
+		return 0, nil
+	}
+	return seq, e
+}
+
+// AsSession - Return a view of the store that attributes the changes it logs to a session
+func (m *MariaDB) AsSession(sessionID uint) Store {
+	view := *m
+	view.session = sessionID
+	return &view
+}
+
+// SetNotifier - Set the notifier told about users with new changes
+func (m *MariaDB) SetNotifier(n Notifier) {
+	m.notifier = n
+}
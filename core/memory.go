@@ -0,0 +1,1134 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+)
+
+var errDuplicate = errors.New("duplicate entry")
+
+var _ Store = &MemoryStore{}
+
+// MemoryStore - Pure Go store that keeps all data in memory, used for development and testing
+type MemoryStore struct {
+	mu   *sync.Mutex
+	data *memoryData
+	inTx bool
+	// Changes logged by this view are attributed to session
+	session  uint
+	notifier Notifier
+}
+
+type memoryData struct {
+	users        map[uint]UserRecord
+	authKeys     map[uint]AuthKeyRecord
+	totp         map[uint]TOTPRecord
+	deleteTokens map[uint]DeleteTokenRecord
+	settings     map[uint]SettingsRecord
+	sessions     map[uint]SessionRecord
+	challenges   map[uint]ChallengeRecord
+	todoLists    map[uint]TodoListRecord
+	todoItems    map[uint]TodoItemRecord
+	noLists      map[uint]NoListRecord
+	tags         map[uint]TagRecord
+	// Trashed lists and tags are kept apart from the others, items of trashed lists stay in todoItems
+	trashedLists map[uint]TodoListRecord
+	trashedTags  map[uint]TagRecord
+	names        map[uint]NameRecord
+	keys         map[uint]KeysRecord
+	leases       map[string]LeaseRecord
+	changes      map[changeKey]ChangeRecord
+	changeSeqs   map[uint]uint64
+	// changed - Users with changes that haven't been notified yet (not copied by clone, so that a rollback discards them)
+	changed map[uint]bool
+}
+
+type changeKey struct {
+	userID uint
+	kind   string
+	id     uint
+}
+
+func newMemoryData() *memoryData {
+	return &memoryData{
+		users:        make(map[uint]UserRecord),
+		authKeys:     make(map[uint]AuthKeyRecord),
+		totp:         make(map[uint]TOTPRecord),
+		deleteTokens: make(map[uint]DeleteTokenRecord),
+		settings:     make(map[uint]SettingsRecord),
+		sessions:     make(map[uint]SessionRecord),
+		challenges:   make(map[uint]ChallengeRecord),
+		todoLists:    make(map[uint]TodoListRecord),
+		todoItems:    make(map[uint]TodoItemRecord),
+		noLists:      make(map[uint]NoListRecord),
+		tags:         make(map[uint]TagRecord),
+		trashedLists: make(map[uint]TodoListRecord),
+		trashedTags:  make(map[uint]TagRecord),
+		names:        make(map[uint]NameRecord),
+		keys:         make(map[uint]KeysRecord),
+		leases:       make(map[string]LeaseRecord),
+		changes:      make(map[changeKey]ChangeRecord),
+		changeSeqs:   make(map[uint]uint64),
+		changed:      make(map[uint]bool)}
+}
+
+// clone - Copy every table so that a transaction can be rolled back
+// Records are never mutated in place, so a shallow copy of each map is sufficient
+func (d *memoryData) clone() *memoryData {
+	c := newMemoryData()
+	for k, v := range d.users {
+		c.users[k] = v
+	}
+	for k, v := range d.authKeys {
+		c.authKeys[k] = v
+	}
+	for k, v := range d.totp {
+		c.totp[k] = v
+	}
+	for k, v := range d.deleteTokens {
+		c.deleteTokens[k] = v
+	}
+	for k, v := range d.settings {
+		c.settings[k] = v
+	}
+	for k, v := range d.sessions {
+		c.sessions[k] = v
+	}
+	for k, v := range d.challenges {
+		c.challenges[k] = v
+	}
+	for k, v := range d.todoLists {
+		c.todoLists[k] = v
+	}
+	for k, v := range d.todoItems {
+		c.todoItems[k] = v
+	}
+	for k, v := range d.noLists {
+		c.noLists[k] = v
+	}
+	for k, v := range d.tags {
+		c.tags[k] = v
+	}
+	for k, v := range d.trashedLists {
+		c.trashedLists[k] = v
+	}
+	for k, v := range d.trashedTags {
+		c.trashedTags[k] = v
+	}
+	for k, v := range d.names {
+		c.names[k] = v
+	}
+	for k, v := range d.keys {
+		c.keys[k] = v
+	}
+	for k, v := range d.leases {
+		c.leases[k] = v
+	}
+	for k, v := range d.changes {
+		c.changes[k] = v
+	}
+	for k, v := range d.changeSeqs {
+		c.changeSeqs[k] = v
+	}
+	return c
+}
+
+// NewMemoryStore - Create an empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		mu:   &sync.Mutex{},
+		data: newMemoryData()}
+}
+
+// lock - Acquire the store's lock, returning the function to release it
+// (views inside of a transaction already hold the lock)
+func (m *MemoryStore) lock() func() {
+	if m.inTx {
+		return func() {}
+	}
+	m.mu.Lock()
+	return m.unlock
+}
+
+// unlock - Release the store's lock, then notify users with changes made while it was held
+func (m *MemoryStore) unlock() {
+	var changed map[uint]bool
+	if len(m.data.changed) > 0 {
+		changed = m.data.changed
+		m.data.changed = make(map[uint]bool)
+	}
+	m.mu.Unlock()
+	if m.notifier != nil {
+		for userID := range changed {
+			m.notifier.Notify(userID)
+		}
+	}
+}
+
+// Atomic - Run fn with exclusive access to the store, restoring its previous state if fn fails
+func (m *MemoryStore) Atomic(fn func(s Store) error) error {
+	if m.inTx {
+		return fn(m)
+	}
+	m.mu.Lock()
+	defer m.unlock()
+	snapshot := m.data.clone()
+	if err := fn(&MemoryStore{mu: m.mu, data: m.data, inTx: true, session: m.session}); err != nil {
+		*m.data = *snapshot
+		return err
+	}
+	return nil
+}
+
+// IDExists - Check whether an ID is in use
+func (m *MemoryStore) IDExists(table string, id uint) (exists bool, e error) {
+	defer m.lock()()
+	switch table {
+	case "Users":
+		_, exists = m.data.users[id]
+	case "Sessions":
+		_, exists = m.data.sessions[id]
+	case "Challenges":
+		_, exists = m.data.challenges[id]
+	case "TodoLists":
+		_, exists = m.data.todoLists[id]
+		if !exists {
+			_, exists = m.data.trashedLists[id]
+		}
+	case "TodoItems":
+		_, exists = m.data.todoItems[id]
+	case "Tags":
+		_, exists = m.data.tags[id]
+		if !exists {
+			_, exists = m.data.trashedTags[id]
+		}
+	default:
+		return false, fmt.Errorf("IDExists called with unknown table '%s'", table)
+	}
+	return exists, nil
+}
+
+// CreateUser - Insert a user, their auth key, and their default settings
+func (m *MemoryStore) CreateUser(user UserRecord, key AuthKeyRecord) error {
+	defer m.lock()()
+	if _, exists := m.data.users[user.ID]; exists {
+		return errDuplicate
+	}
+	for _, u := range m.data.users {
+		if u.Email == user.Email {
+			return errDuplicate
+		}
+	}
+	key.UserID = user.ID
+	m.data.users[user.ID] = user
+	m.data.authKeys[user.ID] = key
+	m.data.settings[user.ID] = SettingsRecord{
+		UserID: user.ID}
+	return nil
+}
+
+// GetUser - Retrieve a user by ID
+func (m *MemoryStore) GetUser(id uint) (UserRecord, error) {
+	defer m.lock()()
+	user, exists := m.data.users[id]
+	if !exists {
+		return user, ErrNotFound
+	}
+	return user, nil
+}
+
+// GetUserByEmail - Retrieve a user by email
+func (m *MemoryStore) GetUserByEmail(email string) (UserRecord, error) {
+	defer m.lock()()
+	for _, user := range m.data.users {
+		if user.Email == email {
+			return user, nil
+		}
+	}
+	return UserRecord{}, ErrNotFound
+}
+
+// DeleteUser - Delete a user and everything belonging to them
+func (m *MemoryStore) DeleteUser(id uint) error {
+	defer m.lock()()
+	for listID, list := range m.data.todoLists {
+		if list.UserID == id {
+			delete(m.data.todoLists, listID)
+		}
+	}
+	for itemID, item := range m.data.todoItems {
+		if item.UserID == id {
+			delete(m.data.todoItems, itemID)
+		}
+	}
+	for tagID, tag := range m.data.tags {
+		if tag.UserID == id {
+			delete(m.data.tags, tagID)
+		}
+	}
+	for listID, list := range m.data.trashedLists {
+		if list.UserID == id {
+			delete(m.data.trashedLists, listID)
+		}
+	}
+	for tagID, tag := range m.data.trashedTags {
+		if tag.UserID == id {
+			delete(m.data.trashedTags, tagID)
+		}
+	}
+	for sessionID, session := range m.data.sessions {
+		if session.UserID == id {
+			delete(m.data.sessions, sessionID)
+		}
+	}
+	for challengeID, challenge := range m.data.challenges {
+		if challenge.UserID == id {
+			delete(m.data.challenges, challengeID)
+		}
+	}
+	delete(m.data.names, id)
+	delete(m.data.authKeys, id)
+	delete(m.data.totp, id)
+	delete(m.data.keys, id)
+	delete(m.data.deleteTokens, id)
+	delete(m.data.noLists, id)
+	delete(m.data.settings, id)
+	delete(m.data.users, id)
+	for key := range m.data.changes {
+		if key.userID == id {
+			delete(m.data.changes, key)
+		}
+	}
+	delete(m.data.changeSeqs, id)
+	return nil
+}
+
+// GetAuthKey - Retrieve a user's auth key
+func (m *MemoryStore) GetAuthKey(userID uint) (AuthKeyRecord, error) {
+	defer m.lock()()
+	key, exists := m.data.authKeys[userID]
+	if !exists {
+		return key, ErrNotFound
+	}
+	return key, nil
+}
+
+// UpdateAuthKey - Replace a user's auth key
+func (m *MemoryStore) UpdateAuthKey(key AuthKeyRecord) error {
+	defer m.lock()()
+	if _, exists := m.data.authKeys[key.UserID]; exists {
+		m.data.authKeys[key.UserID] = key
+	}
+	return nil
+}
+
+// GetTOTP - Retrieve a user's TOTP info
+func (m *MemoryStore) GetTOTP(userID uint) (TOTPRecord, error) {
+	defer m.lock()()
+	totp, exists := m.data.totp[userID]
+	if !exists {
+		return totp, ErrNotFound
+	}
+	return totp, nil
+}
+
+// CreateTOTP - Enable TOTP for a user
+func (m *MemoryStore) CreateTOTP(totp TOTPRecord) error {
+	defer m.lock()()
+	if _, exists := m.data.totp[totp.UserID]; exists {
+		return errDuplicate
+	}
+	m.data.totp[totp.UserID] = totp
+	return nil
+}
+
+// UpdateTOTP - Update a user's remaining backup codes
+func (m *MemoryStore) UpdateTOTP(totp TOTPRecord) error {
+	defer m.lock()()
+	if existing, exists := m.data.totp[totp.UserID]; exists {
+		existing.BackupCodes = totp.BackupCodes
+		m.data.totp[totp.UserID] = existing
+	}
+	return nil
+}
+
+// DeleteTOTP - Disable TOTP for a user
+func (m *MemoryStore) DeleteTOTP(userID uint) error {
+	defer m.lock()()
+	delete(m.data.totp, userID)
+	return nil
+}
+
+// GetDeleteToken - Retrieve a user's pending delete token
+func (m *MemoryStore) GetDeleteToken(userID uint) (DeleteTokenRecord, error) {
+	defer m.lock()()
+	token, exists := m.data.deleteTokens[userID]
+	if !exists {
+		return token, ErrNotFound
+	}
+	return token, nil
+}
+
+// CreateDeleteToken - Store a delete token for a user
+func (m *MemoryStore) CreateDeleteToken(token DeleteTokenRecord) error {
+	defer m.lock()()
+	if _, exists := m.data.deleteTokens[token.UserID]; exists {
+		return errDuplicate
+	}
+	m.data.deleteTokens[token.UserID] = token
+	return nil
+}
+
+// DeleteExpiredDeleteTokens - Delete tokens created before the given time
+func (m *MemoryStore) DeleteExpiredDeleteTokens(createdBefore uint) (deleted int64, e error) {
+	defer m.lock()()
+	for userID, token := range m.data.deleteTokens {
+		if token.Timestamp < createdBefore {
+			delete(m.data.deleteTokens, userID)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// CreateSession - Store a new session
+func (m *MemoryStore) CreateSession(session SessionRecord) error {
+	defer m.lock()()
+	if _, exists := m.data.sessions[session.ID]; exists {
+		return errDuplicate
+	}
+	m.data.sessions[session.ID] = session
+	return nil
+}
+
+// GetSession - Retrieve a session belonging to a user
+func (m *MemoryStore) GetSession(id, userID uint) (SessionRecord, error) {
+	defer m.lock()()
+	session, exists := m.data.sessions[id]
+	if !exists || session.UserID != userID {
+		return SessionRecord{}, ErrNotFound
+	}
+	session.EnableIPLogging = m.data.settings[userID].EnableIPLogging
+	return session, nil
+}
+
+// ListSessions - Retrieve all of a user's sessions
+func (m *MemoryStore) ListSessions(userID uint) ([]SessionRecord, error) {
+	defer m.lock()()
+	var sessions []SessionRecord
+	for _, session := range m.data.sessions {
+		if session.UserID == userID {
+			sessions = append(sessions, session)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].Created < sessions[j].Created
+	})
+	return sessions, nil
+}
+
+// TouchSession - Update a session's last use time
+func (m *MemoryStore) TouchSession(id, lastUsed uint) error {
+	defer m.lock()()
+	if session, exists := m.data.sessions[id]; exists {
+		session.LastUsed = lastUsed
+		m.data.sessions[id] = session
+	}
+	return nil
+}
+
+// CountSessions - Count the sessions that have been used since the given time
+func (m *MemoryStore) CountSessions(usedSince uint) (count uint, e error) {
+	defer m.lock()()
+	for _, session := range m.data.sessions {
+		if session.LastUsed >= usedSince {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// DeleteExpiredSessions - Delete sessions that are both old and not in use
+func (m *MemoryStore) DeleteExpiredSessions(createdBefore, lastUsedBefore uint) (deleted int64, e error) {
+	defer m.lock()()
+	for id, session := range m.data.sessions {
+		if session.Created <= createdBefore && session.LastUsed <= lastUsedBefore {
+			delete(m.data.sessions, id)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// DeleteSession - Delete a session belonging to a user
+func (m *MemoryStore) DeleteSession(id, userID uint) error {
+	defer m.lock()()
+	session, exists := m.data.sessions[id]
+	if !exists || session.UserID != userID {
+		return ErrNotFound
+	}
+	delete(m.data.sessions, id)
+	return nil
+}
+
+// CreateChallenge - Store a new challenge
+func (m *MemoryStore) CreateChallenge(challenge ChallengeRecord) error {
+	defer m.lock()()
+	if _, exists := m.data.challenges[challenge.ID]; exists {
+		return errDuplicate
+	}
+	m.data.challenges[challenge.ID] = challenge
+	return nil
+}
+
+// GetChallenge - Retrieve a challenge
+func (m *MemoryStore) GetChallenge(id uint) (ChallengeRecord, error) {
+	defer m.lock()()
+	challenge, exists := m.data.challenges[id]
+	if !exists {
+		return challenge, ErrNotFound
+	}
+	return challenge, nil
+}
+
+// CountChallenges - Count a user's pending and failed challenges
+func (m *MemoryStore) CountChallenges(userID uint) (pending, failed uint, e error) {
+	defer m.lock()()
+	for _, challenge := range m.data.challenges {
+		if challenge.UserID != userID {
+			continue
+		}
+		if challenge.Failed {
+			failed++
+		} else {
+			pending++
+		}
+	}
+	return pending, failed, nil
+}
+
+// FailChallenge - Mark a challenge as failed
+func (m *MemoryStore) FailChallenge(id uint) error {
+	defer m.lock()()
+	if challenge, exists := m.data.challenges[id]; exists {
+		challenge.Failed = true
+		m.data.challenges[id] = challenge
+	}
+	return nil
+}
+
+// DeleteChallenge - Delete a challenge
+func (m *MemoryStore) DeleteChallenge(id uint) error {
+	defer m.lock()()
+	delete(m.data.challenges, id)
+	return nil
+}
+
+// DeleteExpiredChallenges - Delete abandoned and old failed challenges
+func (m *MemoryStore) DeleteExpiredChallenges(pendingBefore, failedBefore uint) (deleted int64, e error) {
+	defer m.lock()()
+	for id, challenge := range m.data.challenges {
+		if (!challenge.Failed && challenge.Timestamp < pendingBefore) || (challenge.Failed && challenge.Timestamp < failedBefore) {
+			delete(m.data.challenges, id)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// itemsOf - Return the items of a list (or nolist collection) in position order
+func (m *MemoryStore) itemsOf(userID, listID uint) []TodoItemRecord {
+	var items []TodoItemRecord
+	for _, item := range m.data.todoItems {
+		if item.UserID == userID && item.ListID == listID {
+			items = append(items, item)
+		}
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Position == items[j].Position {
+			return items[i].ID < items[j].ID
+		}
+		return items[i].Position < items[j].Position
+	})
+	return items
+}
+
+// CreateTodoList - Store a new todo list and its items
+func (m *MemoryStore) CreateTodoList(list TodoListRecord) error {
+	defer m.lock()()
+	if _, exists := m.data.todoLists[list.ID]; exists {
+		return errDuplicate
+	}
+	for _, item := range list.Items {
+		item.UserID, item.ListID = list.UserID, list.ID
+		m.data.todoItems[item.ID] = item
+	}
+	// Items are stored separately and indexes are derived from ranks, both are set when lists are retrieved
+	list.Items, list.Index = nil, 0
+	m.data.todoLists[list.ID] = list
+	m.logChange(list.UserID, KindTodoList, list.ID, opCreate)
+	return nil
+}
+
+// listsOf - Return a user's todo lists in rank order, along with their items and indexes
+func (m *MemoryStore) listsOf(userID uint) []TodoListRecord {
+	var lists []TodoListRecord
+	for _, list := range m.data.todoLists {
+		if list.UserID == userID {
+			lists = append(lists, list)
+		}
+	}
+	sort.Slice(lists, func(i, j int) bool {
+		if lists[i].Rank == lists[j].Rank {
+			return lists[i].ID < lists[j].ID
+		}
+		return lists[i].Rank < lists[j].Rank
+	})
+	for i := range lists {
+		lists[i].Items = m.itemsOf(userID, lists[i].ID)
+		lists[i].Index = uint(i)
+	}
+	return lists
+}
+
+// GetTodoList - Retrieve a todo list belonging to a user
+func (m *MemoryStore) GetTodoList(id, userID uint) (TodoListRecord, error) {
+	defer m.lock()()
+	if list, exists := m.data.todoLists[id]; !exists || list.UserID != userID {
+		return TodoListRecord{}, ErrNotFound
+	}
+	for _, list := range m.listsOf(userID) {
+		if list.ID == id {
+			return list, nil
+		}
+	}
+	return TodoListRecord{}, ErrNotFound
+}
+
+// ListTodoLists - Retrieve all of a user's todo lists in rank order
+func (m *MemoryStore) ListTodoLists(userID uint) ([]TodoListRecord, error) {
+	defer m.lock()()
+	return m.listsOf(userID), nil
+}
+
+// PageTodoLists - Retrieve a page of a user's todo lists in rank order
+func (m *MemoryStore) PageTodoLists(userID uint, afterRank string, afterID uint, limit int) ([]TodoListRecord, error) {
+	lists, _ := m.ListTodoLists(userID)
+	page := make([]TodoListRecord, 0, limit)
+	for _, list := range lists {
+		if len(page) == limit {
+			break
+		}
+		if list.Rank > afterRank || list.Rank == afterRank && list.ID > afterID {
+			page = append(page, list)
+		}
+	}
+	return page, nil
+}
+
+// UpdateTodoList - Overwrite a todo list
+func (m *MemoryStore) UpdateTodoList(list TodoListRecord) error {
+	defer m.lock()()
+	list.Items, list.Index = nil, 0
+	if existing, exists := m.data.todoLists[list.ID]; exists && existing.UserID == list.UserID && !reflect.DeepEqual(existing, list) {
+		m.data.todoLists[list.ID] = list
+		m.logChange(list.UserID, KindTodoList, list.ID, opUpdate)
+	}
+	return nil
+}
+
+// DeleteTodoList - Delete a todo list belonging to a user, along with its items
+func (m *MemoryStore) DeleteTodoList(id, userID uint) error {
+	defer m.lock()()
+	list, exists := m.data.todoLists[id]
+	if !exists || list.UserID != userID {
+		return ErrNotFound
+	}
+	delete(m.data.todoLists, id)
+	for _, item := range m.itemsOf(userID, id) {
+		delete(m.data.todoItems, item.ID)
+	}
+	m.logChange(userID, KindTodoList, id, opDelete)
+	return nil
+}
+
+// CreateTodoItem - Store a new item in an existing list (or nolist collection)
+func (m *MemoryStore) CreateTodoItem(item TodoItemRecord) error {
+	defer m.lock()()
+	if _, exists := m.data.todoItems[item.ID]; exists {
+		return errDuplicate
+	}
+	m.data.todoItems[item.ID] = item
+	m.logChange(item.UserID, itemParentKind(item), item.ListID, opUpdate)
+	return nil
+}
+
+// GetTodoItem - Retrieve an item belonging to a user
+func (m *MemoryStore) GetTodoItem(id, userID uint) (TodoItemRecord, error) {
+	defer m.lock()()
+	item, exists := m.data.todoItems[id]
+	if !exists || item.UserID != userID {
+		return TodoItemRecord{}, ErrNotFound
+	}
+	return item, nil
+}
+
+// UpdateTodoItem - Overwrite an item, logging an update to the list it was moved from (if it was moved)
+func (m *MemoryStore) UpdateTodoItem(item TodoItemRecord) error {
+	defer m.lock()()
+	existing, exists := m.data.todoItems[item.ID]
+	if !exists || existing.UserID != item.UserID {
+		return ErrNotFound
+	}
+	if !reflect.DeepEqual(existing, item) {
+		m.data.todoItems[item.ID] = item
+		m.logChange(item.UserID, itemParentKind(item), item.ListID, opUpdate)
+		if existing.ListID != item.ListID {
+			m.logChange(item.UserID, itemParentKind(existing), existing.ListID, opUpdate)
+		}
+	}
+	return nil
+}
+
+// DeleteTodoItem - Delete an item belonging to a user
+func (m *MemoryStore) DeleteTodoItem(id, userID uint) error {
+	defer m.lock()()
+	item, exists := m.data.todoItems[id]
+	if !exists || item.UserID != userID {
+		return ErrNotFound
+	}
+	delete(m.data.todoItems, id)
+	m.logChange(userID, itemParentKind(item), item.ListID, opUpdate)
+	return nil
+}
+
+// CreateNoList - Store a user's nolist collection and its items
+func (m *MemoryStore) CreateNoList(nolist NoListRecord) error {
+	defer m.lock()()
+	if _, exists := m.data.noLists[nolist.UserID]; exists {
+		return errDuplicate
+	}
+	for _, item := range nolist.Items {
+		item.UserID, item.ListID = nolist.UserID, 0
+		m.data.todoItems[item.ID] = item
+	}
+	nolist.Items = nil
+	m.data.noLists[nolist.UserID] = nolist
+	m.logChange(nolist.UserID, KindNoList, 0, opCreate)
+	return nil
+}
+
+// GetNoList - Retrieve a user's nolist collection
+func (m *MemoryStore) GetNoList(userID uint) (NoListRecord, error) {
+	defer m.lock()()
+	nolist, exists := m.data.noLists[userID]
+	if !exists {
+		return nolist, ErrNotFound
+	}
+	nolist.Items = m.itemsOf(userID, 0)
+	return nolist, nil
+}
+
+// UpdateNoList - Overwrite a user's nolist collection
+func (m *MemoryStore) UpdateNoList(nolist NoListRecord) error {
+	defer m.lock()()
+	nolist.Items = nil
+	if existing, exists := m.data.noLists[nolist.UserID]; exists && !reflect.DeepEqual(existing, nolist) {
+		m.data.noLists[nolist.UserID] = nolist
+		m.logChange(nolist.UserID, KindNoList, 0, opUpdate)
+	}
+	return nil
+}
+
+// CreateTag - Store a new tag
+func (m *MemoryStore) CreateTag(tag TagRecord) error {
+	defer m.lock()()
+	if _, exists := m.data.tags[tag.ID]; exists {
+		return errDuplicate
+	}
+	m.data.tags[tag.ID] = tag
+	m.logChange(tag.UserID, KindTag, tag.ID, opCreate)
+	return nil
+}
+
+// GetTag - Retrieve a tag belonging to a user
+func (m *MemoryStore) GetTag(id, userID uint) (TagRecord, error) {
+	defer m.lock()()
+	tag, exists := m.data.tags[id]
+	if !exists || tag.UserID != userID {
+		return TagRecord{}, ErrNotFound
+	}
+	return tag, nil
+}
+
+// ListTags - Retrieve all of a user's tags
+func (m *MemoryStore) ListTags(userID uint) ([]TagRecord, error) {
+	defer m.lock()()
+	var tags []TagRecord
+	for _, tag := range m.data.tags {
+		if tag.UserID == userID {
+			tags = append(tags, tag)
+		}
+	}
+	sort.Slice(tags, func(i, j int) bool {
+		return tags[i].ID < tags[j].ID
+	})
+	return tags, nil
+}
+
+// PageTags - Retrieve a page of a user's tags in ID order
+func (m *MemoryStore) PageTags(userID uint, afterID uint, limit int) ([]TagRecord, error) {
+	tags, _ := m.ListTags(userID)
+	page := make([]TagRecord, 0, limit)
+	for _, tag := range tags {
+		if len(page) == limit {
+			break
+		}
+		if tag.ID > afterID {
+			page = append(page, tag)
+		}
+	}
+	return page, nil
+}
+
+// UpdateTag - Overwrite a tag
+func (m *MemoryStore) UpdateTag(tag TagRecord) error {
+	defer m.lock()()
+	if existing, exists := m.data.tags[tag.ID]; exists && existing.UserID == tag.UserID && !reflect.DeepEqual(existing, tag) {
+		m.data.tags[tag.ID] = tag
+		m.logChange(tag.UserID, KindTag, tag.ID, opUpdate)
+	}
+	return nil
+}
+
+// DeleteTag - Delete a tag belonging to a user
+func (m *MemoryStore) DeleteTag(id, userID uint) error {
+	defer m.lock()()
+	tag, exists := m.data.tags[id]
+	if !exists || tag.UserID != userID {
+		return ErrNotFound
+	}
+	delete(m.data.tags, id)
+	m.logChange(userID, KindTag, id, opDelete)
+	return nil
+}
+
+// TrashTodoList - Move a todo list to the trash, its items are kept along with it
+func (m *MemoryStore) TrashTodoList(id, userID, trashed uint) error {
+	defer m.lock()()
+	list, exists := m.data.todoLists[id]
+	if !exists || list.UserID != userID {
+		return ErrNotFound
+	}
+	delete(m.data.todoLists, id)
+	list.Trashed = trashed
+	m.data.trashedLists[id] = list
+	m.logChange(userID, KindTodoList, id, opDelete)
+	return nil
+}
+
+// ListTrashedTodoLists - Retrieve a user's trashed todo lists, most recently trashed first
+func (m *MemoryStore) ListTrashedTodoLists(userID uint) ([]TodoListRecord, error) {
+	defer m.lock()()
+	var lists []TodoListRecord
+	for _, list := range m.data.trashedLists {
+		if list.UserID == userID {
+			list.Items = m.itemsOf(userID, list.ID)
+			lists = append(lists, list)
+		}
+	}
+	sort.Slice(lists, func(i, j int) bool {
+		if lists[i].Trashed == lists[j].Trashed {
+			return lists[i].ID < lists[j].ID
+		}
+		return lists[i].Trashed > lists[j].Trashed
+	})
+	return lists, nil
+}
+
+// GetTrashedTodoList - Retrieve a trashed todo list belonging to a user, along with its items
+func (m *MemoryStore) GetTrashedTodoList(id, userID uint) (TodoListRecord, error) {
+	defer m.lock()()
+	list, exists := m.data.trashedLists[id]
+	if !exists || list.UserID != userID {
+		return TodoListRecord{}, ErrNotFound
+	}
+	list.Items = m.itemsOf(userID, id)
+	return list, nil
+}
+
+// RestoreTodoList - Move a todo list out of the trash with a new rank
+func (m *MemoryStore) RestoreTodoList(id, userID uint, rank string) error {
+	defer m.lock()()
+	list, exists := m.data.trashedLists[id]
+	if !exists || list.UserID != userID {
+		return ErrNotFound
+	}
+	delete(m.data.trashedLists, id)
+	list.Trashed, list.Rank = 0, rank
+	m.data.todoLists[id] = list
+	m.logChange(userID, KindTodoList, id, opCreate)
+	return nil
+}
+
+// PurgeTodoList - Permanently delete a trashed todo list and its items
+func (m *MemoryStore) PurgeTodoList(id, userID uint) error {
+	defer m.lock()()
+	list, exists := m.data.trashedLists[id]
+	if !exists || list.UserID != userID {
+		return ErrNotFound
+	}
+	delete(m.data.trashedLists, id)
+	for _, item := range m.itemsOf(userID, id) {
+		delete(m.data.todoItems, item.ID)
+	}
+	return nil
+}
+
+// TrashTag - Move a tag to the trash
+func (m *MemoryStore) TrashTag(id, userID, trashed uint) error {
+	defer m.lock()()
+	tag, exists := m.data.tags[id]
+	if !exists || tag.UserID != userID {
+		return ErrNotFound
+	}
+	delete(m.data.tags, id)
+	tag.Trashed = trashed
+	m.data.trashedTags[id] = tag
+	m.logChange(userID, KindTag, id, opDelete)
+	return nil
+}
+
+// ListTrashedTags - Retrieve a user's trashed tags, most recently trashed first
+func (m *MemoryStore) ListTrashedTags(userID uint) ([]TagRecord, error) {
+	defer m.lock()()
+	var tags []TagRecord
+	for _, tag := range m.data.trashedTags {
+		if tag.UserID == userID {
+			tags = append(tags, tag)
+		}
+	}
+	sort.Slice(tags, func(i, j int) bool {
+		if tags[i].Trashed == tags[j].Trashed {
+			return tags[i].ID < tags[j].ID
+		}
+		return tags[i].Trashed > tags[j].Trashed
+	})
+	return tags, nil
+}
+
+// GetTrashedTag - Retrieve a trashed tag belonging to a user
+func (m *MemoryStore) GetTrashedTag(id, userID uint) (TagRecord, error) {
+	defer m.lock()()
+	tag, exists := m.data.trashedTags[id]
+	if !exists || tag.UserID != userID {
+		return TagRecord{}, ErrNotFound
+	}
+	return tag, nil
+}
+
+// RestoreTag - Move a tag out of the trash
+func (m *MemoryStore) RestoreTag(id, userID uint) error {
+	defer m.lock()()
+	tag, exists := m.data.trashedTags[id]
+	if !exists || tag.UserID != userID {
+		return ErrNotFound
+	}
+	delete(m.data.trashedTags, id)
+	tag.Trashed = 0
+	m.data.tags[id] = tag
+	m.logChange(userID, KindTag, id, opCreate)
+	return nil
+}
+
+// PurgeTag - Permanently delete a trashed tag
+func (m *MemoryStore) PurgeTag(id, userID uint) error {
+	defer m.lock()()
+	tag, exists := m.data.trashedTags[id]
+	if !exists || tag.UserID != userID {
+		return ErrNotFound
+	}
+	delete(m.data.trashedTags, id)
+	return nil
+}
+
+// PurgeExpiredTrash - Permanently delete todo lists (along with their items) and tags trashed before trashedBefore
+func (m *MemoryStore) PurgeExpiredTrash(trashedBefore uint) (purged int64, e error) {
+	defer m.lock()()
+	for id, list := range m.data.trashedLists {
+		if list.Trashed < trashedBefore {
+			delete(m.data.trashedLists, id)
+			for _, item := range m.itemsOf(list.UserID, id) {
+				delete(m.data.todoItems, item.ID)
+			}
+			purged++
+		}
+	}
+	for id, tag := range m.data.trashedTags {
+		if tag.Trashed < trashedBefore {
+			delete(m.data.trashedTags, id)
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+// CreateName - Store a user's name
+func (m *MemoryStore) CreateName(name NameRecord) error {
+	defer m.lock()()
+	if _, exists := m.data.names[name.UserID]; exists {
+		return errDuplicate
+	}
+	m.data.names[name.UserID] = name
+	m.logChange(name.UserID, KindName, 0, opCreate)
+	return nil
+}
+
+// GetName - Retrieve a user's name
+func (m *MemoryStore) GetName(userID uint) (NameRecord, error) {
+	defer m.lock()()
+	name, exists := m.data.names[userID]
+	if !exists {
+		return name, ErrNotFound
+	}
+	return name, nil
+}
+
+// UpdateName - Overwrite a user's name
+func (m *MemoryStore) UpdateName(name NameRecord) error {
+	defer m.lock()()
+	if existing, exists := m.data.names[name.UserID]; exists && !reflect.DeepEqual(existing, name) {
+		m.data.names[name.UserID] = name
+		m.logChange(name.UserID, KindName, 0, opUpdate)
+	}
+	return nil
+}
+
+// DeleteName - Delete a user's name
+func (m *MemoryStore) DeleteName(userID uint) error {
+	defer m.lock()()
+	if _, exists := m.data.names[userID]; exists {
+		delete(m.data.names, userID)
+		m.logChange(userID, KindName, 0, opDelete)
+	}
+	return nil
+}
+
+// CreateKeys - Store a user's keypair
+func (m *MemoryStore) CreateKeys(keys KeysRecord) error {
+	defer m.lock()()
+	if _, exists := m.data.keys[keys.UserID]; exists {
+		return errDuplicate
+	}
+	m.data.keys[keys.UserID] = keys
+	m.logChange(keys.UserID, KindKeys, 0, opCreate)
+	return nil
+}
+
+// GetKeys - Retrieve a user's keypair
+func (m *MemoryStore) GetKeys(userID uint) (KeysRecord, error) {
+	defer m.lock()()
+	keys, exists := m.data.keys[userID]
+	if !exists {
+		return keys, ErrNotFound
+	}
+	return keys, nil
+}
+
+// UpdateKeys - Overwrite a user's keypair
+func (m *MemoryStore) UpdateKeys(keys KeysRecord) error {
+	defer m.lock()()
+	if existing, exists := m.data.keys[keys.UserID]; exists && !reflect.DeepEqual(existing, keys) {
+		m.data.keys[keys.UserID] = keys
+		m.logChange(keys.UserID, KindKeys, 0, opUpdate)
+	}
+	return nil
+}
+
+// GetSettings - Retrieve a user's settings
+func (m *MemoryStore) GetSettings(userID uint) (SettingsRecord, error) {
+	defer m.lock()()
+	settings, exists := m.data.settings[userID]
+	if !exists {
+		return settings, ErrNotFound
+	}
+	return settings, nil
+}
+
+// UpdateSettings - Overwrite a user's settings
+func (m *MemoryStore) UpdateSettings(settings SettingsRecord) error {
+	defer m.lock()()
+	if _, exists := m.data.settings[settings.UserID]; exists {
+		m.data.settings[settings.UserID] = settings
+	}
+	return nil
+}
+
+// AcquireLease - Take or renew a job lease
+func (m *MemoryStore) AcquireLease(name, holder string, now, expires uint) (bool, error) {
+	defer m.lock()()
+	if lease, exists := m.data.leases[name]; exists && lease.Holder != holder && lease.Expires > now {
+		return false, nil
+	}
+	m.data.leases[name] = LeaseRecord{
+		Name:    name,
+		Holder:  holder,
+		Expires: expires}
+	return true, nil
+}
+
+// logChange - Record a change to a synced resource in its owner's change log (the caller must hold the lock)
+func (m *MemoryStore) logChange(userID uint, kind string, id uint, op changeOp) {
+	d := m.data
+	d.changeSeqs[userID]++
+	key := changeKey{userID, kind, id}
+	change := d.changes[key]
+	change.UserID, change.Kind, change.ResourceID = userID, kind, id
+	change.Seq = d.changeSeqs[userID]
+	change.Deleted = op == opDelete
+	change.SessionID = m.session
+	if op == opCreate {
+		change.CreatedSeq = change.Seq
+	}
+	d.changes[key] = change
+	d.changed[userID] = true
+}
+
+// ListChanges - Retrieve a user's changes after a sequence number
+func (m *MemoryStore) ListChanges(userID uint, since uint64, limit int) ([]ChangeRecord, error) {
+	defer m.lock()()
+	var changes []ChangeRecord
+	for key, change := range m.data.changes {
+		if key.userID == userID && change.Seq > since {
+			changes = append(changes, change)
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Seq < changes[j].Seq
+	})
+	if len(changes) > limit {
+		changes = changes[:limit]
+	}
+	return changes, nil
+}
+
+// AsSession - Return a view of the store that attributes the changes it logs to a session
+func (m *MemoryStore) AsSession(sessionID uint) Store {
+	view := *m
+	view.session = sessionID
+	return &view
+}
+
+// SetNotifier - Set the notifier told about users with new changes
+func (m *MemoryStore) SetNotifier(n Notifier) {
+	m.notifier = n
+}
+
+// ChangeSeq - Retrieve the sequence number of a user's latest change
+func (m *MemoryStore) ChangeSeq(userID uint) (uint64, error) {
+	defer m.lock()()
+	return m.data.changeSeqs[userID], nil
+}
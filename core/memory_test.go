@@ -0,0 +1,331 @@
+package core
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestMemoryStoreAtomic(t *testing.T) {
+	store := NewMemoryStore()
+	user := UserRecord{
+		ID:    1,
+		Email: "user@test.com"}
+	if err := store.CreateUser(user, AuthKeyRecord{}); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Rollback", func(t *testing.T) {
+		failure := errors.New("failure")
+		err := store.Atomic(func(s Store) error {
+			if e := s.CreateTag(TagRecord{ID: 1, UserID: user.ID}); e != nil {
+				return e
+			}
+			return failure
+		})
+		if err != failure {
+			t.Fatalf("Expected Atomic to return the error from fn, got %v", err)
+		}
+		if _, err = store.GetTag(1, user.ID); err != ErrNotFound {
+			t.Error("Expected changes made in a failed transaction to be rolled back")
+		}
+	})
+	t.Run("Commit", func(t *testing.T) {
+		err := store.Atomic(func(s Store) error {
+			return s.CreateTag(TagRecord{ID: 1, UserID: user.ID})
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = store.GetTag(1, user.ID); err != nil {
+			t.Error("Expected changes made in a successful transaction to be kept")
+		}
+	})
+	t.Run("Ownership", func(t *testing.T) {
+		if _, err := store.GetTag(1, user.ID+1); err != ErrNotFound {
+			t.Error("Expected a tag to be hidden from users that don't own it")
+		}
+	})
+	t.Run("Delete User", func(t *testing.T) {
+		if err := store.DeleteUser(user.ID); err != nil {
+			t.Fatal(err)
+		}
+		if exists, _ := store.IDExists("Tags", 1); exists {
+			t.Error("Expected a user's tags to be deleted along with them")
+		}
+	})
+}
+
+func TestChecksum(t *testing.T) {
+	// sha1("abc"), matching MariaDB's SHA(CONCAT('a', 'bc'))
+	if sum := Checksum([]byte("a"), []byte("bc")); sum != "a9993e364706816aba3e25717850c26c9cd0d89d" {
+		t.Errorf("Unexpected checksum %s", sum)
+	}
+}
+
+func TestMemoryStoreChanges(t *testing.T) {
+	store := NewMemoryStore()
+	user := UserRecord{
+		ID:    1,
+		Email: "user@test.com"}
+	if err := store.CreateUser(user, AuthKeyRecord{}); err != nil {
+		t.Fatal(err)
+	}
+	tag := TagRecord{ID: 1, UserID: user.ID, Name: []byte("Tag")}
+	if err := store.CreateTag(tag); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Create", func(t *testing.T) {
+		changes, err := store.ListChanges(user.ID, 0, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(changes) != 1 || changes[0].Seq != 1 || changes[0].CreatedSeq != 1 || changes[0].Kind != KindTag {
+			t.Errorf("Expected the tag's creation to be logged at seq 1, got %+v", changes)
+		}
+	})
+	t.Run("Unchanged Update", func(t *testing.T) {
+		if err := store.UpdateTag(tag); err != nil {
+			t.Fatal(err)
+		}
+		if seq, _ := store.ChangeSeq(user.ID); seq != 1 {
+			t.Errorf("Expected an update that changes nothing not to be logged, seq is %d", seq)
+		}
+	})
+	t.Run("Delete", func(t *testing.T) {
+		if err := store.DeleteTag(tag.ID, user.ID); err != nil {
+			t.Fatal(err)
+		}
+		changes, err := store.ListChanges(user.ID, 1, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Only the latest change to each resource is kept
+		if len(changes) != 1 || changes[0].Seq != 2 || !changes[0].Deleted || changes[0].CreatedSeq != 1 {
+			t.Errorf("Expected a tombstone at seq 2, got %+v", changes)
+		}
+		if changes, _ = store.ListChanges(user.ID, 0, 10); len(changes) != 1 {
+			t.Errorf("Expected the log to be compacted to 1 change, got %d", len(changes))
+		}
+	})
+	t.Run("Rollback", func(t *testing.T) {
+		store.Atomic(func(s Store) error {
+			s.CreateTag(TagRecord{ID: 2, UserID: user.ID})
+			return errors.New("failure")
+		})
+		if seq, _ := store.ChangeSeq(user.ID); seq != 2 {
+			t.Errorf("Expected changes in a failed transaction not to be logged, seq is %d", seq)
+		}
+	})
+}
+
+type testNotifier []uint
+
+func (n *testNotifier) Notify(userID uint) {
+	*n = append(*n, userID)
+}
+
+func TestMemoryStoreNotify(t *testing.T) {
+	store := NewMemoryStore()
+	var notified testNotifier
+	store.SetNotifier(&notified)
+	if err := store.CreateUser(UserRecord{ID: 1}, AuthKeyRecord{}); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Commit", func(t *testing.T) {
+		err := store.AsSession(5).Atomic(func(s Store) error {
+			if e := s.CreateTag(TagRecord{ID: 1, UserID: 1}); e != nil {
+				return e
+			}
+			if len(notified) > 0 {
+				t.Error("Expected users not to be notified before commit")
+			}
+			return s.CreateTag(TagRecord{ID: 2, UserID: 1})
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(notified) != 1 || notified[0] != 1 {
+			t.Errorf("Expected user 1 to be notified once, got %v", notified)
+		}
+		if changes, _ := store.ListChanges(1, 0, 10); len(changes) != 2 || changes[0].SessionID != 5 {
+			t.Errorf("Expected changes to be attributed to session 5, got %+v", changes)
+		}
+	})
+	t.Run("Rollback", func(t *testing.T) {
+		notified = nil
+		store.Atomic(func(s Store) error {
+			s.CreateTag(TagRecord{ID: 3, UserID: 1})
+			return errors.New("failure")
+		})
+		if len(notified) > 0 {
+			t.Error("Expected users not to be notified of rolled back changes")
+		}
+	})
+}
+
+func TestMemoryStoreTodoItems(t *testing.T) {
+	store := NewMemoryStore()
+	if err := store.CreateUser(UserRecord{ID: 1}, AuthKeyRecord{}); err != nil {
+		t.Fatal(err)
+	}
+	list := TodoListRecord{ID: 1, UserID: 1, Items: []TodoItemRecord{
+		{ID: 2, Position: 1, Title: []byte("Second")},
+		{ID: 1, Position: 0, Title: []byte("First")}}}
+	if err := store.CreateTodoList(list); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Items Attached", func(t *testing.T) {
+		stored, err := store.GetTodoList(1, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(stored.Items) != 2 || stored.Items[0].ID != 1 || stored.Items[0].ListID != 1 {
+			t.Errorf("Expected the list's items in position order, got %+v", stored.Items)
+		}
+	})
+	t.Run("Logged As List Update", func(t *testing.T) {
+		before, _ := store.GetTodoList(1, 1)
+		item := before.Items[0]
+		item.Title = []byte("Updated")
+		if err := store.UpdateTodoItem(item); err != nil {
+			t.Fatal(err)
+		}
+		changes, _ := store.ListChanges(1, 1, 10)
+		if len(changes) != 1 || changes[0].Kind != KindTodoList || changes[0].ResourceID != 1 {
+			t.Errorf("Expected an update to the list to be logged, got %+v", changes)
+		}
+		if after, _ := store.GetTodoList(1, 1); after.Checksum() == before.Checksum() {
+			t.Error("Expected the list's checksum to change along with its items")
+		}
+	})
+	t.Run("Moved Between Lists", func(t *testing.T) {
+		seq, _ := store.ChangeSeq(1)
+		item, _ := store.GetTodoItem(2, 1)
+		item.ListID = 0
+		if err := store.UpdateTodoItem(item); err != nil {
+			t.Fatal(err)
+		}
+		changes, _ := store.ListChanges(1, seq, 10)
+		if len(changes) != 2 || changes[0].Kind != KindNoList || changes[1].Kind != KindTodoList {
+			t.Errorf("Expected updates to both lists to be logged, got %+v", changes)
+		}
+		if list, _ := store.GetTodoList(1, 1); len(list.Items) != 1 {
+			t.Errorf("Expected the item to be removed from its list, got %+v", list.Items)
+		}
+	})
+	t.Run("Missing Item", func(t *testing.T) {
+		if err := store.UpdateTodoItem(TodoItemRecord{ID: 3, UserID: 1}); err != ErrNotFound {
+			t.Errorf("Expected ErrNotFound updating a nonexistent item, got %v", err)
+		}
+	})
+	t.Run("Deleted With List", func(t *testing.T) {
+		if err := store.DeleteTodoList(1, 1); err != nil {
+			t.Fatal(err)
+		}
+		if exists, _ := store.IDExists("TodoItems", 1); exists {
+			t.Error("Expected a list's items to be deleted along with it")
+		}
+	})
+}
+
+func TestMemoryStoreTrash(t *testing.T) {
+	store := NewMemoryStore()
+	user := UserRecord{
+		ID:    1,
+		Email: "user@test.com"}
+	if err := store.CreateUser(user, AuthKeyRecord{}); err != nil {
+		t.Fatal(err)
+	}
+	list := TodoListRecord{
+		ID:     1,
+		UserID: user.ID,
+		Title:  []byte("List"),
+		Rank:   "V",
+		Items: []TodoItemRecord{{
+			ID:    1,
+			Title: []byte("Item")}}}
+	if err := store.CreateTodoList(list); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.CreateTag(TagRecord{ID: 1, UserID: user.ID}); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Trash", func(t *testing.T) {
+		if err := store.TrashTodoList(list.ID, user.ID, 100); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.TrashTag(1, user.ID, 200); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := store.GetTodoList(list.ID, user.ID); err != ErrNotFound {
+			t.Error("Expected a trashed list to be hidden")
+		}
+		if tags, _ := store.ListTags(user.ID); len(tags) != 0 {
+			t.Error("Expected a trashed tag to be hidden")
+		}
+		if exists, _ := store.IDExists("TodoLists", list.ID); !exists {
+			t.Error("Expected a trashed list's ID to stay in use")
+		}
+		changes, _ := store.ListChanges(user.ID, 2, 10)
+		if len(changes) != 2 || !changes[0].Deleted || !changes[1].Deleted {
+			t.Errorf("Expected trashing to be logged as deletions, got %+v", changes)
+		}
+
+		lists, err := store.ListTrashedTodoLists(user.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(lists) != 1 || lists[0].Trashed != 100 || len(lists[0].Items) != 1 {
+			t.Errorf("Expected the trashed list to be listed along with its items, got %+v", lists)
+		}
+		if trashed, err := store.GetTrashedTodoList(list.ID, user.ID); err != nil || len(trashed.Items) != 1 {
+			t.Errorf("Expected the trashed list to be retrieved along with its items, got %+v (%v)", trashed, err)
+		}
+		if tag, err := store.GetTrashedTag(1, user.ID); err != nil || tag.Trashed != 200 {
+			t.Errorf("Expected the trashed tag to be retrieved, got %+v (%v)", tag, err)
+		}
+		if _, err := store.GetTrashedTag(2, user.ID); err != ErrNotFound {
+			t.Error("Expected retrieving a tag that isn't in the trash to fail")
+		}
+	})
+	t.Run("Restore", func(t *testing.T) {
+		if err := store.RestoreTodoList(list.ID, user.ID, "W"); err != nil {
+			t.Fatal(err)
+		}
+		restored, err := store.GetTodoList(list.ID, user.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if restored.Rank != "W" || restored.Trashed != 0 || restored.Checksum() != list.Checksum() {
+			t.Errorf("Expected the list to be restored with its new rank, got %+v", restored)
+		}
+		if err = store.RestoreTodoList(list.ID, user.ID, "W"); err != ErrNotFound {
+			t.Error("Expected restoring a list that isn't in the trash to fail")
+		}
+	})
+	t.Run("Purge", func(t *testing.T) {
+		if err := store.PurgeTodoList(list.ID, user.ID); err != ErrNotFound {
+			t.Error("Expected purging a list that isn't in the trash to fail")
+		}
+		if err := store.PurgeTag(1, user.ID); err != nil {
+			t.Fatal(err)
+		}
+		if exists, _ := store.IDExists("Tags", 1); exists {
+			t.Error("Expected a purged tag to be deleted")
+		}
+		store.TrashTodoList(list.ID, user.ID, 300)
+		if purged, _ := store.PurgeExpiredTrash(300); purged != 0 {
+			t.Errorf("Expected nothing trashed at or after the cutoff to be purged, %d purged", purged)
+		}
+		if purged, _ := store.PurgeExpiredTrash(301); purged != 1 {
+			t.Errorf("Expected the trashed list to be purged, %d purged", purged)
+		}
+		if exists, _ := store.IDExists("TodoItems", 1); exists {
+			t.Error("Expected a purged list's items to be deleted")
+		}
+	})
+}
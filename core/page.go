@@ -0,0 +1,121 @@
+package core
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"net/http"
+	"strconv"
+)
+
+var errMalformedCursor = errors.New("malformed cursor")
+
+// Page sizes for paginated collections
+const (
+	DefaultPageSize = 50
+	MaxPageSize     = 200
+)
+
+// Page - The page of a collection requested with ?limit= and ?cursor=
+type Page struct {
+	// Limit - Maximum number of members to return, or 0 if the whole collection was requested
+	Limit int
+	// After - Sort key of the last member of the previous page, or nil for the first page
+	After []uint64
+	// AfterRank - Rank of the last member of the previous page, for collections sorted by rank before the fields of After
+	AfterRank string
+}
+
+// ParsePage - Parse the page requested by r, for a collection sorted by a key with the given number of fields
+// Collections are only paginated if a limit or cursor is sent, so that clients that don't paginate still receive every member
+func ParsePage(r *http.Request, fields int) (page Page, e *HTTPError) {
+	return parsePage(r, fields, false)
+}
+
+// ParseRankedPage - Parse the page requested by r, for a collection sorted by rank and then a key with the given number of fields
+func ParseRankedPage(r *http.Request, fields int) (page Page, e *HTTPError) {
+	return parsePage(r, fields, true)
+}
+
+func parsePage(r *http.Request, fields int, ranked bool) (page Page, e *HTTPError) {
+	query := r.URL.Query()
+	if l := query.Get("limit"); len(l) > 0 {
+		limit, err := strconv.Atoi(l)
+		if err != nil || limit <= 0 || limit > MaxPageSize {
+			return page, &HTTPError{
+				Code:    CodeBadRequest,
+				Title:   "Bad Request",
+				Message: "The limit must be an integer between 1 and " + strconv.Itoa(MaxPageSize),
+				Status:  400}
+		}
+		page.Limit = limit
+	}
+	if c := query.Get("cursor"); len(c) > 0 {
+		after, rank, err := decodeCursor(c, fields, ranked)
+		if err != nil {
+			return page, &HTTPError{
+				Code:    CodeInvalidCursor,
+				Title:   "Invalid Cursor",
+				Message: "The cursor is malformed, request the first page again",
+				Status:  400}
+		}
+		page.After = after
+		page.AfterRank = rank
+		if page.Limit == 0 {
+			page.Limit = DefaultPageSize
+		}
+	}
+	return page, nil
+}
+
+// Paginated - Whether a page of the collection was requested, rather than the whole collection
+func (page Page) Paginated() bool {
+	return page.Limit > 0
+}
+
+// Key - Return field i of the sort key to start after, or 0 for the first page
+func (page Page) Key(i int) uint64 {
+	if page.After == nil {
+		return 0
+	}
+	return page.After[i]
+}
+
+// SetNext - Set a Link header referring to the page after one ending with a member sorted by key
+func (page Page) SetNext(w http.ResponseWriter, r *http.Request, key ...uint64) {
+	page.SetNextRanked(w, r, "", key...)
+}
+
+// SetNextRanked - Set a Link header referring to the page after one ending with a member sorted by rank and then key
+func (page Page) SetNextRanked(w http.ResponseWriter, r *http.Request, rank string, key ...uint64) {
+	query := r.URL.Query()
+	query.Set("limit", strconv.Itoa(page.Limit))
+	query.Set("cursor", encodeCursor(rank, key))
+	w.Header().Set("Link", "<"+r.URL.Path+"?"+query.Encode()+`>; rel="next"`)
+}
+
+// encodeCursor - Encode a sort key (followed by a rank, if the collection is ranked) as an opaque cursor
+func encodeCursor(rank string, key []uint64) string {
+	b := make([]byte, 8*len(key), 8*len(key)+len(rank))
+	for i, field := range key {
+		binary.BigEndian.PutUint64(b[8*i:], field)
+	}
+	b = append(b, rank...)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// decodeCursor - Decode a cursor encoding a sort key with the given number of fields, followed by a rank if the collection is ranked
+func decodeCursor(cursor string, fields int, ranked bool) ([]uint64, string, error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(b) < 8*fields || !ranked && len(b) != 8*fields || ranked && len(b) == 8*fields {
+		return nil, "", errMalformedCursor
+	}
+	key := make([]uint64, fields)
+	for i := range key {
+		key[i] = binary.BigEndian.Uint64(b[8*i:])
+	}
+	return key, string(b[8*fields:]), nil
+}
@@ -0,0 +1,73 @@
+package core
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParsePage(t *testing.T) {
+	cursor := encodeCursor("", []uint64{3, 1 << 40})
+	tests := []struct {
+		query string
+		page  Page
+		code  string
+	}{
+		{"", Page{}, ""},
+		{"?limit=10", Page{Limit: 10}, ""},
+		{"?limit=10&cursor=" + cursor, Page{Limit: 10, After: []uint64{3, 1 << 40}}, ""},
+		{"?cursor=" + cursor, Page{Limit: DefaultPageSize, After: []uint64{3, 1 << 40}}, ""},
+		{"?limit=0", Page{}, CodeBadRequest},
+		{"?limit=1000", Page{}, CodeBadRequest},
+		{"?cursor=not-a-cursor", Page{}, CodeInvalidCursor},
+		// Cursors for a key with a different number of fields are rejected
+		{"?cursor=" + encodeCursor("", []uint64{3}), Page{}, CodeInvalidCursor}}
+
+	for _, test := range tests {
+		page, err := ParsePage(httptest.NewRequest("GET", "/todos"+test.query, nil), 2)
+		if len(test.code) > 0 {
+			if err == nil || err.Code != test.code {
+				t.Errorf("Expected %s to fail with %s, got %v", test.query, test.code, err)
+			}
+		} else if err != nil || !reflect.DeepEqual(page, test.page) {
+			t.Errorf("Expected %s to be parsed as %+v, got %+v (%v)", test.query, test.page, page, err)
+		}
+	}
+}
+
+func TestSetNext(t *testing.T) {
+	r := httptest.NewRequest("GET", "/tags?limit=5", nil)
+	w := httptest.NewRecorder()
+	Page{Limit: 5}.SetNext(w, r, 42)
+	link := w.Header().Get("Link")
+	if !strings.HasPrefix(link, "</tags?") || !strings.HasSuffix(link, `>; rel="next"`) {
+		t.Fatalf("Unexpected Link header %s", link)
+	}
+
+	next := httptest.NewRequest("GET", strings.TrimSuffix(strings.TrimPrefix(link, "<"), `>; rel="next"`), nil)
+	page, err := ParsePage(next, 1)
+	if err != nil || page.Limit != 5 || page.Key(0) != 42 {
+		t.Errorf("Expected the next page to start after 42, got %+v (%v)", page, err)
+	}
+}
+
+func TestRankedPage(t *testing.T) {
+	r := httptest.NewRequest("GET", "/todos?limit=5", nil)
+	w := httptest.NewRecorder()
+	Page{Limit: 5}.SetNextRanked(w, r, "U1V", 42)
+	link := w.Header().Get("Link")
+	next := httptest.NewRequest("GET", strings.TrimSuffix(strings.TrimPrefix(link, "<"), `>; rel="next"`), nil)
+	page, err := ParseRankedPage(next, 1)
+	if err != nil || page.AfterRank != "U1V" || page.Key(0) != 42 {
+		t.Errorf("Expected the next page to start after (U1V, 42), got %+v (%v)", page, err)
+	}
+	// Cursors of unranked collections are rejected, and vice versa
+	if _, err = ParsePage(next, 1); err == nil {
+		t.Error("Expected a ranked cursor to be rejected for an unranked collection")
+	}
+	unranked := httptest.NewRequest("GET", "/todos?cursor="+encodeCursor("", []uint64{42}), nil)
+	if _, err = ParseRankedPage(unranked, 1); err == nil {
+		t.Error("Expected an unranked cursor to be rejected for a ranked collection")
+	}
+}
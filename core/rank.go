@@ -0,0 +1,153 @@
+package core
+
+import (
+	"sort"
+	"strings"
+)
+
+// Ranks are strings of base 62 digits that sort lexicographically (by byte value), used to order resources without renumbering them
+// A rank can always be generated between two others, so moving a resource only changes its own rank
+// Ranks never end with the lowest digit, which would leave no room for a rank between them and their prefix
+const rankDigits = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// MaxRankLength - Length past which ranks are rebalanced rather than generated between their neighbors
+const MaxRankLength = 64
+
+// RankBetween - Generate a rank that sorts after a and before b, an empty a or b is the start or end of the order
+// Returns false if there's no room for a rank between them (a and b aren't in order, or it would be too long), in which case every rank must be rebalanced
+func RankBetween(a, b string) (rank string, ok bool) {
+	if len(b) > 0 && a >= b {
+		return "", false
+	}
+	rank = midpoint(a, b)
+	if len(rank) > MaxRankLength || rank <= a || len(b) > 0 && rank >= b {
+		return "", false
+	}
+	return rank, true
+}
+
+// digitAt - Return the value of the digit of rank at i, ranks are treated as if they're padded with the lowest digit
+func digitAt(rank string, i int) int {
+	if i >= len(rank) {
+		return 0
+	}
+	return strings.IndexByte(rankDigits, rank[i])
+}
+
+// midpoint - Return a rank between a and b (an empty b is the end of the order), using the shortest rank possible
+func midpoint(a, b string) string {
+	if len(b) > 0 {
+		// Ranks that share a prefix only differ after it
+		n := 0
+		for n < len(b) && digitAt(a, n) == digitAt(b, n) {
+			n++
+		}
+		if n > 0 {
+			rest := ""
+			if n < len(a) {
+				rest = a[n:]
+			}
+			return b[:n] + midpoint(rest, b[n:])
+		}
+	}
+
+	low := digitAt(a, 0)
+	high := len(rankDigits)
+	if len(b) > 0 {
+		high = digitAt(b, 0)
+	}
+	if high-low > 1 {
+		return string(rankDigits[(low+high)/2])
+	}
+	// The first digits are consecutive, so the rank is either b's first digit alone (if b is longer) or a's first digit followed by a rank after the rest of a
+	if len(b) > 1 {
+		return b[:1]
+	}
+	rest := ""
+	if len(a) > 1 {
+		rest = a[1:]
+	}
+	return string(rankDigits[low]) + midpoint(rest, "")
+}
+
+// SpreadRanks - Generate n evenly spaced ranks in order, used to rebalance an order
+func SpreadRanks(n int) []string {
+	// Leave room for at least 4 moves between each pair of neighbors before they need to be made longer
+	width := 1
+	space := uint64(len(rankDigits))
+	for space < 16*uint64(n+1) {
+		width++
+		space *= uint64(len(rankDigits))
+	}
+
+	ranks := make([]string, n)
+	for i := range ranks {
+		value := uint64(i+1) * (space / uint64(n+1))
+		digits := make([]byte, width)
+		for j := width - 1; j >= 0; j-- {
+			digits[j] = rankDigits[value%uint64(len(rankDigits))]
+			value /= uint64(len(rankDigits))
+		}
+		ranks[i] = strings.TrimRight(string(digits), rankDigits[:1])
+	}
+	return ranks
+}
+
+// Rerank - Return new ranks for resources that are being put in the order of ranks, keeping as many of their ranks as possible
+// The resources with the longest sequence of ranks that are already in order keep them, and the rest are ranked between them
+// (unless there's no room, in which case every resource is rebalanced)
+func Rerank(ranks []string) []string {
+	reranked := make([]string, len(ranks))
+	keep := inOrder(ranks)
+	before := ""
+	for i := range ranks {
+		if keep[i] {
+			reranked[i] = ranks[i]
+			before = ranks[i]
+			continue
+		}
+		after := ""
+		for j := i + 1; j < len(ranks); j++ {
+			if keep[j] {
+				after = ranks[j]
+				break
+			}
+		}
+		rank, ok := RankBetween(before, after)
+		if !ok {
+			return SpreadRanks(len(ranks))
+		}
+		reranked[i] = rank
+		before = rank
+	}
+	return reranked
+}
+
+// inOrder - Mark the longest sequence of ranks that are strictly increasing
+func inOrder(ranks []string) []bool {
+	// tails[n] is the index of the lowest rank ending an increasing sequence of length n+1
+	tails := make([]int, 0, len(ranks))
+	prev := make([]int, len(ranks))
+	for i, rank := range ranks {
+		n := sort.Search(len(tails), func(n int) bool {
+			return ranks[tails[n]] >= rank
+		})
+		prev[i] = -1
+		if n > 0 {
+			prev[i] = tails[n-1]
+		}
+		if n == len(tails) {
+			tails = append(tails, i)
+		} else {
+			tails[n] = i
+		}
+	}
+
+	keep := make([]bool, len(ranks))
+	if len(tails) > 0 {
+		for i := tails[len(tails)-1]; i >= 0; i = prev[i] {
+			keep[i] = true
+		}
+	}
+	return keep
+}
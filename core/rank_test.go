@@ -0,0 +1,99 @@
+package core
+
+import (
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+func TestRankBetween(t *testing.T) {
+	tests := []struct {
+		a, b string
+		ok   bool
+	}{
+		{"", "", true},
+		{"", "1", true},
+		{"z", "", true},
+		{"U", "V", true},
+		{"U", "U1", true},
+		{"Tzz", "U00V", true},
+		{"V", "U", false},
+		{"U", "U", false}}
+	for _, test := range tests {
+		rank, ok := RankBetween(test.a, test.b)
+		if ok != test.ok {
+			t.Errorf("Expected RankBetween(%q, %q) to return ok=%t, got %q", test.a, test.b, test.ok, rank)
+		} else if ok && (rank <= test.a || len(test.b) > 0 && rank >= test.b || rank[len(rank)-1] == '0') {
+			t.Errorf("RankBetween(%q, %q) returned %q, which isn't between them", test.a, test.b, rank)
+		}
+	}
+}
+
+func TestRankOrder(t *testing.T) {
+	// Insert ranks at random positions, rebalancing when there's no room, and check that the order is kept
+	random := rand.New(rand.NewSource(1))
+	var ranks []string
+	rebalanced := 0
+	for i := 0; i < 2000; i++ {
+		position := random.Intn(len(ranks) + 1)
+		// Favor inserting at the start, the worst case for rank length
+		if i%2 == 0 {
+			position = 0
+		}
+		var before, after string
+		if position > 0 {
+			before = ranks[position-1]
+		}
+		if position < len(ranks) {
+			after = ranks[position]
+		}
+		rank, ok := RankBetween(before, after)
+		if !ok {
+			ranks = SpreadRanks(len(ranks))
+			rebalanced++
+			i--
+			continue
+		}
+		ranks = append(ranks[:position], append([]string{rank}, ranks[position:]...)...)
+		if !sort.StringsAreSorted(ranks) {
+			t.Fatalf("Ranks are out of order after inserting %q at %d", rank, position)
+		}
+	}
+	if rebalanced == 0 {
+		t.Error("Expected ranks to be rebalanced after growing past the max length")
+	}
+	if spread := SpreadRanks(len(ranks)); !sort.StringsAreSorted(spread) {
+		t.Error("Expected spread ranks to be in order")
+	}
+}
+
+func TestRerank(t *testing.T) {
+	ranks := SpreadRanks(10)
+	random := rand.New(rand.NewSource(1))
+	for i := 0; i < 200; i++ {
+		// Move a random rank to a random position
+		from, to := random.Intn(len(ranks)), random.Intn(len(ranks))
+		moved := ranks[from]
+		order := append(append([]string{}, ranks[:from]...), ranks[from+1:]...)
+		order = append(order[:to], append([]string{moved}, order[to:]...)...)
+
+		reranked := Rerank(order)
+		if !sort.StringsAreSorted(reranked) {
+			t.Fatalf("Reranked %v out of order: %v", order, reranked)
+		}
+		changed := 0
+		for j := range order {
+			if reranked[j] != order[j] {
+				changed++
+			}
+		}
+		if changed > 1 && changed != len(order) {
+			t.Errorf("Expected moving one rank to change only its rank (or rebalance every rank), %d changed", changed)
+		}
+		ranks = reranked
+	}
+
+	if reranked := Rerank([]string{"c", "b", "a"}); !sort.StringsAreSorted(reranked) || reranked[0] != "c" && reranked[1] != "b" && reranked[2] != "a" {
+		t.Errorf("Expected one rank to be kept when reversing an order, got %v", reranked)
+	}
+}
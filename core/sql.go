@@ -3,10 +3,12 @@ package core
 import (
 	"fmt"
 	"log"
-	"os"
+	"strings"
 
 	"github.com/jmoiron/sqlx"
 
+	"github.com/very-amused/CSplan-API/config"
+
 	// MySQL Database driver
 	_ "github.com/go-sql-driver/mysql"
 )
@@ -14,20 +16,20 @@ import (
 // DB - MariaDB Connection Pool
 var DB *sqlx.DB
 
-// DB constants
-var User = "admin"
-var password = os.Getenv("MARIADB_PASSWORD")
-
-const database = "CSplanGo"
-
 // dsn - Return SQL data source name
-func dsn() string {
-	return fmt.Sprintf("%s:%s@/%s", User, password, database)
+func dsn(c config.Database) string {
+	var address string
+	if strings.HasPrefix(c.Host, "/") {
+		address = fmt.Sprintf("unix(%s)", c.Host)
+	} else if len(c.Host) > 0 {
+		address = fmt.Sprintf("tcp(%s)", c.Host)
+	}
+	return fmt.Sprintf("%s:%s@%s/%s", c.User, c.Password, address, c.Name)
 }
 
-// Connect to the database, should be called after parsing flags
+// DBConnect - Connect to the database, should be called after the config has been loaded
 func DBConnect() {
-	db, err := sqlx.Connect("mysql", dsn())
+	db, err := sqlx.Connect("mysql", dsn(config.Current().Database))
 	if err != nil {
 		log.Fatalf("Failed to connect to MariaDB:\n%s", err)
 	}
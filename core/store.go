@@ -0,0 +1,390 @@
+package core
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+)
+
+// ErrNotFound - Returned by a Store when the requested record doesn't exist
+var ErrNotFound = errors.New("record not found")
+
+// Store - Persistence layer used by every route handler
+// Encrypted fields are passed to and from a Store as raw bytes, all base64 handling and checksumming is done in Go
+// so that every implementation produces identical output
+type Store interface {
+	// Atomic - Run fn against a transactional view of the store, committing only if fn returns nil
+	// Calling Atomic on a view that is already transactional runs fn within the existing transaction
+	Atomic(fn func(s Store) error) error
+	// IDExists - Report whether a record with the given ID exists in the given table (Users, Sessions, Challenges, TodoLists, TodoItems, Tags)
+	IDExists(table string, id uint) (bool, error)
+
+	UserStore
+	SessionStore
+	ChallengeStore
+	TodoStore
+	TodoItemStore
+	NoListStore
+	TagStore
+	TrashStore
+	NameStore
+	KeyStore
+	SettingsStore
+	LeaseStore
+	ChangeStore
+}
+
+// UserStore - Storage of users and their authentication factors
+type UserStore interface {
+	// CreateUser - Create a user along with their authentication key and default settings
+	CreateUser(user UserRecord, key AuthKeyRecord) error
+	GetUser(id uint) (UserRecord, error)
+	GetUserByEmail(email string) (UserRecord, error)
+	// DeleteUser - Permanently delete a user and every resource belonging to them
+	DeleteUser(id uint) error
+
+	GetAuthKey(userID uint) (AuthKeyRecord, error)
+	UpdateAuthKey(key AuthKeyRecord) error
+
+	GetTOTP(userID uint) (TOTPRecord, error)
+	CreateTOTP(totp TOTPRecord) error
+	UpdateTOTP(totp TOTPRecord) error
+	DeleteTOTP(userID uint) error
+
+	GetDeleteToken(userID uint) (DeleteTokenRecord, error)
+	CreateDeleteToken(token DeleteTokenRecord) error
+	// DeleteExpiredDeleteTokens - Delete tokens created before the given time, returning the number deleted
+	DeleteExpiredDeleteTokens(createdBefore uint) (int64, error)
+}
+
+// SessionStore - Storage of login sessions
+type SessionStore interface {
+	CreateSession(session SessionRecord) error
+	GetSession(id, userID uint) (SessionRecord, error)
+	ListSessions(userID uint) ([]SessionRecord, error)
+	// TouchSession - Update the last time a session was used
+	TouchSession(id, lastUsed uint) error
+	// CountSessions - Count the sessions (of all users) that have been used since the given time
+	CountSessions(usedSince uint) (uint, error)
+	DeleteSession(id, userID uint) error
+	// DeleteExpiredSessions - Delete sessions created at or before createdBefore that haven't been used after lastUsedBefore,
+	// returning the number deleted
+	DeleteExpiredSessions(createdBefore, lastUsedBefore uint) (int64, error)
+}
+
+// ChallengeStore - Storage of authentication challenges
+type ChallengeStore interface {
+	CreateChallenge(challenge ChallengeRecord) error
+	GetChallenge(id uint) (ChallengeRecord, error)
+	// CountChallenges - Count the number of pending and failed challenges for a user
+	CountChallenges(userID uint) (pending, failed uint, e error)
+	FailChallenge(id uint) error
+	DeleteChallenge(id uint) error
+	// DeleteExpiredChallenges - Delete pending challenges requested before pendingBefore and failed challenges requested before failedBefore,
+	// returning the number deleted
+	DeleteExpiredChallenges(pendingBefore, failedBefore uint) (int64, error)
+}
+
+// TodoStore - Storage of todo lists
+// Lists are retrieved along with their items, ordered by position
+type TodoStore interface {
+	// CreateTodoList - Store a new todo list along with its items
+	CreateTodoList(list TodoListRecord) error
+	GetTodoList(id, userID uint) (TodoListRecord, error)
+	// ListTodoLists - Retrieve all of a user's todo lists, ordered by rank then ID
+	ListTodoLists(userID uint) ([]TodoListRecord, error)
+	// PageTodoLists - Retrieve up to limit of a user's todo lists sorted after (afterRank, afterID), ordered by rank then ID
+	PageTodoLists(userID uint, afterRank string, afterID uint, limit int) ([]TodoListRecord, error)
+	// UpdateTodoList - Overwrite all fields of a todo list, including its rank (its items are updated through TodoItemStore)
+	UpdateTodoList(list TodoListRecord) error
+	// DeleteTodoList - Permanently delete a todo list along with its items, without moving it to the trash
+	DeleteTodoList(id, userID uint) error
+}
+
+// TodoItemStore - Storage of the items of todo lists and nolist collections
+// Changes to an item are logged as updates to the list (or nolist collection) containing it
+type TodoItemStore interface {
+	CreateTodoItem(item TodoItemRecord) error
+	GetTodoItem(id, userID uint) (TodoItemRecord, error)
+	// UpdateTodoItem - Overwrite all fields of an item, including the list it belongs to (moving it logs updates to both lists)
+	// ErrNotFound is returned if the item doesn't exist
+	UpdateTodoItem(item TodoItemRecord) error
+	DeleteTodoItem(id, userID uint) error
+}
+
+// NoListStore - Storage of nolist collections
+// Collections are retrieved along with their items, ordered by position
+type NoListStore interface {
+	// CreateNoList - Store a user's nolist collection along with its items
+	CreateNoList(nolist NoListRecord) error
+	GetNoList(userID uint) (NoListRecord, error)
+	// UpdateNoList - Overwrite a nolist collection's key (its items are updated through TodoItemStore)
+	UpdateNoList(nolist NoListRecord) error
+}
+
+// TagStore - Storage of tags
+type TagStore interface {
+	CreateTag(tag TagRecord) error
+	GetTag(id, userID uint) (TagRecord, error)
+	ListTags(userID uint) ([]TagRecord, error)
+	// PageTags - Retrieve up to limit of a user's tags with an ID greater than afterID, ordered by ID
+	PageTags(userID uint, afterID uint, limit int) ([]TagRecord, error)
+	UpdateTag(tag TagRecord) error
+	// DeleteTag - Permanently delete a tag, without moving it to the trash
+	DeleteTag(id, userID uint) error
+}
+
+// TrashStore - Storage of deleted todo lists and tags, which are kept until they're restored or purged
+// Trashed resources aren't retrieved by TodoStore or TagStore, moving a resource to the trash logs its deletion and restoring it logs its creation
+type TrashStore interface {
+	// TrashTodoList - Move a todo list (along with its items) to the trash, recording when it was trashed
+	TrashTodoList(id, userID, trashed uint) error
+	// ListTrashedTodoLists - Retrieve a user's trashed todo lists along with their items, most recently trashed first
+	ListTrashedTodoLists(userID uint) ([]TodoListRecord, error)
+	// GetTrashedTodoList - Retrieve a trashed todo list along with its items
+	GetTrashedTodoList(id, userID uint) (TodoListRecord, error)
+	// RestoreTodoList - Move a todo list out of the trash, giving it a new rank
+	RestoreTodoList(id, userID uint, rank string) error
+	// PurgeTodoList - Permanently delete a trashed todo list along with its items
+	PurgeTodoList(id, userID uint) error
+
+	// TrashTag - Move a tag to the trash, recording when it was trashed
+	TrashTag(id, userID, trashed uint) error
+	// ListTrashedTags - Retrieve a user's trashed tags, most recently trashed first
+	ListTrashedTags(userID uint) ([]TagRecord, error)
+	// GetTrashedTag - Retrieve a trashed tag
+	GetTrashedTag(id, userID uint) (TagRecord, error)
+	RestoreTag(id, userID uint) error
+	// PurgeTag - Permanently delete a trashed tag
+	PurgeTag(id, userID uint) error
+
+	// PurgeExpiredTrash - Permanently delete the todo lists and tags (of every user) trashed before trashedBefore, returning the number deleted
+	PurgeExpiredTrash(trashedBefore uint) (int64, error)
+}
+
+// NameStore - Storage of user names
+type NameStore interface {
+	CreateName(name NameRecord) error
+	GetName(userID uint) (NameRecord, error)
+	UpdateName(name NameRecord) error
+	DeleteName(userID uint) error
+}
+
+// KeyStore - Storage of user keypairs
+type KeyStore interface {
+	CreateKeys(keys KeysRecord) error
+	GetKeys(userID uint) (KeysRecord, error)
+	UpdateKeys(keys KeysRecord) error
+}
+
+// SettingsStore - Storage of user privacy settings
+type SettingsStore interface {
+	GetSettings(userID uint) (SettingsRecord, error)
+	UpdateSettings(settings SettingsRecord) error
+}
+
+// LeaseStore - Storage of time limited leases, used to elect a single instance to run each background job
+type LeaseStore interface {
+	// AcquireLease - Take (or renew) the named lease for holder until expires, reporting whether it was acquired
+	// A lease can only be taken from another holder once it has expired (its expiry is at or before now)
+	AcquireLease(name, holder string, now, expires uint) (bool, error)
+}
+
+// UserRecord - A stored user
+type UserRecord struct {
+	ID       uint
+	Email    string
+	Verified bool
+}
+
+// AuthKeyRecord - A user's salted authentication key, along with the parameters used to derive it
+type AuthKeyRecord struct {
+	UserID     uint
+	AuthKey    []byte
+	HashParams []byte // JSON encoded
+}
+
+// TOTPRecord - A user's TOTP secret and remaining backup codes
+type TOTPRecord struct {
+	UserID      uint
+	Secret      []byte `db:"_Secret"`
+	BackupCodes []byte // JSON encoded
+}
+
+// DeleteTokenRecord - A token used to confirm the deletion of an account
+type DeleteTokenRecord struct {
+	UserID    uint
+	Token     string
+	Timestamp uint `db:"_Timestamp"`
+}
+
+// SessionRecord - A stored login session
+type SessionRecord struct {
+	ID         uint
+	UserID     uint
+	Token      []byte
+	CSRFtoken  []byte
+	Created    uint
+	LastUsed   uint
+	DeviceInfo string
+	// EnableIPLogging - The user's IP logging setting, retrieved along with the session by GetSession so that it's available to every authenticated request
+	EnableIPLogging bool
+}
+
+// ChallengeRecord - A stored authentication challenge
+type ChallengeRecord struct {
+	ID        uint
+	UserID    uint
+	Data      []byte `db:"_Data"`
+	Failed    bool
+	Timestamp uint `db:"_Timestamp"`
+}
+
+// TodoListRecord - A stored todo list
+type TodoListRecord struct {
+	ID     uint
+	UserID uint
+	Title  []byte
+	Items  []TodoItemRecord // Stored separately, ordered by position
+	Rank   string           `db:"_Rank"` // Generated by RankBetween
+	// Index - Position of the list in the user's lists, set when lists are retrieved (it's derived from the list's rank rather than stored)
+	Index     uint `db:"_Index"`
+	CryptoKey []byte
+	// Trashed - Unix time the list was moved to the trash, or 0 if it isn't in the trash
+	Trashed uint
+}
+
+// Checksum - Checksum of a todo list's encrypted fields and the checksums of its items
+func (list TodoListRecord) Checksum() string {
+	return Checksum(append([][]byte{list.Title}, itemChecksums(list.Items)...)...)
+}
+
+// NoListRecord - A stored nolist collection
+type NoListRecord struct {
+	UserID    uint
+	Items     []TodoItemRecord // Stored separately, ordered by position
+	CryptoKey []byte
+}
+
+// Checksum - Checksum of a nolist collection's item checksums and key
+func (nolist NoListRecord) Checksum() string {
+	return Checksum(append(itemChecksums(nolist.Items), nolist.CryptoKey)...)
+}
+
+// TodoItemRecord - A stored item of a todo list or nolist collection
+type TodoItemRecord struct {
+	ID          uint
+	UserID      uint
+	ListID      uint // 0 for items in the nolist collection
+	Position    uint `db:"_Position"`
+	Title       []byte
+	Description []byte
+	Done        []byte
+	Tags        []byte // JSON encoded
+	// CryptoKey - The item's own key, or empty if the item is encrypted with its list's key
+	CryptoKey []byte
+}
+
+// Checksum - Checksum of an item's encrypted fields and key
+func (item TodoItemRecord) Checksum() string {
+	return Checksum(item.Title, item.Description, item.Done, item.Tags, item.CryptoKey)
+}
+
+// itemChecksums - Return the checksum of each item, for inclusion in the checksum of the list containing them
+func itemChecksums(items []TodoItemRecord) [][]byte {
+	checksums := make([][]byte, len(items))
+	for i, item := range items {
+		checksums[i] = []byte(item.Checksum())
+	}
+	return checksums
+}
+
+// TagRecord - A stored tag
+type TagRecord struct {
+	ID        uint
+	UserID    uint
+	Name      []byte
+	Color     []byte
+	CryptoKey []byte
+	// Trashed - Unix time the tag was moved to the trash, or 0 if it isn't in the trash
+	Trashed uint
+}
+
+// Checksum - Checksum of a tag's encrypted fields and key
+func (tag TagRecord) Checksum() string {
+	return Checksum(tag.Name, tag.Color, tag.CryptoKey)
+}
+
+// NameRecord - A user's stored name
+type NameRecord struct {
+	UserID    uint
+	FirstName []byte
+	LastName  []byte
+	Username  []byte
+	CryptoKey []byte
+}
+
+// Checksum - Checksum of a name's encrypted fields
+func (name NameRecord) Checksum() string {
+	return Checksum(name.FirstName, name.LastName, name.Username)
+}
+
+// KeysRecord - A user's stored keypair
+type KeysRecord struct {
+	UserID     uint
+	PublicKey  []byte
+	PrivateKey []byte
+	HashSalt   []byte
+	HashParams []byte // JSON encoded
+}
+
+// Checksum - Checksum of a user's keys and hash parameters
+func (keys KeysRecord) Checksum() string {
+	return Checksum(keys.PublicKey, keys.PrivateKey, keys.HashSalt, keys.HashParams)
+}
+
+// SettingsRecord - A user's stored privacy settings
+type SettingsRecord struct {
+	UserID          uint
+	EnableIPLogging bool
+	EnableReminders bool
+}
+
+// LeaseRecord - A stored job lease
+type LeaseRecord struct {
+	Name    string
+	Holder  string
+	Expires uint
+}
+
+// Checksum - Hex encoded SHA1 sum of the concatenation of fields (equivalent to MariaDB's SHA(CONCAT(...)))
+func Checksum(fields ...[]byte) string {
+	hash := sha1.New()
+	for _, field := range fields {
+		hash.Write(field)
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// FromBase64 - Decode a standard base64 string, input is expected to have already been validated
+func FromBase64(s string) []byte {
+	decoded, _ := base64.StdEncoding.DecodeString(s)
+	return decoded
+}
+
+// ToBase64 - Encode bytes as a standard base64 string
+func ToBase64(b []byte) string {
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+// StoreFrom - Retrieve the store attached to a request context
+func StoreFrom(ctx context.Context) Store {
+	return ctx.Value(Key("store")).(Store)
+}
+
+// WithStore - Attach a store to a context
+func WithStore(ctx context.Context, s Store) context.Context {
+	return context.WithValue(ctx, Key("store"), s)
+}
@@ -1,8 +1,9 @@
 module github.com/very-amused/CSplan-API
 
-go 1.15
+go 1.16
 
 require (
+	github.com/BurntSushi/toml v1.2.1
 	github.com/go-playground/universal-translator v0.17.0 // indirect
 	github.com/go-playground/validator v9.31.0+incompatible
 	github.com/go-redis/redis v6.15.9+incompatible
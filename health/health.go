@@ -0,0 +1,118 @@
+// Package health - Liveness and readiness endpoints for load balancers and orchestrators
+package health
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// Check - A named dependency check, returning an error if the dependency isn't usable
+type Check struct {
+	Name string
+	Run  func() error
+}
+
+// Result - Outcome of a single check
+type Result struct {
+	Name       string  `json:"name"`
+	OK         bool    `json:"ok"`
+	Error      string  `json:"error,omitempty"`
+	DurationMS float64 `json:"durationMs"`
+}
+
+// Report - Response body of both endpoints
+type Report struct {
+	Status string   `json:"status"`
+	Checks []Result `json:"checks"`
+}
+
+// Timeout - How long checks are given to complete before being reported as failed
+var Timeout = time.Second * 2
+
+// Healthz - Report that the process is alive and serving requests
+func Healthz(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(Report{
+		Status: "ok",
+		Checks: []Result{}})
+}
+
+// Readyz - Run every check concurrently, responding with 200 if all of them pass or 503 otherwise
+func Readyz(checks ...Check) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		report := Report{
+			Status: "ready",
+			Checks: run(checks)}
+		status := http.StatusOK
+		for _, result := range report.Checks {
+			if !result.OK {
+				report.Status = "unavailable"
+				status = http.StatusServiceUnavailable
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(report)
+	}
+}
+
+// run - Run checks concurrently, failing any that don't complete within Timeout
+func run(checks []Check) []Result {
+	type completed struct {
+		index  int
+		result Result
+	}
+	// Buffered so that checks finishing after the timeout don't block forever
+	finished := make(chan completed, len(checks))
+	results := make([]Result, len(checks))
+	for i, check := range checks {
+		results[i] = Result{
+			Name:       check.Name,
+			Error:      "timed out",
+			DurationMS: milliseconds(Timeout)}
+		go func(i int, check Check) {
+			start := time.Now()
+			err := check.Run()
+			result := Result{
+				Name:       check.Name,
+				OK:         err == nil,
+				DurationMS: milliseconds(time.Since(start))}
+			if err != nil {
+				result.Error = err.Error()
+			}
+			finished <- completed{i, result}
+		}(i, check)
+	}
+
+	timeout := time.After(Timeout)
+	for remaining := len(checks); remaining > 0; remaining-- {
+		select {
+		case c := <-finished:
+			results[c.index] = c.result
+		case <-timeout:
+			return results
+		}
+	}
+	return results
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// Wrap - Serve /healthz and /readyz ahead of next, bypassing any routing, authentication, and CORS handling done by next
+func Wrap(next http.Handler, checks ...Check) http.Handler {
+	readyz := Readyz(checks...)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/healthz":
+			Healthz(w, r)
+		case "/readyz":
+			readyz(w, r)
+		default:
+			next.ServeHTTP(w, r)
+		}
+	})
+}
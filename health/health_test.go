@@ -0,0 +1,66 @@
+package health
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWrap(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	})
+	passing := Check{
+		Name: "passing",
+		Run: func() error {
+			return nil
+		}}
+	failing := Check{
+		Name: "failing",
+		Run: func() error {
+			return errors.New("unreachable")
+		}}
+	hanging := Check{
+		Name: "hanging",
+		Run: func() error {
+			time.Sleep(Timeout * 2)
+			return nil
+		}}
+	Timeout = time.Millisecond * 50
+
+	serve := func(handler http.Handler, path string) (status int, report Report) {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		json.NewDecoder(w.Body).Decode(&report)
+		return w.Code, report
+	}
+
+	t.Run("Healthz", func(t *testing.T) {
+		if status, report := serve(Wrap(next, failing), "/healthz"); status != 200 || report.Status != "ok" {
+			t.Errorf("Expected /healthz to succeed regardless of checks, got %d", status)
+		}
+	})
+	t.Run("Ready", func(t *testing.T) {
+		status, report := serve(Wrap(next, passing), "/readyz")
+		if status != 200 || report.Status != "ready" || len(report.Checks) != 1 || !report.Checks[0].OK {
+			t.Errorf("Expected /readyz to succeed when every check passes, got %d %+v", status, report)
+		}
+	})
+	t.Run("Unavailable", func(t *testing.T) {
+		status, report := serve(Wrap(next, passing, failing, hanging), "/readyz")
+		if status != 503 || report.Status != "unavailable" {
+			t.Fatalf("Expected /readyz to fail when any check fails, got %d", status)
+		}
+		if report.Checks[1].Error != "unreachable" || report.Checks[2].Error != "timed out" {
+			t.Errorf("Expected check errors to be reported, got %+v", report.Checks)
+		}
+	})
+	t.Run("Passthrough", func(t *testing.T) {
+		if status, _ := serve(Wrap(next), "/whoami"); status != http.StatusUnauthorized {
+			t.Errorf("Expected other requests to be passed to the wrapped handler, got %d", status)
+		}
+	})
+}
@@ -1,25 +1,45 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
+	"github.com/go-redis/redis"
 	"github.com/gorilla/mux"
 
+	"github.com/very-amused/CSplan-API/certs"
+	"github.com/very-amused/CSplan-API/config"
 	"github.com/very-amused/CSplan-API/core"
+	"github.com/very-amused/CSplan-API/health"
+	"github.com/very-amused/CSplan-API/metrics"
 	"github.com/very-amused/CSplan-API/middleware"
+	"github.com/very-amused/CSplan-API/ratelimit"
 	"github.com/very-amused/CSplan-API/routes"
+	"github.com/very-amused/CSplan-API/scheduler"
+	"github.com/very-amused/CSplan-API/sql/migrations"
 
 	// No clue why this needs a special name
 	"github.com/very-amused/CSplan-API/routes/auth"
 )
 
+var configPath string
+
+// Flags that override their corresponding config values when set
 var logfile string
+var dbUser string
+var storeType string
 
 func loadRoutes(r *mux.Router) {
 	routes.LoadRoutes()
@@ -42,10 +62,17 @@ func loadRoutes(r *mux.Router) {
 	r.PathPrefix("/").HandlerFunc(routes.CatchAll)
 }
 
-func loadMiddleware(r *mux.Router) {
+func loadMiddleware(r *mux.Router, store core.Store, limiter ratelimit.Limiter) {
+	// Event streams are woken through the hub when their user's changes are committed
+	hub := core.NewHub()
+	store.SetNotifier(hub)
+	r.Use(middleware.AttachStore(store))
+	r.Use(middleware.AttachHub(hub))
+	r.Use(middleware.AttachLimiter(limiter))
+	r.Use(middleware.LogRequests)
 	r.Use(middleware.SetContentType)
 	r.Use(middleware.CORS)
-	if len(logfile) > 0 {
+	if logfile := config.Current().Log.File; len(logfile) > 0 {
 		middleware.SetupLogger(logfile)
 	}
 }
@@ -53,8 +80,10 @@ func loadMiddleware(r *mux.Router) {
 func parseFlags() {
 	// Handle auth bypass (used in development to avoid the tediousness of a crypto challenge handshake)
 	flag.BoolVar(&auth.AuthBypass, "allow-auth-bypass", false, "Bypass the authentication system for the purpose of running tests in development.")
-	flag.StringVar(&logfile, "logfile", "", "File path for logging output. (rotation is handled in-house, old log files will be timestamped)")
-	flag.StringVar(&core.User, "db-user", "admin", "User to connect to MariaDB as. (password is specified as MARIADB_PASSWORD)")
+	flag.StringVar(&configPath, "config", os.Getenv("CSPLAN_CONFIG"), "Path to a TOML config file. (every value can also be set through a CSPLAN_<SECTION>_<KEY> environment variable, send SIGHUP to reload)")
+	flag.StringVar(&logfile, "logfile", "", "File path for logging output, overrides log.file. (rotation is handled in-house, old log files will be timestamped)")
+	flag.StringVar(&dbUser, "db-user", "", "User to connect to MariaDB as, overrides database.user. (password is specified as database.password or MARIADB_PASSWORD)")
+	flag.StringVar(&storeType, "store", "", "Storage backend to use, either 'mariadb' or 'memory', overrides database.store. (the memory store is lost on exit, and is intended for development only)")
 	flag.Parse()
 	if auth.AuthBypass && os.Getenv("CSPLAN_NO_BYPASS_WARNING") != "true" {
 		fmt.Println("\x1b[31mSECURITY WARNING: Authentication bypass is enabled.\n",
@@ -63,19 +92,315 @@ func parseFlags() {
 	}
 }
 
+// registerMetrics - Register metrics that are computed from the store when scraped
+func registerMetrics(store core.Store) {
+	metrics.NewGaugeFunc("csplan_active_sessions", "Number of sessions used within the past hour.", func() (float64, error) {
+		count, err := store.CountSessions(uint(time.Now().Add(-time.Hour).Unix()))
+		return float64(count), err
+	})
+}
+
+// readinessChecks - Return the checks that must pass for the API to be ready to serve requests
+func readinessChecks(storeType string) []health.Check {
+	if storeType != "mariadb" {
+		return nil
+	}
+	return []health.Check{
+		{
+			Name: "database",
+			Run:  core.DB.Ping},
+		{
+			Name: "schema",
+			Run: func() error {
+				version, err := migrations.Version(core.DB)
+				if err == nil && version != migrations.Latest() {
+					err = fmt.Errorf("schema version is %d, expected %d", version, migrations.Latest())
+				}
+				return err
+			}}}
+}
+
+// newLimiter - Create the rate limiter backend selected by the config
+func newLimiter(c config.RateLimit) ratelimit.Limiter {
+	if c.Backend != "redis" {
+		return ratelimit.NewMemory()
+	}
+	client := redis.NewClient(&redis.Options{
+		Addr: c.RedisAddr})
+	// Requests are allowed while redis is unavailable, so this isn't fatal
+	if err := client.Ping().Err(); err != nil {
+		core.Warnf("Unable to connect to redis for rate limiting: %s", err)
+	}
+	return ratelimit.NewRedis(client, "csplan:ratelimit:")
+}
+
+// adminHandler - Return the handler for the admin listener
+func adminHandler(checks []health.Check) http.Handler {
+	handler := http.NewServeMux()
+	handler.Handle("/metrics", metrics.Handler())
+	return health.Wrap(handler, checks...)
+}
+
+// loadConfig - Load the config file and environment overrides, then apply flag overrides
+func loadConfig() (*config.Config, error) {
+	c, err := config.Load(configPath)
+	if err != nil {
+		return nil, err
+	}
+	if len(logfile) > 0 {
+		c.Log.File = logfile
+	}
+	if len(dbUser) > 0 {
+		c.Database.User = dbUser
+	}
+	if len(storeType) > 0 {
+		c.Database.Store = storeType
+	}
+	return c, nil
+}
+
+// watchConfig - Reload the config whenever SIGHUP is received
+func watchConfig() {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	go func() {
+		for range hangup {
+			c, err := loadConfig()
+			if err == nil {
+				var ignored []string
+				if ignored, err = config.Reload(c); len(ignored) > 0 {
+					core.Warnf("Changes to %s require a restart to take effect", strings.Join(ignored, ", "))
+				}
+			}
+			if err != nil {
+				core.Errorf("Failed to reload config: %s", err)
+				continue
+			}
+			core.Infof("Reloaded config")
+		}
+	}()
+}
+
+// migrate - Handle the migrate subcommand (migrate up|down [n|all]|status)
+func migrate(args []string) {
+	if len(args) == 0 {
+		log.Fatal("Usage: migrate up|down [n|all]|status")
+	}
+	core.DBConnect()
+	defer core.DB.Close()
+
+	switch args[0] {
+	case "up":
+		applied, err := migrations.Up(core.DB)
+		for _, m := range applied {
+			fmt.Printf("Applied migration %04d_%s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		if len(applied) == 0 {
+			fmt.Println("The database schema is up to date.")
+		}
+
+	case "down":
+		// Roll back a single migration unless a count (or all) is specified
+		n := 1
+		if len(args) > 1 {
+			if args[1] == "all" {
+				n = int(migrations.Latest())
+			} else if parsed, err := strconv.Atoi(args[1]); err == nil && parsed > 0 {
+				n = parsed
+			} else {
+				log.Fatalf("Invalid number of migrations to roll back '%s'", args[1])
+			}
+		}
+		rolledBack, err := migrations.Down(core.DB, n)
+		for _, m := range rolledBack {
+			fmt.Printf("Rolled back migration %04d_%s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+
+	case "status":
+		states, err := migrations.Status(core.DB)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, state := range states {
+			status := "pending"
+			if state.Applied {
+				status = "applied " + time.Unix(int64(state.AppliedAt), 0).Format(time.RFC3339)
+			}
+			fmt.Printf("%04d_%s: %s\n", state.Version, state.Name, status)
+		}
+
+	default:
+		log.Fatalf("Unknown migrate command '%s', expected up, down, or status", args[0])
+	}
+}
+
+// exportSpec - Handle the openapi subcommand (openapi [file]), writing the OpenAPI document to a file or stdout
+func exportSpec(args []string) {
+	routes.LoadRoutes()
+	encoded, err := json.MarshalIndent(routes.Spec(), "", "  ")
+	if err != nil {
+		log.Fatal(err)
+	}
+	encoded = append(encoded, '\n')
+	if len(args) == 0 {
+		os.Stdout.Write(encoded)
+		return
+	}
+	if err = ioutil.WriteFile(args[0], encoded, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
+
 func main() {
 	r := mux.NewRouter()
 	parseFlags()
-	core.DBConnect()
-	loadMiddleware(r)
+	c, err := loadConfig()
+	if err == nil {
+		err = config.Set(c)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Handle subcommands
+	if args := flag.Args(); len(args) > 0 {
+		switch args[0] {
+		case "migrate":
+			migrate(args[1:])
+		case "openapi":
+			exportSpec(args[1:])
+		default:
+			log.Fatalf("Unknown command '%s'", args[0])
+		}
+		return
+	}
+	var store core.Store
+	switch c.Database.Store {
+	case "mariadb":
+		core.DBConnect()
+		store = core.NewMariaDB(core.DB)
+		// Warn about (but don't refuse to run with) an outdated schema
+		if version, err := migrations.Version(core.DB); err != nil {
+			core.Warnf("Unable to check schema version: %s", err)
+		} else if version < migrations.Latest() {
+			core.Warnf("The database schema (version %d) is outdated, run 'migrate up' to upgrade to version %d", version, migrations.Latest())
+		}
+		metrics.RegisterDBStats(core.DB.DB)
+	case "memory":
+		store = core.NewMemoryStore()
+	}
+	registerMetrics(store)
+	loadMiddleware(r, store, newLimiter(c.RateLimit))
 	loadRoutes(r)
 
-	srv := http.Server{
-		Addr:         ":3000",
-		ReadTimeout:  time.Second,
-		WriteTimeout: time.Second * 10,
-		Handler:      r}
+	// Clear expired data in the background (only one instance sharing the store runs each job at a time)
+	jobs := scheduler.New(store)
+	jobs.Add(scheduler.CleanupJobs()...)
+	jobs.Start()
 
-	log.Println("Starting up CSplan API")
-	log.Fatal(srv.ListenAndServe())
+	watchConfig()
+
+	// Health endpoints are served outside of the router to bypass auth and CORS
+	checks := readinessChecks(c.Database.Store)
+	srv := &http.Server{
+		Addr:         c.Server.Addr,
+		ReadTimeout:  c.Server.ReadTimeout.Duration,
+		WriteTimeout: c.Server.WriteTimeout.Duration,
+		IdleTimeout:  c.Server.IdleTimeout.Duration,
+		Handler:      health.Wrap(r, checks...)}
+	servers := []*http.Server{srv}
+	serverErr := make(chan error, 3)
+
+	if c.TLS.Enabled {
+		reloader, err := certs.NewReloader(c.TLS.CertFile, c.TLS.KeyFile, c.TLS.Dir)
+		if err != nil {
+			log.Fatalf("Failed to load TLS certificate: %s", err)
+		}
+		reloader.Watch(c.TLS.ReloadInterval.Duration)
+		srv.TLSConfig = reloader.TLSConfig()
+		core.Infof("Starting up CSplan API on %s (TLS)", c.Server.Addr)
+		go func() {
+			// The certificate is provided by TLSConfig.GetCertificate
+			serverErr <- srv.ListenAndServeTLS("", "")
+		}()
+
+		if len(c.TLS.RedirectAddr) > 0 {
+			redirect := &http.Server{
+				Addr:         c.TLS.RedirectAddr,
+				ReadTimeout:  c.Server.ReadTimeout.Duration,
+				WriteTimeout: c.Server.WriteTimeout.Duration,
+				Handler:      middleware.RedirectHTTPS(c.Server.Addr)}
+			servers = append(servers, redirect)
+			core.Infof("Redirecting HTTP requests on %s to HTTPS", c.TLS.RedirectAddr)
+			go func() {
+				serverErr <- redirect.ListenAndServe()
+			}()
+		}
+	} else {
+		core.Infof("Starting up CSplan API on %s", c.Server.Addr)
+		go func() {
+			serverErr <- srv.ListenAndServe()
+		}()
+	}
+
+	if len(c.Server.AdminAddr) > 0 {
+		admin := &http.Server{
+			Addr:         c.Server.AdminAddr,
+			ReadTimeout:  c.Server.ReadTimeout.Duration,
+			WriteTimeout: c.Server.WriteTimeout.Duration,
+			Handler:      adminHandler(checks)}
+		servers = append(servers, admin)
+		core.Infof("Serving admin endpoints on %s", c.Server.AdminAddr)
+		go func() {
+			serverErr <- admin.ListenAndServe()
+		}()
+	}
+
+	// Wait for either a fatal server error or a shutdown signal
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	select {
+	case err := <-serverErr:
+		log.Fatal(err)
+	case sig := <-stop:
+		core.Infof("Received %s, shutting down", sig)
+	}
+	shutdown(servers, c.Server.ShutdownTimeout.Duration)
+}
+
+// shutdown - Stop accepting connections, drain active requests and background tasks, then close the DB pool
+// Servers are drained concurrently so that a slow server doesn't use up the deadline of the others,
+// all draining shares a single deadline, after which remaining connections are forcibly closed
+func shutdown(servers []*http.Server, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	core.BeginShutdown()
+	var wg sync.WaitGroup
+	for _, srv := range servers {
+		wg.Add(1)
+		go func(srv *http.Server) {
+			defer wg.Done()
+			if err := srv.Shutdown(ctx); err != nil {
+				core.Warnf("Failed to drain active requests on %s within %s, closing remaining connections: %s", srv.Addr, timeout, err)
+				srv.Close()
+			}
+		}(srv)
+	}
+	wg.Wait()
+	if err := core.StopBackground(ctx); err != nil {
+		core.Warnf("Failed to wait for background tasks within %s: %s", timeout, err)
+	}
+	if core.DB != nil {
+		if err := core.DB.Close(); err != nil {
+			core.Errorf("Failed to close the database connection pool: %s", err)
+		}
+	}
+	core.Infof("Shutdown complete")
 }
@@ -0,0 +1,55 @@
+package metrics
+
+import (
+	"database/sql"
+)
+
+// Metrics recorded by the API
+var (
+	// RequestDuration - Latency of handled requests, labeled by routes.Map key
+	RequestDuration = NewHistogram("csplan_http_request_duration_seconds",
+		"Latency of HTTP requests, by route.", DefaultBuckets, "route")
+	// Requests - Handled requests, labeled by routes.Map key and response status
+	Requests = NewCounter("csplan_http_requests_total",
+		"HTTP requests handled, by route and status.", "route", "status")
+	// RateLimited - Requests rejected with a 429 response, labeled by routes.Map key
+	RateLimited = NewCounter("csplan_http_rate_limited_total",
+		"HTTP requests rejected with 429 Too Many Requests, by route.", "route")
+	// ChallengeFailures - Authentication challenges submitted with incorrect data
+	ChallengeFailures = NewCounter("csplan_auth_challenge_failures_total",
+		"Authentication challenges submitted with incorrect data.")
+	// TOTPFailures - Logins rejected because of an invalid TOTP or backup code
+	TOTPFailures = NewCounter("csplan_auth_totp_failures_total",
+		"Logins rejected because of an invalid TOTP or backup code.")
+	// JobRuns - Scheduled job runs, labeled by job name and result (success, error, or skipped if another instance holds the lease)
+	JobRuns = NewCounter("csplan_job_runs_total",
+		"Scheduled job runs, by job and result.", "job", "result")
+	// JobDuration - Time taken by scheduled jobs, labeled by job name
+	JobDuration = NewHistogram("csplan_job_duration_seconds",
+		"Time taken by scheduled job runs, by job.", DefaultBuckets, "job")
+)
+
+// RegisterDBStats - Expose the connection pool statistics of db
+func RegisterDBStats(db *sql.DB) {
+	stat := func(fn func(s sql.DBStats) float64) func() (float64, error) {
+		return func() (float64, error) {
+			return fn(db.Stats()), nil
+		}
+	}
+	NewGaugeFunc("csplan_db_max_open_connections", "Maximum number of open connections to the database.",
+		stat(func(s sql.DBStats) float64 { return float64(s.MaxOpenConnections) }))
+	NewGaugeFunc("csplan_db_open_connections", "Number of established connections to the database.",
+		stat(func(s sql.DBStats) float64 { return float64(s.OpenConnections) }))
+	NewGaugeFunc("csplan_db_in_use_connections", "Number of database connections currently in use.",
+		stat(func(s sql.DBStats) float64 { return float64(s.InUse) }))
+	NewGaugeFunc("csplan_db_idle_connections", "Number of idle database connections.",
+		stat(func(s sql.DBStats) float64 { return float64(s.Idle) }))
+	NewCounterFunc("csplan_db_wait_count_total", "Total number of times a database connection was waited for.",
+		stat(func(s sql.DBStats) float64 { return float64(s.WaitCount) }))
+	NewCounterFunc("csplan_db_wait_duration_seconds_total", "Total time spent waiting for a database connection.",
+		stat(func(s sql.DBStats) float64 { return s.WaitDuration.Seconds() }))
+	NewCounterFunc("csplan_db_max_idle_closed_total", "Total number of connections closed due to the idle connection limit.",
+		stat(func(s sql.DBStats) float64 { return float64(s.MaxIdleClosed) }))
+	NewCounterFunc("csplan_db_max_lifetime_closed_total", "Total number of connections closed due to the connection lifetime limit.",
+		stat(func(s sql.DBStats) float64 { return float64(s.MaxLifetimeClosed) }))
+}
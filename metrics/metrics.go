@@ -0,0 +1,257 @@
+// Package metrics - Minimal Prometheus instrumentation, exposed in the text exposition format
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// collector - A registered metric that can write itself in the text exposition format
+type collector interface {
+	name() string
+	write(w io.Writer)
+}
+
+var (
+	registryMu sync.Mutex
+	registry   = make(map[string]collector)
+)
+
+func register(c collector) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, exists := registry[c.name()]; exists {
+		panic(fmt.Sprintf("metric %s is already registered", c.name()))
+	}
+	registry[c.name()] = c
+}
+
+// Handler - Serve every registered metric in the Prometheus text exposition format
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		registryMu.Lock()
+		collectors := make([]collector, 0, len(registry))
+		for _, c := range registry {
+			collectors = append(collectors, c)
+		}
+		registryMu.Unlock()
+		sort.Slice(collectors, func(i, j int) bool {
+			return collectors[i].name() < collectors[j].name()
+		})
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		for _, c := range collectors {
+			c.write(w)
+		}
+	})
+}
+
+// desc - Name, help text, and label names shared by every metric type
+type desc struct {
+	metricName string
+	help       string
+	labels     []string
+}
+
+func (d desc) name() string {
+	return d.metricName
+}
+
+func (d desc) writeHeader(w io.Writer, metricType string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", d.metricName, d.help, d.metricName, metricType)
+}
+
+// key - Join label values into a map key
+func (d desc) key(values []string) string {
+	if len(values) != len(d.labels) {
+		panic(fmt.Sprintf("metric %s expects %d label values, got %d", d.metricName, len(d.labels), len(values)))
+	}
+	return strings.Join(values, "\xff")
+}
+
+// formatLabels - Format the label set for a key, with extra appended as a final label if not empty
+func (d desc) formatLabels(key string, extra ...string) string {
+	var pairs []string
+	if len(d.labels) > 0 {
+		for i, value := range strings.Split(key, "\xff") {
+			pairs = append(pairs, fmt.Sprintf("%s=%s", d.labels[i], strconv.Quote(value)))
+		}
+	}
+	if len(extra) == 2 {
+		pairs = append(pairs, fmt.Sprintf("%s=%s", extra[0], strconv.Quote(extra[1])))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// Counter - Monotonically increasing count, optionally partitioned by labels
+type Counter struct {
+	desc
+	mu     sync.Mutex
+	values map[string]uint64
+}
+
+// NewCounter - Create and register a counter
+func NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{
+		desc:   desc{name, help, labels},
+		values: make(map[string]uint64)}
+	register(c)
+	return c
+}
+
+// Inc - Increment the counter for the given label values
+func (c *Counter) Inc(labelValues ...string) {
+	key := c.key(labelValues)
+	c.mu.Lock()
+	c.values[key]++
+	c.mu.Unlock()
+}
+
+// Value - Return the current count for the given label values
+func (c *Counter) Value(labelValues ...string) uint64 {
+	key := c.key(labelValues)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.values[key]
+}
+
+func (c *Counter) write(w io.Writer) {
+	c.writeHeader(w, "counter")
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// Unlabeled counters are always exposed, even before their first increment
+	if len(c.labels) == 0 && len(c.values) == 0 {
+		fmt.Fprintf(w, "%s 0\n", c.metricName)
+	}
+	keys := make([]string, 0, len(c.values))
+	for key := range c.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(w, "%s%s %d\n", c.metricName, c.formatLabels(key), c.values[key])
+	}
+}
+
+// DefaultBuckets - Histogram buckets (in seconds) suitable for request latencies
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Histogram - Distribution of observed values, optionally partitioned by labels
+type Histogram struct {
+	desc
+	buckets []float64
+	mu      sync.Mutex
+	values  map[string]*histogramData
+}
+
+type histogramData struct {
+	counts []uint64 // Non-cumulative count per bucket
+	sum    float64
+	count  uint64
+}
+
+// NewHistogram - Create and register a histogram with the given upper bucket bounds (in ascending order)
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{
+		desc:    desc{name, help, labels},
+		buckets: buckets,
+		values:  make(map[string]*histogramData)}
+	register(h)
+	return h
+}
+
+// Observe - Record a value for the given label values
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	key := h.key(labelValues)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	data := h.values[key]
+	if data == nil {
+		data = &histogramData{
+			counts: make([]uint64, len(h.buckets))}
+		h.values[key] = data
+	}
+	for i, bound := range h.buckets {
+		if v <= bound {
+			data.counts[i]++
+			break
+		}
+	}
+	data.sum += v
+	data.count++
+}
+
+func (h *Histogram) write(w io.Writer) {
+	h.writeHeader(w, "histogram")
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	keys := make([]string, 0, len(h.values))
+	for key := range h.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		data := h.values[key]
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += data.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.metricName, h.formatLabels(key, "le", formatFloat(bound)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.metricName, h.formatLabels(key, "le", "+Inf"), data.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.metricName, h.formatLabels(key), formatFloat(data.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.metricName, h.formatLabels(key), data.count)
+	}
+}
+
+// GaugeFunc - Value computed when metrics are scraped
+type GaugeFunc struct {
+	desc
+	metricType string
+	fn         func() (float64, error)
+}
+
+// NewGaugeFunc - Create and register a gauge whose value is computed by fn on every scrape
+// The gauge is omitted from a scrape if fn returns an error
+func NewGaugeFunc(name, help string, fn func() (float64, error)) *GaugeFunc {
+	g := &GaugeFunc{
+		desc:       desc{name, help, nil},
+		metricType: "gauge",
+		fn:         fn}
+	register(g)
+	return g
+}
+
+// NewCounterFunc - Create and register a counter whose value is read from an external source by fn on every scrape
+func NewCounterFunc(name, help string, fn func() (float64, error)) *GaugeFunc {
+	g := &GaugeFunc{
+		desc:       desc{name, help, nil},
+		metricType: "counter",
+		fn:         fn}
+	register(g)
+	return g
+}
+
+func (g *GaugeFunc) write(w io.Writer) {
+	value, err := g.fn()
+	if err != nil {
+		return
+	}
+	g.writeHeader(w, g.metricType)
+	fmt.Fprintf(w, "%s %s\n", g.metricName, formatFloat(value))
+}
@@ -0,0 +1,43 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	counter := NewCounter("test_requests_total", "Test counter.", "route", "status")
+	counter.Inc("GET:/tags/{id}", "200")
+	counter.Inc("GET:/tags/{id}", "200")
+	histogram := NewHistogram("test_duration_seconds", "Test histogram.", []float64{0.1, 1}, "route")
+	histogram.Observe(0.05, "GET:/tags")
+	histogram.Observe(0.5, "GET:/tags")
+	histogram.Observe(5, "GET:/tags")
+	NewGaugeFunc("test_gauge", "Test gauge.", func() (float64, error) {
+		return 3, nil
+	})
+
+	w := httptest.NewRecorder()
+	Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	body := w.Body.String()
+
+	expected := []string{
+		"# TYPE test_requests_total counter",
+		`test_requests_total{route="GET:/tags/{id}",status="200"} 2`,
+		"# TYPE test_duration_seconds histogram",
+		`test_duration_seconds_bucket{route="GET:/tags",le="0.1"} 1`,
+		`test_duration_seconds_bucket{route="GET:/tags",le="1"} 2`,
+		`test_duration_seconds_bucket{route="GET:/tags",le="+Inf"} 3`,
+		`test_duration_seconds_sum{route="GET:/tags"} 5.55`,
+		`test_duration_seconds_count{route="GET:/tags"} 3`,
+		"# TYPE test_gauge gauge",
+		"test_gauge 3",
+		// Unlabeled counters are exposed before their first increment
+		"csplan_auth_totp_failures_total 0"}
+	for _, line := range expected {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("Expected metrics output to contain '%s'", line)
+		}
+	}
+}
@@ -1,21 +1,29 @@
 package middleware
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"log"
+	"net/http"
 	"os"
+	"strconv"
 	"strings"
 	"time"
-)
 
-// How big a single logfile is permitted to grow
-const maxLogfileSize int64 = 500000
+	"github.com/gorilla/mux"
 
-// How often the logfile's size is checked
-const rotationPeriod = time.Hour
+	"github.com/very-amused/CSplan-API/config"
+	"github.com/very-amused/CSplan-API/core"
+	"github.com/very-amused/CSplan-API/metrics"
+)
 
 var outfile *os.File
 
+var errDeadlineUnsupported = errors.New("write deadlines aren't supported by the underlying ResponseWriter")
+
 func getTimestamp() string {
 	now := time.Now()
 	return fmt.Sprintf("%d-%d-%d_%d:%d:%d", now.Year(), now.Month(), now.Day(), now.Hour(), now.Minute(), now.Second())
@@ -25,8 +33,8 @@ func getTimestamp() string {
 // (and archive any logfiles after they have exceeded a max size)
 func RotateLogs(logfile string) {
 	stat, err := os.Stat(logfile)
-	// Stamp and archive the logfile if its size has exceeded 500kb
-	if err == nil && stat.Size() > maxLogfileSize {
+	// Stamp and archive the logfile if its size has exceeded the configured max (500kb by default)
+	if err == nil && stat.Size() > config.Current().Log.MaxFileSize {
 		outfile.Close()
 		stamp := getTimestamp()
 		// Split logfile extension
@@ -58,13 +66,113 @@ func RotateLogs(logfile string) {
 
 // SetupLogger - Initialize logging
 func SetupLogger(logfile string) {
-	// Setup ticker to rotate logs
+	// Setup ticker to rotate logs until shutdown
 	RotateLogs(logfile)
-	ticker := time.NewTicker(rotationPeriod)
-	go func() {
-		select {
-		case <-ticker.C:
-			RotateLogs(logfile)
+	ticker := time.NewTicker(config.Current().Log.RotationPeriod.Duration)
+	core.Go(func(ctx context.Context) {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				RotateLogs(logfile)
+			case <-ctx.Done():
+				return
+			}
+		}
+	})
+}
+
+// statusRecorder - ResponseWriter wrapper recording the status code of a response
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusRecorder) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusRecorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush - Flush buffered data to the client, if supported by the underlying ResponseWriter (used by event streams)
+func (w *statusRecorder) Flush() {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// SetWriteDeadline - Change the write deadline of the response, if supported by the underlying ResponseWriter (used by event streams)
+func (w *statusRecorder) SetWriteDeadline(deadline time.Time) error {
+	if setter, ok := w.ResponseWriter.(interface {
+		SetWriteDeadline(time.Time) error
+	}); ok {
+		return setter.SetWriteDeadline(deadline)
+	}
+	return errDeadlineUnsupported
+}
+
+// Unwrap - Return the underlying ResponseWriter (used by http.ResponseController)
+func (w *statusRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// newRequestID - Generate a random 128 bit request ID
+func newRequestID() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// routeKey - Return the routes.Map key (METHOD:/path/{template}) of the route matching r
+func routeKey(r *http.Request) string {
+	if route := mux.CurrentRoute(r); route != nil {
+		if template, err := route.GetPathTemplate(); err == nil {
+			return r.Method + ":" + template
+		}
+	}
+	return r.Method + ":"
+}
+
+// LogRequests - Assign each request an ID (sent as X-Request-ID), attach a request-scoped logger to its context,
+// and record metrics and a JSON access log entry once it has been handled
+func LogRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		info := &core.RequestInfo{
+			ID: newRequestID()}
+		info.Logger = core.Logger{}.With("request_id", info.ID)
+		w.Header().Set("X-Request-ID", info.ID)
+
+		recorder := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(recorder, r.WithContext(core.WithRequestInfo(r.Context(), info)))
+
+		route := routeKey(r)
+		duration := time.Since(start)
+		metrics.RequestDuration.Observe(duration.Seconds(), route)
+		metrics.Requests.Inc(route, strconv.Itoa(recorder.status))
+		if recorder.status == http.StatusTooManyRequests {
+			metrics.RateLimited.Inc(route)
+		}
+
+		fields := map[string]interface{}{
+			"route":       route,
+			"status":      recorder.status,
+			"duration_ms": float64(duration.Microseconds()) / 1000}
+		// IP addresses are only logged for users that have consented to IP logging (read along with their session during authentication)
+		if info.UserID != 0 && info.LogIP {
+			fields["ip"] = core.ClientIP(r)
 		}
-	}()
+		info.Logger.Log("info", "request", fields)
+	})
 }
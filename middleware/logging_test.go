@@ -0,0 +1,62 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	"github.com/very-amused/CSplan-API/core"
+)
+
+func TestLogRequests(t *testing.T) {
+	var output bytes.Buffer
+	log.SetOutput(&output)
+	defer log.SetOutput(os.Stderr)
+
+	r := mux.NewRouter()
+	r.Use(LogRequests)
+	r.HandleFunc("/tags/{id}", func(w http.ResponseWriter, r *http.Request) {
+		info := core.RequestInfoFrom(r.Context())
+		info.SetUser(1)
+		info.LogIP = true
+		core.WriteError404(w)
+	}).Methods("GET")
+
+	req := httptest.NewRequest("GET", "/tags/abc", nil)
+	req.RemoteAddr = "192.0.2.1:4000"
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	requestID := w.Header().Get("X-Request-ID")
+	if len(requestID) != 32 {
+		t.Fatalf("Expected a request ID to be sent, got '%s'", requestID)
+	}
+	var body core.HTTPError
+	json.NewDecoder(w.Body).Decode(&body)
+	if body.RequestID != requestID {
+		t.Errorf("Expected the error body to contain the request ID, got '%s'", body.RequestID)
+	}
+
+	var entry map[string]interface{}
+	if err := json.Unmarshal(output.Bytes(), &entry); err != nil {
+		t.Fatalf("Expected a single JSON access log entry, got '%s'", output.String())
+	}
+	expected := map[string]interface{}{
+		"msg":        "request",
+		"request_id": requestID,
+		"route":      "GET:/tags/{id}",
+		"status":     float64(404),
+		"user_id":    float64(1),
+		"ip":         "192.0.2.1"}
+	for key, value := range expected {
+		if entry[key] != value {
+			t.Errorf("Expected access log field %s to be %v, got %v", key, value, entry[key])
+		}
+	}
+}
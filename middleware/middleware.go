@@ -1,9 +1,13 @@
 package middleware
 
 import (
+	"net"
 	"net/http"
+	"net/url"
 
+	"github.com/very-amused/CSplan-API/config"
 	"github.com/very-amused/CSplan-API/core"
+	"github.com/very-amused/CSplan-API/ratelimit"
 )
 
 // SetContentType - Set both incoming and outcoming content type to application/json
@@ -13,6 +17,7 @@ func SetContentType(next http.Handler) http.Handler {
 		w.Header().Set("Content-Type", "application/json")
 		if len(r.Header.Get("Content-Type")) > 0 && r.Header.Get("Content-Type") != "application/json" {
 			core.WriteError(w, core.HTTPError{
+				Code:    core.CodeUnsupportedMediaType,
 				Title:   "Unsupported Media Type",
 				Message: "Expected content type is \"application/json\"",
 				Status:  415})
@@ -32,21 +37,63 @@ func SetConnection(next http.Handler) http.Handler {
 	})
 }
 
-var allowedOrigins = [2]string{"https://csplan.co", "https://localhost:3030"}
-
 // CORS - Handle cross-origin resource access
 func CORS(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		origin := r.Header.Get("Origin")
-		for _, allowed := range allowedOrigins {
-			if origin == allowed {
-				w.Header().Set("Access-Control-Allow-Origin", origin)
-				w.Header().Set("Access-Control-Allow-Credentials", "true")
-				w.Header().Set("Access-Control-Allow-Headers", "Content-Type, CSRF-Token")
-				w.Header().Set("Access-Control-Allow-Credentials", "true")
-				break
-			}
+		// Origins are read on every request so that they can be changed by reloading the config
+		if origin := r.Header.Get("Origin"); config.Current().CORS.Allows(origin) {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+			w.Header().Set("Access-Control-Allow-Headers", config.CORSAllowHeaders)
+			w.Header().Set("Access-Control-Expose-Headers", config.CORSExposeHeaders)
 		}
 		next.ServeHTTP(w, r)
 	})
 }
+
+// AttachStore - Make a store available to every route handler through the request context
+func AttachStore(s core.Store) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(core.WithStore(r.Context(), s)))
+		})
+	}
+}
+
+// AttachHub - Make the hub waking event streams available to every route handler through the request context
+func AttachHub(h *core.Hub) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(core.WithHub(r.Context(), h)))
+		})
+	}
+}
+
+// AttachLimiter - Make a rate limiter available to every route handler through the request context
+func AttachLimiter(l ratelimit.Limiter) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(ratelimit.WithLimiter(r.Context(), l)))
+		})
+	}
+}
+
+// RedirectHTTPS - Redirect every request to the same host and path over HTTPS, on the port of tlsAddr
+func RedirectHTTPS(tlsAddr string) http.Handler {
+	_, port, _ := net.SplitHostPort(tlsAddr)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(r.Host); err == nil {
+			host = h
+		}
+		if len(port) > 0 && port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+		target := url.URL{
+			Scheme:   "https",
+			Host:     host,
+			Path:     r.URL.Path,
+			RawQuery: r.URL.RawQuery}
+		http.Redirect(w, r, target.String(), http.StatusPermanentRedirect)
+	})
+}
@@ -0,0 +1,308 @@
+// Package openapi - OpenAPI 3 document types, with schemas generated from Go types and their validator tags
+package openapi
+
+import (
+	"encoding/json"
+	"path"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Document - Root of an OpenAPI 3.0 document
+type Document struct {
+	OpenAPI    string               `json:"openapi"`
+	Info       Info                 `json:"info"`
+	Paths      map[string]*PathItem `json:"paths"`
+	Components Components           `json:"components"`
+}
+
+// Info - Metadata about the API
+type Info struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	Version     string `json:"version"`
+}
+
+// PathItem - Operations available on a single path, keyed by lowercase HTTP method
+type PathItem map[string]*Operation
+
+// Operation - A single API operation on a path
+type Operation struct {
+	OperationID string                `json:"operationId"`
+	Summary     string                `json:"summary,omitempty"`
+	Tags        []string              `json:"tags,omitempty"`
+	Parameters  []Parameter           `json:"parameters,omitempty"`
+	RequestBody *RequestBody          `json:"requestBody,omitempty"`
+	Responses   map[string]*Response  `json:"responses"`
+	Security    []map[string][]string `json:"security"`
+}
+
+// Parameter - A path, query, or header parameter
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required"`
+	Schema      *Schema `json:"schema"`
+}
+
+// RequestBody - Content accepted as a request body
+type RequestBody struct {
+	Required bool                 `json:"required"`
+	Content  map[string]MediaType `json:"content"`
+}
+
+// Response - A possible response to an operation
+type Response struct {
+	Description string               `json:"description"`
+	Headers     map[string]*Header   `json:"headers,omitempty"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+// Header - A header sent with a response
+type Header struct {
+	Description string  `json:"description,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+// MediaType - Schema of content with a specific media type
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+// Components - Reusable schemas and security schemes referenced by operations
+type Components struct {
+	Schemas         map[string]*Schema         `json:"schemas"`
+	SecuritySchemes map[string]*SecurityScheme `json:"securitySchemes,omitempty"`
+}
+
+// SecurityScheme - A method of authenticating requests
+type SecurityScheme struct {
+	Type        string `json:"type"`
+	Name        string `json:"name"`
+	In          string `json:"in"`
+	Description string `json:"description,omitempty"`
+}
+
+// Schema - Subset of the OpenAPI schema object needed to describe this API's types
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	MinLength            *uint64            `json:"minLength,omitempty"`
+	MaxLength            *uint64            `json:"maxLength,omitempty"`
+	MinItems             *uint64            `json:"minItems,omitempty"`
+	MaxItems             *uint64            `json:"maxItems,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+}
+
+// New - Create an empty document
+func New(info Info) *Document {
+	return &Document{
+		OpenAPI: "3.0.3",
+		Info:    info,
+		Paths:   make(map[string]*PathItem),
+		Components: Components{
+			Schemas: make(map[string]*Schema)}}
+}
+
+// SchemaName - Name a struct type is registered under in components, qualified by package to avoid collisions (e.g. todo.Patch and tags.Patch)
+func SchemaName(t reflect.Type) string {
+	return path.Base(t.PkgPath()) + "." + t.Name()
+}
+
+// SchemaOf - Return the schema for v's type
+// Named struct types are added to the document's components and referenced, so that clients can generate a type for each
+func (d *Document) SchemaOf(v interface{}) *Schema {
+	return d.schema(reflect.TypeOf(v))
+}
+
+func (d *Document) schema(t reflect.Type) *Schema {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	// Raw JSON can hold any value
+	if t == reflect.TypeOf(json.RawMessage{}) {
+		return &Schema{}
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		if len(t.Name()) == 0 {
+			return d.structSchema(t)
+		}
+		name := SchemaName(t)
+		if _, exists := d.Components.Schemas[name]; !exists {
+			// Reserve the name before recursing so that self-referential types terminate
+			d.Components.Schemas[name] = &Schema{}
+			*d.Components.Schemas[name] = *d.structSchema(t)
+		}
+		return &Schema{Ref: "#/components/schemas/" + name}
+	case reflect.Slice, reflect.Array:
+		// encoding/json encodes byte slices as base64 strings
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte"}
+		}
+		return &Schema{
+			Type:  "array",
+			Items: d.schema(t.Elem())}
+	case reflect.Map:
+		return &Schema{
+			Type:                 "object",
+			AdditionalProperties: d.schema(t.Elem())}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32:
+		return &Schema{Type: "integer", Format: "int32"}
+	case reflect.Int64:
+		return &Schema{Type: "integer", Format: "int64"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		zero := float64(0)
+		format := "int64"
+		if t.Bits() <= 16 {
+			format = "int32"
+		}
+		return &Schema{Type: "integer", Format: format, Minimum: &zero}
+	case reflect.Float32:
+		return &Schema{Type: "number", Format: "float"}
+	case reflect.Float64:
+		return &Schema{Type: "number", Format: "double"}
+	}
+	// Interfaces and other dynamic types can hold any value
+	return &Schema{}
+}
+
+// structSchema - Build an object schema from a struct's exported JSON fields, inlining embedded structs the same way encoding/json does
+func (d *Document) structSchema(t reflect.Type) *Schema {
+	s := &Schema{
+		Type:       "object",
+		Properties: make(map[string]*Schema)}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, ok := jsonName(field)
+		if !ok {
+			continue
+		}
+		if field.Anonymous && !strings.Contains(string(field.Tag), `json:"`) {
+			embedded := field.Type
+			if embedded.Kind() == reflect.Ptr {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				inlined := d.structSchema(embedded)
+				for k, v := range inlined.Properties {
+					s.Properties[k] = v
+				}
+				s.Required = append(s.Required, inlined.Required...)
+				continue
+			}
+		}
+
+		property := d.schema(field.Type)
+		// Schemas are shared between requests and responses, so only fields that validation requires are marked as required
+		if applyValidation(property, field.Type, field.Tag.Get("validate")) {
+			s.Required = append(s.Required, name)
+		}
+		s.Properties[name] = property
+	}
+	return s
+}
+
+// jsonName - Return the name a struct field is encoded as by encoding/json, and whether it's encoded at all
+func jsonName(field reflect.StructField) (name string, ok bool) {
+	if len(field.PkgPath) > 0 && !field.Anonymous {
+		return "", false
+	}
+	tag := field.Tag.Get("json")
+	if tag == "-" {
+		return "", false
+	}
+	name = strings.Split(tag, ",")[0]
+	if len(name) == 0 {
+		name = field.Name
+	}
+	return name, true
+}
+
+// applyValidation - Add the constraints described by a validator (go-playground/validator) tag to s, returning whether the field is required
+// Constraints following dive apply to each element of a slice
+func applyValidation(s *Schema, t reflect.Type, tag string) (required bool) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	// Constraints on referenced schemas can't be expressed without wrapping them, and no validator tags on struct fields need it
+	if len(s.Ref) > 0 {
+		return strings.Contains(tag, "required")
+	}
+	rules := strings.Split(tag, ",")
+	for i, rule := range rules {
+		if len(rule) == 0 {
+			continue
+		}
+		parts := strings.SplitN(rule, "=", 2)
+		var param string
+		if len(parts) == 2 {
+			param = parts[1]
+		}
+		switch parts[0] {
+		case "required":
+			required = true
+		case "dive":
+			if s.Items != nil {
+				applyValidation(s.Items, t.Elem(), strings.Join(rules[i+1:], ","))
+			}
+			return required
+		case "base64":
+			s.Format = "byte"
+		case "email":
+			s.Format = "email"
+		case "oneof":
+			s.Enum = strings.Fields(param)
+		case "min", "max", "len":
+			n, err := strconv.ParseUint(param, 10, 64)
+			if err != nil {
+				continue
+			}
+			if parts[0] == "min" || parts[0] == "len" {
+				setBound(s, t, n, true)
+			}
+			if parts[0] == "max" || parts[0] == "len" {
+				setBound(s, t, n, false)
+			}
+		}
+	}
+	return required
+}
+
+// setBound - Set the lower or upper bound of a value, interpreted as validator does for t's kind (length for strings, count for slices, value for numbers)
+func setBound(s *Schema, t reflect.Type, n uint64, lower bool) {
+	switch t.Kind() {
+	case reflect.String:
+		if lower {
+			s.MinLength = &n
+		} else {
+			s.MaxLength = &n
+		}
+	case reflect.Slice, reflect.Array, reflect.Map:
+		if lower {
+			s.MinItems = &n
+		} else {
+			s.MaxItems = &n
+		}
+	default:
+		f := float64(n)
+		if lower {
+			s.Minimum = &f
+		} else {
+			s.Maximum = &f
+		}
+	}
+}
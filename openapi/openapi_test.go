@@ -0,0 +1,75 @@
+package openapi
+
+import (
+	"reflect"
+	"testing"
+)
+
+type embedded struct {
+	Checksum string `json:"checksum"`
+}
+
+type item struct {
+	Tags []string `json:"tags" validate:"required,dive,base64,max=20"`
+}
+
+type example struct {
+	embedded
+	ID       uint    `json:"-"`
+	Title    string  `json:"title" validate:"required,base64,max=255"`
+	Note     *string `json:"note,omitempty" validate:"omitempty,base64"`
+	Items    []item  `json:"items" validate:"required,max=10,dive"`
+	Raw      []byte  `json:"raw"`
+	Index    uint    `json:"index" validate:"max=86400"`
+	Kind     string  `json:"kind" validate:"oneof=a b"`
+	internal string
+}
+
+func TestSchemaOf(t *testing.T) {
+	doc := New(Info{})
+	ref := doc.SchemaOf(&example{})
+	if ref.Ref != "#/components/schemas/openapi.example" {
+		t.Fatalf("Expected a reference to the example schema, got '%s'", ref.Ref)
+	}
+	s := doc.Components.Schemas["openapi.example"]
+
+	expectedProperties := []string{"checksum", "title", "note", "items", "raw", "index", "kind"}
+	if len(s.Properties) != len(expectedProperties) {
+		t.Errorf("Expected %d properties, got %d", len(expectedProperties), len(s.Properties))
+	}
+	for _, name := range expectedProperties {
+		if s.Properties[name] == nil {
+			t.Errorf("Expected property %s", name)
+		}
+	}
+	if !reflect.DeepEqual(s.Required, []string{"title", "items"}) {
+		t.Errorf("Expected title and items to be required, got %v", s.Required)
+	}
+
+	title := s.Properties["title"]
+	if title.Type != "string" || title.Format != "byte" || title.MaxLength == nil || *title.MaxLength != 255 {
+		t.Errorf("Expected title to be a base64 string with a max length of 255, got %+v", title)
+	}
+	if raw := s.Properties["raw"]; raw.Type != "string" || raw.Format != "byte" {
+		t.Errorf("Expected raw to be a base64 string, got %+v", raw)
+	}
+	if index := s.Properties["index"]; index.Type != "integer" || *index.Minimum != 0 || *index.Maximum != 86400 {
+		t.Errorf("Expected index to be an integer from 0 to 86400, got %+v", index)
+	}
+	if kind := s.Properties["kind"]; !reflect.DeepEqual(kind.Enum, []string{"a", "b"}) {
+		t.Errorf("Expected kind to be an enum of a and b, got %v", kind.Enum)
+	}
+
+	// Constraints before dive apply to the slice, constraints after it apply to each element
+	items := s.Properties["items"]
+	if items.MaxItems == nil || *items.MaxItems != 10 {
+		t.Errorf("Expected items to have at most 10 elements, got %+v", items)
+	}
+	if items.Items.Ref != "#/components/schemas/openapi.item" {
+		t.Fatalf("Expected items to reference the item schema, got '%s'", items.Items.Ref)
+	}
+	tags := doc.Components.Schemas["openapi.item"].Properties["tags"]
+	if tags.MaxItems != nil || tags.Items.Format != "byte" || *tags.Items.MaxLength != 20 {
+		t.Errorf("Expected each tag to be a base64 string with a max length of 20, got %+v", tags.Items)
+	}
+}
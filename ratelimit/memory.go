@@ -0,0 +1,70 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Memory - Limiter keeping buckets in process memory, limits aren't shared between instances
+type Memory struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	full    time.Time // When the bucket will be full if no more tokens are taken
+}
+
+// How often buckets that have refilled completely (and are therefore indistinguishable from new ones) are removed
+const sweepInterval = time.Minute
+
+// NewMemory - Create an in-memory limiter
+func NewMemory() *Memory {
+	return &Memory{
+		buckets: make(map[string]*bucket)}
+}
+
+// Take - Take a token from the bucket identified by key
+func (m *Memory) Take(key string, p Policy, now time.Time) (Result, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if now.Sub(m.lastSweep) >= sweepInterval {
+		m.sweep(now)
+	}
+
+	b := m.buckets[key]
+	if b == nil {
+		b = &bucket{
+			tokens:  float64(p.Requests),
+			updated: now}
+		m.buckets[key] = b
+	}
+	// Refill tokens for the time passed since the bucket was last updated
+	if elapsed := now.Sub(b.updated).Seconds(); elapsed > 0 {
+		b.tokens += elapsed * p.rate()
+		if b.tokens > float64(p.Requests) {
+			b.tokens = float64(p.Requests)
+		}
+		b.updated = now
+	}
+
+	allowed := b.tokens >= 1
+	if allowed {
+		b.tokens--
+	}
+	r := p.result(allowed, b.tokens)
+	b.full = now.Add(r.Reset)
+	return r, nil
+}
+
+func (m *Memory) sweep(now time.Time) {
+	for key, b := range m.buckets {
+		if !now.Before(b.full) {
+			delete(m.buckets, key)
+		}
+	}
+	m.lastSweep = now
+}
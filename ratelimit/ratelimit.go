@@ -0,0 +1,121 @@
+// Package ratelimit - Token bucket rate limiting with in-memory and Redis backends
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Key types, determining what a policy's buckets are keyed by
+const (
+	ByIP     = "ip"
+	ByUser   = "user"
+	ByIPUser = "ip+user"
+)
+
+// Policy - Token bucket parameters: bursts of up to Requests are allowed, refilling at Requests per Period
+type Policy struct {
+	Requests int
+	Period   time.Duration
+	Key      string
+}
+
+// ParsePolicy - Parse a policy formatted as "<requests>/<period> <key>" (e.g. "10/1m ip")
+func ParsePolicy(s string) (p Policy, e error) {
+	fields := strings.Fields(s)
+	if len(fields) != 2 {
+		return p, fmt.Errorf("expected '<requests>/<period> <key>', got '%s'", s)
+	}
+	rate := strings.SplitN(fields[0], "/", 2)
+	if len(rate) != 2 {
+		return p, fmt.Errorf("expected '<requests>/<period>', got '%s'", fields[0])
+	}
+	if p.Requests, e = strconv.Atoi(rate[0]); e != nil || p.Requests <= 0 {
+		return p, fmt.Errorf("invalid number of requests '%s'", rate[0])
+	}
+	if p.Period, e = time.ParseDuration(rate[1]); e != nil || p.Period <= 0 {
+		return p, fmt.Errorf("invalid period '%s'", rate[1])
+	}
+	switch fields[1] {
+	case ByIP, ByUser, ByIPUser:
+		p.Key = fields[1]
+	default:
+		return p, fmt.Errorf("key must be one of %s, %s, or %s, got '%s'", ByIP, ByUser, ByIPUser, fields[1])
+	}
+	return p, nil
+}
+
+// String - Format p the same way it's parsed
+func (p Policy) String() string {
+	return fmt.Sprintf("%d/%s %s", p.Requests, p.Period, p.Key)
+}
+
+// BucketKey - Return the key of the bucket a request belongs to
+// Requests by anonymous users (userID 0) are keyed by IP regardless of the policy's key
+func (p Policy) BucketKey(route, ip string, userID uint) string {
+	if userID == 0 {
+		return route + "|ip:" + ip
+	}
+	switch p.Key {
+	case ByUser:
+		return fmt.Sprintf("%s|user:%d", route, userID)
+	case ByIPUser:
+		return fmt.Sprintf("%s|ip:%s|user:%d", route, ip, userID)
+	}
+	return route + "|ip:" + ip
+}
+
+// rate - Tokens refilled per second
+func (p Policy) rate() float64 {
+	return float64(p.Requests) / p.Period.Seconds()
+}
+
+// Result - Outcome of taking a token from a bucket
+type Result struct {
+	Allowed   bool
+	Remaining int
+	// Reset - Time until the bucket is full again
+	Reset time.Duration
+	// RetryAfter - Time until a token is available, zero if the request was allowed
+	RetryAfter time.Duration
+}
+
+// result - Build a result from the number of tokens left in a bucket after an attempt to take one
+func (p Policy) result(allowed bool, tokens float64) Result {
+	rate := p.rate()
+	r := Result{
+		Allowed:   allowed,
+		Remaining: int(math.Floor(tokens)),
+		Reset:     seconds((float64(p.Requests) - tokens) / rate)}
+	if !allowed {
+		r.RetryAfter = seconds((1 - tokens) / rate)
+	}
+	return r
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// Limiter - Backend storing token buckets
+type Limiter interface {
+	// Take - Take a token from the bucket identified by key, refilling it according to p first
+	Take(key string, p Policy, now time.Time) (Result, error)
+}
+
+type key string
+
+// WithLimiter - Attach a limiter to a context
+func WithLimiter(ctx context.Context, l Limiter) context.Context {
+	return context.WithValue(ctx, key("limiter"), l)
+}
+
+// LimiterFrom - Retrieve the limiter attached to a context, or nil if there is none
+func LimiterFrom(ctx context.Context) Limiter {
+	l, _ := ctx.Value(key("limiter")).(Limiter)
+	return l
+}
@@ -0,0 +1,101 @@
+package ratelimit
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+func TestParsePolicy(t *testing.T) {
+	p, err := ParsePolicy("10/1m ip+user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Requests != 10 || p.Period != time.Minute || p.Key != ByIPUser {
+		t.Errorf("Incorrectly parsed policy: %+v", p)
+	}
+	if p.String() != "10/1m0s ip+user" {
+		t.Errorf("Expected the policy to be formatted as it was parsed, got '%s'", p.String())
+	}
+
+	for _, invalid := range []string{"", "10/1m", "10 ip", "0/1m ip", "10/0s ip", "10/minute ip", "10/1m session"} {
+		if _, err := ParsePolicy(invalid); err == nil {
+			t.Errorf("Expected policy '%s' to be invalid", invalid)
+		}
+	}
+}
+
+func TestBucketKey(t *testing.T) {
+	policy := Policy{Requests: 1, Period: time.Second, Key: ByUser}
+	if key := policy.BucketKey("GET:/todos", "192.0.2.1", 5); key != "GET:/todos|user:5" {
+		t.Errorf("Expected a user keyed bucket, got '%s'", key)
+	}
+	// Anonymous requests can only be limited by IP
+	if key := policy.BucketKey("GET:/todos", "192.0.2.1", 0); key != "GET:/todos|ip:192.0.2.1" {
+		t.Errorf("Expected an IP keyed bucket, got '%s'", key)
+	}
+}
+
+// testLimiter - Check that l allows a burst, limits further requests, and refills over time
+func testLimiter(t *testing.T, l Limiter, key string) {
+	policy := Policy{Requests: 3, Period: time.Minute, Key: ByIP}
+	now := time.Now()
+	for i := 2; i >= 0; i-- {
+		result, err := l.Take(key, policy, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !result.Allowed || result.Remaining != i {
+			t.Fatalf("Expected request to be allowed with %d remaining, got %+v", i, result)
+		}
+	}
+
+	result, err := l.Take(key, policy, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Allowed {
+		t.Fatal("Expected a request exceeding the burst to be limited")
+	}
+	// A token is refilled every 20s
+	if result.RetryAfter.Round(time.Second) != 20*time.Second || result.Reset.Round(time.Second) != time.Minute {
+		t.Errorf("Expected to retry after 20s and reset after 1m, got %+v", result)
+	}
+
+	result, err = l.Take(key, policy, now.Add(20*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Allowed || result.Remaining != 0 {
+		t.Errorf("Expected a refilled token to be allowed, got %+v", result)
+	}
+}
+
+func TestMemory(t *testing.T) {
+	m := NewMemory()
+	testLimiter(t, m, "test")
+
+	// Full buckets are swept
+	m.Take("other", Policy{Requests: 1, Period: time.Second, Key: ByIP}, time.Now())
+	m.mu.Lock()
+	m.sweep(time.Now().Add(time.Hour))
+	remaining := len(m.buckets)
+	m.mu.Unlock()
+	if remaining != 0 {
+		t.Errorf("Expected refilled buckets to be swept, %d remain", remaining)
+	}
+}
+
+func TestRedis(t *testing.T) {
+	addr := os.Getenv("CSPLAN_TEST_REDIS_ADDR")
+	if len(addr) == 0 {
+		t.Skip("CSPLAN_TEST_REDIS_ADDR isn't set")
+	}
+	client := redis.NewClient(&redis.Options{Addr: addr})
+	defer client.Close()
+	key := "test" + time.Now().Format(time.RFC3339Nano)
+	defer client.Del("csplan:test:" + key)
+	testLimiter(t, NewRedis(client, "csplan:test:"), key)
+}
@@ -0,0 +1,67 @@
+package ratelimit
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// Redis - Limiter keeping buckets in Redis, so that limits are shared between every instance using the same server
+type Redis struct {
+	client *redis.Client
+	prefix string
+}
+
+// NewRedis - Create a limiter storing buckets in Redis under keys starting with prefix
+func NewRedis(client *redis.Client, prefix string) *Redis {
+	return &Redis{
+		client: client,
+		prefix: prefix}
+}
+
+// Refilling and taking a token is done in a single script so that concurrent requests can't both take the last token
+// Buckets are stored as hashes of tokens and the time they were updated (in milliseconds),
+// and expire once they would be full so that idle buckets don't accumulate
+var takeScript = redis.NewScript(`
+local capacity = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2]) -- Tokens per millisecond
+local now = tonumber(ARGV[3])
+
+local bucket = redis.call("HMGET", KEYS[1], "tokens", "updated")
+local tokens = tonumber(bucket[1])
+local updated = tonumber(bucket[2])
+if tokens == nil then
+	tokens = capacity
+	updated = now
+end
+if now > updated then
+	tokens = math.min(capacity, tokens + (now - updated) * rate)
+	updated = now
+end
+
+local allowed = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+end
+redis.call("HSET", KEYS[1], "tokens", tostring(tokens), "updated", updated)
+redis.call("PEXPIRE", KEYS[1], math.ceil((capacity - tokens) / rate) + 1)
+-- Integer replies truncate numbers, so tokens are returned as a string
+return {allowed, tostring(tokens)}
+`)
+
+// Take - Take a token from the bucket identified by key
+func (r *Redis) Take(key string, p Policy, now time.Time) (Result, error) {
+	reply, err := takeScript.Run(r.client, []string{r.prefix + key},
+		p.Requests, p.rate()/1000, now.UnixNano()/int64(time.Millisecond)).Result()
+	if err != nil {
+		return Result{}, err
+	}
+	values := reply.([]interface{})
+	tokens, err := strconv.ParseFloat(values[1].(string), 64)
+	if err != nil {
+		return Result{}, err
+	}
+	return p.result(values[0].(int64) == 1, tokens), nil
+}
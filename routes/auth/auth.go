@@ -24,6 +24,7 @@ type Info struct {
 	UserID    uint
 	SessionID uint
 	AuthLevel int
+	LogIP     bool // Whether the user has enabled IP logging
 }
 
 func compareTokens(provided, correct []byte) (equal bool) {
@@ -45,11 +46,13 @@ var (
 
 	// HTTPUnauthorized - User is not authorized entirely
 	HTTPUnauthorized = core.HTTPError{
+		Code:    core.CodeUnauthorized,
 		Title:   "Unauthorized",
 		Message: "Missing or invalid authorization token(s)",
 		Status:  401}
 	// HTTPForbidden - User is authorized, but for the requested route
 	HTTPForbidden = core.HTTPError{
+		Code:    core.CodeForbidden,
 		Title:   "Forbidden",
 		Message: "Insufficient authentication level for the requested route",
 		Status:  403}
@@ -94,19 +97,24 @@ func Authenticate(r *http.Request) Info {
 		return unauthorized
 	}
 
-	row := core.DB.QueryRow("SELECT Token, CSRFtoken FROM Sessions WHERE ID = ? AND UserID = ?", sessionID, userID)
-
-	var session Session
-	row.Scan(&session.RawToken, &session.RawCSRFtoken)
+	store := core.StoreFrom(r.Context())
+	session, err := store.GetSession(sessionID, userID)
+	if err != nil {
+		return unauthorized
+	}
 	// Compare tokens at a byte sensitive level to ensure they are EXACTLY accurate
-	if compareTokens(userSession.RawToken, session.RawToken) &&
-		(compareTokens(userSession.RawCSRFtoken, session.RawCSRFtoken) || AuthBypass) { // Don't check CSRF tokens if auth bypass is enabled
+	if compareTokens(userSession.RawToken, session.Token) &&
+		(compareTokens(userSession.RawCSRFtoken, session.CSRFtoken) || AuthBypass) { // Don't check CSRF tokens if auth bypass is enabled
 		// Update token to show most recent time of use (prevents deletion in the middle of a session)
-		go core.DB.Exec("UPDATE Sessions SET LastUsed = ? WHERE ID = ?", time.Now().Unix(), sessionID)
+		now := uint(time.Now().Unix())
+		core.Go(func(context.Context) {
+			store.TouchSession(sessionID, now)
+		})
 		return Info{
 			UserID:    userID,
 			SessionID: sessionID,
-			AuthLevel: 1}
+			AuthLevel: 1,
+			LogIP:     session.EnableIPLogging}
 	}
 
 	// If the token didn't match, the user is not authenticated
@@ -115,39 +123,61 @@ func Authenticate(r *http.Request) Info {
 
 // Login - Bypass the challenge authentication system, and simply return either a 409 or a token for the account
 // associated with the email sent
-func Login(_ context.Context, w http.ResponseWriter, r *http.Request) {
+func Login(ctx context.Context, w http.ResponseWriter, r *http.Request) {
 	if !AuthBypass {
 		core.WriteError(w, core.HTTPError{
+			Code:    core.CodeAuthBypassDisabled,
 			Title:   "Unauthorized",
 			Message: "Invalid authorization route requested. An authorization challenge must be requested and submitted.",
 			Status:  401})
 		return
 	}
 
-	var user User
-	json.NewDecoder(r.Body).Decode(&user)
-	if !user.exists() {
+	var req ChallengeRequest
+	if err := core.DecodeJSON(r, &req); err != nil {
+		core.WriteError(w, *err)
+		return
+	}
+	user := User{
+		Email: req.Email}
+
+	store := core.StoreFrom(ctx)
+	// Select the user's ID and verification status based on their email
+	record, err := store.GetUserByEmail(user.Email)
+	if err == core.ErrNotFound {
 		core.WriteError(w, core.HTTPError{
+			Code:    core.CodeUserNotFound,
 			Title:   "Not Found",
 			Message: "This user doesn't exist.",
 			Status:  404})
 		return
+	} else if err != nil {
+		core.WriteError500(w, err)
+		return
 	}
-
-	// Select the user's ID based on their email
-	core.DB.Get(&user, "SELECT ID FROM Users WHERE Email = ?", user.Email)
+	user.ID = record.ID
+	user.Verified = record.Verified
 
 	// Parse the user's device info and create a new session
-	user.parseDeviceInfo(r)
-	session, err := user.newSession()
+	user.parseDeviceInfo(store, r)
+	session, err := user.newSession(store)
 	if err != nil {
 		core.WriteError500(w, err)
 		return
 	}
 
-	w.Header().Set("Set-Cookie", fmt.Sprintf("Authorization=%s; Max-Age=%d; HttpOnly", session.Token, twoWeeks))
+	setAuthCookie(w, r, session.Token)
 	// Don't write the HttpOnly token to the JSON response, this token must be kept from javascript access
 	json.NewEncoder(w).Encode(UserState{
 		EncodedID: core.EncodeID(user.ID),
 		Verified:  user.Verified})
 }
+
+// setAuthCookie - Send the Authorization cookie, marked Secure if the request was made over TLS
+func setAuthCookie(w http.ResponseWriter, r *http.Request, token string) {
+	cookie := fmt.Sprintf("Authorization=%s; Path=/; HttpOnly; Max-Age=%d", token, twoWeeks)
+	if r.TLS != nil {
+		cookie += "; Secure"
+	}
+	w.Header().Set("Set-Cookie", cookie)
+}
@@ -7,11 +7,13 @@ import (
 	"crypto/rand"
 	"encoding/base64"
 	"encoding/json"
-	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/very-amused/CSplan-API/config"
 	core "github.com/very-amused/CSplan-API/core"
+	"github.com/very-amused/CSplan-API/metrics"
 )
 
 // Size in bytes of random IV passed to CTR cipher each time
@@ -27,9 +29,10 @@ type Challenge struct {
 	HashParams  *HashParams `json:"hashParams,omitempty"`
 }
 
+// ChallengeRequest - Request for an authentication challenge, TOTPCode must be sent if TOTP is enabled for the account
 type ChallengeRequest struct {
-	User
-	TOTPcode *uint64 `json:"TOTP_Code" validate:"required"`
+	Email    string  `json:"email" validate:"required,email"`
+	TOTPCode *uint64 `json:"TOTP_Code,omitempty"`
 }
 
 func (challenge *Challenge) encryptData(block cipher.Block) error {
@@ -48,62 +51,78 @@ func (challenge *Challenge) encryptData(block cipher.Block) error {
 }
 
 // RequestChallenge - Request an authentication challenge
-func RequestChallenge(_ context.Context, w http.ResponseWriter, r *http.Request) {
+func RequestChallenge(ctx context.Context, w http.ResponseWriter, r *http.Request) {
 	// Enforce action verbage
 	if r.URL.Query().Get("action") != "request" {
 		core.WriteError(w, core.HTTPError{
+			Code:    core.CodeInvalidAction,
 			Title:   "Invalid Action Parameter",
 			Message: "To enforce semantics, all new challenge requests must contain '?action=request'.",
 			Status:  422})
 		return
 	}
 
-	var user User
-	json.NewDecoder(r.Body).Decode(&user)
+	var req ChallengeRequest
+	if err := core.DecodeJSON(r, &req); err != nil {
+		core.WriteError(w, *err)
+		return
+	}
+	user := User{
+		Email:    req.Email,
+		TOTPCode: req.TOTPCode}
+
+	store := core.StoreFrom(ctx)
 
-	if !user.exists() {
+	// Get the user's ID
+	record, err := store.GetUserByEmail(user.Email)
+	if err == core.ErrNotFound {
 		core.WriteError(w, core.HTTPError{
+			Code:    core.CodeUserNotFound,
 			Title:   "Not Found",
 			Message: "The user associated with the requested challenge does not exist.",
 			Status:  404})
 		return
+	} else if err != nil {
+		core.WriteError500(w, err)
+		return
 	}
-	// Get the user's ID
-	core.DB.Get(&user.ID, "SELECT ID FROM Users WHERE Email = ?", user.Email)
+	user.ID = record.ID
 
 	// If the user has 2FA enabled, send a 412 (precondition failed), indicating that the client must submit a TOTP code along with the challenge request
-	rows, err := core.DB.Query("SELECT _Secret, BackupCodes FROM TOTP WHERE UserID = ?", user.ID)
-	defer rows.Close()
-	if err != nil {
+	totpRecord, err := store.GetTOTP(user.ID)
+	if err != nil && err != core.ErrNotFound {
 		core.WriteError500(w, err)
 		return
 	}
-	if rows.Next() {
+	if err == nil {
 		if user.TOTPCode == nil {
 			core.WriteError(w, core.HTTPError{
+				Code:    core.CodeTOTPRequired,
 				Title:   "Precondition Failed",
 				Message: "TOTP code required to log in.",
 				Status:  412})
 			return
 		}
 		var totp TOTPInfo
-		var encodedBackupCodes []byte
-		rows.Scan(&totp.Secret, &encodedBackupCodes)
-		json.Unmarshal(encodedBackupCodes, &totp.BackupCodes)
+		totp.Secret = totpRecord.Secret
+		json.Unmarshal(totpRecord.BackupCodes, &totp.BackupCodes)
 		totp.UserID = user.ID
-		if httpErr := validateTOTP(totp, *user.TOTPCode); httpErr != nil {
+		if httpErr := validateTOTP(store, totp, *user.TOTPCode); httpErr != nil {
 			core.WriteError(w, *httpErr)
 			return
 		}
 	}
 
-	// If there are 5+ pending challenges for the user, or 10+ failed attempts decline providing a new one
-	var pending uint
-	var failed uint
-	core.DB.Get(&pending, "SELECT COUNT(ID) FROM Challenges WHERE UserID = ? AND Failed = 0", user.ID)
-	core.DB.Get(&failed, "SELECT COUNT(ID) FROM Challenges WHERE UserID = ? AND Failed = 1", user.ID)
-	if pending >= 5 || failed >= 10 {
+	// If there are too many pending or failed challenges for the user (5+ and 10+ by default) decline providing a new one
+	pending, failed, err := store.CountChallenges(user.ID)
+	if err != nil {
+		core.WriteError500(w, err)
+		return
+	}
+	limits := config.Current().Limits
+	if pending >= limits.MaxPendingChallenges || failed >= limits.MaxFailedChallenges {
 		core.WriteError(w, core.HTTPError{
+			Code:    core.CodeChallengeLimit,
 			Title:   "Too Many Requests",
 			Message: "There are too many pending/failed challenges requested to provide a new one. You are being ratelimited.",
 			Status:  429})
@@ -111,24 +130,22 @@ func RequestChallenge(_ context.Context, w http.ResponseWriter, r *http.Request)
 	}
 	// Select and parse user's authentication key and hash parameters
 	var challenge Challenge
-	var saltAndKey []byte
-	row := core.DB.QueryRow("SELECT AuthKey, HashParams FROM AuthKeys WHERE UserID = ?", user.ID)
-	var encodedHashParams []byte
-	err = row.Scan(&saltAndKey, &encodedHashParams)
-	// Decode hash params
-	challenge.HashParams = &HashParams{}
-	json.Unmarshal(encodedHashParams, challenge.HashParams)
+	authKey, err := store.GetAuthKey(user.ID)
 	if err != nil {
 		core.WriteError500(w, err)
 		return
 	}
+	saltAndKey := authKey.AuthKey
+	// Decode hash params
+	challenge.HashParams = &HashParams{}
+	json.Unmarshal(authKey.HashParams, challenge.HashParams)
 	// Slice salt and actual authKey using stored salt length (max 16)
 	saltLen := *challenge.HashParams.SaltLen
 	challenge.Salt = base64.StdEncoding.EncodeToString(saltAndKey[0:saltLen])
-	authKey := saltAndKey[saltLen:]
+	key := saltAndKey[saltLen:]
 
 	// Create a unique ID
-	challenge.ID, err = core.MakeUniqueID("Challenges")
+	challenge.ID, err = core.MakeUniqueID(store, "Challenges")
 	if err != nil {
 		core.WriteError500(w, err)
 		return
@@ -140,7 +157,7 @@ func RequestChallenge(_ context.Context, w http.ResponseWriter, r *http.Request)
 	rand.Read(challenge.Data)
 
 	// Create a block cipher from the authkey, then encrypt the challenge's data
-	block, err := aes.NewCipher(authKey)
+	block, err := aes.NewCipher(key)
 	if err != nil {
 		core.WriteError500(w, err)
 		return
@@ -151,7 +168,11 @@ func RequestChallenge(_ context.Context, w http.ResponseWriter, r *http.Request)
 	}
 
 	// Add the challenge to the database
-	_, err = core.DB.Exec("INSERT INTO Challenges (ID, UserID, _Data) VALUES (?, ?, ?)", challenge.ID, user.ID, challenge.Data)
+	err = store.CreateChallenge(core.ChallengeRecord{
+		ID:        challenge.ID,
+		UserID:    user.ID,
+		Data:      challenge.Data,
+		Timestamp: uint(time.Now().Unix())})
 	if err != nil {
 		core.WriteError500(w, err)
 		return
@@ -162,20 +183,21 @@ func RequestChallenge(_ context.Context, w http.ResponseWriter, r *http.Request)
 }
 
 // SubmitChallenge - Submit an authentication challenge
-func SubmitChallenge(_ context.Context, w http.ResponseWriter, r *http.Request) {
+func SubmitChallenge(ctx context.Context, w http.ResponseWriter, r *http.Request) {
 	// Enforce action verbage
 	if r.URL.Query().Get("action") != "submit" {
 		core.WriteError(w, core.HTTPError{
+			Code:    core.CodeInvalidAction,
 			Title:   "Invalid Action Parameter",
 			Message: "To enforce semantics, all challenge submissions must contain '?action=submit'.",
 			Status:  422})
 		return
 	}
 
+	store := core.StoreFrom(ctx)
 	var challenge Challenge
 	var user User
-	json.NewDecoder(r.Body).Decode(&challenge)
-	if err := core.ValidateStruct(challenge); err != nil {
+	if err := core.DecodeJSON(r, &challenge); err != nil {
 		core.WriteError(w, *err)
 		return
 	}
@@ -184,6 +206,7 @@ func SubmitChallenge(_ context.Context, w http.ResponseWriter, r *http.Request)
 	challenge.ID, err = core.DecodeID(mux.Vars(r)["id"])
 	if err != nil {
 		core.WriteError(w, core.HTTPError{
+			Code:    core.CodeMalformedID,
 			Title:   "Bad Request",
 			Message: "Missing or malformed ID provided.",
 			Status:  400})
@@ -191,21 +214,23 @@ func SubmitChallenge(_ context.Context, w http.ResponseWriter, r *http.Request)
 	}
 	challenge.Data, err = base64.StdEncoding.DecodeString(challenge.EncodedData)
 
-	var correctData []byte
-	// The final clause checks that no challenges older than 1min are selected,
+	record, err := store.GetChallenge(challenge.ID)
+	// The final clause checks that no challenges older than 1min are accepted,
 	// This accounts for the case where a partially unresponsive database must not allow a challenge to be attempted over and over again,
 	// because of it not being set as failed or deleted
-	row := core.DB.QueryRow("SELECT _Data, UserID FROM Challenges WHERE ID = ? AND Failed = 0 AND UNIX_TIMESTAMP() - _Timestamp <= 60", challenge.ID)
-	err = row.Scan(&correctData, &user.ID)
-	if err != nil {
+	if err != nil || record.Failed || uint(time.Now().Unix())-record.Timestamp > 60 {
 		core.WriteError404(w)
 		return
 	}
+	correctData := record.Data
+	user.ID = record.UserID
 
 	// Compare lengths first to avoid range errors
 	if len(challenge.Data) != len(correctData) {
-		core.DB.Exec("UPDATE Challenges SET Failed = 1 WHERE ID = ?", challenge.ID)
+		store.FailChallenge(challenge.ID)
+		metrics.ChallengeFailures.Inc()
 		core.WriteError(w, core.HTTPError{
+			Code:    core.CodeChallengeFailed,
 			Title:   "Challenge Failed",
 			Message: "Incorrect data provided.",
 			Status:  401})
@@ -215,8 +240,10 @@ func SubmitChallenge(_ context.Context, w http.ResponseWriter, r *http.Request)
 	// If the data isn't equal to the
 	for i := range correctData {
 		if correctData[i] != challenge.Data[i] {
-			core.DB.Exec("UPDATE Challenges SET Failed = 1 WHERE ID = ?", challenge.ID)
+			store.FailChallenge(challenge.ID)
+			metrics.ChallengeFailures.Inc()
 			core.WriteError(w, core.HTTPError{
+				Code:    core.CodeChallengeFailed,
 				Title:   "Challenge Failed",
 				Message: "Incorrect data provided.",
 				Status:  401})
@@ -225,17 +252,17 @@ func SubmitChallenge(_ context.Context, w http.ResponseWriter, r *http.Request)
 	}
 
 	// At this point, the challenge is successful and the user is authorized
-	user.parseDeviceInfo(r)
+	user.parseDeviceInfo(store, r)
 	// Create new tokens
-	core.DB.Exec("DELETE FROM Challenges WHERE ID = ?", challenge.ID)
-	tokens, err := user.newSession()
+	store.DeleteChallenge(challenge.ID)
+	tokens, err := user.newSession(store)
 	if err != nil {
 		core.WriteError500(w, err)
 		return
 	}
 
 	user.EncodedID = core.EncodeID(user.ID)
-	w.Header().Set("Set-Cookie", fmt.Sprintf("Authorization=%s; Path=/; HttpOnly; Max-Age=%d", tokens.Token, twoWeeks))
+	setAuthCookie(w, r, tokens.Token)
 	json.NewEncoder(w).Encode(map[string]string{
 		"id":        user.EncodedID,
 		"CSRFtoken": tokens.CSRFtoken})
@@ -23,6 +23,7 @@ const argon2MaxThreads = 1          // Restrict to 1 thread
 // Validate a set of HashParams
 func (h HashParams) Validate() (err *core.HTTPError) {
 	err = &core.HTTPError{
+		Code:    core.CodeInvalidHashParams,
 		Title:   "Validation Error",
 		Message: "",
 		Status:  400}
@@ -18,21 +18,20 @@ type KeyPatch struct {
 func UpdateKey(ctx context.Context, w http.ResponseWriter, r *http.Request) {
 	userID := ctx.Value(core.Key("user")).(uint)
 	var patch KeyPatch
-	json.NewDecoder(r.Body).Decode(&patch)
-	if err := core.ValidateStruct(patch); err != nil {
+	if err := core.DecodeJSON(r, &patch); err != nil {
 		core.WriteError(w, *err)
 		return
 	} else if err := patch.HashParams.Validate(); err != nil {
-		core.WriteError(w, core.HTTPError{
-			Title:   "Validation Error",
-			Message: err.Error(),
-			Status:  400})
+		core.WriteError(w, *err)
 		return
 	}
 
 	// Encode hashparams
 	encodedHashParams, _ := json.Marshal(patch.HashParams)
-	_, err := core.DB.Exec("UPDATE AuthKeys SET AuthKey = FROM_BASE64(?), HashParams = ? WHERE UserID = ?", patch.Key, encodedHashParams, userID)
+	err := core.StoreFrom(ctx).UpdateAuthKey(core.AuthKeyRecord{
+		UserID:     userID,
+		AuthKey:    core.FromBase64(patch.Key),
+		HashParams: encodedHashParams})
 	if err != nil {
 		core.WriteError500(w, err)
 		return
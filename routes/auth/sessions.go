@@ -27,19 +27,20 @@ func GetSessions(ctx context.Context, w http.ResponseWriter, _ *http.Request) {
 	userID := ctx.Value(core.Key("user")).(uint)
 	sessionID := ctx.Value(core.Key("session")).(uint)
 
-	rows, err := core.DB.Query("SELECT ID, DeviceInfo, Created, LastUsed FROM Sessions WHERE UserID = ?", userID)
-	defer rows.Close()
+	records, err := core.StoreFrom(ctx).ListSessions(userID)
 	if err != nil {
 		core.WriteError500(w, err)
 		return
 	}
 
 	var sessions []SessionInfo
-	i := 0
-	for rows.Next() {
+	for i, record := range records {
 		session := SessionInfo{
-			AuthLevel: 1}
-		rows.Scan(&session.ID, &session.DeviceInfo, &session.Created, &session.LastUsed)
+			ID:         record.ID,
+			DeviceInfo: record.DeviceInfo,
+			Created:    record.Created,
+			LastUsed:   record.LastUsed,
+			AuthLevel:  1}
 		session.EncodedID = core.EncodeID(session.ID)
 		// This flag is to inform clients to log the user out as soon as possible, so that the session can be automatically cleared
 		// (or clear it manually using an API call)
@@ -53,7 +54,6 @@ func GetSessions(ctx context.Context, w http.ResponseWriter, _ *http.Request) {
 		if session.ID == sessionID {
 			w.Header().Set("X-Current-Session", strconv.Itoa(i))
 		}
-		i++
 	}
 	json.NewEncoder(w).Encode(sessions)
 }
@@ -63,23 +63,25 @@ func Logout(ctx context.Context, w http.ResponseWriter, r *http.Request) {
 	userID := ctx.Value(core.Key("user")).(uint)
 	sessionID := ctx.Value(core.Key("session")).(uint)
 	idParam := mux.Vars(r)["id"]
+	store := core.StoreFrom(ctx)
 
 	// If no session ID is provided, assume logging out from current session
 	var err error
 	if len(idParam) == 0 {
-		core.DB.Exec("DELETE FROM Sessions WHERE ID = ?", sessionID)
+		err = store.DeleteSession(sessionID, userID)
 	} else {
 		sessionID, err := core.DecodeID(idParam)
 		if err != nil {
 			core.WriteError(w, core.HTTPError{
+				Code:    core.CodeMalformedID,
 				Title:   "Bad Request",
 				Message: "Malformed id param",
 				Status:  400})
 			return
 		}
-		core.DB.Exec("DELETE FROM Sessions WHERE ID = ? AND UserID = ?", sessionID, userID)
+		err = store.DeleteSession(sessionID, userID)
 	}
-	if err != nil {
+	if err != nil && err != core.ErrNotFound {
 		core.WriteError500(w, err)
 		return
 	}
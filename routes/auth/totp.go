@@ -15,6 +15,7 @@ import (
 	"time"
 
 	"github.com/very-amused/CSplan-API/core"
+	"github.com/very-amused/CSplan-API/metrics"
 )
 
 var backupCodeMax = big.NewInt(99999999)
@@ -45,38 +46,34 @@ func SetTOTP(ctx context.Context, w http.ResponseWriter, r *http.Request) {
 
 	default:
 		core.WriteError(w, core.HTTPError{
+			Code:    core.CodeInvalidAction,
 			Title:   "Invalid Action Parameter",
 			Message: "To enable or disable TOTP, either ?action=enable or ?action=disable must be specified.",
-			Status:  429})
+			Status:  422})
 	}
 }
 
 func GetBackupCodes(ctx context.Context, w http.ResponseWriter, _ *http.Request) {
 	userID := ctx.Value(core.Key("user")).(uint)
 
-	rows, err := core.DB.Query("SELECT BackupCodes FROM TOTP WHERE UserID = ?", userID)
-	defer rows.Close()
-	if err != nil {
-		core.WriteError500(w, err)
-		return
-	}
-
-	if !rows.Next() {
+	totp, err := core.StoreFrom(ctx).GetTOTP(userID)
+	if err == core.ErrNotFound {
 		core.WriteError(w, core.HTTPError{
+			Code:    core.CodeTOTPNotEnabled,
 			Title:   "Precondition Failed",
 			Message: "TOTP is not enabled for this user.",
 			Status:  412})
 		return
+	} else if err != nil {
+		core.WriteError500(w, err)
+		return
 	}
 
 	// No decoding and re-encoding is necessary because the backup codes are already stored as json
-	var encodedBackupCodes []byte
-	rows.Scan(&encodedBackupCodes)
-
-	w.Write(encodedBackupCodes)
+	w.Write(totp.BackupCodes)
 }
 
-func validateTOTP(totp TOTPInfo, code uint64) (e *core.HTTPError) {
+func validateTOTP(store core.Store, totp TOTPInfo, code uint64) (e *core.HTTPError) {
 	// Create 30 second TOTP counter
 	now := time.Now().Unix()
 	counter := uint64(math.Floor(float64(now) / float64(30)))
@@ -97,7 +94,9 @@ func validateTOTP(totp TOTPInfo, code uint64) (e *core.HTTPError) {
 			newCodes := totp.BackupCodes
 			newCodes = append(newCodes[0:i], newCodes[i+1:]...)
 			encodedBackupCodes, _ := json.Marshal(newCodes)
-			_, err := core.DB.Exec("UPDATE TOTP SET BackupCodes = ? WHERE UserID = ?", encodedBackupCodes, totp.UserID)
+			err := store.UpdateTOTP(core.TOTPRecord{
+				UserID:      totp.UserID,
+				BackupCodes: encodedBackupCodes})
 			if err != nil {
 				serverErr := core.ServerErrorFrom(err)
 				return &serverErr
@@ -106,7 +105,9 @@ func validateTOTP(totp TOTPInfo, code uint64) (e *core.HTTPError) {
 		}
 	}
 
+	metrics.TOTPFailures.Inc()
 	return &core.HTTPError{
+		Code:    core.CodeTOTPInvalid,
 		Title:   "Unauthorized",
 		Message: "Invalid TOTP or backup code.",
 		Status:  401}
@@ -133,16 +134,17 @@ func RunTOTP(secret []byte, counter uint64) (code int32) {
 
 func enableTOTP(ctx context.Context, w http.ResponseWriter, _ *http.Request) {
 	userID := ctx.Value(core.Key("user")).(uint)
+	store := core.StoreFrom(ctx)
 
 	// Don't overwrite existing TOTP settings
-	rows, err := core.DB.Query("SELECT 1 FROM TOTP WHERE UserID = ?", userID)
-	defer rows.Close()
-	if err != nil {
+	_, err := store.GetTOTP(userID)
+	if err != nil && err != core.ErrNotFound {
 		core.WriteError500(w, err)
 		return
 	}
-	if rows.Next() {
+	if err == nil {
 		core.WriteError(w, core.HTTPError{
+			Code:    core.CodeTOTPEnabled,
 			Title:   "Resource Conflict",
 			Message: "TOTP is already enabled for this user.",
 			Status:  409})
@@ -162,8 +164,10 @@ func enableTOTP(ctx context.Context, w http.ResponseWriter, _ *http.Request) {
 
 	// Insert TOTP info into db
 	encodedBackupCodes, _ := json.Marshal(totp.BackupCodes)
-	_, err = core.DB.Exec("INSERT INTO TOTP (UserID, _Secret, BackupCodes) VALUES (?, ?, ?)",
-		userID, totp.Secret, encodedBackupCodes)
+	err = store.CreateTOTP(core.TOTPRecord{
+		UserID:      userID,
+		Secret:      totp.Secret,
+		BackupCodes: encodedBackupCodes})
 	if err != nil {
 		core.WriteError500(w, err)
 		return
@@ -177,6 +181,9 @@ func enableTOTP(ctx context.Context, w http.ResponseWriter, _ *http.Request) {
 
 func disableTOTP(ctx context.Context, w http.ResponseWriter, _ *http.Request) {
 	userID := ctx.Value(core.Key("user")).(uint)
-	core.DB.Exec("DELETE FROM TOTP WHERE UserID = ?", userID)
+	if err := core.StoreFrom(ctx).DeleteTOTP(userID); err != nil {
+		core.WriteError500(w, err)
+		return
+	}
 	w.WriteHeader(204)
 }
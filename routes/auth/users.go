@@ -8,7 +8,7 @@ import (
 	"fmt"
 	"net/http"
 	"regexp"
-	"strings"
+	"time"
 
 	core "github.com/very-amused/CSplan-API/core"
 )
@@ -101,28 +101,20 @@ var operatingSystems = []uaOS{
 		regex: regexp.MustCompile("Linux")}}
 
 // user.exists - Return true if a user with the specified email already exists
-func (user *User) exists() bool {
-	rows, err := core.DB.Query("SELECT 1 FROM Users WHERE Email = ?", user.Email)
-	defer rows.Close()
-	return err == nil && rows.Next()
+func (user *User) exists(store core.Store) bool {
+	_, err := store.GetUserByEmail(user.Email)
+	return err == nil
 }
 
 // parse the user's device info in the form of ip,browser,os
 // (colons can't be used as separators because of ipv6 addresses)
-func (user *User) parseDeviceInfo(r *http.Request) {
+func (user *User) parseDeviceInfo(store core.Store, r *http.Request) {
 	// Check if user has consented to ip logging
-	var hasUserConsent bool
 	var ip string
-	core.DB.Get(&hasUserConsent, "SELECT EnableIPLogging FROM Settings WHERE UserID = ?", user.ID)
+	settings, _ := store.GetSettings(user.ID)
 	// Parse user ip address
-	if hasUserConsent {
-		// Check X-FORWARDED-FOR header, then fallback to raw address if that fails
-		if ip = r.Header.Get("X-Forwarded-For"); len(ip) == 0 {
-			ip = r.RemoteAddr
-		}
-		// Trim the port from the ip
-		parts := strings.Split(ip, ":")
-		ip = strings.Join(parts[:len(parts)-1], ":")
+	if settings.EnableIPLogging {
+		ip = core.ClientIP(r)
 	} else {
 		ip = "Disabled"
 	}
@@ -157,11 +149,9 @@ func (user *User) parseDeviceInfo(r *http.Request) {
 	user.DeviceInfo = fmt.Sprintf("%s,%s,%s", ip, browser, os)
 }
 
-func (user *User) newSession() (session Session, e error) {
-	// Get the user's ID from the db for identification purposes
-	core.DB.Get(&user.ID, "SELECT ID FROM Users WHERE Email = ?", user.Email)
+func (user *User) newSession(store core.Store) (session Session, e error) {
 	// Generate a session ID
-	session.ID, e = core.MakeUniqueID("Sessions")
+	session.ID, e = core.MakeUniqueID(store, "Sessions")
 	if e != nil {
 		return session, e
 	}
@@ -175,16 +165,24 @@ func (user *User) newSession() (session Session, e error) {
 	// Tokens are formatted as data:userID:sessionID, ensuring the user is always identifying themselves (by ID), as well as their session
 	session.Token = base64.RawURLEncoding.EncodeToString(session.RawToken) + ":" + core.EncodeID(user.ID) + ":" + core.EncodeID(session.ID)
 	session.CSRFtoken = base64.RawURLEncoding.EncodeToString(session.RawCSRFtoken)
-	// Insert the encoded tokens into the db
-	_, e = core.DB.Exec("INSERT INTO Sessions (ID, UserID, Token, CSRFtoken, DeviceInfo) VALUES (?, ?, ?, ?, ?)", session.ID, user.ID, session.RawToken, session.RawCSRFtoken, user.DeviceInfo)
+	// Store the raw tokens
+	now := uint(time.Now().Unix())
+	e = store.CreateSession(core.SessionRecord{
+		ID:         session.ID,
+		UserID:     user.ID,
+		Token:      session.RawToken,
+		CSRFtoken:  session.RawCSRFtoken,
+		Created:    now,
+		LastUsed:   now,
+		DeviceInfo: user.DeviceInfo})
 	return session, e
 }
 
 // Register - Create a new account
-func Register(_ context.Context, w http.ResponseWriter, r *http.Request) {
+func Register(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	store := core.StoreFrom(ctx)
 	var user User
-	json.NewDecoder(r.Body).Decode(&user)
-	if err := core.ValidateStruct(user); err != nil {
+	if err := core.DecodeJSON(r, &user); err != nil {
 		core.WriteError(w, *err)
 		return
 	}
@@ -194,8 +192,9 @@ func Register(_ context.Context, w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	// Check if the user already exists
-	if user.exists() {
+	if user.exists(store) {
 		core.WriteError(w, core.HTTPError{
+			Code:    core.CodeUserExists,
 			Title:   "Resource Conflict",
 			Message: "This user already exists",
 			Status:  409})
@@ -204,40 +203,26 @@ func Register(_ context.Context, w http.ResponseWriter, r *http.Request) {
 
 	// Generate the user's ID
 	var err error
-	user.ID, err = core.MakeUniqueID("Users")
+	user.ID, err = core.MakeUniqueID(store, "Users")
 	if err != nil {
 		core.WriteError500(w, err)
 		return
 	}
 	user.EncodedID = core.EncodeID(user.ID)
 
-	// Insert the user into the database
-	tx, err := core.DB.Begin()
-	defer tx.Rollback()
-	if err != nil {
-		core.WriteError500(w, err)
-		return
-	}
-
-	// Add to the users table
-	_, err = tx.Exec("INSERT INTO Users (ID, Email, Verified) VALUES (?, ?, ?)", user.ID, user.Email, user.Verified)
-	if err != nil {
-		core.WriteError500(w, err)
-		return
-	}
-
-	// Add the user's key info to the cryptokeys table
+	// Store the user along with their key info
 	encodedHashParams, _ := json.Marshal(user.HashParams)
-	_, err = tx.Exec("INSERT INTO AuthKeys (UserID, AuthKey, HashParams) VALUES (?, FROM_BASE64(?), ?)",
-		user.ID, user.AuthKey, encodedHashParams)
+	err = store.CreateUser(core.UserRecord{
+		ID:       user.ID,
+		Email:    user.Email,
+		Verified: user.Verified}, core.AuthKeyRecord{
+		UserID:     user.ID,
+		AuthKey:    core.FromBase64(user.AuthKey),
+		HashParams: encodedHashParams})
 	if err != nil {
 		core.WriteError500(w, err)
 		return
 	}
-	if err = tx.Commit(); err != nil {
-		core.WriteError500(w, err)
-		return
-	}
 
 	w.WriteHeader(201)
 	json.NewEncoder(w).Encode(UserState{
@@ -248,57 +233,35 @@ func Register(_ context.Context, w http.ResponseWriter, r *http.Request) {
 // DeleteAccount - Delete a user's account
 func DeleteAccount(ctx context.Context, w http.ResponseWriter, r *http.Request) {
 	user := ctx.Value(core.Key("user")).(uint)
+	store := core.StoreFrom(ctx)
 	confirm := r.Header.Get("X-Confirm")
 
 	if len(confirm) > 0 {
 		// Verify legitimacy of token
-		var token string
-		core.DB.Get(&token, "SELECT Token FROM DeleteTokens WHERE UserID = ?", user)
-		if confirm != token {
+		token, _ := store.GetDeleteToken(user)
+		if len(token.Token) == 0 || confirm != token.Token {
 			core.WriteError(w, core.HTTPError{
+				Code:    core.CodeDeleteTokenInvalid,
 				Title:   "Forbidden",
 				Message: "Invalid or malformed confirmation token",
 				Status:  403})
 			return
 		}
 
-		// Notify the user with a 500 response if any of the delete queries fail
+		// Notify the user with a 500 response if the deletion fails
 		// If this ever happens in production, something has gone horribly wrong
-		tx, err := core.DB.Begin()
-		defer tx.Rollback()
-		if err != nil {
+		if err := store.DeleteUser(user); err != nil {
 			core.WriteError500(w, err)
 			return
 		}
-		queries := []string{
-			"DELETE FROM TodoLists WHERE UserID = ?",
-			"DELETE FROM Names WHERE UserID = ?",
-			"DELETE FROM AuthKeys WHERE UserID = ?",
-			"DELETE FROM TOTP WHERE UserID = ?",
-			"DELETE FROM CryptoKeys WHERE UserID = ?",
-			"DELETE FROM DeleteTokens WHERE UserID = ?",
-			"DELETE FROM Sessions WHERE UserID = ?",
-			"DELETE FROM Challenges WHERE UserID = ?",
-			"DELETE FROM NoList WHERE UserID = ?",
-			"DELETE FROM Settings WHERE UserID = ?",
-			"DELETE FROM Users WHERE ID = ?"}
-		for _, query := range queries {
-			_, err := core.DB.Exec(query, user)
-			if err != nil {
-				core.WriteError500(w, err)
-				return
-			}
-		}
-		tx.Commit()
 
 		json.NewEncoder(w).Encode(DeleteConfirm{
 			Message: "Your account has been successfully deleted."})
 	} else {
 		// If there isn't a confirmation header, prompt the user for confirmation
-		exists := 0
-		core.DB.Get(&exists, "SELECT 1 FROM DeleteTokens WHERE UserID = ?", user)
-		if exists == 1 {
+		if _, err := store.GetDeleteToken(user); err == nil {
 			core.WriteError(w, core.HTTPError{
+				Code:    core.CodeDeleteTokenExists,
 				Title:   "Resource Conflict",
 				Message: "This user already has a delete token stored. It will be automatically cleared in approximately 5min.",
 				Status:  409})
@@ -315,7 +278,10 @@ func DeleteAccount(ctx context.Context, w http.ResponseWriter, r *http.Request)
 
 		// Encode token to string
 		token := base64.RawURLEncoding.EncodeToString(bytes)
-		_, err = core.DB.Exec("INSERT INTO DeleteTokens (UserID, Token) VALUES (?, ?)", user, token)
+		err = store.CreateDeleteToken(core.DeleteTokenRecord{
+			UserID:    user,
+			Token:     token,
+			Timestamp: uint(time.Now().Unix())})
 		if err != nil {
 			core.WriteError500(w, err)
 			return
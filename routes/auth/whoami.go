@@ -12,7 +12,12 @@ import (
 func WhoAmI(ctx context.Context, w http.ResponseWriter, _ *http.Request) {
 	user := ctx.Value(core.Key("user")).(uint)
 	var state UserState
-	core.DB.Get(&state, "SELECT Verified FROM Users WHERE ID = ?", user)
+	record, err := core.StoreFrom(ctx).GetUser(user)
+	if err != nil {
+		core.WriteError500(w, err)
+		return
+	}
+	state.Verified = record.Verified
 	state.EncodedID = core.EncodeID(user)
 	json.NewEncoder(w).Encode(state)
 }
@@ -0,0 +1,219 @@
+package routes
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"github.com/very-amused/CSplan-API/core"
+)
+
+// Operation - A request made as part of a batch
+type Operation struct {
+	Method string `json:"method" validate:"required,oneof=GET POST PUT PATCH DELETE"`
+	// Path - Path of the route, including any query parameters
+	Path string `json:"path" validate:"required,startswith=/"`
+	// IfMatch - Sent as the operation's If-Match header
+	IfMatch string          `json:"ifMatch,omitempty"`
+	Body    json.RawMessage `json:"body,omitempty"`
+}
+
+// BatchRequest - Operations to run in a single transaction, in order
+type BatchRequest struct {
+	Operations []Operation `json:"operations" validate:"required,min=1,max=50,dive"`
+}
+
+// OperationResult - The response to an operation
+type OperationResult struct {
+	Status int `json:"status"`
+	// Headers - The operation's ETag, Location, and Retry-After headers, if it sent them
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    json.RawMessage   `json:"body,omitempty"`
+}
+
+// BatchResponse - The response to each operation, in the order they were sent
+// If any operation fails, no operations are committed, and operations after the failed one are not run (their status is 424)
+// Operations that can't be run at all (such as unbatchable or rate limited operations) fail the batch before any operation is run
+type BatchResponse struct {
+	Committed bool              `json:"committed"`
+	Results   []OperationResult `json:"results"`
+}
+
+// Routes that can't be run as part of a batch, in addition to any that don't require exactly user level authentication
+var unbatchable = map[string]bool{
+	"POST:/batch": true,
+	"GET:/events": true}
+
+// errOperationFailed - Returned to roll back a batch after an operation fails
+var errOperationFailed = errors.New("operation failed")
+
+// Batch - Run several operations in a single transaction, committing them only if every operation succeeds
+func Batch(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	var req BatchRequest
+	if err := core.DecodeJSON(r, &req); err != nil {
+		core.WriteError(w, *err)
+		return
+	}
+
+	response := BatchResponse{
+		Results: make([]OperationResult, len(req.Operations))}
+	// Operations are routed (and take their rate limit tokens) before the transaction is opened,
+	// so that the rate limiter's backend isn't waited on while the transaction holds its locks
+	operations := make([]*operation, len(req.Operations))
+	for i, op := range req.Operations {
+		var failed *OperationResult
+		if operations[i], failed = prepareOperation(ctx, w.Header().Get("X-Request-ID"), r, op); failed != nil {
+			for j := range response.Results {
+				response.Results[j].Status = http.StatusFailedDependency
+			}
+			response.Results[i] = *failed
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+	}
+
+	err := core.StoreFrom(ctx).Atomic(func(store core.Store) error {
+		// Every operation's handler uses the transaction
+		ctx := core.WithStore(ctx, store)
+		for i, op := range operations {
+			response.Results[i] = op.run(ctx)
+			if response.Results[i].Status >= 400 {
+				for j := i + 1; j < len(operations); j++ {
+					response.Results[j].Status = http.StatusFailedDependency
+				}
+				return errOperationFailed
+			}
+		}
+		return nil
+	})
+	if err != nil && err != errOperationFailed {
+		core.WriteError500(w, err)
+		return
+	}
+
+	response.Committed = err == nil
+	json.NewEncoder(w).Encode(response)
+}
+
+// operation - An operation that has been routed, ready to be run
+type operation struct {
+	route    *Route
+	req      *http.Request
+	recorder *recorder
+}
+
+// prepareOperation - Route an operation and take a token from its route's rate limit bucket,
+// returning the recorded response instead if it can't be run
+// Operations are made from the same address as the batch request, so that they count against its rate limits
+func prepareOperation(ctx context.Context, requestID string, batch *http.Request, op Operation) (*operation, *OperationResult) {
+	recorder := newRecorder()
+	// Errors sent by operations include the ID of the batch request
+	recorder.Header().Set("X-Request-ID", requestID)
+	failed := func() (*operation, *OperationResult) {
+		result := recorder.result()
+		return nil, &result
+	}
+
+	sub, err := http.NewRequestWithContext(ctx, op.Method, op.Path, bytes.NewReader(op.Body))
+	if err != nil {
+		core.WriteError400(recorder, "Malformed operation path")
+		return failed()
+	}
+	sub.RemoteAddr = batch.RemoteAddr
+	if forwarded := batch.Header.Get("X-Forwarded-For"); len(forwarded) > 0 {
+		sub.Header.Set("X-Forwarded-For", forwarded)
+	}
+	if len(op.Body) > 0 {
+		sub.Header.Set("Content-Type", "application/json")
+	}
+	if len(op.IfMatch) > 0 {
+		sub.Header.Set("If-Match", op.IfMatch)
+	}
+
+	var match mux.RouteMatch
+	if !matcher.Match(sub, &match) {
+		CatchAll(recorder, sub)
+		return failed()
+	}
+	key := match.Route.GetName()
+	route := Map[key]
+	if unbatchable[key] || route.AuthLevel != 1 {
+		core.WriteError(recorder, core.HTTPError{
+			Code:    core.CodeBadRequest,
+			Title:   "Bad Request",
+			Message: key + " can't be run as part of a batch",
+			Status:  400})
+		return failed()
+	}
+
+	// Each operation takes a token from its route's bucket, as if it had been sent on its own
+	if !route.allow(recorder, sub) {
+		return failed()
+	}
+	sub = mux.SetURLVars(sub, match.Vars)
+	sub.Body = http.MaxBytesReader(recorder, sub.Body, route.maxBodySize())
+	return &operation{
+		route:    route,
+		req:      sub,
+		recorder: recorder}, nil
+}
+
+// run - Run an operation's handler with ctx, recording the response
+func (op *operation) run(ctx context.Context) OperationResult {
+	op.route.handler(ctx, op.recorder, op.req.WithContext(ctx))
+	return op.recorder.result()
+}
+
+// recorder - Records the response to an operation
+type recorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newRecorder() *recorder {
+	return &recorder{
+		header: make(http.Header)}
+}
+
+func (rec *recorder) Header() http.Header {
+	return rec.header
+}
+
+func (rec *recorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+}
+
+func (rec *recorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	return rec.body.Write(b)
+}
+
+// result - Convert the recorded response to an operation result
+func (rec *recorder) result() OperationResult {
+	result := OperationResult{
+		Status: rec.status}
+	if result.Status == 0 {
+		result.Status = http.StatusOK
+	}
+	for _, name := range []string{"ETag", "Location", "Retry-After"} {
+		if value := rec.header.Get(name); len(value) > 0 {
+			if result.Headers == nil {
+				result.Headers = make(map[string]string)
+			}
+			result.Headers[name] = value
+		}
+	}
+	if body := bytes.TrimSpace(rec.body.Bytes()); len(body) > 0 {
+		result.Body = body
+	}
+	return result
+}
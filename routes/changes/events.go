@@ -0,0 +1,199 @@
+package changes
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/very-amused/CSplan-API/config"
+	core "github.com/very-amused/CSplan-API/core"
+)
+
+// Event - Notification of a change to one of the user's resources, sent as the data of each change event
+// Event IDs are change sequence numbers, and can be used as sync cursors (and vice versa)
+type Event struct {
+	Type string `json:"type"`
+	// ID - Encoded ID of the resource, omitted for resources each user only has one of (nolist, name, and keys)
+	ID     string `json:"id,omitempty"`
+	Action string `json:"action"`
+	// Checksum - The resource's new checksum, omitted for deletions
+	Checksum string `json:"checksum,omitempty"`
+	// Session - Encoded ID of the session that made the change, so that clients can ignore their own changes
+	Session string `json:"session,omitempty"`
+}
+
+// ResetEvent - Sent instead of change events when the Last-Event-ID can't be resumed from, the client must sync again without a cursor
+type ResetEvent struct {
+	Cursor uint64 `json:"cursor"`
+}
+
+const (
+	// Interval between heartbeats, which keep proxies from closing idle streams
+	// The change log is also checked at each heartbeat, in case changes were made through another instance
+	heartbeatInterval = 15 * time.Second
+	// How long a stream is kept open before it's ended (clients reconnect automatically, resuming from their Last-Event-ID)
+	streamDuration = 10 * time.Minute
+	// Delay before clients reconnect after a stream ends
+	retryDelay = time.Second
+)
+
+// deadlineSetter - ResponseWriter whose write deadline can be changed (implemented by net/http's writers since Go 1.20)
+type deadlineSetter interface {
+	SetWriteDeadline(deadline time.Time) error
+}
+
+// streamTiming - Return how long a stream can be kept open and the interval between its heartbeats
+// The server's write timeout applies to the whole response, so it's pushed back before each wait for the next event.
+// If the deadline can't be changed, the stream is ended just before the write timeout is reached instead,
+// with heartbeats sent well within that time
+func streamTiming(w http.ResponseWriter) (duration, heartbeat time.Duration, extend func() error) {
+	timeout := config.Current().Server.WriteTimeout.Duration
+	if setter, ok := w.(deadlineSetter); ok {
+		extend = func() error {
+			return setter.SetWriteDeadline(time.Now().Add(heartbeatInterval + timeout))
+		}
+		if extend() == nil {
+			return streamDuration, heartbeatInterval, extend
+		}
+	}
+
+	duration = timeout / 2
+	if timeout > 2*time.Second {
+		duration = timeout - time.Second
+	}
+	heartbeat = heartbeatInterval
+	if heartbeat > duration/3 {
+		heartbeat = duration / 3
+	}
+	return duration, heartbeat, func() error {
+		return nil
+	}
+}
+
+// GetEvents - Stream an event for each change to the user's resources
+// Streams resume after the Last-Event-ID header if it's sent, otherwise they start with the next change
+func GetEvents(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	user := ctx.Value(core.Key("user")).(uint)
+	store := core.StoreFrom(ctx)
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		core.WriteError500(w, errors.New("streaming responses aren't supported"))
+		return
+	}
+
+	seq, err := store.ChangeSeq(user)
+	if err != nil {
+		core.WriteError500(w, err)
+		return
+	}
+	cursor := seq
+	reset := false
+	if lastID := r.Header.Get("Last-Event-ID"); len(lastID) > 0 {
+		last, err := strconv.ParseUint(lastID, 10, 64)
+		if err != nil || last > seq {
+			reset = true
+		} else {
+			cursor = last
+		}
+	}
+
+	wake, unsubscribe, ok := core.HubFrom(ctx).Subscribe(user, config.Current().Limits.MaxEventStreams)
+	if !ok {
+		core.WriteError(w, core.HTTPError{
+			Code:    core.CodeStreamLimit,
+			Title:   "Too Many Requests",
+			Message: "Too many event streams are open for this account",
+			Status:  429})
+		return
+	}
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// Disable response buffering in nginx
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(200)
+	fmt.Fprintf(w, "retry: %d\n\n", retryDelay.Milliseconds())
+	if reset {
+		writeEvent(w, seq, "reset", ResetEvent{
+			Cursor: seq})
+	}
+
+	duration, interval, extendDeadline := streamTiming(w)
+	end := time.NewTimer(duration)
+	defer end.Stop()
+	heartbeat := time.NewTicker(interval)
+	defer heartbeat.Stop()
+	for {
+		if cursor, err = sendChanges(w, store, user, cursor); err != nil {
+			core.LoggerFrom(ctx).Errorf("Failed to send change events: %s", err)
+			return
+		}
+		flusher.Flush()
+		extendDeadline()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-end.C:
+			return
+		// Streams are ended once shutdown begins so that they don't hold up draining
+		case <-core.ShuttingDown():
+			return
+		case <-wake:
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+		}
+	}
+}
+
+// sendChanges - Write an event for each of the user's changes after cursor, returning the new cursor
+func sendChanges(w http.ResponseWriter, store core.Store, user uint, cursor uint64) (uint64, error) {
+	for {
+		records, err := store.ListChanges(user, cursor, maxLimit)
+		if err != nil {
+			return cursor, err
+		}
+		start := cursor
+		for _, record := range records {
+			cursor = record.Seq
+			action, skip := actionSince(record, start)
+			if skip {
+				continue
+			}
+
+			event := Event{
+				Type:   record.Kind,
+				Action: action}
+			if record.ResourceID != 0 {
+				event.ID = core.EncodeID(record.ResourceID)
+			}
+			if record.SessionID != 0 {
+				event.Session = core.EncodeID(record.SessionID)
+			}
+			if !record.Deleted {
+				_, event.Checksum, err = current(store, user, record.Kind, record.ResourceID)
+				// The resource may have been deleted since the change log was read, in which case a tombstone follows
+				if err == core.ErrNotFound {
+					continue
+				} else if err != nil {
+					return cursor, err
+				}
+			}
+			writeEvent(w, record.Seq, "change", event)
+		}
+		if len(records) < maxLimit {
+			return cursor, nil
+		}
+	}
+}
+
+// writeEvent - Write a server-sent event with a JSON encoded data field
+func writeEvent(w http.ResponseWriter, id uint64, name string, data interface{}) {
+	encoded, _ := json.Marshal(data)
+	fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", id, name, encoded)
+}
@@ -0,0 +1,282 @@
+package changes
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	core "github.com/very-amused/CSplan-API/core"
+	"github.com/very-amused/CSplan-API/routes/profile"
+	"github.com/very-amused/CSplan-API/routes/tags"
+	"github.com/very-amused/CSplan-API/routes/todo"
+)
+
+// Actions that can be pushed
+const (
+	ActionCreate = "create"
+	ActionUpdate = "update"
+	ActionDelete = "delete"
+)
+
+// Outcomes of each pushed change
+const (
+	StatusApplied  = "applied"
+	StatusConflict = "conflict"
+	StatusNotFound = "not_found"
+	StatusInvalid  = "invalid"
+)
+
+// PushChange - A change made by a client while offline
+// Keys can't be pushed, they must be updated through /keys
+type PushChange struct {
+	Type string `json:"type" validate:"required,oneof=todo tag nolist name"`
+	// ID - Encoded ID of the todo list or tag being updated or deleted
+	ID     string `json:"id,omitempty"`
+	Action string `json:"action" validate:"required,oneof=create update delete"`
+	// BaseChecksum - Checksum of the resource the change was made to, required to update or delete
+	BaseChecksum string `json:"baseChecksum,omitempty"`
+	// Data - The resource (create) or a patch to it (update), in the same form accepted by the resource's POST or PATCH route
+	Data json.RawMessage `json:"data,omitempty"`
+}
+
+// PushRequest - A set of changes to apply together
+type PushRequest struct {
+	Changes []PushChange `json:"changes" validate:"required,max=100,dive"`
+}
+
+// PushResult - The outcome of a pushed change
+type PushResult struct {
+	Type   string `json:"type"`
+	ID     string `json:"id,omitempty"`
+	Status string `json:"status"`
+	// Checksum - Checksum of the resource after the change was applied, or its current checksum if the change conflicted
+	Checksum string `json:"checksum,omitempty"`
+	// Data - The current resource, sent with conflicts so that the client can merge its change
+	Data  interface{}     `json:"data,omitempty"`
+	Error *core.HTTPError `json:"error,omitempty"`
+}
+
+// PushResponse - The outcome of each pushed change, in the order they were sent
+type PushResponse struct {
+	Results []PushResult `json:"results"`
+}
+
+// Push - Apply a set of changes made by a client
+// Every change is applied in a single transaction, changes that conflict with the current state of a resource are skipped and reported
+func Push(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	user := ctx.Value(core.Key("user")).(uint)
+	var req PushRequest
+	if err := core.DecodeJSON(r, &req); err != nil {
+		core.WriteError(w, *err)
+		return
+	}
+
+	results := make([]PushResult, len(req.Changes))
+	err := core.StoreFrom(ctx).Atomic(func(store core.Store) (e error) {
+		for i, change := range req.Changes {
+			if results[i], e = apply(store, user, change); e != nil {
+				return e
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		core.WriteError500(w, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(PushResponse{
+		Results: results})
+}
+
+// apply - Apply a single change, returning an error only if the change couldn't be applied due to a server side error
+func apply(store core.Store, user uint, change PushChange) (result PushResult, e error) {
+	result = PushResult{
+		Type: change.Type,
+		ID:   change.ID}
+	invalid := func(httpErr core.HTTPError) (PushResult, error) {
+		result.Status = StatusInvalid
+		result.Error = &httpErr
+		return result, nil
+	}
+
+	// Todo lists and tags are identified by ID, and are assigned a new one when created
+	var id uint
+	if (change.Type == core.KindTodoList || change.Type == core.KindTag) && change.Action != ActionCreate {
+		if id, e = core.DecodeID(change.ID); e != nil {
+			return invalid(core.HTTPError{
+				Code:    core.CodeMalformedID,
+				Title:   "Bad Request",
+				Message: "Malformed or missing id",
+				Status:  400})
+		}
+	}
+	if change.Action != ActionCreate && len(change.BaseChecksum) == 0 {
+		return invalid(core.HTTPError{
+			Code:    core.CodeValidation,
+			Title:   "Validation Error",
+			Message: "A base checksum is required to update or delete a resource",
+			Status:  400,
+			Errors: []core.FieldError{{
+				Field:   "baseChecksum",
+				Rule:    "required",
+				Message: "is required"}}})
+	}
+	if change.Action == ActionDelete && change.Type == core.KindNoList {
+		return invalid(core.HTTPError{
+			Code:    core.CodeBadRequest,
+			Title:   "Bad Request",
+			Message: "The nolist collection can't be deleted",
+			Status:  400})
+	}
+
+	var data interface{}
+	if change.Action != ActionDelete {
+		if len(change.Data) == 0 {
+			return invalid(core.HTTPError{
+				Code:    core.CodeValidation,
+				Title:   "Validation Error",
+				Message: "Data is required to create or update a resource",
+				Status:  400,
+				Errors: []core.FieldError{{
+					Field:   "data",
+					Rule:    "required",
+					Message: "is required"}}})
+		}
+		data = newData(change.Type, change.Action)
+		if httpErr := core.DecodeJSONBytes(change.Data, data); httpErr != nil {
+			return invalid(*httpErr)
+		}
+	}
+
+	// Check the change against the current state of the resource
+	existing, checksum, e := current(store, user, change.Type, id)
+	if e == core.ErrNotFound {
+		if change.Action != ActionCreate {
+			result.Status = StatusNotFound
+			return result, nil
+		}
+	} else if e != nil {
+		return result, e
+	} else if change.Action == ActionCreate && (change.Type == core.KindNoList || change.Type == core.KindName) ||
+		change.Action != ActionCreate && change.BaseChecksum != checksum {
+		// The resource was created or modified by another client
+		result.Status = StatusConflict
+		result.Checksum = checksum
+		result.Data = existing
+		return result, nil
+	}
+
+	switch change.Action {
+	case ActionCreate:
+		id, result.Checksum, e = create(store, user, data)
+		if id != 0 {
+			result.ID = core.EncodeID(id)
+		}
+	case ActionUpdate:
+		result.Checksum, e = update(store, user, id, data)
+	case ActionDelete:
+		e = remove(store, user, change.Type, id)
+	}
+	if httpErr, ok := e.(core.HTTPError); ok {
+		return invalid(httpErr)
+	} else if e != nil {
+		return result, e
+	}
+	result.Status = StatusApplied
+	return result, nil
+}
+
+// newData - Allocate the type a change's data is decoded into
+func newData(kind, action string) interface{} {
+	create := action == ActionCreate
+	switch {
+	case kind == core.KindTodoList && create:
+		return &todo.List{}
+	case kind == core.KindTodoList:
+		return &todo.Patch{}
+	case kind == core.KindTag && create:
+		return &tags.Tag{}
+	case kind == core.KindTag:
+		return &tags.Patch{}
+	case kind == core.KindNoList && create:
+		return &todo.NoList{}
+	case kind == core.KindNoList:
+		return &todo.NoListPatch{}
+	case kind == core.KindName && create:
+		return &profile.Name{}
+	}
+	return &profile.NamePatch{}
+}
+
+// create - Store a new resource, returning its ID (if it has one) and checksum
+func create(store core.Store, user uint, data interface{}) (id uint, checksum string, e error) {
+	switch data := data.(type) {
+	case *todo.List:
+		record, e := todo.Insert(store, user, *data)
+		return record.ID, record.Checksum(), e
+	case *tags.Tag:
+		record, e := tags.Insert(store, user, *data)
+		return record.ID, record.Checksum(), e
+	case *todo.NoList:
+		record, e := todo.InsertNoList(store, user, *data)
+		return 0, record.Checksum(), e
+	case *profile.Name:
+		record := profile.NameRecord(user, *data)
+		return 0, record.Checksum(), store.CreateName(record)
+	}
+	return 0, "", nil
+}
+
+// update - Patch an existing resource, returning its new checksum
+func update(store core.Store, user, id uint, patch interface{}) (checksum string, e error) {
+	switch patch := patch.(type) {
+	case *todo.Patch:
+		record, e := store.GetTodoList(id, user)
+		if e != nil {
+			return "", e
+		}
+		record, e = todo.ApplyPatch(store, record, *patch)
+		return record.Checksum(), e
+	case *tags.Patch:
+		record, e := store.GetTag(id, user)
+		if e != nil {
+			return "", e
+		}
+		record = tags.ApplyPatch(record, *patch)
+		return record.Checksum(), store.UpdateTag(record)
+	case *todo.NoListPatch:
+		record, e := store.GetNoList(user)
+		if e != nil {
+			return "", e
+		}
+		record, e = todo.PatchNoList(store, record, *patch)
+		return record.Checksum(), e
+	case *profile.NamePatch:
+		record, e := store.GetName(user)
+		if e != nil {
+			return "", e
+		}
+		record = profile.PatchName(record, *patch)
+		return record.Checksum(), store.UpdateName(record)
+	}
+	return "", nil
+}
+
+// remove - Delete an existing resource (todo lists and tags are moved to the trash)
+func remove(store core.Store, user uint, kind string, id uint) error {
+	switch kind {
+	case core.KindTodoList:
+		record, e := store.GetTodoList(id, user)
+		if e != nil {
+			return e
+		}
+		return todo.Remove(store, record)
+	case core.KindTag:
+		return store.TrashTag(id, user, uint(time.Now().Unix()))
+	case core.KindName:
+		return store.DeleteName(user)
+	}
+	return nil
+}
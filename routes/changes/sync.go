@@ -0,0 +1,252 @@
+// Package changes - Delta sync of a user's resources, backed by the store's change log
+package changes
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	core "github.com/very-amused/CSplan-API/core"
+	"github.com/very-amused/CSplan-API/routes/crypto"
+	"github.com/very-amused/CSplan-API/routes/profile"
+	"github.com/very-amused/CSplan-API/routes/tags"
+	"github.com/very-amused/CSplan-API/routes/todo"
+)
+
+// Actions describing how a resource has changed since a cursor
+const (
+	ActionCreated = "created"
+	ActionUpdated = "updated"
+	ActionDeleted = "deleted"
+)
+
+// Limits on the number of changes sent in a single response
+const (
+	defaultLimit = 100
+	maxLimit     = 500
+)
+
+// Change - The current state of a resource that has changed since a cursor
+type Change struct {
+	Seq  uint64 `json:"seq"`
+	Type string `json:"type"`
+	// ID - Encoded ID of the resource, omitted for resources each user only has one of (nolist, name, and keys)
+	ID     string `json:"id,omitempty"`
+	Action string `json:"action"`
+	// Data - The resource as it would be returned by its GET route, omitted for deletions
+	Data interface{} `json:"data,omitempty"`
+}
+
+// SyncResponse - Changes since a cursor
+type SyncResponse struct {
+	// Cursor - Cursor to send with the next sync
+	Cursor uint64 `json:"cursor"`
+	// HasMore - Whether more changes are available (sync again immediately with the new cursor to get them)
+	HasMore bool     `json:"hasMore"`
+	Changes []Change `json:"changes"`
+}
+
+// GetSync - Retrieve every change to the user's resources since ?cursor=
+// A cursor of 0 (or no cursor) retrieves a snapshot of every resource
+func GetSync(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	user := ctx.Value(core.Key("user")).(uint)
+	query := r.URL.Query()
+	var cursor uint64
+	if c := query.Get("cursor"); len(c) > 0 {
+		var err error
+		if cursor, err = strconv.ParseUint(c, 10, 64); err != nil {
+			core.WriteError(w, invalidCursor("The cursor must be an unsigned integer"))
+			return
+		}
+	}
+	limit := defaultLimit
+	if l := query.Get("limit"); len(l) > 0 {
+		var err error
+		if limit, err = strconv.Atoi(l); err != nil || limit <= 0 || limit > maxLimit {
+			core.WriteError400(w, "The limit must be an integer between 1 and "+strconv.Itoa(maxLimit))
+			return
+		}
+	}
+
+	var response SyncResponse
+	// Changes are read in a transaction so that every resource is read as of the same point in the change log
+	err := core.StoreFrom(ctx).Atomic(func(store core.Store) (e error) {
+		seq, e := store.ChangeSeq(user)
+		if e != nil {
+			return e
+		}
+		if cursor > seq {
+			return invalidCursor("The cursor is ahead of the change log, sync again without a cursor")
+		}
+		if cursor == 0 {
+			response.Cursor = seq
+			response.Changes, e = snapshot(store, user, seq)
+			return e
+		}
+		response, e = since(store, user, cursor, limit)
+		return e
+	})
+	if httpErr, ok := err.(core.HTTPError); ok {
+		core.WriteError(w, httpErr)
+		return
+	} else if err != nil {
+		core.WriteError500(w, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(response)
+}
+
+func invalidCursor(msg string) core.HTTPError {
+	return core.HTTPError{
+		Code:    core.CodeInvalidCursor,
+		Title:   "Invalid Cursor",
+		Message: msg,
+		Status:  400}
+}
+
+// snapshot - List every resource belonging to user as created at seq
+func snapshot(store core.Store, user uint, seq uint64) ([]Change, error) {
+	changes := make([]Change, 0)
+	add := func(kind string, id uint, data interface{}) {
+		change := Change{
+			Seq:    seq,
+			Type:   kind,
+			Action: ActionCreated,
+			Data:   data}
+		if id != 0 {
+			change.ID = core.EncodeID(id)
+		}
+		changes = append(changes, change)
+	}
+
+	lists, err := store.ListTodoLists(user)
+	if err != nil {
+		return nil, err
+	}
+	for _, record := range lists {
+		list, err := todo.FromRecord(record)
+		if err != nil {
+			return nil, err
+		}
+		add(core.KindTodoList, record.ID, list)
+	}
+	tagRecords, err := store.ListTags(user)
+	if err != nil {
+		return nil, err
+	}
+	for _, record := range tagRecords {
+		add(core.KindTag, record.ID, tags.FromRecord(record))
+	}
+	// Resources each user only has one of
+	for _, kind := range []string{core.KindNoList, core.KindName, core.KindKeys} {
+		data, _, err := current(store, user, kind, 0)
+		if err == core.ErrNotFound {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		add(kind, 0, data)
+	}
+	return changes, nil
+}
+
+// since - List up to limit changes after cursor
+func since(store core.Store, user uint, cursor uint64, limit int) (response SyncResponse, e error) {
+	// One extra change is requested to find out if there are more
+	records, e := store.ListChanges(user, cursor, limit+1)
+	if e != nil {
+		return response, e
+	}
+	if len(records) > limit {
+		records = records[:limit]
+		response.HasMore = true
+	}
+
+	response.Cursor = cursor
+	response.Changes = make([]Change, 0, len(records))
+	for _, record := range records {
+		response.Cursor = record.Seq
+		action, skip := actionSince(record, cursor)
+		if skip {
+			continue
+		}
+
+		change := Change{
+			Seq:    record.Seq,
+			Type:   record.Kind,
+			Action: action}
+		if record.ResourceID != 0 {
+			change.ID = core.EncodeID(record.ResourceID)
+		}
+		if !record.Deleted {
+			change.Data, _, e = current(store, user, record.Kind, record.ResourceID)
+			// A resource that can no longer be read (such as one that's been moved to the trash) is sent as a tombstone,
+			// unless it was created since the cursor, in which case the client never saw it
+			if e == core.ErrNotFound {
+				if record.CreatedSeq > cursor {
+					continue
+				}
+				change.Action = ActionDeleted
+				change.Data = nil
+			} else if e != nil {
+				return response, e
+			}
+		}
+		response.Changes = append(response.Changes, change)
+	}
+	return response, nil
+}
+
+// actionSince - Describe how a resource has changed since cursor
+// Resources that were created and deleted since the cursor were never seen by the client, and are skipped
+func actionSince(record core.ChangeRecord, cursor uint64) (action string, skip bool) {
+	created := record.CreatedSeq > cursor
+	switch {
+	case record.Deleted:
+		return ActionDeleted, created
+	case created:
+		return ActionCreated, false
+	}
+	return ActionUpdated, false
+}
+
+// current - Retrieve the current API representation and checksum of a resource
+func current(store core.Store, user uint, kind string, id uint) (data interface{}, checksum string, e error) {
+	switch kind {
+	case core.KindTodoList:
+		record, e := store.GetTodoList(id, user)
+		if e != nil {
+			return nil, "", e
+		}
+		data, e = todo.FromRecord(record)
+		return data, record.Checksum(), e
+	case core.KindTag:
+		record, e := store.GetTag(id, user)
+		if e != nil {
+			return nil, "", e
+		}
+		return tags.FromRecord(record), record.Checksum(), nil
+	case core.KindNoList:
+		record, e := store.GetNoList(user)
+		if e != nil {
+			return nil, "", e
+		}
+		data, e = todo.NoListFromRecord(record)
+		return data, record.Checksum(), e
+	case core.KindName:
+		record, e := store.GetName(user)
+		if e != nil {
+			return nil, "", e
+		}
+		return profile.NameFromRecord(record), record.Checksum(), nil
+	case core.KindKeys:
+		record, e := store.GetKeys(user)
+		if e != nil {
+			return nil, "", e
+		}
+		return crypto.FromRecord(record), record.Checksum(), nil
+	}
+	return nil, "", core.ErrNotFound
+}
@@ -3,9 +3,7 @@ package crypto
 import (
 	"context"
 	"encoding/json"
-	"fmt"
 	"net/http"
-	"strings"
 
 	core "github.com/very-amused/CSplan-API/core"
 	"github.com/very-amused/CSplan-API/routes/auth"
@@ -32,8 +30,7 @@ type KeysPatch struct {
 func AddKeys(ctx context.Context, w http.ResponseWriter, r *http.Request) {
 	user := ctx.Value(core.Key("user")).(uint)
 	var keys Keys
-	json.NewDecoder(r.Body).Decode(&keys)
-	if err := core.ValidateStruct(keys); err != nil {
+	if err := core.DecodeJSON(r, &keys); err != nil {
 		core.WriteError(w, *err)
 		return
 	}
@@ -44,8 +41,12 @@ func AddKeys(ctx context.Context, w http.ResponseWriter, r *http.Request) {
 		core.WriteError500(w, err)
 		return
 	}
-	_, err = core.DB.Exec("INSERT INTO CryptoKeys (UserID, PublicKey, PrivateKey, HashSalt, HashParams) VALUES (?, FROM_BASE64(?), FROM_BASE64(?), FROM_BASE64(?), ?)",
-		user, keys.PublicKey, keys.PrivateKey, keys.HashSalt, encodedHashParams)
+	err = core.StoreFrom(ctx).CreateKeys(core.KeysRecord{
+		UserID:     user,
+		PublicKey:  core.FromBase64(keys.PublicKey),
+		PrivateKey: core.FromBase64(keys.PrivateKey),
+		HashSalt:   core.FromBase64(keys.HashSalt),
+		HashParams: encodedHashParams})
 	if err != nil {
 		core.WriteError500(w, err)
 		return
@@ -54,47 +55,60 @@ func AddKeys(ctx context.Context, w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(201)
 }
 
+// FromRecord - Convert a stored keypair to its API representation
+func FromRecord(record core.KeysRecord) Keys {
+	keys := Keys{
+		PublicKey:  core.ToBase64(record.PublicKey),
+		PrivateKey: core.ToBase64(record.PrivateKey),
+		HashSalt:   core.ToBase64(record.HashSalt)}
+	json.Unmarshal(record.HashParams, &keys.HashParams)
+	return keys
+}
+
 // GetKeys - Retrieve a user's key information
-func GetKeys(ctx context.Context, w http.ResponseWriter, _ *http.Request) {
+func GetKeys(ctx context.Context, w http.ResponseWriter, r *http.Request) {
 	userID := ctx.Value(core.Key("user")).(uint)
-	var keys Keys
-	var encodedHashParams []byte
-	row := core.DB.QueryRow("SELECT TO_BASE64(PublicKey), TO_BASE64(PrivateKey), TO_BASE64(HashSalt), HashParams FROM CryptoKeys WHERE UserID = ?", userID)
-	err := row.Scan(&keys.PublicKey, &keys.PrivateKey, &keys.HashSalt, &encodedHashParams)
-	if err != nil {
+	record, err := core.StoreFrom(ctx).GetKeys(userID)
+	if err == core.ErrNotFound {
 		core.WriteError(w, core.HTTPError{
+			Code:    core.CodeNotFound,
 			Title:   "Not Found",
 			Message: "The requested keypair was not found",
 			Status:  404})
 		return
+	} else if err != nil {
+		core.WriteError500(w, err)
+		return
 	}
-	json.Unmarshal(encodedHashParams, &keys.HashParams)
+	keys := FromRecord(record)
 
+	if core.NotModified(w, r, record.Checksum()) {
+		return
+	}
 	json.NewEncoder(w).Encode(keys)
 }
 
 // UpdateKeys - Update a user's cryptographic keypair
 func UpdateKeys(ctx context.Context, w http.ResponseWriter, r *http.Request) {
 	userID := ctx.Value(core.Key("user")).(uint)
+	store := core.StoreFrom(ctx)
 	// Existence check
-	rows, _ := core.DB.Query("SELECT 1 FROM CryptoKeys WHERE UserID = ?", userID)
-	defer rows.Close()
-	if !rows.Next() {
+	keys, err := store.GetKeys(userID)
+	if err == core.ErrNotFound {
 		core.WriteError404(w)
 		return
+	} else if err != nil {
+		core.WriteError500(w, err)
+		return
 	}
 
 	// Decode patches and verify
 	var patch KeysPatch
-	json.NewDecoder(r.Body).Decode(&patch)
-	if err := core.ValidateStruct(patch); err != nil {
+	if err := core.DecodeJSON(r, &patch); err != nil {
 		core.WriteError(w, *err)
 		return
 	}
 
-	var updateQuery []string
-	var updateValues []interface{}
-
 	// If hash params are being updated, the user's private key must also be updated because different hash params will produce a different key, and therefore differently encrypted data
 	if patch.HashSalt != nil && patch.HashParams != nil && patch.PrivateKey != nil {
 		// Validate hash params
@@ -109,38 +123,29 @@ func UpdateKeys(ctx context.Context, w http.ResponseWriter, r *http.Request) {
 			core.WriteError500(w, err)
 			return
 		}
-		updateQuery = append(updateQuery, []string{
-			"HashSalt = FROM_BASE64(?)",
-			"HashParams = ?",
-			"PrivateKey = FROM_BASE64(?)"}...)
-		updateValues = append(updateValues, []interface{}{
-			*patch.HashSalt,
-			encodedHashParams,
-			*patch.PrivateKey}...)
+		keys.HashSalt = core.FromBase64(*patch.HashSalt)
+		keys.HashParams = encodedHashParams
+		keys.PrivateKey = core.FromBase64(*patch.PrivateKey)
 
 		// The user may also have specified an update to the public key
 		if patch.PublicKey != nil {
-			updateQuery = append(updateQuery, "PublicKey = FROM_BASE64(?)")
-			updateValues = append(updateValues, *patch.PublicKey)
+			keys.PublicKey = core.FromBase64(*patch.PublicKey)
 		}
 		// The other possible patch here would be if the user is updating their master keypair without any changes to their hashParams
 	} else if patch.PrivateKey != nil && patch.PublicKey != nil {
-		updateQuery = append(updateQuery, []string{
-			"PrivateKey = FROM_BASE64(?)",
-			"PublicKey = FROM_BASE64(?)"}...)
-		updateValues = append(updateValues, []interface{}{
-			*patch.PrivateKey,
-			*patch.PublicKey}...)
+		keys.PrivateKey = core.FromBase64(*patch.PrivateKey)
+		keys.PublicKey = core.FromBase64(*patch.PublicKey)
 	} else {
 		// Send the client a 412, indicating that no changes were made due to a failed precondition
-		w.WriteHeader(412)
+		core.WriteError(w, core.HTTPError{
+			Code:    core.CodeIncompletePatch,
+			Title:   "Precondition Failed",
+			Message: "Updating the private key requires also updating either the public key, or the hash salt and hash params",
+			Status:  412})
 		return
 	}
 
-	_, err := core.DB.Exec(
-		fmt.Sprintf("UPDATE CryptoKeys SET %s WHERE UserID = ?", strings.Join(updateQuery, ",")),
-		append(updateValues, userID)...)
-	if err != nil {
+	if err := store.UpdateKeys(keys); err != nil {
 		core.WriteError500(w, err)
 		return
 	}
@@ -0,0 +1,26 @@
+package routes
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"github.com/very-amused/CSplan-API/core"
+)
+
+// GetErrors - List every error code the API can send
+func GetErrors(_ context.Context, w http.ResponseWriter, _ *http.Request) {
+	json.NewEncoder(w).Encode(core.ErrorCatalog)
+}
+
+// GetError - Describe a single error code, this is the resource identified by the type of each problem
+func GetError(_ context.Context, w http.ResponseWriter, r *http.Request) {
+	info, ok := core.LookupError(mux.Vars(r)["code"])
+	if !ok {
+		core.WriteError404(w)
+		return
+	}
+	json.NewEncoder(w).Encode(info)
+}
@@ -4,20 +4,96 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"net/url"
+	"sort"
 	"strings"
+	"sync"
 
+	"github.com/gorilla/mux"
+
+	"github.com/very-amused/CSplan-API/config"
 	"github.com/very-amused/CSplan-API/core"
+	"github.com/very-amused/CSplan-API/openapi"
 	"github.com/very-amused/CSplan-API/routes/auth"
+	"github.com/very-amused/CSplan-API/routes/changes"
 	"github.com/very-amused/CSplan-API/routes/crypto"
 	"github.com/very-amused/CSplan-API/routes/profile"
 	"github.com/very-amused/CSplan-API/routes/tags"
 	"github.com/very-amused/CSplan-API/routes/todo"
+	"github.com/very-amused/CSplan-API/routes/trash"
 )
 
 // Route - Information to handle HTTP routes
 type Route struct {
 	handler   func(c context.Context, w http.ResponseWriter, r *http.Request)
+	key       string // Key of the route in Map, set by LoadRoutes
 	AuthLevel int
+	// MaxBodySize - Maximum size in bytes of the request body, defaults to limits.max_body_size
+	MaxBodySize int64
+
+	// Documentation used to generate the OpenAPI document
+	Summary  string
+	Request  interface{} // Zero value of the JSON request body's type, or nil if the route doesn't accept a body
+	Response interface{} // Zero value of the JSON response body's type, or nil if the route doesn't send one
+	// ContentType - Media type of the response body, defaults to application/json (for event streams, Response documents the data of each event)
+	ContentType string
+	Status      int // Status sent on success, defaults to 200 (or 204 without a response body)
+	Query       []openapi.Parameter
+	ETag        bool // Whether the resource's checksum is sent as an ETag, and checked against If-Match (PATCH) or If-None-Match (GET)
+	// QueryCSRF - Whether the CSRF token can be sent as ?csrf= instead of a header, for clients such as EventSource that can't set headers
+	QueryCSRF bool
+}
+
+// status - Return the status sent on success
+func (route Route) status() int {
+	if route.Status != 0 {
+		return route.Status
+	} else if route.Response == nil {
+		return 204
+	}
+	return 200
+}
+
+// maxBodySize - Return the maximum size of the request body
+func (route Route) maxBodySize() int64 {
+	if route.MaxBodySize > 0 {
+		return route.MaxBodySize
+	}
+	return config.Current().Limits.MaxBodySize
+}
+
+// Bodies sent to authentication routes only ever contain an email, keys, and hash parameters,
+// so they're limited more strictly than other routes
+const authBodySize = 4 << 10
+
+// Changes pushed to /sync and batches can contain many full todo lists
+const bulkBodySize = 16 << 20
+
+// action - Document a required ?action= query parameter
+func action(values ...string) []openapi.Parameter {
+	return []openapi.Parameter{{
+		Name:     "action",
+		In:       "query",
+		Required: true,
+		Schema: &openapi.Schema{
+			Type: "string",
+			Enum: values}}}
+}
+
+// pagination - Document the ?limit= and ?cursor= query parameters of a paginated collection
+func pagination() []openapi.Parameter {
+	return []openapi.Parameter{{
+		Name:        "limit",
+		In:          "query",
+		Description: fmt.Sprintf("Maximum number of members to return (1-%d), the whole collection is returned if neither limit or cursor are sent. A Link header refers to the next page, if there is one", core.MaxPageSize),
+		Schema: &openapi.Schema{
+			Type:   "integer",
+			Format: "int32"}}, {
+		Name:        "cursor",
+		In:          "query",
+		Description: fmt.Sprintf("Opaque cursor taken from the Link header of the previous page (limit defaults to %d if only a cursor is sent)", core.DefaultPageSize),
+		Schema: &openapi.Schema{
+			Type: "string"}}}
 }
 
 // Map - Static map of HTTP routes to their corresponding handlers
@@ -28,6 +104,12 @@ func (route Route) Handler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	// Handle authentication
 	if route.AuthLevel > 0 {
+		if !allowAuthentication(w, r) {
+			return
+		}
+		if route.QueryCSRF && len(r.Header.Get("CSRF-Token")) == 0 {
+			r.Header.Set("CSRF-Token", r.URL.Query().Get("csrf"))
+		}
 		authLvl := auth.Authenticate(r)
 		// Send relevant 401/403 response if the user isn't properly authenticated for the route
 		if authLvl.AuthLevel == -1 {
@@ -38,215 +120,475 @@ func (route Route) Handler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		// Add the user and session id to the route context
+		info := core.RequestInfoFrom(ctx)
+		info.SetUser(authLvl.UserID)
+		info.LogIP = authLvl.LogIP
 		ctx = context.WithValue(ctx, core.Key("user"), authLvl.UserID)
 		ctx = context.WithValue(ctx, core.Key("session"), authLvl.SessionID)
+		// Changes made by the route are attributed to the session in the change log
+		ctx = core.WithStore(ctx, core.StoreFrom(ctx).AsSession(authLvl.SessionID))
+	}
+	// Per route rate limiting is done after authentication so that limits can be applied per user
+	if !route.allow(w, r) {
+		return
 	}
+	r.Body = http.MaxBytesReader(w, r.Body, route.maxBodySize())
 	route.handler(ctx, w, r)
 }
 
+var loadOnce sync.Once
+
 // LoadRoutes - Load all routes, should be called after flags are parsed
+// Only the first call has any effect, so that multiple routers (such as those created by tests) can share Map
 func LoadRoutes() {
+	loadOnce.Do(loadRoutes)
+}
+
+func loadRoutes() {
+	Map["GET:/openapi.json"] = &Route{
+		handler:   GetSpec,
+		AuthLevel: 0,
+		Summary:   "Get this OpenAPI document",
+		Response:  map[string]interface{}{}}
+	Map["GET:/errors"] = &Route{
+		handler:   GetErrors,
+		AuthLevel: 0,
+		Summary:   "List every error code the API can send",
+		Response:  []core.ErrorInfo{}}
+	Map["GET:/errors/{code}"] = &Route{
+		handler:   GetError,
+		AuthLevel: 0,
+		Summary:   "Describe an error code",
+		Response:  core.ErrorInfo{}}
 	Map["POST:/register"] = &Route{
-		handler:   auth.Register,
-		AuthLevel: 0}
+		handler:     auth.Register,
+		AuthLevel:   0,
+		MaxBodySize: authBodySize,
+		Summary:     "Create an account",
+		Request:     auth.User{},
+		Response:    auth.UserState{},
+		Status:      201}
 	Map["GET:/whoami"] = &Route{
 		handler:   auth.WhoAmI,
-		AuthLevel: 1}
+		AuthLevel: 1,
+		Summary:   "Get the authenticated user's ID and verification status",
+		Response:  auth.UserState{}}
 	/* Two separate HTTP requests (second one must contain a token sent in the first)
 	to this fairly difficult to hit by accident URL are needed to actually delete a user account,
 	making it practically impossible to accomplish by accident
 	*/
 	Map["DELETE:/delete_my_account_please"] = &Route{
 		handler:   auth.DeleteAccount,
-		AuthLevel: 1}
+		AuthLevel: 1,
+		Summary:   "Request a deletion token, or delete the account if the token is sent in the X-Confirm header",
+		Response:  auth.DeleteToken{}}
 
 	Map["POST:/challenge"] = &Route{
-		handler:   auth.RequestChallenge,
-		AuthLevel: 0}
+		handler:     auth.RequestChallenge,
+		AuthLevel:   0,
+		MaxBodySize: authBodySize,
+		Summary:     "Request an authentication challenge encrypted with the user's auth key",
+		Request:     auth.ChallengeRequest{},
+		Response:    auth.Challenge{},
+		Status:      201,
+		Query:       action("request")}
 	Map["POST:/challenge/{id}"] = &Route{
-		handler:   auth.SubmitChallenge,
-		AuthLevel: 0}
+		handler:     auth.SubmitChallenge,
+		AuthLevel:   0,
+		MaxBodySize: authBodySize,
+		Summary:     "Submit a decrypted authentication challenge, setting the Authorization cookie on success",
+		Request:     auth.Challenge{},
+		Response:    auth.Session{},
+		Query:       action("submit")}
 	if auth.AuthBypass {
 		Map["POST:/login"] = &Route{
-			handler:   auth.Login,
-			AuthLevel: 0}
+			handler:     auth.Login,
+			AuthLevel:   0,
+			MaxBodySize: authBodySize,
+			Summary:     "Log in without a challenge (only available when auth bypass is enabled)",
+			Request:     auth.ChallengeRequest{},
+			Response:    auth.UserState{}}
 	}
 	Map["POST:/totp"] = &Route{
 		handler:   auth.SetTOTP,
-		AuthLevel: 1}
+		AuthLevel: 1,
+		Summary:   "Enable TOTP (returning the secret and backup codes) or disable TOTP (204)",
+		Response:  auth.TOTPInfo{},
+		Status:    201,
+		Query:     action("enable", "disable")}
 	Map["GET:/totp/backup_codes"] = &Route{
 		handler:   auth.GetBackupCodes,
-		AuthLevel: 1}
+		AuthLevel: 1,
+		Summary:   "Get the remaining TOTP backup codes",
+		Response:  []uint64{}}
 
 	// Session management
 	Map["POST:/logout"] = &Route{
 		handler:   auth.Logout,
-		AuthLevel: 1}
+		AuthLevel: 1,
+		Summary:   "Log out of the current session"}
 	Map["POST:/logout/{id}"] = &Route{
 		handler:   auth.Logout,
-		AuthLevel: 2}
+		AuthLevel: 2,
+		Summary:   "Log out of another session"}
 	Map["GET:/sessions"] = &Route{
 		handler:   auth.GetSessions,
-		AuthLevel: 1}
+		AuthLevel: 1,
+		Summary:   "List sessions, with the position of the current session in the X-Current-Session header",
+		Response:  []auth.SessionInfo{}}
 
 	Map["PUT:/authkey"] = &Route{
-		handler:   auth.UpdateKey,
-		AuthLevel: 1}
+		handler:     auth.UpdateKey,
+		AuthLevel:   1,
+		MaxBodySize: authBodySize,
+		Summary:     "Replace the auth key and its hash parameters",
+		Request:     auth.KeyPatch{},
+		Status:      200}
 
 	Map["POST:/keys"] = &Route{
 		handler:   crypto.AddKeys,
-		AuthLevel: 1}
+		AuthLevel: 1,
+		Summary:   "Store the user's encrypted master keypair",
+		Request:   crypto.Keys{},
+		Status:    201}
 	Map["GET:/keys"] = &Route{
 		handler:   crypto.GetKeys,
-		AuthLevel: 1}
+		AuthLevel: 1,
+		Summary:   "Get the user's encrypted master keypair",
+		Response:  crypto.Keys{},
+		ETag:      true}
 	Map["PATCH:/keys"] = &Route{
 		handler:   crypto.UpdateKeys,
-		AuthLevel: 1}
+		AuthLevel: 1,
+		Summary:   "Update the master keypair and/or its hash parameters",
+		Request:   crypto.KeysPatch{},
+		Status:    200}
 
 	Map["GET:/settings"] = &Route{
 		handler:   profile.GetSettings,
-		AuthLevel: 1}
+		AuthLevel: 1,
+		Summary:   "Get privacy settings",
+		Response:  profile.Settings{}}
 	Map["PATCH:/settings"] = &Route{
 		handler:   profile.UpdateSettings,
-		AuthLevel: 1}
+		AuthLevel: 1,
+		Summary:   "Update privacy settings",
+		Request:   profile.Settings{},
+		Response:  profile.Settings{}}
 
 	Map["POST:/name"] = &Route{
 		handler:   profile.AddName,
-		AuthLevel: 1}
+		AuthLevel: 1,
+		Summary:   "Create the user's encrypted name",
+		Request:   profile.Name{},
+		Response:  profile.MetaResponse{},
+		Status:    201}
 	Map["GET:/name"] = &Route{
 		handler:   profile.GetName,
-		AuthLevel: 1}
+		AuthLevel: 1,
+		Summary:   "Get the user's encrypted name",
+		Response:  profile.Name{},
+		ETag:      true}
 	Map["PATCH:/name"] = &Route{
 		handler:   profile.UpdateName,
-		AuthLevel: 1}
+		AuthLevel: 1,
+		Summary:   "Update the user's encrypted name",
+		Request:   profile.NamePatch{},
+		Response:  profile.MetaResponse{},
+		ETag:      true}
 	Map["DELETE:/name"] = &Route{
 		handler:   profile.DeleteName,
-		AuthLevel: 1}
+		AuthLevel: 1,
+		Summary:   "Delete the user's name"}
 
 	Map["POST:/todos"] = &Route{
 		handler:   todo.AddTodo,
-		AuthLevel: 1}
+		AuthLevel: 1,
+		Summary:   "Create a todo list",
+		Request:   todo.List{},
+		Response:  todo.Response{},
+		Status:    201}
 	Map["GET:/todos"] = &Route{
 		handler:   todo.GetTodos,
-		AuthLevel: 1}
+		AuthLevel: 1,
+		Summary:   "List todo lists, sorted by index",
+		Response:  []todo.List{},
+		Query:     pagination(),
+		ETag:      true}
+	Map["PUT:/todos/order"] = &Route{
+		handler:   todo.ReorderTodos,
+		AuthLevel: 1,
+		Summary:   "Replace the order of every todo list in a single transaction",
+		Request:   todo.Order{},
+		Response:  []todo.Response{}}
 	Map["GET:/todos/{id}"] = &Route{
 		handler:   todo.GetTodo,
-		AuthLevel: 1}
+		AuthLevel: 1,
+		Summary:   "Get a todo list",
+		Response:  todo.List{},
+		ETag:      true}
 	Map["PATCH:/todos/{id}"] = &Route{
 		handler:   todo.UpdateTodo,
-		AuthLevel: 1}
+		AuthLevel: 1,
+		Summary:   "Update a todo list",
+		Request:   todo.Patch{},
+		Response:  todo.Response{},
+		ETag:      true}
 	Map["DELETE:/todos/{id}"] = &Route{
 		handler:   todo.DeleteTodo,
-		AuthLevel: 1}
+		AuthLevel: 1,
+		Summary:   "Move a todo list (along with its items) to the trash"}
+	Map["POST:/todos/{id}/items"] = &Route{
+		handler:   todo.AddItem,
+		AuthLevel: 1,
+		Summary:   "Add an item to the end of a todo list",
+		Request:   todo.Item{},
+		Response:  todo.ItemResponse{},
+		Status:    201}
+	Map["PATCH:/todos/{id}/items/{itemId}"] = &Route{
+		handler:   todo.UpdateItem,
+		AuthLevel: 1,
+		Summary:   "Update an item of a todo list",
+		Request:   todo.ItemPatch{},
+		Response:  todo.ItemResponse{},
+		ETag:      true}
+	Map["DELETE:/todos/{id}/items/{itemId}"] = &Route{
+		handler:   todo.DeleteItem,
+		AuthLevel: 1,
+		Summary:   "Delete an item of a todo list"}
+	Map["POST:/todos/{id}/items/{itemId}/move"] = &Route{
+		handler:   todo.MoveItem,
+		AuthLevel: 1,
+		Summary:   "Move an item of a todo list to a position in another list (or within its own)",
+		Request:   todo.Move{},
+		Response:  todo.MoveResponse{},
+		ETag:      true}
 
 	Map["POST:/tags"] = &Route{
 		handler:   tags.AddTag,
-		AuthLevel: 1}
+		AuthLevel: 1,
+		Summary:   "Create a tag",
+		Request:   tags.Tag{},
+		Response:  tags.Response{},
+		Status:    201}
 	Map["GET:/tags"] = &Route{
 		handler:   tags.GetTags,
-		AuthLevel: 1}
+		AuthLevel: 1,
+		Summary:   "List tags, sorted by ID",
+		Response:  []tags.Tag{},
+		Query:     pagination(),
+		ETag:      true}
 	Map["GET:/tags/{id}"] = &Route{
 		handler:   tags.GetTag,
-		AuthLevel: 1}
+		AuthLevel: 1,
+		Summary:   "Get a tag",
+		Response:  tags.Tag{},
+		ETag:      true}
 	Map["PATCH:/tags/{id}"] = &Route{
 		handler:   tags.UpdateTag,
-		AuthLevel: 1}
+		AuthLevel: 1,
+		Summary:   "Update a tag",
+		Request:   tags.Patch{},
+		Response:  tags.Response{},
+		ETag:      true}
 	Map["DELETE:/tags/{id}"] = &Route{
 		handler:   tags.DeleteTag,
-		AuthLevel: 1}
+		AuthLevel: 1,
+		Summary:   "Move a tag to the trash"}
+
+	Map["GET:/trash"] = &Route{
+		handler:   trash.GetTrash,
+		AuthLevel: 1,
+		Summary:   "List the todo lists and tags in the trash, most recently deleted first",
+		Response:  []trash.Entry{}}
+	Map["POST:/trash/{type}/{id}/restore"] = &Route{
+		handler:   trash.RestoreTrash,
+		AuthLevel: 1,
+		Summary:   "Restore a todo list (to its previous position) or tag from the trash",
+		Response:  trash.RestoreResponse{}}
+	Map["DELETE:/trash/{type}/{id}"] = &Route{
+		handler:   trash.PurgeTrash,
+		AuthLevel: 1,
+		Summary:   "Permanently delete a todo list or tag from the trash"}
+	Map["DELETE:/trash"] = &Route{
+		handler:   trash.EmptyTrash,
+		AuthLevel: 1,
+		Summary:   "Permanently delete everything in the trash"}
 
 	Map["POST:/nolist"] = &Route{
 		handler:   todo.CreateNoList,
-		AuthLevel: 1}
+		AuthLevel: 1,
+		Summary:   "Create the list of items not belonging to any todo list",
+		Request:   todo.NoList{},
+		Response:  core.StateResponse{},
+		Status:    201}
 	Map["PATCH:/nolist"] = &Route{
 		handler:   todo.UpdateNoList,
-		AuthLevel: 1}
+		AuthLevel: 1,
+		Summary:   "Update the list of items not belonging to any todo list",
+		Request:   todo.NoListPatch{},
+		Response:  core.StateResponse{},
+		ETag:      true}
 	Map["GET:/nolist"] = &Route{
 		handler:   todo.GetNoList,
-		AuthLevel: 1}
+		AuthLevel: 1,
+		Summary:   "Get the list of items not belonging to any todo list",
+		Response:  todo.NoList{},
+		ETag:      true}
+	Map["POST:/nolist/items"] = &Route{
+		handler:   todo.AddItem,
+		AuthLevel: 1,
+		Summary:   "Add an item to the end of the list of items not belonging to any todo list",
+		Request:   todo.Item{},
+		Response:  todo.ItemResponse{},
+		Status:    201}
+	Map["PATCH:/nolist/items/{itemId}"] = &Route{
+		handler:   todo.UpdateItem,
+		AuthLevel: 1,
+		Summary:   "Update an item not belonging to any todo list",
+		Request:   todo.ItemPatch{},
+		Response:  todo.ItemResponse{},
+		ETag:      true}
+	Map["DELETE:/nolist/items/{itemId}"] = &Route{
+		handler:   todo.DeleteItem,
+		AuthLevel: 1,
+		Summary:   "Delete an item not belonging to any todo list"}
+	Map["POST:/nolist/items/{itemId}/move"] = &Route{
+		handler:   todo.MoveItem,
+		AuthLevel: 1,
+		Summary:   "Move an item not belonging to any todo list to a position in a todo list (or within the nolist collection)",
+		Request:   todo.Move{},
+		Response:  todo.MoveResponse{},
+		ETag:      true}
+	Map["GET:/sync"] = &Route{
+		handler:   changes.GetSync,
+		AuthLevel: 1,
+		Summary:   "Get every change to the user's resources since a cursor, or all of them if no cursor is sent",
+		Response:  changes.SyncResponse{},
+		Query: []openapi.Parameter{{
+			Name:        "cursor",
+			In:          "query",
+			Description: "Cursor returned by the last sync",
+			Schema: &openapi.Schema{
+				Type:   "integer",
+				Format: "int64"}}, {
+			Name:        "limit",
+			In:          "query",
+			Description: "Maximum number of changes to return (1-500, defaults to 100)",
+			Schema: &openapi.Schema{
+				Type:   "integer",
+				Format: "int32"}}}}
+	Map["GET:/events"] = &Route{
+		handler:     changes.GetEvents,
+		AuthLevel:   1,
+		QueryCSRF:   true,
+		Summary:     "Stream a notification of each change to the user's resources, resuming after Last-Event-ID",
+		Response:    changes.Event{},
+		ContentType: "text/event-stream",
+		Query: []openapi.Parameter{{
+			Name:        "csrf",
+			In:          "query",
+			Description: "CSRF token, for clients that can't send the CSRF-Token header",
+			Schema: &openapi.Schema{
+				Type: "string"}}, {
+			Name:        "Last-Event-ID",
+			In:          "header",
+			Description: "ID of the last event received (or a sync cursor), changes after it are sent before any new ones",
+			Schema: &openapi.Schema{
+				Type: "string"}}}}
+	Map["POST:/sync"] = &Route{
+		handler:     changes.Push,
+		AuthLevel:   1,
+		MaxBodySize: bulkBodySize,
+		Summary:     "Apply changes made while offline, reporting any that conflict",
+		Request:     changes.PushRequest{},
+		Response:    changes.PushResponse{}}
+	Map["POST:/batch"] = &Route{
+		handler:     Batch,
+		AuthLevel:   1,
+		MaxBodySize: bulkBodySize,
+		Summary:     "Run several operations in a single transaction, committing them only if every operation succeeds",
+		Request:     BatchRequest{},
+		Response:    BatchResponse{}}
+
+	for key, route := range Map {
+		route.key = key
+	}
+	loadMatcher()
+}
+
+// matcher - Matches requests to routes (for batch operations and preflight requests), built from Map by loadRoutes
+var matcher *mux.Router
+
+// methods - Every method used by a route in Map, sorted
+var methods []string
+
+// loadMatcher - Register every route in Map with matcher, naming each route by its key
+func loadMatcher() {
+	matcher = mux.NewRouter()
+	used := make(map[string]bool)
+	for key := range Map {
+		slice := strings.SplitN(key, ":", 2)
+		matcher.NewRoute().Methods(slice[0]).Path(slice[1]).Name(key)
+		if !used[slice[0]] {
+			used[slice[0]] = true
+			methods = append(methods, slice[0])
+		}
+	}
+	sort.Strings(methods)
 }
 
 // CatchAll - Add a catchall route for otherwise unmatched routes
 func CatchAll(w http.ResponseWriter, _ *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	core.WriteError(w, core.HTTPError{
+		Code:    core.CodeRouteNotFound,
 		Title:   "Not Found",
 		Message: "The requested route could not be found",
 		Status:  404})
 }
 
-var resources = [2]string{"todos", "categories"}
-var methods = [4]string{"GET", "POST", "PATCH", "DELETE"}
-var allowedOrigins = [2]string{"http://localhost:3030", "https://csplan.co"}
+// allowedMethods - Return the methods that preflight requests for path can request, derived from the routes matching it
+// Routes that require greater than normal user auth aren't accessible through preflight requests
+func allowedMethods(path string) []string {
+	var allowed []string
+	for _, method := range methods {
+		req := &http.Request{
+			Method: method,
+			URL: &url.URL{
+				Path: path}}
+		var match mux.RouteMatch
+		if matcher.Match(req, &match) && Map[match.Route.GetName()].AuthLevel <= 1 {
+			allowed = append(allowed, method)
+		}
+	}
+	return allowed
+}
 
 // Preflight - Respond to preflight requests
 func Preflight(w http.ResponseWriter, r *http.Request) {
-	reqMethod := r.Header.Get("Access-Control-Request-Method")
-	reqMethodSupported := false
-	var supportedMethods []string
+	supportedMethods := allowedMethods(r.URL.Path)
+	if len(supportedMethods) == 0 {
+		CatchAll(w, r)
+		return
+	}
 
-	for _, method := range methods { // Don't allow access to OPTIONS requests for routes that require greater than normal user auth
-		if Map[fmt.Sprintf("%s:%s", method, r.URL.Path)] == nil || Map[fmt.Sprintf("%s:%s", method, r.URL.Path)].AuthLevel > 1 {
-			continue
-		}
-		supportedMethods = append(supportedMethods, method)
+	// If the requested method is supported send a 200 response, otherwise send a 405 (method not allowed)
+	reqMethod := r.Header.Get("Access-Control-Request-Method")
+	reqMethodSupported := len(reqMethod) == 0 // Allow OPTIONS requests without a specified method
+	for _, method := range supportedMethods {
 		if method == reqMethod {
 			reqMethodSupported = true
 		}
 	}
-
-	// If the requested method is supported send a 200 response, otherwise send a 405 (method not allowed)
-	if len(supportedMethods) > 0 {
-		w.Header().Set("Access-Control-Allow-Methods", strings.Join(supportedMethods, ","))
-		if reqMethodSupported || len(reqMethod) == 0 { // Allow OPTIONS requests without a specified emthod
-			origin := r.Header.Get("Origin")
-			for _, allowed := range allowedOrigins {
-				if allowed == origin {
-					w.Header().Set("Access-Control-Allow-Origin", origin)
-					w.Header().Set("Access-Control-Allow-Headers", "Content-Type, CSRF-Token")
-					break
-				}
-			}
-			w.WriteHeader(200)
-		} else {
-			w.WriteHeader(405)
-		}
+	w.Header().Set("Access-Control-Allow-Methods", strings.Join(supportedMethods, ","))
+	if !reqMethodSupported {
+		w.WriteHeader(405)
 		return
 	}
-
-	// If matching a route by exact path has failed, try matching it by resource identifier
-	for _, resource := range resources {
-		// Validate whether the URL path is requesting the resource
-		if !strings.HasPrefix(r.URL.Path, fmt.Sprintf("/%s/", resource)) {
-			continue
-		}
-
-		// Match all supported methods for the resource
-		for _, method := range methods {
-			if Map[fmt.Sprintf("%s:/%s/{id}", method, resource)] != nil {
-				supportedMethods = append(supportedMethods, method)
-				if method == reqMethod {
-					reqMethodSupported = true
-				}
-			}
-		}
-		w.Header().Set("Access-Control-Allow-Methods", strings.Join(supportedMethods, ","))
-
-		if reqMethodSupported || len(reqMethod) == 0 {
-			origin := r.Header.Get("Origin")
-			for _, allowed := range allowedOrigins {
-				if allowed == origin {
-					w.Header().Set("Access-Control-Allow-Origin", origin)
-					w.Header().Set("Access-Control-Allow-Headers", "Content-Type, CSRF-Token")
-					break
-				}
-			}
-			w.WriteHeader(200)
-		} else {
-			w.WriteHeader(405)
-		}
-		return // Don't go on to the catchall handler
+	if origin := r.Header.Get("Origin"); config.Current().CORS.Allows(origin) {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Set("Access-Control-Allow-Headers", config.CORSAllowHeaders)
 	}
-	// If all of the above has failed, send a 404
-	CatchAll(w, r)
+	w.WriteHeader(200)
 }
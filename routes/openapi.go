@@ -0,0 +1,165 @@
+package routes
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/very-amused/CSplan-API/core"
+	"github.com/very-amused/CSplan-API/openapi"
+)
+
+// Version - Version of the API reported in the OpenAPI document, set at build time with
+// -ldflags "-X github.com/very-amused/CSplan-API/routes.Version=..."
+var Version = "dev"
+
+// Matches {param} segments in route paths
+var pathParamRegex = regexp.MustCompile(`{(\w+)}`)
+
+// Spec - Generate an OpenAPI document describing every route in Map
+func Spec() *openapi.Document {
+	doc := openapi.New(openapi.Info{
+		Title:       "CSplan API",
+		Description: "Zero-access encrypted planning API. Resource contents are encrypted clientside and sent as base64.",
+		Version:     Version})
+	doc.Components.SecuritySchemes = map[string]*openapi.SecurityScheme{
+		"session": {
+			Type:        "apiKey",
+			Name:        "Authorization",
+			In:          "cookie",
+			Description: "HttpOnly session token, set by submitting an authentication challenge"},
+		"csrf": {
+			Type:        "apiKey",
+			Name:        "CSRF-Token",
+			In:          "header",
+			Description: "CSRF token returned alongside the session token"}}
+	errorSchema := doc.SchemaOf(core.HTTPError{})
+	// Enumerate error codes so that clients can generate a type for them
+	codes := doc.Components.Schemas[openapi.SchemaName(reflect.TypeOf(core.HTTPError{}))].Properties["code"]
+	for _, info := range core.ErrorCatalog {
+		codes.Enum = append(codes.Enum, info.Code)
+	}
+
+	// Sort keys so that the generated document (and operation order within it) is stable
+	keys := make([]string, 0, len(Map))
+	for key := range Map {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		route := Map[key]
+		slice := strings.SplitN(key, ":", 2)
+		method, path := slice[0], slice[1]
+
+		op := &openapi.Operation{
+			OperationID: operationID(method, path),
+			Summary:     route.Summary,
+			Tags:        []string{strings.Split(strings.TrimPrefix(path, "/"), "/")[0]},
+			Responses:   make(map[string]*openapi.Response),
+			// An empty list explicitly marks routes that don't require authentication
+			Security: []map[string][]string{}}
+		if route.AuthLevel > 0 {
+			op.Security = append(op.Security, map[string][]string{
+				"session": {},
+				"csrf":    {}})
+		}
+		for _, match := range pathParamRegex.FindAllStringSubmatch(path, -1) {
+			op.Parameters = append(op.Parameters, openapi.Parameter{
+				Name:     match[1],
+				In:       "path",
+				Required: true,
+				Schema: &openapi.Schema{
+					Type: "string"}})
+		}
+		op.Parameters = append(op.Parameters, route.Query...)
+
+		if route.Request != nil {
+			op.RequestBody = &openapi.RequestBody{
+				Required: true,
+				Content: map[string]openapi.MediaType{
+					"application/json": {Schema: doc.SchemaOf(route.Request)}}}
+		}
+		success := &openapi.Response{
+			Description: http.StatusText(route.status())}
+		if route.Response != nil {
+			contentType := route.ContentType
+			if len(contentType) == 0 {
+				contentType = "application/json"
+			}
+			success.Content = map[string]openapi.MediaType{
+				contentType: {Schema: doc.SchemaOf(route.Response)}}
+		}
+		if route.ETag {
+			success.Headers = map[string]*openapi.Header{
+				"ETag": {
+					Description: "The resource's checksum (or a checksum of every member for collections), as a strong entity tag",
+					Schema: &openapi.Schema{
+						Type: "string"}}}
+			switch method {
+			case "GET":
+				op.Parameters = append(op.Parameters, openapi.Parameter{
+					Name:        "If-None-Match",
+					In:          "header",
+					Description: "ETag of the cached copy, a 304 is sent without a body if it's current",
+					Schema: &openapi.Schema{
+						Type: "string"}})
+				op.Responses["304"] = &openapi.Response{
+					Description: "The cached copy is current"}
+			case "PATCH":
+				op.Parameters = append(op.Parameters, openapi.Parameter{
+					Name:        "If-Match",
+					In:          "header",
+					Description: "Only apply the patch if the resource's ETag matches, otherwise a 412 precondition_failed problem with the current checksum is sent",
+					Schema: &openapi.Schema{
+						Type: "string"}})
+			}
+		}
+		op.Responses[strconv.Itoa(route.status())] = success
+		op.Responses["default"] = &openapi.Response{
+			Description: "Error (see GET /errors for every error code)",
+			Content: map[string]openapi.MediaType{
+				"application/problem+json": {Schema: errorSchema}}}
+
+		// OpenAPI paths use the same {param} syntax as mux
+		if doc.Paths[path] == nil {
+			doc.Paths[path] = &openapi.PathItem{}
+		}
+		(*doc.Paths[path])[strings.ToLower(method)] = op
+	}
+	return doc
+}
+
+// operationID - Derive a stable operation ID from a route, e.g. PATCH:/todos/{id} becomes patchTodosById
+func operationID(method, path string) string {
+	var id strings.Builder
+	id.WriteString(strings.ToLower(method))
+	for _, segment := range strings.FieldsFunc(path, func(r rune) bool {
+		return r == '/' || r == '_' || r == '.'
+	}) {
+		if match := pathParamRegex.FindStringSubmatch(segment); match != nil {
+			id.WriteString("By")
+			segment = match[1]
+		}
+		id.WriteString(strings.ToUpper(segment[:1]) + segment[1:])
+	}
+	return id.String()
+}
+
+var (
+	specOnce sync.Once
+	spec     []byte
+)
+
+// GetSpec - Serve the OpenAPI document, which is generated once (routes can't change after being loaded)
+func GetSpec(_ context.Context, w http.ResponseWriter, _ *http.Request) {
+	specOnce.Do(func() {
+		spec, _ = json.Marshal(Spec())
+	})
+	w.Write(spec)
+}
@@ -41,38 +41,66 @@ type State struct {
 	Checksum string `json:"checksum"`
 }
 
+// NameRecord - Convert a name to the record stored for user
+func NameRecord(user uint, name Name) core.NameRecord {
+	return core.NameRecord{
+		UserID:    user,
+		FirstName: core.FromBase64(name.FirstName),
+		LastName:  core.FromBase64(name.LastName),
+		Username:  core.FromBase64(name.Username),
+		CryptoKey: core.FromBase64(name.Meta.CryptoKey)}
+}
+
+// NameFromRecord - Convert a stored name to its API representation
+func NameFromRecord(record core.NameRecord) Name {
+	return Name{
+		FirstName: core.ToBase64(record.FirstName),
+		LastName:  core.ToBase64(record.LastName),
+		Username:  core.ToBase64(record.Username),
+		Meta: core.Meta{
+			CryptoKey: core.ToBase64(record.CryptoKey),
+			Checksum:  record.Checksum()}}
+}
+
+// PatchName - Update only the fields of a stored name that aren't empty in patch
+func PatchName(record core.NameRecord, patch NamePatch) core.NameRecord {
+	if len(patch.FirstName) > 0 {
+		record.FirstName = core.FromBase64(patch.FirstName)
+	}
+	if len(patch.LastName) > 0 {
+		record.LastName = core.FromBase64(patch.LastName)
+	}
+	if len(patch.Username) > 0 {
+		record.Username = core.FromBase64(patch.Username)
+	}
+	if len(patch.Meta.CryptoKey) > 0 {
+		record.CryptoKey = core.FromBase64(patch.Meta.CryptoKey)
+	}
+	return record
+}
+
 // AddName - Add a user's name
 func AddName(ctx context.Context, w http.ResponseWriter, r *http.Request) {
 	var name Name
-	json.NewDecoder(r.Body).Decode(&name)
-	if err := core.ValidateStruct(name); err != nil {
+	if err := core.DecodeJSON(r, &name); err != nil {
 		core.WriteError(w, *err)
 		return
 	}
 	user := ctx.Value(core.Key("user")).(uint)
+	store := core.StoreFrom(ctx)
 
 	// Existence check
-	var exists int
-	err := core.DB.Get(&exists, "SELECT 1 FROM Names WHERE UserID = ?", user)
-	if err == nil {
+	if _, err := store.GetName(user); err == nil {
 		core.WriteError(w, core.HTTPError{
+			Code:    core.CodeConflict,
 			Title:   "Resource Conflict",
 			Message: "Name already created for this user. PATCH:/name for updates",
 			Status:  409})
 		return
 	}
 
-	_, err = core.DB.Exec("INSERT INTO Names (UserID, FirstName, LastName, Username, CryptoKey) VALUES (?, FROM_BASE64(?), FROM_BASE64(?), FROM_BASE64(?), FROM_BASE64(?))",
-		user, name.FirstName, name.LastName, name.Username, name.Meta.CryptoKey)
-	if err != nil {
-		core.WriteError500(w, err)
-		return
-	}
-
-	// Select checksum for encrypted fields
-	var Checksum string
-	err = core.DB.Get(&Checksum, "SELECT SHA(CONCAT(FirstName, LastName, Username)) AS Checksum FROM Names WHERE UserID = ?", user)
-	if err != nil {
+	record := NameRecord(user, name)
+	if err := store.CreateName(record); err != nil {
 		core.WriteError500(w, err)
 		return
 	}
@@ -80,17 +108,18 @@ func AddName(ctx context.Context, w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(201)
 	json.NewEncoder(w).Encode(MetaResponse{
 		Meta: State{
-			Checksum}})
+			record.Checksum()}})
 }
 
 // GetName - Retrieve a user's name
 func GetName(ctx context.Context, w http.ResponseWriter, r *http.Request) {
 	user := ctx.Value(core.Key("user")).(uint)
+	store := core.StoreFrom(ctx)
 
-	var name Name
-	err := core.DB.Get(&name, "SELECT TO_BASE64(FirstName) AS FirstName, TO_BASE64(LastName) AS LastName, TO_BASE64(Username) AS Username FROM Names WHERE UserID = ?", user)
+	record, err := store.GetName(user)
 	if err != nil {
 		core.WriteError(w, core.HTTPError{
+			Code:    core.CodeNotFound,
 			Title:   "Not Found",
 			Message: "The requested name was not found",
 			Status:  404})
@@ -98,81 +127,73 @@ func GetName(ctx context.Context, w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Refuse to return a resource without its associated cryptokey
-	err = core.DB.Get(&name.Meta, "SELECT TO_BASE64(CryptoKey) AS CryptoKey, SHA(CONCAT(FirstName, LastName, Username)) AS Checksum FROM Names WHERE UserID = ?", user)
-	if err != nil || len(name.Meta.CryptoKey) == 0 {
-		_, err = core.DB.Exec("DELETE FROM Names WHERE UserID = ?", user)
-		if err != nil {
+	if len(record.CryptoKey) == 0 {
+		if err = store.DeleteName(user); err != nil {
 			core.WriteError500(w, err)
+			return
 		}
 		core.WriteError(w, core.HTTPError{
+			Code:    core.CodeNotFound,
 			Title:   "Not Found",
 			Message: "The requested name was not found",
 			Status:  404})
 		return
 	}
 
-	json.NewEncoder(w).Encode(name)
+	if core.NotModified(w, r, record.Checksum()) {
+		return
+	}
+	json.NewEncoder(w).Encode(NameFromRecord(record))
 }
 
 // UpdateName - Update a user's name
 func UpdateName(ctx context.Context, w http.ResponseWriter, r *http.Request) {
 	var patch NamePatch
-	json.NewDecoder(r.Body).Decode(&patch)
-	if err := core.ValidateStruct(patch); err != nil {
+	if err := core.DecodeJSON(r, &patch); err != nil {
 		core.WriteError(w, *err)
 		return
 	}
 
 	user := ctx.Value(core.Key("user")).(uint)
-	// Existence check
-	var exists int
-	err := core.DB.Get(&exists, "SELECT 1 FROM Names WHERE UserID = ?", user)
-	if err != nil {
-		core.WriteError404(w)
-		return
-	}
-
-	// Only patch the fields that aren't empty
-	errs := make([]error, 4)
-	if len(patch.FirstName) > 0 {
-		_, err = core.DB.Exec("UPDATE Names SET FirstName = FROM_BASE64(?) WHERE UserID = ?", patch.FirstName, user)
-		errs = append(errs, err)
-	}
-	if len(patch.LastName) > 0 {
-		_, err = core.DB.Exec("UPDATE Names SET LastName = FROM_BASE64(?) WHERE UserID = ?", patch.LastName, user)
-		errs = append(errs, err)
-	}
-	if len(patch.Username) > 0 {
-		_, err = core.DB.Exec("UPDATE Names SET Username = FROM_BASE64(?) WHERE UserID = ?", patch.Username, user)
-		errs = append(errs, err)
-	}
-	if len(patch.Meta.CryptoKey) > 0 {
-		_, err = core.DB.Exec("UPDATE Names SET CryptoKey = FROM_BASE64(?) WHERE UserID = ?", patch.Meta.CryptoKey, user)
-		errs = append(errs, err)
-	}
-	for _, err = range errs {
-		if err != nil {
-			core.WriteError500(w, err)
-			return
+	var record core.NameRecord
+	err := core.StoreFrom(ctx).Atomic(func(store core.Store) (e error) {
+		// Existence check
+		record, e = store.GetName(user)
+		if e != nil {
+			return e
+		}
+		// Refuse to overwrite changes the client hasn't seen
+		if e = core.IfMatch(r, record.Checksum()); e != nil {
+			return e
 		}
-	}
 
-	var Checksum string
-	err = core.DB.Get(&Checksum, "SELECT SHA(CONCAT(FirstName, LastName, Username)) AS Checksum FROM Names WHERE UserID = ?", user)
-	if err != nil {
+		record = PatchName(record, patch)
+		return store.UpdateName(record)
+	})
+	if err == core.ErrNotFound {
+		core.WriteError404(w)
+		return
+	} else if httpErr, ok := err.(core.HTTPError); ok {
+		core.WriteError(w, httpErr)
+		return
+	} else if err != nil {
 		core.WriteError500(w, err)
 		return
 	}
 
+	w.Header().Set("ETag", core.ETag(record.Checksum()))
 	json.NewEncoder(w).Encode(MetaResponse{
 		Meta: State{
-			Checksum}})
+			record.Checksum()}})
 }
 
 // DeleteName - Delete a user's name
 func DeleteName(ctx context.Context, w http.ResponseWriter, r *http.Request) {
 	user := ctx.Value(core.Key("user")).(uint)
 
-	core.DB.Exec("DELETE FROM Names WHERE UserID = ?", user)
+	if err := core.StoreFrom(ctx).DeleteName(user); err != nil {
+		core.WriteError500(w, err)
+		return
+	}
 	w.WriteHeader(204)
 }
@@ -15,26 +15,38 @@ type Settings struct {
 	EnableReminders *bool `json:"enableReminders"`
 }
 
-func (settings *Settings) get() error {
-	err := core.DB.Get(settings, "SELECT EnableIPLogging, EnableReminders FROM Settings WHERE UserID = ?", settings.UserID)
-	return err
+func (settings *Settings) get(store core.Store) error {
+	record, err := store.GetSettings(settings.UserID)
+	if err != nil {
+		return err
+	}
+	settings.EnableIPLogging = &record.EnableIPLogging
+	settings.EnableReminders = &record.EnableReminders
+	return nil
 }
 
-func (settings Settings) update() {
-	// Check if pointers are not nil to avoid letting go's typesafety cause unwanted changes
-	if settings.EnableIPLogging != nil {
-		core.DB.Exec("UPDATE Settings SET EnableIPLogging = ?", settings.EnableIPLogging)
-	}
-	if settings.EnableReminders != nil {
-		core.DB.Exec("UPDATE Settings SET EnableReminders = ?", settings.EnableReminders)
-	}
+func (settings Settings) update(store core.Store) error {
+	return store.Atomic(func(store core.Store) error {
+		record, err := store.GetSettings(settings.UserID)
+		if err != nil {
+			return err
+		}
+		// Check if pointers are not nil to avoid letting go's typesafety cause unwanted changes
+		if settings.EnableIPLogging != nil {
+			record.EnableIPLogging = *settings.EnableIPLogging
+		}
+		if settings.EnableReminders != nil {
+			record.EnableReminders = *settings.EnableReminders
+		}
+		return store.UpdateSettings(record)
+	})
 }
 
 // GetSettings - Retrieve a user's privacy settings
 func GetSettings(ctx context.Context, w http.ResponseWriter, r *http.Request) {
 	var settings Settings
 	settings.UserID = ctx.Value(core.Key("user")).(uint)
-	if err := settings.get(); err != nil {
+	if err := settings.get(core.StoreFrom(ctx)); err != nil {
 		core.WriteError500(w, err)
 		return
 	}
@@ -46,10 +58,17 @@ func UpdateSettings(ctx context.Context, w http.ResponseWriter, r *http.Request)
 	var settings Settings
 	settings.UserID = ctx.Value(core.Key("user")).(uint)
 	// Apply any settings decoded from the body
-	json.NewDecoder(r.Body).Decode(&settings)
-	settings.update()
+	if err := core.DecodeJSON(r, &settings); err != nil {
+		core.WriteError(w, *err)
+		return
+	}
+	store := core.StoreFrom(ctx)
+	if err := settings.update(store); err != nil {
+		core.WriteError500(w, err)
+		return
+	}
 	// Fill in the rest of the struct for encoding purposes
-	if err := settings.get(); err != nil {
+	if err := settings.get(store); err != nil {
 		core.WriteError500(w, err)
 		return
 	}
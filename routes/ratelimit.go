@@ -0,0 +1,68 @@
+package routes
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/very-amused/CSplan-API/config"
+	"github.com/very-amused/CSplan-API/core"
+	"github.com/very-amused/CSplan-API/ratelimit"
+)
+
+// allow - Take a token from the request's rate limit bucket, writing a 429 response and returning false if the bucket is empty
+// Policies are read on every request so that they can be changed by reloading the config
+func (route Route) allow(w http.ResponseWriter, r *http.Request) bool {
+	c := config.Current().RateLimit
+	return take(w, r, c.Enabled, route.key, c.PolicyFor(route.key))
+}
+
+// allowAuthentication - Take a token from the IP's bucket for authentication attempts, writing a 429 response and returning false if the bucket is empty
+// This bucket is shared by every authenticated route, and is checked before credentials so that invalid credentials can't be flooded
+func allowAuthentication(w http.ResponseWriter, r *http.Request) bool {
+	c := config.Current().RateLimit
+	return take(w, r, c.Enabled, "authenticate", c.Authenticate)
+}
+
+// take - Take a token from the bucket of the request for a policy, setting RateLimit headers
+func take(w http.ResponseWriter, r *http.Request, enabled bool, key string, rawPolicy string) bool {
+	limiter := ratelimit.LimiterFrom(r.Context())
+	if !enabled || limiter == nil {
+		return true
+	}
+	// Policies are validated when the config is loaded
+	policy, err := ratelimit.ParsePolicy(rawPolicy)
+	if err != nil {
+		return true
+	}
+
+	info := core.RequestInfoFrom(r.Context())
+	result, err := limiter.Take(policy.BucketKey(key, core.ClientIP(r), info.UserID), policy, time.Now())
+	if err != nil {
+		// Fail open, an unavailable backend mustn't take the API down with it
+		info.Logger.Warnf("Rate limiting failed: %s", err)
+		return true
+	}
+
+	header := w.Header()
+	header.Set("RateLimit-Limit", strconv.Itoa(policy.Requests))
+	header.Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	header.Set("RateLimit-Reset", ceilSeconds(result.Reset))
+	header.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%s", policy.Requests, ceilSeconds(policy.Period)))
+	if result.Allowed {
+		return true
+	}
+	header.Set("Retry-After", ceilSeconds(result.RetryAfter))
+	core.WriteError(w, core.HTTPError{
+		Code:    core.CodeRateLimited,
+		Title:   "Too Many Requests",
+		Message: fmt.Sprintf("Rate limit exceeded, retry in %s seconds", ceilSeconds(result.RetryAfter)),
+		Status:  429})
+	return false
+}
+
+func ceilSeconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
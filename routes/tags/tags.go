@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"net/http"
+	"time"
 
 	"github.com/gorilla/mux"
 	. "github.com/very-amused/CSplan-API/core"
@@ -33,114 +34,150 @@ type Patch struct {
 	Meta      *MetaPatch `json:"meta,omitempty"`
 }
 
+// Insert - Store a new tag for user, returning the stored record
+func Insert(store Store, user uint, tag Tag) (record TagRecord, e error) {
+	e = store.Atomic(func(store Store) error {
+		// Generate a unique ID
+		id, e := MakeUniqueID(store, "Tags")
+		if e != nil {
+			return e
+		}
+
+		record = TagRecord{
+			ID:        id,
+			UserID:    user,
+			Name:      FromBase64(tag.Name),
+			Color:     FromBase64(tag.Color),
+			CryptoKey: FromBase64(tag.Meta.CryptoKey)}
+		return store.CreateTag(record)
+	})
+	return record, e
+}
+
+// ApplyPatch - Update only the fields of a stored tag that are specified in patch
+func ApplyPatch(record TagRecord, patch Patch) TagRecord {
+	if patch.Name != nil {
+		record.Name = FromBase64(*patch.Name)
+	}
+	if patch.Color != nil {
+		record.Color = FromBase64(*patch.Color)
+	}
+	if patch.Meta != nil && patch.Meta.CryptoKey != nil {
+		record.CryptoKey = FromBase64(*patch.Meta.CryptoKey)
+	}
+	return record
+}
+
 // AddTag - Create a new tag
 func AddTag(ctx context.Context, w http.ResponseWriter, r *http.Request) {
 	user := ctx.Value(Key("user")).(uint)
+	store := StoreFrom(ctx)
 	var tag Tag
-	json.NewDecoder(r.Body).Decode(&tag)
-	if err := ValidateStruct(tag); err != nil {
+	if err := DecodeJSON(r, &tag); err != nil {
 		WriteError(w, *err)
 		return
 	}
 
-	tag.ID = MakeID()
-	// Validate tag ID's uniqueness
-	for {
-		rows, err := DB.Query("SELECT 1 FROM Tags WHERE ID = ?", tag.ID)
-		defer rows.Close()
-		if !rows.Next() {
-			break
-		} else {
-			if err != nil {
-				WriteError500(w, err)
-				return
-			}
-			tag.ID++
-		}
-	}
-
-	// Add to db
-	_, err := DB.Exec("INSERT INTO Tags (ID, UserID, Name, Color, CryptoKey) VALUES (?, ?, FROM_BASE64(?), FROM_BASE64(?), FROM_BASE64(?))", tag.ID, user, tag.Name, tag.Color, tag.Meta.CryptoKey)
+	record, err := Insert(store, user, tag)
 	if err != nil {
 		WriteError500(w, err)
 		return
 	}
 
-	// Get tag checksum
-	var checksum string
-	DB.Get(&checksum, "SELECT SHA(CONCAT(Name, Color, CryptoKey)) FROM Tags WHERE ID = ?", tag.ID)
-
 	w.WriteHeader(201)
 	json.NewEncoder(w).Encode(Response{
-		EncodedID: EncodeID(tag.ID),
+		EncodedID: EncodeID(record.ID),
 		Meta: State{
-			Checksum: checksum}})
+			Checksum: record.Checksum()}})
+}
+
+// FromRecord - Convert a stored tag to its API representation
+func FromRecord(record TagRecord) Tag {
+	return Tag{
+		ID:        record.ID,
+		EncodedID: EncodeID(record.ID),
+		Name:      ToBase64(record.Name),
+		Color:     ToBase64(record.Color),
+		Meta: Meta{
+			CryptoKey: ToBase64(record.CryptoKey),
+			Checksum:  record.Checksum()}}
 }
 
 // GetTags - Retrieve a list of all of a user's tags
 func GetTags(ctx context.Context, w http.ResponseWriter, r *http.Request) {
 	user := ctx.Value(Key("user")).(uint)
 	tags := make([]Tag, 0)
+	// Tags are paginated by ID
+	page, httpErr := ParsePage(r, 1)
+	if httpErr != nil {
+		WriteError(w, *httpErr)
+		return
+	}
 
-	rows, err := DB.Query("SELECT ID, TO_BASE64(Name) AS Name, TO_BASE64(Color) AS Color, TO_BASE64(CryptoKey) AS CryptoKey, SHA(CONCAT(Name, Color, CryptoKey)) FROM Tags WHERE UserID = ?", user)
-	defer rows.Close()
+	var records []TagRecord
+	var err error
+	if page.Paginated() {
+		// One extra tag is retrieved to find out whether there's a next page
+		records, err = StoreFrom(ctx).PageTags(user, uint(page.Key(0)), page.Limit+1)
+	} else {
+		records, err = StoreFrom(ctx).ListTags(user)
+	}
 	if err != nil {
 		WriteError500(w, err)
 		return
 	}
+	if page.Paginated() && len(records) > page.Limit {
+		records = records[:page.Limit]
+		page.SetNext(w, r, uint64(records[len(records)-1].ID))
+	}
 
-	// Create the tags list by iterating through the result set
-	for rows.Next() {
-		var tag Tag
-		err := rows.Scan(&tag.ID, &tag.Name, &tag.Color, &tag.Meta.CryptoKey, &tag.Meta.Checksum)
-		if err != nil {
-			WriteError500(w, err)
-			return
-		}
-		tag.EncodedID = EncodeID(tag.ID)
+	// Create the tags list by converting each record
+	members := make([][]string, 0, len(records))
+	for _, record := range records {
+		tag := FromRecord(record)
 		tags = append(tags, tag)
+		members = append(members, []string{tag.EncodedID, tag.Meta.Checksum})
 	}
 
+	if NotModified(w, r, CollectionChecksum(members)) {
+		return
+	}
 	json.NewEncoder(w).Encode(tags)
 }
 
 // GetTag - Retrieve a tag by ID
 func GetTag(ctx context.Context, w http.ResponseWriter, r *http.Request) {
 	user := ctx.Value(Key("user")).(uint)
-	var tag Tag
-	tag.EncodedID = mux.Vars(r)["id"]
-	var err error
-	tag.ID, err = DecodeID(tag.EncodedID)
+	id, err := DecodeID(mux.Vars(r)["id"])
 	if err != nil {
 		WriteError(w, HTTPError{
+			Code:    CodeMalformedID,
 			Title:   "Bad Request",
 			Message: "Malformed id param",
 			Status:  400})
+		return
 	}
 
-	rows, err := DB.Query("SELECT TO_BASE64(Name), TO_BASE64(Color), TO_BASE64(CryptoKey), SHA(CONCAT(Name, Color, CryptoKey)) FROM Tags WHERE UserID = ? AND ID = ?", user, tag.ID)
-	defer rows.Close()
-	if err != nil {
+	record, err := StoreFrom(ctx).GetTag(id, user)
+	if err == ErrNotFound {
+		WriteError404(w)
+		return
+	} else if err != nil {
 		WriteError500(w, err)
 		return
 	}
 
-	if rows.Next() {
-		rows.Scan(&tag.Name, &tag.Color, &tag.Meta.CryptoKey, &tag.Meta.Checksum)
-	} else {
-		WriteError404(w)
+	if NotModified(w, r, record.Checksum()) {
 		return
 	}
-
-	json.NewEncoder(w).Encode(tag)
+	json.NewEncoder(w).Encode(FromRecord(record))
 }
 
 // UpdateTag - Update a tag's name, color, and/or cryptokey
 func UpdateTag(ctx context.Context, w http.ResponseWriter, r *http.Request) {
 	user := ctx.Value(Key("user")).(uint)
 	var patch Patch
-	json.NewDecoder(r.Body).Decode(&patch)
-	if err := ValidateStruct(patch); err != nil {
+	if err := DecodeJSON(r, &patch); err != nil {
 		WriteError(w, *err)
 		return
 	}
@@ -148,68 +185,62 @@ func UpdateTag(ctx context.Context, w http.ResponseWriter, r *http.Request) {
 	id, err := DecodeID(mux.Vars(r)["id"])
 	if err != nil {
 		WriteError(w, HTTPError{
+			Code:    CodeMalformedID,
 			Title:   "Bad Request",
 			Message: "Malformed id param",
 			Status:  400})
 		return
 	}
 
-	// Check if the referenced tag exists
-	rows, err := DB.Query("SELECT 1 FROM Tags WHERE ID = ? AND UserID = ?", id, user)
-	defer rows.Close()
-	if !rows.Next() {
+	var record TagRecord
+	err = StoreFrom(ctx).Atomic(func(store Store) (e error) {
+		// Check if the referenced tag exists
+		record, e = store.GetTag(id, user)
+		if e != nil {
+			return e
+		}
+		// Refuse to overwrite changes the client hasn't seen
+		if e = IfMatch(r, record.Checksum()); e != nil {
+			return e
+		}
+
+		record = ApplyPatch(record, patch)
+		return store.UpdateTag(record)
+	})
+	if err == ErrNotFound {
 		WriteError404(w)
 		return
+	} else if httpErr, ok := err.(HTTPError); ok {
+		WriteError(w, httpErr)
+		return
 	} else if err != nil {
 		WriteError500(w, err)
 		return
 	}
 
-	// Update only the specified fields
-	if patch.Name != nil {
-		_, err = DB.Exec("UPDATE Tags SET Name = FROM_BASE64(?) WHERE ID = ?", patch.Name, id)
-		if err != nil {
-			WriteError500(w, err)
-			return
-		}
-	}
-	if patch.Color != nil {
-		_, err = DB.Exec("UPDATE Tags SET Color = FROM_BASE64(?) WHERE ID = ?", patch.Color, id)
-		if err != nil {
-			WriteError500(w, err)
-			return
-		}
-	}
-	if patch.Meta != nil && patch.Meta.CryptoKey != nil {
-		_, err = DB.Exec("UPDATE Tags SET CryptoKey = FROM_BASE64(?) WHERE ID = ?", *patch.Meta.CryptoKey, id)
-		if err != nil {
-			WriteError500(w, err)
-			return
-		}
-	}
-
-	// Select the new checksum
-	var checksum string
-	DB.Get(&checksum, "SELECT SHA(CONCAT(Name, Color, CryptoKey)) FROM Tags WHERE ID = ?", id)
-
+	w.Header().Set("ETag", ETag(record.Checksum()))
 	json.NewEncoder(w).Encode(Response{
 		EncodedID: EncodeID(id),
 		Meta: State{
-			Checksum: checksum}})
+			Checksum: record.Checksum()}})
 }
 
-// DeleteTag - Delete a tag by ID
+// DeleteTag - Move a tag to the trash by ID
 func DeleteTag(ctx context.Context, w http.ResponseWriter, r *http.Request) {
 	user := ctx.Value(Key("user")).(uint)
 	id, err := DecodeID(mux.Vars(r)["id"])
 	if err != nil {
 		WriteError(w, HTTPError{
+			Code:    CodeMalformedID,
 			Title:   "Bad Request",
 			Message: "Malformed id param",
 			Status:  400})
 		return
 	}
 
-	DB.Exec("DELETE FROM Tags WHERE ID = ? AND UserID = ?", id, user)
+	if err = StoreFrom(ctx).TrashTag(id, user, uint(time.Now().Unix())); err != nil && err != ErrNotFound {
+		WriteError500(w, err)
+		return
+	}
 	w.WriteHeader(204)
 }
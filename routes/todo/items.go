@@ -0,0 +1,485 @@
+package todo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	core "github.com/very-amused/CSplan-API/core"
+)
+
+var errMissingID = errors.New("missing ID")
+
+// Item - A singular todo item belonging to a parent List (or the nolist collection)
+type Item struct {
+	ID uint `json:"-"`
+	// EncodedID - Assigned when the item is created, items sent with the ID of an existing item replace it when a list's items are replaced
+	EncodedID   string   `json:"id,omitempty"`
+	Title       string   `json:"title" validate:"required,base64"`
+	Description string   `json:"description" validate:"required,base64"`
+	Done        string   `json:"done"` // This is in reality a boolean, but it is an encrypted one, so we store it as a string
+	Tags        []string `json:"tags" validate:"required,dive,base64"`
+	// Meta - Optional when sending an item, items without their own cryptoKey are encrypted with their list's key
+	Meta *ItemMeta `json:"meta,omitempty"`
+}
+
+// ItemMeta - Meta information about an item, which may have its own key
+type ItemMeta struct {
+	CryptoKey string `json:"cryptoKey,omitempty" validate:"omitempty,base64,max=700"`
+	Checksum  string `json:"checksum"`
+}
+
+// ItemPatch - Patch to update a single item
+type ItemPatch struct {
+	Title       *string         `json:"title,omitempty" validate:"omitempty,base64"`
+	Description *string         `json:"description,omitempty" validate:"omitempty,base64"`
+	Done        *string         `json:"done,omitempty"`
+	Tags        *[]string       `json:"tags,omitempty" validate:"omitempty,dive,base64"`
+	Meta        *core.MetaPatch `json:"meta,omitempty"`
+}
+
+// ItemResponse - Response to creation or update of an item
+type ItemResponse struct {
+	EncodedID string     `json:"id"`
+	Meta      core.State `json:"meta"`
+	// List - The new state of the list (or nolist collection) containing the item
+	List core.State `json:"list"`
+}
+
+// Move - Move of an item to a position in a list, or within its own list
+type Move struct {
+	// To - Encoded ID of the list to move the item to, omitted to move it to the nolist collection
+	To string `json:"to,omitempty"`
+	// Position - Position of the item among the destination's items once it's moved, positions past the end move it to the end
+	Position uint `json:"position"`
+}
+
+// MoveResponse - Response to the move of an item
+type MoveResponse struct {
+	EncodedID string     `json:"id"`
+	Meta      core.State `json:"meta"`
+	// From - The new state of the list the item was moved from
+	From core.State `json:"from"`
+	// To - The new state of the list the item was moved to (the same as From if it was moved within its list)
+	To core.State `json:"to"`
+}
+
+// encodeTags - JSON encode an item's tags, making sure that empty slices aren't encoded as null
+func encodeTags(tags []string) []byte {
+	if tags == nil {
+		tags = make([]string, 0)
+	}
+	encoded, _ := json.Marshal(tags)
+	return encoded
+}
+
+// ItemRecord - Convert an item to the record stored for user in a list (0 for the nolist collection), without assigning it an ID
+func ItemRecord(user, listID, position uint, item Item) core.TodoItemRecord {
+	record := core.TodoItemRecord{
+		UserID:      user,
+		ListID:      listID,
+		Position:    position,
+		Title:       core.FromBase64(item.Title),
+		Description: core.FromBase64(item.Description),
+		Done:        []byte(item.Done), // Not necessarily base64, so it's stored as sent
+		Tags:        encodeTags(item.Tags)}
+	if item.Meta != nil {
+		record.CryptoKey = core.FromBase64(item.Meta.CryptoKey)
+	}
+	return record
+}
+
+// ItemFromRecord - Convert a stored item to its API representation
+func ItemFromRecord(record core.TodoItemRecord) (item Item, e error) {
+	item = Item{
+		ID:          record.ID,
+		EncodedID:   core.EncodeID(record.ID),
+		Title:       core.ToBase64(record.Title),
+		Description: core.ToBase64(record.Description),
+		Done:        string(record.Done),
+		Meta: &ItemMeta{
+			CryptoKey: core.ToBase64(record.CryptoKey),
+			Checksum:  record.Checksum()}}
+	e = json.Unmarshal(record.Tags, &item.Tags)
+	return item, e
+}
+
+// itemsFromRecords - Convert stored items to their API representation, making sure that an empty list isn't encoded as null
+func itemsFromRecords(records []core.TodoItemRecord) ([]Item, error) {
+	items := make([]Item, len(records))
+	for i, record := range records {
+		item, e := ItemFromRecord(record)
+		if e != nil {
+			return nil, e
+		}
+		items[i] = item
+	}
+	return items, nil
+}
+
+// newItemRecords - Assign each item of a new list (0 for the nolist collection) an ID and its position
+func newItemRecords(store core.Store, user, listID uint, items []Item) ([]core.TodoItemRecord, error) {
+	records := make([]core.TodoItemRecord, len(items))
+	for i, item := range items {
+		records[i] = ItemRecord(user, listID, uint(i), item)
+		id, e := core.MakeUniqueID(store, "TodoItems")
+		if e != nil {
+			return nil, e
+		}
+		records[i].ID = id
+	}
+	return records, nil
+}
+
+// replaceItems - Replace the stored items of a list (0 for the nolist collection) with items, returning the new records
+// Items sent with the ID of an existing item are updated in place, other items are created, and existing items that weren't sent are deleted
+func replaceItems(store core.Store, user, listID uint, existing []core.TodoItemRecord, items []Item) ([]core.TodoItemRecord, error) {
+	byID := make(map[uint]core.TodoItemRecord, len(existing))
+	for _, record := range existing {
+		byID[record.ID] = record
+	}
+
+	records := make([]core.TodoItemRecord, len(items))
+	for i, item := range items {
+		record := ItemRecord(user, listID, uint(i), item)
+		id, err := core.DecodeID(item.EncodedID)
+		if old, ok := byID[id]; ok && err == nil && len(item.EncodedID) > 0 {
+			record.ID = id
+			// Items sent without meta keep their key
+			if item.Meta == nil {
+				record.CryptoKey = old.CryptoKey
+			}
+			delete(byID, id)
+			if e := store.UpdateTodoItem(record); e != nil {
+				return nil, e
+			}
+		} else {
+			if record.ID, err = core.MakeUniqueID(store, "TodoItems"); err != nil {
+				return nil, err
+			}
+			if e := store.CreateTodoItem(record); e != nil {
+				return nil, e
+			}
+		}
+		records[i] = record
+	}
+
+	for id := range byID {
+		if e := store.DeleteTodoItem(id, user); e != nil {
+			return nil, e
+		}
+	}
+	return records, nil
+}
+
+// parentID - Parse the ID of the list an item route refers to, routes for items of the nolist collection don't have one (0 is returned)
+func parentID(r *http.Request) (uint, error) {
+	encoded, ok := mux.Vars(r)["id"]
+	if !ok {
+		return 0, nil
+	}
+	id, e := core.DecodeID(encoded)
+	if e == nil && id == 0 {
+		e = errMissingID
+	}
+	return id, e
+}
+
+// getParent - Retrieve the items and checksum of a list (0 for the nolist collection)
+func getParent(store core.Store, user, listID uint) (items []core.TodoItemRecord, checksum string, e error) {
+	if listID == 0 {
+		nolist, e := store.GetNoList(user)
+		return nolist.Items, nolist.Checksum(), e
+	}
+	list, e := store.GetTodoList(listID, user)
+	return list.Items, list.Checksum(), e
+}
+
+// itemPath - Return the path of an item in a list (0 for the nolist collection)
+func itemPath(listID, id uint) string {
+	if listID == 0 {
+		return "/nolist/items/" + core.EncodeID(id)
+	}
+	return "/todos/" + core.EncodeID(listID) + "/items/" + core.EncodeID(id)
+}
+
+// parseItemRoute - Parse the list and item IDs of an item route, writing an error and returning false if either is malformed
+func parseItemRoute(w http.ResponseWriter, r *http.Request) (listID, id uint, ok bool) {
+	listID, err := parentID(r)
+	if err == nil {
+		id, err = core.DecodeID(mux.Vars(r)["itemId"])
+	}
+	if err != nil {
+		core.WriteError(w, core.HTTPError{
+			Code:    core.CodeMalformedID,
+			Title:   "Bad Request",
+			Message: "Malformed or missing ID param",
+			Status:  400})
+		return 0, 0, false
+	}
+	return listID, id, true
+}
+
+// AddItem - Add an item to the end of a todo list or the nolist collection
+func AddItem(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	user := ctx.Value(core.Key("user")).(uint)
+	listID, err := parentID(r)
+	if err != nil {
+		core.WriteError(w, core.HTTPError{
+			Code:    core.CodeMalformedID,
+			Title:   "Bad Request",
+			Message: "Malformed or missing ID param",
+			Status:  400})
+		return
+	}
+	var item Item
+	if err := core.DecodeJSON(r, &item); err != nil {
+		core.WriteError(w, *err)
+		return
+	}
+
+	var record core.TodoItemRecord
+	var checksum string
+	err = core.StoreFrom(ctx).Atomic(func(store core.Store) error {
+		items, _, e := getParent(store, user, listID)
+		if e != nil {
+			return e
+		}
+		var position uint
+		if len(items) > 0 {
+			position = items[len(items)-1].Position + 1
+		}
+		record = ItemRecord(user, listID, position, item)
+		if record.ID, e = core.MakeUniqueID(store, "TodoItems"); e != nil {
+			return e
+		}
+		if e = store.CreateTodoItem(record); e != nil {
+			return e
+		}
+		_, checksum, e = getParent(store, user, listID)
+		return e
+	})
+	if err == core.ErrNotFound {
+		core.WriteError404(w)
+		return
+	} else if err != nil {
+		core.WriteError500(w, err)
+		return
+	}
+
+	w.Header().Set("Location", itemPath(listID, record.ID))
+	w.WriteHeader(201)
+	json.NewEncoder(w).Encode(ItemResponse{
+		EncodedID: core.EncodeID(record.ID),
+		Meta: core.State{
+			Checksum: record.Checksum()},
+		List: core.State{
+			Checksum: checksum}})
+}
+
+// UpdateItem - Update a single item of a todo list or the nolist collection
+func UpdateItem(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	user := ctx.Value(core.Key("user")).(uint)
+	listID, id, ok := parseItemRoute(w, r)
+	if !ok {
+		return
+	}
+	var patch ItemPatch
+	if err := core.DecodeJSON(r, &patch); err != nil {
+		core.WriteError(w, *err)
+		return
+	}
+
+	var record core.TodoItemRecord
+	var checksum string
+	err := core.StoreFrom(ctx).Atomic(func(store core.Store) (e error) {
+		record, e = store.GetTodoItem(id, user)
+		if e != nil {
+			return e
+		}
+		if record.ListID != listID {
+			return core.ErrNotFound
+		}
+		// Items of trashed lists can't be changed
+		if _, _, e = getParent(store, user, listID); e != nil {
+			return e
+		}
+		// Refuse to overwrite changes the client hasn't seen
+		if e = core.IfMatch(r, record.Checksum()); e != nil {
+			return e
+		}
+
+		if patch.Title != nil {
+			record.Title = core.FromBase64(*patch.Title)
+		}
+		if patch.Description != nil {
+			record.Description = core.FromBase64(*patch.Description)
+		}
+		if patch.Done != nil {
+			record.Done = []byte(*patch.Done)
+		}
+		if patch.Tags != nil {
+			record.Tags = encodeTags(*patch.Tags)
+		}
+		if patch.Meta != nil && patch.Meta.CryptoKey != nil {
+			record.CryptoKey = core.FromBase64(*patch.Meta.CryptoKey)
+		}
+		if e = store.UpdateTodoItem(record); e != nil {
+			return e
+		}
+		_, checksum, e = getParent(store, user, listID)
+		return e
+	})
+	if err == core.ErrNotFound {
+		core.WriteError404(w)
+		return
+	} else if httpErr, ok := err.(core.HTTPError); ok {
+		core.WriteError(w, httpErr)
+		return
+	} else if err != nil {
+		core.WriteError500(w, err)
+		return
+	}
+
+	w.Header().Set("ETag", core.ETag(record.Checksum()))
+	json.NewEncoder(w).Encode(ItemResponse{
+		EncodedID: core.EncodeID(id),
+		Meta: core.State{
+			Checksum: record.Checksum()},
+		List: core.State{
+			Checksum: checksum}})
+}
+
+// DeleteItem - Delete a single item of a todo list or the nolist collection
+func DeleteItem(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	user := ctx.Value(core.Key("user")).(uint)
+	listID, id, ok := parseItemRoute(w, r)
+	if !ok {
+		return
+	}
+
+	err := core.StoreFrom(ctx).Atomic(func(store core.Store) error {
+		record, e := store.GetTodoItem(id, user)
+		if e != nil {
+			return e
+		}
+		if record.ListID != listID {
+			return core.ErrNotFound
+		}
+		// Items of trashed lists can't be changed
+		if _, _, e = getParent(store, user, listID); e != nil {
+			return e
+		}
+		if e = core.IfMatch(r, record.Checksum()); e != nil {
+			return e
+		}
+		return store.DeleteTodoItem(id, user)
+	})
+	if httpErr, ok := err.(core.HTTPError); ok {
+		core.WriteError(w, httpErr)
+		return
+	} else if err != nil && err != core.ErrNotFound {
+		core.WriteError500(w, err)
+		return
+	}
+
+	w.WriteHeader(204)
+}
+
+// MoveItem - Move an item to a position in another todo list (or the nolist collection), or within its own
+// Items after the position in the destination are shifted down, and both lists' new checksums are returned
+func MoveItem(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	user := ctx.Value(core.Key("user")).(uint)
+	fromID, id, ok := parseItemRoute(w, r)
+	if !ok {
+		return
+	}
+	var move Move
+	if err := core.DecodeJSON(r, &move); err != nil {
+		core.WriteError(w, *err)
+		return
+	}
+	var toID uint
+	if len(move.To) > 0 {
+		var err error
+		if toID, err = core.DecodeID(move.To); err != nil || toID == 0 {
+			core.WriteError(w, core.HTTPError{
+				Code:    core.CodeMalformedID,
+				Title:   "Bad Request",
+				Message: "Malformed destination list ID",
+				Status:  400})
+			return
+		}
+	}
+
+	var record core.TodoItemRecord
+	var response MoveResponse
+	err := core.StoreFrom(ctx).Atomic(func(store core.Store) (e error) {
+		record, e = store.GetTodoItem(id, user)
+		if e != nil {
+			return e
+		}
+		if record.ListID != fromID {
+			return core.ErrNotFound
+		}
+		if e = core.IfMatch(r, record.Checksum()); e != nil {
+			return e
+		}
+		items, _, e := getParent(store, user, toID)
+		if e == core.ErrNotFound {
+			return core.HTTPError{
+				Code:    core.CodeNotFound,
+				Title:   "Not Found",
+				Message: "The destination list doesn't exist",
+				Status:  404}
+		} else if e != nil {
+			return e
+		}
+
+		// Insert the item among the destination's other items, then store the position of every item that changed
+		ordered := make([]core.TodoItemRecord, 0, len(items)+1)
+		for _, item := range items {
+			if item.ID != id {
+				ordered = append(ordered, item)
+			}
+		}
+		position := int(move.Position)
+		if position > len(ordered) {
+			position = len(ordered)
+		}
+		ordered = append(ordered[:position], append([]core.TodoItemRecord{record}, ordered[position:]...)...)
+		for i, item := range ordered {
+			if item.ID != id && item.Position == uint(i) {
+				continue
+			}
+			item.ListID = toID
+			item.Position = uint(i)
+			if e = store.UpdateTodoItem(item); e != nil {
+				return e
+			}
+		}
+
+		if _, response.From.Checksum, e = getParent(store, user, fromID); e != nil {
+			return e
+		}
+		_, response.To.Checksum, e = getParent(store, user, toID)
+		return e
+	})
+	if err == core.ErrNotFound {
+		core.WriteError404(w)
+		return
+	} else if httpErr, ok := err.(core.HTTPError); ok {
+		core.WriteError(w, httpErr)
+		return
+	} else if err != nil {
+		core.WriteError500(w, err)
+		return
+	}
+
+	response.EncodedID = core.EncodeID(id)
+	response.Meta.Checksum = record.Checksum()
+	w.Header().Set("ETag", core.ETag(record.Checksum()))
+	json.NewEncoder(w).Encode(response)
+}
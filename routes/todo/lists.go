@@ -3,10 +3,10 @@ package todo
 import (
 	"context"
 	"encoding/json"
-	"fmt"
 	"net/http"
+	"sort"
 	"strconv"
-	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 	core "github.com/very-amused/CSplan-API/core"
@@ -40,14 +40,6 @@ func (lists SortableLists) Swap(i, j int) {
 	lists[i] = old2
 }
 
-// Item - A singular todo item belonging to a parent List
-type Item struct {
-	Title       string   `json:"title" validate:"required,base64"`
-	Description string   `json:"description" validate:"required,base64"`
-	Done        string   `json:"done"` // This is in reality a boolean, but it is an encrypted one, so we store it as a string
-	Tags        []string `json:"tags" validate:"required,dive,base64"`
-}
-
 // Response - Response to creation or update of a todo list
 type Response struct {
 	EncodedID string            `json:"id"`
@@ -73,196 +65,244 @@ func parseID(strID string) (uint, error) {
 	return uint(id), e
 }
 
+// FromRecord - Convert a stored todo list to its API representation
+func FromRecord(record core.TodoListRecord) (list List, e error) {
+	list = List{
+		ID:        record.ID,
+		EncodedID: core.EncodeID(record.ID),
+		Title:     core.ToBase64(record.Title),
+		Meta: core.IndexedMeta{
+			CryptoKey: core.ToBase64(record.CryptoKey),
+			Checksum:  record.Checksum(),
+			Index:     record.Index}}
+	list.Items, e = itemsFromRecords(record.Items)
+	return list, e
+}
+
+// Insert - Store a new todo list for user at the end of their lists, returning the stored record
+func Insert(store core.Store, user uint, list List) (record core.TodoListRecord, e error) {
+	e = store.Atomic(func(store core.Store) (e error) {
+		// Generate a unique ID
+		id, e := core.MakeUniqueID(store, "TodoLists")
+		if e != nil {
+			return e
+		}
+
+		items, e := newItemRecords(store, user, id, list.Items)
+		if e != nil {
+			return e
+		}
+		record = core.TodoListRecord{
+			ID:        id,
+			UserID:    user,
+			Title:     core.FromBase64(list.Title),
+			Items:     items,
+			CryptoKey: core.FromBase64(list.Meta.CryptoKey)}
+
+		// Rank the list after the user's other lists
+		lists, e := store.ListTodoLists(user)
+		if e != nil {
+			return e
+		}
+		if e = rankAt(store, &record, lists, len(lists)); e != nil {
+			return e
+		}
+		return store.CreateTodoList(record)
+	})
+	return record, e
+}
+
 // AddTodo - Add a todo list to the database
 func AddTodo(ctx context.Context, w http.ResponseWriter, r *http.Request) {
 	var list List
-	json.NewDecoder(r.Body).Decode(&list)
-	if err := core.ValidateStruct(list); err != nil {
+	if err := core.DecodeJSON(r, &list); err != nil {
 		core.WriteError(w, *err)
 		return
 	}
 	user := ctx.Value(core.Key("user")).(uint)
 
-	// Generate a unique ID
-	var err error
-	list.ID, err = core.MakeUniqueID("TodoLists")
-	if err != nil {
-		core.WriteError500(w, err)
-	}
-	list.EncodedID = core.EncodeID(list.ID)
-
-	// Figure out list index
-	var max, index uint
-	err = core.DB.Get(&max, "SELECT MAX(_Index) FROM TodoLists WHERE UserID = ?", user)
-	if err != nil {
-		index = 0
-	} else if max == 255 {
-		core.WriteError(w, core.HTTPError{
-			Title: "Resource Conflict",
-			Message: `The max index allowed for todo-lists (255) has been exceeded.
-			Remove one or more todo lists before attempting to add more`,
-			Status: 409})
+	record, err := Insert(core.StoreFrom(ctx), user, list)
+	if httpErr, ok := err.(core.HTTPError); ok {
+		core.WriteError(w, httpErr)
 		return
-	} else {
-		index = max + 1
-	}
-
-	// Encode items as json
-	for i, item := range list.Items {
-		if len(item.Tags) == 0 {
-			list.Items[i].Tags = make([]string, 0) // This is really dumb, golang json lib bad
-			// For clarity, go's json lib will serialize any empty primitive array as null unless you check each it and manually call make with a value of 0
-			// I don't know who thought this was a good idea, I don't know how this hasn't been patched yet, but this is really, really dumb
-		}
-	}
-	m, err := json.Marshal(list.Items)
-	encoded := string(m)
-
-	_, err = core.DB.Exec("INSERT INTO TodoLists (ID, UserID, Title, Items, _Index, CryptoKey) VALUES (?, ?, FROM_BASE64(?), ?, ?, FROM_BASE64(?))",
-		list.ID, user, list.Title, encoded, index, list.Meta.CryptoKey)
-	if err != nil {
+	} else if err != nil {
 		core.WriteError500(w, err)
 		return
 	}
 
-	// Get checksum
-	var Checksum string
-	err = core.DB.Get(&Checksum, "SELECT SHA(CONCAT(Title, Items)) AS Checksum FROM TodoLists WHERE ID = ?", list.ID)
-	if err != nil {
-		core.WriteError500(w, err)
-		return
-	}
 	w.WriteHeader(201)
 	json.NewEncoder(w).Encode(Response{
-		EncodedID: core.EncodeID(list.ID),
+		EncodedID: core.EncodeID(record.ID),
 		Meta: core.IndexedState{
-			Index:    index,
-			Checksum: Checksum}})
+			Index:    record.Index,
+			Checksum: record.Checksum()}})
 }
 
 // GetTodos - Retrieve a slice of all todo lists belonging to a user
 func GetTodos(ctx context.Context, w http.ResponseWriter, r *http.Request) {
 	user := ctx.Value(core.Key("user")).(uint)
+	store := core.StoreFrom(ctx)
+	// Lists are paginated by (rank, ID)
+	page, httpErr := core.ParseRankedPage(r, 1)
+	if httpErr != nil {
+		core.WriteError(w, *httpErr)
+		return
+	}
 
-	var max int
-	core.DB.Get(&max, "SELECT Max(_Index) FROM TodoLists WHERE UserID = ?", user)
-
-	rows, err := core.DB.Query("SELECT ID, TO_BASE64(Title), Items, _Index, TO_BASE64(CryptoKey), SHA(CONCAT(Title, Items)) FROM TodoLists WHERE UserID = ?", user)
-	defer rows.Close()
+	var records []core.TodoListRecord
+	var err error
+	if page.Paginated() {
+		// One extra list is retrieved to find out whether there's a next page
+		records, err = store.PageTodoLists(user, page.AfterRank, uint(page.Key(0)), page.Limit+1)
+	} else {
+		records, err = store.ListTodoLists(user)
+	}
 	if err != nil {
 		core.WriteError500(w, err)
 		return
 	}
+	if page.Paginated() && len(records) > page.Limit {
+		records = records[:page.Limit]
+		last := records[len(records)-1]
+		page.SetNextRanked(w, r, last.Rank, uint64(last.ID))
+	}
 
-	// A slice of slice pointers is used here as a solution to 2 problems
-	// 1. If a single depth slice is used and there are index collisions in the db, then overwrites will occur and effectively render a list unaccessable
-	// 2. If pointers are not used, then gaps in indexes (nil values in the parent slice) are unable to be checked for
-	// This allows for lists to effectively be sorted in such a way that they later can be copied to a single-depth slice for encoding, while also
-	// fixing index collisions/gaps in deferred SQL statements
-	// This solution is much faster than simply appending and then implementing a sort algorithm
-	var lists = make([]*[]List, max+1)
-
-	for rows.Next() {
-		var list List
-		var encoded string
-		err = rows.Scan(&list.ID, &list.Title, &encoded, &list.Meta.Index, &list.Meta.CryptoKey, &list.Meta.Checksum)
+	lists := make([]List, 0, len(records))
+	for _, record := range records {
+		list, err := FromRecord(record)
 		if err != nil {
 			core.WriteError500(w, err)
 			return
 		}
-		// json unmarshal list items
-		json.Unmarshal([]byte(encoded), &list.Items)
-
-		list.EncodedID = core.EncodeID(list.ID)
-		i := list.Meta.Index
-		// Append the list to the subslice at index i
-		if lists[i] != nil {
-			*lists[i] = append(*lists[i], list)
-		} else {
-			lists[i] = &[]List{list}
-		}
+		lists = append(lists, list)
 	}
 
-	// Validate and fix index collisions
-	var final []List
-	var k int
-	for i := 0; i < len(lists); i++ {
-		if lists[i] == nil {
-			continue
-		}
-
-		for j := 0; j < len(*lists[i]); j++ {
-			final = append(final, (*lists[i])[j])
-			// Defer updating any inaccurate indexes in the db
-			if final[k].Meta.Index != uint(k) {
-				defer core.DB.Exec("UPDATE TodoLists SET _Index = ? WHERE ID = ?", k, final[k].ID)
-			}
-			k++
-		}
-		// Immediately free the memory occupied by this subslice
-		lists[i] = nil
+	members := make([][]string, len(lists))
+	for i, list := range lists {
+		members[i] = []string{list.EncodedID, strconv.Itoa(int(list.Meta.Index)), list.Meta.Checksum, list.Meta.CryptoKey}
 	}
-	if len(final) == 0 {
-		final = make([]List, 0)
+	if core.NotModified(w, r, core.CollectionChecksum(members)) {
+		return
 	}
-
-	json.NewEncoder(w).Encode(final)
+	json.NewEncoder(w).Encode(lists)
 }
 
 // GetTodo - Retrieve a single todo list by id
 func GetTodo(ctx context.Context, w http.ResponseWriter, r *http.Request) {
 	user := ctx.Value(core.Key("user")).(uint)
-	var list List
 
 	// Parse ID from url
 	id, err := core.DecodeID(mux.Vars(r)["id"])
 	if err != nil {
 		core.WriteError(w, core.HTTPError{
+			Code:    core.CodeMalformedID,
 			Title:   "Bad Request",
 			Message: "Malformed id param",
 			Status:  400})
 		return
 	}
-	list.ID = id
 
 	// Select the todo list from the db
-	var encoded string
-	rows, err := core.DB.Query("SELECT TO_BASE64(Title) AS Title, Items, _Index, TO_BASE64(CryptoKey) AS CryptoKey, SHA(CONCAT(Title, Items)) AS Checksum FROM TodoLists WHERE ID = ? AND UserID = ?",
-		list.ID, user)
-	defer rows.Close()
-	if err != nil {
-		core.WriteError500(w, err)
-		return
-	}
-	// If rows.Next() returns false, it means no rows are found, which indicates a 404
-	if rows.Next() {
-		err = rows.Scan(&list.Title, &encoded, &list.Meta.Index, &list.Meta.CryptoKey, &list.Meta.Checksum)
-		if err != nil {
-			core.WriteError500(w, err)
-			return
-		}
-	} else {
+	record, err := core.StoreFrom(ctx).GetTodoList(id, user)
+	if err == core.ErrNotFound {
 		core.WriteError404(w)
 		return
+	} else if err != nil {
+		core.WriteError500(w, err)
+		return
 	}
 
-	// json unmarshal list items
-	err = json.Unmarshal([]byte(encoded), &list.Items)
+	list, err := FromRecord(record)
 	if err != nil {
 		core.WriteError500(w, err)
 		return
 	}
-	list.EncodedID = core.EncodeID(list.ID)
 
+	if core.NotModified(w, r, record.Checksum()) {
+		return
+	}
 	json.NewEncoder(w).Encode(list)
 }
 
+// rankAt - Rank record to sort at position among the user's other lists (in order), only record's rank is changed unless
+// there's no room for it between its neighbors, in which case every list is rebalanced
+func rankAt(store core.Store, record *core.TodoListRecord, others []core.TodoListRecord, position int) error {
+	if position > len(others) {
+		position = len(others)
+	}
+	var before, after string
+	if position > 0 {
+		before = others[position-1].Rank
+	}
+	if position < len(others) {
+		after = others[position].Rank
+	}
+	if rank, ok := core.RankBetween(before, after); ok {
+		record.Rank = rank
+		record.Index = uint(position)
+		return nil
+	}
+
+	ordered := make([]core.TodoListRecord, 0, len(others)+1)
+	ordered = append(append(append(ordered, others[:position]...), *record), others[position:]...)
+	for i, rank := range core.SpreadRanks(len(ordered)) {
+		if ordered[i].ID == record.ID {
+			record.Rank = rank
+			record.Index = uint(i)
+			continue
+		}
+		ordered[i].Rank = rank
+		if e := store.UpdateTodoList(ordered[i]); e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+// ApplyPatch - Apply patch to a stored todo list, ranking it between its new neighbors if its index is changed
+func ApplyPatch(store core.Store, record core.TodoListRecord, patch Patch) (core.TodoListRecord, error) {
+	e := store.Atomic(func(store core.Store) (e error) {
+		// Patch only the fields specified in the request
+		if patch.Title != nil {
+			record.Title = core.FromBase64(*patch.Title)
+		}
+		if patch.Items != nil {
+			if record.Items, e = replaceItems(store, record.UserID, record.ID, record.Items, *patch.Items); e != nil {
+				return e
+			}
+		}
+		if patch.Meta != nil && len(patch.Meta.CryptoKey) > 0 {
+			record.CryptoKey = core.FromBase64(patch.Meta.CryptoKey)
+		}
+
+		// Move the list if a new index is specified, indexes past the end move it to the end
+		if patch.Meta != nil && patch.Meta.Index != nil && *patch.Meta.Index != record.Index {
+			lists, e := store.ListTodoLists(record.UserID)
+			if e != nil {
+				return e
+			}
+			others := make([]core.TodoListRecord, 0, len(lists))
+			for _, list := range lists {
+				if list.ID != record.ID {
+					others = append(others, list)
+				}
+			}
+			if e = rankAt(store, &record, others, int(*patch.Meta.Index)); e != nil {
+				return e
+			}
+		}
+		return store.UpdateTodoList(record)
+	})
+	return record, e
+}
+
 // UpdateTodo - Update a todo list by id
 func UpdateTodo(ctx context.Context, w http.ResponseWriter, r *http.Request) {
 	user := ctx.Value(core.Key("user")).(uint)
 	id, err := core.DecodeID(mux.Vars(r)["id"])
 	if err != nil {
 		core.WriteError(w, core.HTTPError{
+			Code:    core.CodeMalformedID,
 			Title:   "Bad Request",
 			Message: "Malformed ID param",
 			Status:  400})
@@ -271,144 +311,96 @@ func UpdateTodo(ctx context.Context, w http.ResponseWriter, r *http.Request) {
 
 	// Validate patch body
 	var patch Patch
-	json.NewDecoder(r.Body).Decode(&patch)
-	if err := core.ValidateStruct(patch); err != nil {
+	if err := core.DecodeJSON(r, &patch); err != nil {
 		core.WriteError(w, *err)
 		return
 	}
 
-	// Existence + ownership check
-	rows, _ := core.DB.Query("SELECT 1 FROM TodoLists WHERE ID = ? AND UserID = ?", id, user)
-	defer rows.Close()
-	if !rows.Next() {
-		core.WriteError404(w)
-		return
-	}
-
-	// Patch only the fields specified in the request
-	var updates []string
-	var args []interface{}
-	if patch.Title != nil {
-		updates = append(updates, "Title = FROM_BASE64(?)")
-		args = append(args, *patch.Title)
-	}
-	if patch.Items != nil {
-		// Avoid being encoded as null
-		if len(*patch.Items) == 0 {
-			*patch.Items = make([]Item, 0)
+	// Initiate a transaction, so if any step fails, things are not left in a broken state
+	var record core.TodoListRecord
+	err = core.StoreFrom(ctx).Atomic(func(store core.Store) (e error) {
+		// Existence + ownership check
+		record, e = store.GetTodoList(id, user)
+		if e != nil {
+			return e
 		}
-		m, _ := json.Marshal(patch.Items)
-		encoded := string(m)
-		updates = append(updates, "Items = ?")
-		args = append(args, encoded)
-	}
-	if patch.Meta != nil && len(patch.Meta.CryptoKey) > 0 {
-		updates = append(updates, "CryptoKey = FROM_BASE64(?)")
-		args = append(args, patch.Meta.CryptoKey)
-	}
-	// Perform the patch as a single query
-	if len(updates) > 0 {
-		query := fmt.Sprintf("UPDATE TodoLists SET %s WHERE ID = ?", strings.Join(updates, ", "))
-		args = append(args, id)
-		_, err := core.DB.Exec(query, args...)
-		if err != nil {
-			core.WriteError500(w, err)
-			return
+		// Refuse to overwrite changes the client hasn't seen
+		if e = core.IfMatch(r, record.Checksum()); e != nil {
+			return e
 		}
-	}
-
-	// Get state information
-	var state core.IndexedState
-	err = core.DB.Get(&state, "SELECT SHA(CONCAT(Title, Items)) AS Checksum, _Index FROM TodoLists WHERE ID = ?", id)
-	if err != nil {
+		record, e = ApplyPatch(store, record, patch)
+		return e
+	})
+	if err == core.ErrNotFound {
+		core.WriteError404(w)
+		return
+	} else if httpErr, ok := err.(core.HTTPError); ok {
+		core.WriteError(w, httpErr)
+		return
+	} else if err != nil {
 		core.WriteError500(w, err)
 		return
 	}
 
-	// If there's an index shift specified, perform it
-	o := state.Index
-	if patch.Meta != nil && patch.Meta.Index != nil && *patch.Meta.Index != o {
-		n := *patch.Meta.Index
-		// Initiate a transaction, so if any step fails, things are not left in a broken state
-		tx, err := core.DB.Beginx()
-		if err != nil {
-			core.WriteError500(w, err)
-			return
-		}
-		defer tx.Rollback()
+	w.Header().Set("ETag", core.ETag(record.Checksum()))
+	json.NewEncoder(w).Encode(Response{
+		EncodedID: core.EncodeID(id),
+		Meta: core.IndexedState{
+			Index:    record.Index,
+			Checksum: record.Checksum()}})
+}
 
-		// Select a map of index -> id
-		ids := make(map[uint]uint)
-		rows, err := core.DB.Query("SELECT ID, _Index FROM TodoLists WHERE UserID = ?", user)
-		defer rows.Close()
-		if err != nil {
-			core.WriteError500(w, err)
-			return
-		}
-		for rows.Next() {
-			var id, index uint
-			rows.Scan(&id, &index)
-			ids[index] = id
-		}
+// Remove - Move a stored todo list to the trash (the indexes of the lists after it are derived from their ranks, so they don't need to be updated)
+func Remove(store core.Store, record core.TodoListRecord) error {
+	return store.TrashTodoList(record.ID, record.UserID, uint(time.Now().Unix()))
+}
 
-		// Shift
-		if n > o {
-			// (o, n]
-			for i := o + 1; i <= n; i++ {
-				_, err = tx.Exec("UPDATE TodoLists SET _Index = ? WHERE ID = ?", i-1, ids[i])
-				if err != nil {
-					core.WriteError500(w, err)
-					return
-				}
-			}
-		} else if n < o {
-			// [n, o)
-			for i := n; i < o; i++ {
-				_, err = tx.Exec("UPDATE TodoLists SET _Index = ? WHERE ID = ?", i+1, ids[i])
-				if err != nil {
-					core.WriteError500(w, err)
-					return
-				}
-			}
+// Restore - Move a todo list out of the trash, returning it to its previous position among the lists that are left
+// The list keeps its rank if it still sorts between its neighbors, otherwise it's ranked between them
+func Restore(store core.Store, record core.TodoListRecord) (core.TodoListRecord, error) {
+	e := store.Atomic(func(store core.Store) error {
+		lists, e := store.ListTodoLists(record.UserID)
+		if e != nil {
+			return e
 		}
-		// Update the index of the list itself
-		_, err = tx.Exec("UPDATE TodoLists SET _Index = ? WHERE ID = ?", n, id)
-		if err != nil {
-			core.WriteError500(w, err)
-			return
+		position := sort.Search(len(lists), func(i int) bool {
+			return lists[i].Rank > record.Rank || lists[i].Rank == record.Rank && lists[i].ID > record.ID
+		})
+		if position > 0 && lists[position-1].Rank == record.Rank || position < len(lists) && lists[position].Rank == record.Rank {
+			if e = rankAt(store, &record, lists, position); e != nil {
+				return e
+			}
 		}
-		state.Index = n
-		tx.Commit()
-	}
-
-	json.NewEncoder(w).Encode(Response{
-		EncodedID: core.EncodeID(id),
-		Meta:      state})
+		record.Index = uint(position)
+		record.Trashed = 0
+		return store.RestoreTodoList(record.ID, record.UserID, record.Rank)
+	})
+	return record, e
 }
 
-// DeleteTodo - Delete a todo list by ID
+// DeleteTodo - Move a todo list to the trash by ID
 func DeleteTodo(ctx context.Context, w http.ResponseWriter, r *http.Request) {
 	user := ctx.Value(core.Key("user")).(uint)
 	id, err := core.DecodeID(mux.Vars(r)["id"])
 	if err != nil {
 		core.WriteError(w, core.HTTPError{
+			Code:    core.CodeMalformedID,
 			Title:   "Bad Request",
 			Message: "Malformed or missing ID param",
 			Status:  400})
 		return
 	}
 
-	// Select useful information that will be used for updating indexes later
-	var max, index uint
-	core.DB.Get(&max, "SELECT MAX(_Index) FROM TodoLists WHERE UserID = ?", user)
-	core.DB.Get(&index, "SELECT _Index FROM TodoLists WHERE ID = ? AND UserID = ?", id, user)
-
-	results, _ := core.DB.Exec("DELETE FROM TodoLists WHERE ID = ? AND UserID = ?", id, user)
-	if affected, _ := results.RowsAffected(); affected > 0 {
-		// Update indexes to be accurate after the delete operation
-		for i := index + 1; i <= max; i++ {
-			core.DB.Exec("UPDATE TodoLists SET _Index = ? WHERE _Index = ? AND UserID = ?", i-1, i, user)
+	err = core.StoreFrom(ctx).Atomic(func(store core.Store) error {
+		record, e := store.GetTodoList(id, user)
+		if e != nil {
+			return e
 		}
+		return Remove(store, record)
+	})
+	if err != nil && err != core.ErrNotFound {
+		core.WriteError500(w, err)
+		return
 	}
 
 	w.WriteHeader(204)
@@ -20,107 +20,147 @@ type NoListPatch struct {
 	Meta  *core.MetaPatch `json:"meta,omitempty"`
 }
 
-// CreateNoList - The creation of a nolist collection should be automatically accomplished at register-time,
-// but a route is specified here as a manual failsafe (no body POST)
-// TODO: allow post body for this route
+// InsertNoList - Store a new nolist collection for user, returning the stored record
+func InsertNoList(store core.Store, user uint, list NoList) (record core.NoListRecord, e error) {
+	e = store.Atomic(func(store core.Store) (e error) {
+		items, e := newItemRecords(store, user, 0, list.Items)
+		if e != nil {
+			return e
+		}
+		record = core.NoListRecord{
+			UserID:    user,
+			Items:     items,
+			CryptoKey: core.FromBase64(list.Meta.CryptoKey)}
+		return store.CreateNoList(record)
+	})
+	return record, e
+}
+
+// NoListFromRecord - Convert a stored nolist collection to its API representation
+func NoListFromRecord(record core.NoListRecord) (nolist NoList, e error) {
+	nolist = NoList{
+		Meta: core.Meta{
+			CryptoKey: core.ToBase64(record.CryptoKey),
+			Checksum:  record.Checksum()}}
+	nolist.Items, e = itemsFromRecords(record.Items)
+	return nolist, e
+}
+
+// PatchNoList - Update the items and key of a stored nolist collection (if included in the patch)
+func PatchNoList(store core.Store, record core.NoListRecord, patch NoListPatch) (core.NoListRecord, error) {
+	e := store.Atomic(func(store core.Store) (e error) {
+		if patch.Items != nil {
+			if record.Items, e = replaceItems(store, record.UserID, 0, record.Items, *patch.Items); e != nil {
+				return e
+			}
+		}
+		if patch.Meta != nil && patch.Meta.CryptoKey != nil {
+			record.CryptoKey = core.FromBase64(*patch.Meta.CryptoKey)
+		}
+		return store.UpdateNoList(record)
+	})
+	return record, e
+}
+
+// CreateNoList - Create the user's nolist collection from the request body
+// The creation of a nolist collection should be automatically accomplished at register-time, but a route is specified here as a manual failsafe
 func CreateNoList(ctx context.Context, w http.ResponseWriter, r *http.Request) {
 	user := ctx.Value(core.Key("user")).(uint)
+	store := core.StoreFrom(ctx)
 	var list NoList
-	json.NewDecoder(r.Body).Decode(&list)
-	if err := core.ValidateStruct(list); err != nil {
+	if err := core.DecodeJSON(r, &list); err != nil {
 		core.WriteError(w, *err)
 		return
 	}
 
 	// Check for resource conflicts
-	rows, err := core.DB.Query("SELECT 1 FROM NoList WHERE UserID = ?", user)
-	defer rows.Close()
-	if rows.Next() {
+	_, err := store.GetNoList(user)
+	if err == nil {
 		core.WriteError(w, core.HTTPError{
+			Code:    core.CodeConflict,
 			Title:   "Resource Conflict",
 			Message: "This user already has a no list collection created.",
 			Status:  409})
 		return
-	} else if err != nil {
+	} else if err != core.ErrNotFound {
 		core.WriteError500(w, err)
 		return
 	}
 
-	// Marshal list items
-	marshalled, _ := json.Marshal(list.Items)
-	encoded := string(marshalled)
-
-	_, err = core.DB.Exec("INSERT INTO NoList (UserID, Items, CryptoKey) VALUES (?, ?, FROM_BASE64(?))", user, encoded, list.Meta.CryptoKey)
+	record, err := InsertNoList(store, user, list)
 	if err != nil {
 		core.WriteError500(w, err)
 		return
 	}
-	var Checksum string
-	core.DB.Get(&Checksum, "SELECT SHA(CONCAT(Items, CryptoKey)) FROM NoList WHERE UserID = ?", user)
 
 	w.Header().Set("Location", "/nolist")
 	w.WriteHeader(201)
 	json.NewEncoder(w).Encode(core.StateResponse{
 		Meta: core.State{
-			Checksum: Checksum}})
+			Checksum: record.Checksum()}})
 }
 
 // UpdateNoList - Update the items or key of a nolist collection
 func UpdateNoList(ctx context.Context, w http.ResponseWriter, r *http.Request) {
 	user := ctx.Value(core.Key("user")).(uint)
 	var patch NoListPatch
-	json.NewDecoder(r.Body).Decode(&patch)
-	if err := core.ValidateStruct(patch); err != nil {
+	if err := core.DecodeJSON(r, &patch); err != nil {
 		core.WriteError(w, *err)
 		return
 	}
 
-	// Update the collection's items (if included in the request)
-	if patch.Items != nil {
-		marshalled, _ := json.Marshal(patch.Items)
-		_, err := core.DB.Exec("UPDATE NoList SET Items = ? WHERE UserID = ?", marshalled, user)
-		if err != nil {
-			core.WriteError500(w, err)
-			return
+	var record core.NoListRecord
+	err := core.StoreFrom(ctx).Atomic(func(store core.Store) (e error) {
+		record, e = store.GetNoList(user)
+		if e != nil {
+			return e
 		}
-	}
-	if patch.Meta != nil && patch.Meta.CryptoKey != nil {
-		_, err := core.DB.Exec("UPDATE NoList SET CryptoKey = FROM_BASE64(?) WHERE UserID = ?", *patch.Meta.CryptoKey, user)
-		if err != nil {
-			core.WriteError500(w, err)
-			return
+		// Refuse to overwrite changes the client hasn't seen
+		if e = core.IfMatch(r, record.Checksum()); e != nil {
+			return e
 		}
-	}
 
-	// Retrieve the updated checksum
-	var checksum string
-	core.DB.Get(&checksum, "SELECT SHA(CONCAT(Items, CryptoKey)) FROM NoList WHERE UserID = ?", user)
+		record, e = PatchNoList(store, record, patch)
+		return e
+	})
+	if err == core.ErrNotFound {
+		core.WriteError404(w)
+		return
+	} else if httpErr, ok := err.(core.HTTPError); ok {
+		core.WriteError(w, httpErr)
+		return
+	} else if err != nil {
+		core.WriteError500(w, err)
+		return
+	}
 
+	w.Header().Set("ETag", core.ETag(record.Checksum()))
 	json.NewEncoder(w).Encode(core.StateResponse{
 		Meta: core.State{
-			Checksum: checksum}})
+			Checksum: record.Checksum()}})
 }
 
 // GetNoList - Retrieve a nolist collection
 func GetNoList(ctx context.Context, w http.ResponseWriter, r *http.Request) {
 	user := ctx.Value(core.Key("user")).(uint)
-	var nolist NoList
 
-	rows, err := core.DB.Query("SELECT Items, TO_BASE64(CryptoKey), SHA(CONCAT(Items, CryptoKey)) FROM NoList WHERE UserID = ?", user)
-	defer rows.Close()
-	if !rows.Next() {
+	record, err := core.StoreFrom(ctx).GetNoList(user)
+	if err == core.ErrNotFound {
 		core.WriteError404(w)
 		return
 	} else if err != nil {
 		core.WriteError500(w, err)
 		return
 	}
-	var encoded string
-	rows.Scan(&encoded, &nolist.Meta.CryptoKey, &nolist.Meta.Checksum)
-	err = json.Unmarshal([]byte(encoded), &nolist.Items)
+
+	nolist, err := NoListFromRecord(record)
 	if err != nil {
 		core.WriteError500(w, err)
+		return
 	}
 
+	if core.NotModified(w, r, record.Checksum()) {
+		return
+	}
 	json.NewEncoder(w).Encode(nolist)
 }
@@ -0,0 +1,107 @@
+package todo
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	core "github.com/very-amused/CSplan-API/core"
+)
+
+// Order - The complete order of a user's todo lists
+type Order struct {
+	// Lists - Encoded ID of every one of the user's todo lists, in their new order
+	Lists []string `json:"lists" validate:"required,dive,required"`
+}
+
+// errOrderMismatch - Sent if an order doesn't contain each of the user's lists exactly once
+var errOrderMismatch = core.HTTPError{
+	Code:    core.CodeOrderMismatch,
+	Title:   "Order Mismatch",
+	Message: "The order must contain the ID of every todo list exactly once",
+	Status:  409}
+
+// Reorder - Put every one of a user's lists in the order of ids, returning the reordered lists
+// Only the ranks of lists that are out of order are changed, unless every list needs to be rebalanced
+func Reorder(store core.Store, user uint, ids []uint) (ordered []core.TodoListRecord, e error) {
+	e = store.Atomic(func(store core.Store) error {
+		lists, e := store.ListTodoLists(user)
+		if e != nil {
+			return e
+		}
+		if len(ids) != len(lists) {
+			return errOrderMismatch
+		}
+		byID := make(map[uint]core.TodoListRecord, len(lists))
+		for _, list := range lists {
+			byID[list.ID] = list
+		}
+		ordered = make([]core.TodoListRecord, len(ids))
+		ranks := make([]string, len(ids))
+		for i, id := range ids {
+			list, ok := byID[id]
+			if !ok {
+				return errOrderMismatch
+			}
+			// Remove the list so that duplicate IDs are refused
+			delete(byID, id)
+			ordered[i] = list
+			ranks[i] = list.Rank
+		}
+
+		for i, rank := range core.Rerank(ranks) {
+			ordered[i].Index = uint(i)
+			if rank == ordered[i].Rank {
+				continue
+			}
+			ordered[i].Rank = rank
+			if e = store.UpdateTodoList(ordered[i]); e != nil {
+				return e
+			}
+		}
+		return nil
+	})
+	return ordered, e
+}
+
+// ReorderTodos - Replace the order of the user's todo lists in a single transaction
+func ReorderTodos(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	user := ctx.Value(core.Key("user")).(uint)
+	var order Order
+	if err := core.DecodeJSON(r, &order); err != nil {
+		core.WriteError(w, *err)
+		return
+	}
+	ids := make([]uint, len(order.Lists))
+	for i, encoded := range order.Lists {
+		id, err := core.DecodeID(encoded)
+		if err != nil {
+			core.WriteError(w, core.HTTPError{
+				Code:    core.CodeMalformedID,
+				Title:   "Bad Request",
+				Message: "Malformed list ID '" + encoded + "'",
+				Status:  400})
+			return
+		}
+		ids[i] = id
+	}
+
+	ordered, err := Reorder(core.StoreFrom(ctx), user, ids)
+	if httpErr, ok := err.(core.HTTPError); ok {
+		core.WriteError(w, httpErr)
+		return
+	} else if err != nil {
+		core.WriteError500(w, err)
+		return
+	}
+
+	response := make([]Response, len(ordered))
+	for i, record := range ordered {
+		response[i] = Response{
+			EncodedID: core.EncodeID(record.ID),
+			Meta: core.IndexedState{
+				Index:    record.Index,
+				Checksum: record.Checksum()}}
+	}
+	json.NewEncoder(w).Encode(response)
+}
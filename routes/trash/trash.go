@@ -0,0 +1,224 @@
+// Package trash - Deleted todo lists and tags, which can be restored until they're purged
+package trash
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+
+	"github.com/gorilla/mux"
+	"github.com/very-amused/CSplan-API/config"
+	core "github.com/very-amused/CSplan-API/core"
+	"github.com/very-amused/CSplan-API/routes/tags"
+	"github.com/very-amused/CSplan-API/routes/todo"
+)
+
+// Entry - A todo list or tag in the trash
+type Entry struct {
+	// Type - Either todo or tag, matching the types used by /sync
+	Type      string `json:"type"`
+	EncodedID string `json:"id"`
+	// Trashed - Unix time the resource was moved to the trash
+	Trashed uint `json:"trashed"`
+	// Expires - Unix time the resource will be purged, omitted if the trash isn't purged automatically
+	Expires uint `json:"expires,omitempty"`
+	// List - The trashed todo list, its index is always 0
+	List *todo.List `json:"list,omitempty"`
+	Tag  *tags.Tag  `json:"tag,omitempty"`
+}
+
+// RestoreResponse - Response to the restoration of a todo list or tag
+type RestoreResponse struct {
+	Type      string       `json:"type"`
+	EncodedID string       `json:"id"`
+	Meta      RestoredMeta `json:"meta"`
+}
+
+// RestoredMeta - The state of a restored resource
+type RestoredMeta struct {
+	Checksum string `json:"checksum"`
+	// Index - Position of a restored todo list among the user's lists, omitted for tags
+	Index *uint `json:"index,omitempty"`
+}
+
+// parseEntry - Parse the type and ID params of a trash route, writing an error and returning false if either is invalid
+// IDs are only unique among resources of the same type, so both are needed to identify an entry
+func parseEntry(w http.ResponseWriter, r *http.Request) (kind string, id uint, ok bool) {
+	vars := mux.Vars(r)
+	kind = vars["type"]
+	if kind != core.KindTodoList && kind != core.KindTag {
+		core.WriteError400(w, "The type param must be either "+core.KindTodoList+" or "+core.KindTag)
+		return "", 0, false
+	}
+	id, err := core.DecodeID(vars["id"])
+	if err != nil {
+		core.WriteError(w, core.HTTPError{
+			Code:    core.CodeMalformedID,
+			Title:   "Bad Request",
+			Message: "Malformed or missing ID param",
+			Status:  400})
+		return "", 0, false
+	}
+	return kind, id, true
+}
+
+// GetTrash - Retrieve every todo list and tag in the user's trash, most recently trashed first
+func GetTrash(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	user := ctx.Value(core.Key("user")).(uint)
+	store := core.StoreFrom(ctx)
+
+	lists, err := store.ListTrashedTodoLists(user)
+	if err != nil {
+		core.WriteError500(w, err)
+		return
+	}
+	tagRecords, err := store.ListTrashedTags(user)
+	if err != nil {
+		core.WriteError500(w, err)
+		return
+	}
+
+	retention := uint(config.Current().Trash.Retention().Seconds())
+	entries := make([]Entry, 0, len(lists)+len(tagRecords))
+	for _, record := range lists {
+		list, err := todo.FromRecord(record)
+		if err != nil {
+			core.WriteError500(w, err)
+			return
+		}
+		entries = append(entries, Entry{
+			Type:      core.KindTodoList,
+			EncodedID: list.EncodedID,
+			Trashed:   record.Trashed,
+			List:      &list})
+	}
+	for _, record := range tagRecords {
+		tag := tags.FromRecord(record)
+		entries = append(entries, Entry{
+			Type:      core.KindTag,
+			EncodedID: tag.EncodedID,
+			Trashed:   record.Trashed,
+			Tag:       &tag})
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Trashed > entries[j].Trashed
+	})
+	if retention > 0 {
+		for i := range entries {
+			entries[i].Expires = entries[i].Trashed + retention
+		}
+	}
+	json.NewEncoder(w).Encode(entries)
+}
+
+// RestoreTrash - Move a todo list or tag out of the trash, restored todo lists return to their previous position
+func RestoreTrash(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	user := ctx.Value(core.Key("user")).(uint)
+	kind, id, ok := parseEntry(w, r)
+	if !ok {
+		return
+	}
+
+	var response RestoreResponse
+	err := core.StoreFrom(ctx).Atomic(func(store core.Store) error {
+		if kind == core.KindTag {
+			return restoreTag(store, user, id, &response)
+		}
+		list, e := store.GetTrashedTodoList(id, user)
+		if e != nil {
+			return e
+		}
+		if list, e = todo.Restore(store, list); e != nil {
+			return e
+		}
+		response = RestoreResponse{
+			Type:      core.KindTodoList,
+			EncodedID: core.EncodeID(id),
+			Meta: RestoredMeta{
+				Checksum: list.Checksum(),
+				Index:    &list.Index}}
+		return nil
+	})
+	if err == core.ErrNotFound {
+		core.WriteError404(w)
+		return
+	} else if err != nil {
+		core.WriteError500(w, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(response)
+}
+
+// restoreTag - Move a tag out of the trash, filling in the response to its restoration
+func restoreTag(store core.Store, user, id uint, response *RestoreResponse) error {
+	tag, e := store.GetTrashedTag(id, user)
+	if e != nil {
+		return e
+	}
+	*response = RestoreResponse{
+		Type:      core.KindTag,
+		EncodedID: core.EncodeID(id),
+		Meta: RestoredMeta{
+			Checksum: tag.Checksum()}}
+	return store.RestoreTag(id, user)
+}
+
+// PurgeTrash - Permanently delete a todo list or tag from the trash
+func PurgeTrash(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	user := ctx.Value(core.Key("user")).(uint)
+	kind, id, ok := parseEntry(w, r)
+	if !ok {
+		return
+	}
+
+	var err error
+	if kind == core.KindTodoList {
+		err = core.StoreFrom(ctx).PurgeTodoList(id, user)
+	} else {
+		err = core.StoreFrom(ctx).PurgeTag(id, user)
+	}
+	if err == core.ErrNotFound {
+		core.WriteError404(w)
+		return
+	} else if err != nil {
+		core.WriteError500(w, err)
+		return
+	}
+
+	w.WriteHeader(204)
+}
+
+// EmptyTrash - Permanently delete every todo list and tag in the user's trash
+func EmptyTrash(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	user := ctx.Value(core.Key("user")).(uint)
+
+	err := core.StoreFrom(ctx).Atomic(func(store core.Store) error {
+		lists, e := store.ListTrashedTodoLists(user)
+		if e != nil {
+			return e
+		}
+		for _, list := range lists {
+			if e = store.PurgeTodoList(list.ID, user); e != nil {
+				return e
+			}
+		}
+		tagRecords, e := store.ListTrashedTags(user)
+		if e != nil {
+			return e
+		}
+		for _, tag := range tagRecords {
+			if e = store.PurgeTag(tag.ID, user); e != nil {
+				return e
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		core.WriteError500(w, err)
+		return
+	}
+
+	w.WriteHeader(204)
+}
@@ -0,0 +1,65 @@
+package scheduler
+
+import (
+	"time"
+
+	"github.com/very-amused/CSplan-API/config"
+	"github.com/very-amused/CSplan-API/core"
+)
+
+// Expiry rules for authentication data
+const (
+	// SessionLifetime - How long a session lasts before being deleted
+	SessionLifetime = time.Hour * 24 * 14
+	// SessionIdleTime - How long an expired session must have been unused before being deleted (so that it isn't deleted in the middle of use)
+	SessionIdleTime = time.Hour
+	// DeleteTokenLifetime - How long an account deletion token is valid for
+	DeleteTokenLifetime = time.Minute * 5
+	// ChallengeLifetime - How long a challenge can go unattempted before it is considered abandoned
+	ChallengeLifetime = time.Minute
+	// FailedChallengeLifetime - How long failed challenges are kept for ratelimiting purposes
+	FailedChallengeLifetime = time.Hour
+)
+
+// cleanupInterval - How often expired data is cleared
+const cleanupInterval = time.Minute
+
+// trashInterval - How often resources are purged from the trash once they've been kept for trash.retention_days
+const trashInterval = time.Hour
+
+func unixBefore(now time.Time, d time.Duration) uint {
+	return uint(now.Add(-d).Unix())
+}
+
+// CleanupJobs - Jobs clearing expired authentication data and purging the trash
+func CleanupJobs() []Job {
+	return []Job{
+		{
+			Name:     "ClearSessions",
+			Interval: cleanupInterval,
+			Run: func(store core.Store, now time.Time) (int64, error) {
+				return store.DeleteExpiredSessions(unixBefore(now, SessionLifetime), unixBefore(now, SessionIdleTime))
+			}},
+		{
+			Name:     "ClearDeleteTokens",
+			Interval: cleanupInterval,
+			Run: func(store core.Store, now time.Time) (int64, error) {
+				return store.DeleteExpiredDeleteTokens(unixBefore(now, DeleteTokenLifetime))
+			}},
+		{
+			Name:     "ClearChallenges",
+			Interval: cleanupInterval,
+			Run: func(store core.Store, now time.Time) (int64, error) {
+				return store.DeleteExpiredChallenges(unixBefore(now, ChallengeLifetime), unixBefore(now, FailedChallengeLifetime))
+			}},
+		{
+			Name:     "PurgeTrash",
+			Interval: trashInterval,
+			Run: func(store core.Store, now time.Time) (int64, error) {
+				retention := config.Current().Trash.Retention()
+				if retention == 0 {
+					return 0, nil
+				}
+				return store.PurgeExpiredTrash(unixBefore(now, retention))
+			}}}
+}
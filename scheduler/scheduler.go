@@ -0,0 +1,112 @@
+// Package scheduler - In-process background jobs, each run on an interval by a single instance elected through a lease
+package scheduler
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"os"
+	"time"
+
+	"github.com/very-amused/CSplan-API/core"
+	"github.com/very-amused/CSplan-API/metrics"
+)
+
+// Job - A task run periodically against the store
+type Job struct {
+	Name string
+	// Interval - Time between runs, no two runs of a job (on any instance) will start closer together than this
+	Interval time.Duration
+	// Run - Perform the job as of now, returning the number of records affected
+	Run func(store core.Store, now time.Time) (affected int64, e error)
+}
+
+// Maximum random delay added to each interval (as a fraction of the interval),
+// so that instances started at the same time don't contend for leases in lockstep
+const jitterFraction = 0.1
+
+// Scheduler - Runs jobs in the background until shutdown
+type Scheduler struct {
+	store  core.Store
+	holder string
+	jobs   []Job
+}
+
+// New - Create a scheduler that elects itself to run jobs using leases stored in store
+func New(store core.Store) *Scheduler {
+	hostname, _ := os.Hostname()
+	id := make([]byte, 4)
+	rand.Read(id)
+	return &Scheduler{
+		store:  store,
+		holder: fmt.Sprintf("%s:%d:%s", hostname, os.Getpid(), hex.EncodeToString(id))}
+}
+
+// Add - Add jobs to the scheduler, must be called before Start
+func (s *Scheduler) Add(jobs ...Job) {
+	s.jobs = append(s.jobs, jobs...)
+}
+
+// Start - Run every job on its interval in a background worker
+func (s *Scheduler) Start() {
+	for _, job := range s.jobs {
+		job := job
+		core.Go(func(ctx context.Context) {
+			for {
+				timer := time.NewTimer(withJitter(job.Interval))
+				select {
+				case <-timer.C:
+					s.RunOnce(job, time.Now())
+				case <-ctx.Done():
+					timer.Stop()
+					return
+				}
+			}
+		})
+	}
+}
+
+// withJitter - Add a random delay of up to jitterFraction to interval
+func withJitter(interval time.Duration) time.Duration {
+	max := int64(float64(interval) * jitterFraction)
+	if max <= 0 {
+		return interval
+	}
+	jitter, _ := rand.Int(rand.Reader, big.NewInt(max))
+	return interval + time.Duration(jitter.Int64())
+}
+
+// RunOnce - Run job as of now if this instance holds (or can take) its lease, reporting whether it was run
+func (s *Scheduler) RunOnce(job Job, now time.Time) (ran bool, e error) {
+	logger := core.Logger{}.With("job", job.Name)
+	acquired, err := s.store.AcquireLease(job.Name, s.holder, uint(now.Unix()), uint(now.Add(job.Interval).Unix()))
+	if err != nil {
+		metrics.JobRuns.Inc(job.Name, "error")
+		logger.Errorf("Failed to acquire lease: %s", err)
+		return false, err
+	}
+	if !acquired {
+		metrics.JobRuns.Inc(job.Name, "skipped")
+		logger.Debugf("Skipped, another instance holds the lease")
+		return false, nil
+	}
+
+	start := time.Now()
+	affected, err := job.Run(s.store, now)
+	duration := time.Since(start)
+	metrics.JobDuration.Observe(duration.Seconds(), job.Name)
+	fields := map[string]interface{}{
+		"affected":    affected,
+		"duration_ms": float64(duration.Microseconds()) / 1000}
+	if err != nil {
+		metrics.JobRuns.Inc(job.Name, "error")
+		fields["error"] = err.Error()
+		logger.Log("error", "job failed", fields)
+		return true, err
+	}
+	metrics.JobRuns.Inc(job.Name, "success")
+	logger.Log("debug", "job completed", fields)
+	return true, nil
+}
@@ -0,0 +1,143 @@
+package scheduler
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/very-amused/CSplan-API/config"
+	"github.com/very-amused/CSplan-API/core"
+)
+
+func unix(t time.Time) uint {
+	return uint(t.Unix())
+}
+
+func TestCleanupJobs(t *testing.T) {
+	store := core.NewMemoryStore()
+	now := time.Now()
+	store.CreateUser(core.UserRecord{ID: 1}, core.AuthKeyRecord{})
+	store.CreateUser(core.UserRecord{ID: 2}, core.AuthKeyRecord{})
+
+	sessions := map[uint]core.SessionRecord{
+		// Expired and idle
+		1: {Created: unix(now.Add(-SessionLifetime)), LastUsed: unix(now.Add(-SessionIdleTime))},
+		// Expired but in use
+		2: {Created: unix(now.Add(-SessionLifetime * 2)), LastUsed: unix(now.Add(-time.Minute))},
+		// Not expired
+		3: {Created: unix(now.Add(-time.Hour * 24)), LastUsed: unix(now.Add(-time.Hour * 24))}}
+	for id, session := range sessions {
+		session.ID = id
+		session.UserID = 1
+		store.CreateSession(session)
+	}
+	store.CreateDeleteToken(core.DeleteTokenRecord{UserID: 1, Timestamp: unix(now.Add(-DeleteTokenLifetime - time.Second))})
+	store.CreateDeleteToken(core.DeleteTokenRecord{UserID: 2, Timestamp: unix(now.Add(-time.Minute))})
+	challenges := map[uint]core.ChallengeRecord{
+		// Abandoned
+		1: {Timestamp: unix(now.Add(-ChallengeLifetime - time.Second))},
+		// Pending
+		2: {Timestamp: unix(now)},
+		// Failed long enough ago to no longer count towards ratelimiting
+		3: {Failed: true, Timestamp: unix(now.Add(-FailedChallengeLifetime - time.Second))},
+		// Recently failed
+		4: {Failed: true, Timestamp: unix(now.Add(-ChallengeLifetime * 2))}}
+	for id, challenge := range challenges {
+		challenge.ID = id
+		challenge.UserID = 1
+		store.CreateChallenge(challenge)
+	}
+
+	// Trashed just past the retention period, and recently trashed (config.Current() is the default config)
+	retention := config.Current().Trash.Retention()
+	for id, trashed := range map[uint]time.Time{1: now.Add(-retention - time.Second), 2: now.Add(-time.Hour)} {
+		store.CreateTodoList(core.TodoListRecord{ID: id, UserID: 1})
+		store.CreateTodoItem(core.TodoItemRecord{ID: id, UserID: 1, ListID: id})
+		store.TrashTodoList(id, 1, unix(trashed))
+		store.CreateTag(core.TagRecord{ID: id, UserID: 1})
+		store.TrashTag(id, 1, unix(trashed))
+	}
+
+	s := New(store)
+	for _, job := range CleanupJobs() {
+		if ran, err := s.RunOnce(job, now); !ran || err != nil {
+			t.Fatalf("Expected job %s to run (err: %v)", job.Name, err)
+		}
+	}
+
+	expected := []struct {
+		table  string
+		id     uint
+		exists bool
+	}{
+		{"Sessions", 1, false},
+		{"Sessions", 2, true},
+		{"Sessions", 3, true},
+		{"Challenges", 1, false},
+		{"Challenges", 2, true},
+		{"Challenges", 3, false},
+		{"Challenges", 4, true},
+		{"TodoLists", 1, false},
+		{"TodoItems", 1, false},
+		{"TodoLists", 2, true},
+		{"TodoItems", 2, true},
+		{"Tags", 1, false},
+		{"Tags", 2, true}}
+	for _, e := range expected {
+		if exists, _ := store.IDExists(e.table, e.id); exists != e.exists {
+			t.Errorf("Expected %s %d to exist: %t", e.table, e.id, e.exists)
+		}
+	}
+	if _, err := store.GetDeleteToken(1); err != core.ErrNotFound {
+		t.Error("Expected an expired delete token to be deleted")
+	}
+	if _, err := store.GetDeleteToken(2); err != nil {
+		t.Error("Expected a valid delete token to be kept")
+	}
+}
+
+func TestLeases(t *testing.T) {
+	store := core.NewMemoryStore()
+	now := time.Now()
+	var runs int
+	job := Job{
+		Name:     "Test",
+		Interval: time.Minute,
+		Run: func(core.Store, time.Time) (int64, error) {
+			runs++
+			return 0, nil
+		}}
+	first, second := New(store), New(store)
+
+	if ran, _ := first.RunOnce(job, now); !ran {
+		t.Fatal("Expected the first instance to acquire the lease")
+	}
+	if ran, _ := second.RunOnce(job, now.Add(time.Second*30)); ran {
+		t.Error("Expected the second instance to be blocked by an unexpired lease")
+	}
+	if ran, _ := first.RunOnce(job, now.Add(time.Second*30)); !ran {
+		t.Error("Expected the lease holder to be able to renew its lease")
+	}
+	if ran, _ := second.RunOnce(job, now.Add(time.Second*90)); !ran {
+		t.Error("Expected the second instance to take over an expired lease")
+	}
+	if runs != 3 {
+		t.Errorf("Expected 3 runs, got %d", runs)
+	}
+
+	failure := errors.New("failure")
+	job.Run = func(core.Store, time.Time) (int64, error) {
+		return 0, failure
+	}
+	if ran, err := second.RunOnce(job, now.Add(time.Second*100)); !ran || err != failure {
+		t.Errorf("Expected job errors to be returned, got %v", err)
+	}
+}
+
+func TestJitter(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		if d := withJitter(time.Minute); d < time.Minute || d >= time.Minute+time.Minute/10 {
+			t.Fatalf("Jittered interval %s out of range", d)
+		}
+	}
+}
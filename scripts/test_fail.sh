@@ -4,13 +4,14 @@
 # using pure SQL queries. This is meant to be run in the case of API tests failing to delete the test user.
 
 # Tables are read in reverse order to ensure that no foreign key constraints are violated
-tables=$(tac sql/schema.sql | grep 'CREATE TABLE IF NOT EXISTS' | awk '{print $6}')
-db=$(awk 'NR==1{split($1,a,"."); print a[1]}' <<< $tables)
+tables=$(cat sql/migrations/*.up.sql | tac | grep 'CREATE TABLE IF NOT EXISTS' | awk '{print $6}')
+db=CSplanGo
 
 queries="SELECT ID INTO @UserID FROM $db.Users WHERE Email = 'user@test.com';"
 while read table; do
-	[ "$table" == "$db.Users" ] && continue
-	query="DELETE FROM $table WHERE UserID = @UserID;"
+	# Skip tables that don't belong to a user
+	[ "$table" == "Users" ] || [ "$table" == "JobLeases" ] && continue
+	query="DELETE FROM $db.$table WHERE UserID = @UserID;"
 	queries="$queries $query"
 done <<< $tables
 
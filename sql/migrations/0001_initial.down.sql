@@ -0,0 +1,15 @@
+-- Tables are dropped in reverse order of creation to avoid violating foreign key constraints
+DROP TABLE IF EXISTS Reminders;
+DROP TABLE IF EXISTS Tags;
+DROP TABLE IF EXISTS NoList;
+DROP TABLE IF EXISTS TodoLists;
+DROP TABLE IF EXISTS Names;
+DROP TABLE IF EXISTS CryptoKeys;
+DROP TABLE IF EXISTS Challenges;
+DROP TABLE IF EXISTS DeleteTokens;
+DROP TRIGGER IF EXISTS CreateSettings;
+DROP TABLE IF EXISTS Settings;
+DROP TABLE IF EXISTS Sessions;
+DROP TABLE IF EXISTS TOTP;
+DROP TABLE IF EXISTS AuthKeys;
+DROP TABLE IF EXISTS Users;
@@ -1,8 +1,6 @@
 ---- Tables
-CREATE DATABASE IF NOT EXISTS CSplanGo;
-
 -- Authentication - Users and Tokens
-CREATE TABLE IF NOT EXISTS CSplanGo.Users (
+CREATE TABLE IF NOT EXISTS Users (
 	ID bigint unsigned NOT NULL,
 	Email varchar(255) NOT NULL,
 	Verified boolean NOT NULL DEFAULT 0,
@@ -10,24 +8,24 @@ CREATE TABLE IF NOT EXISTS CSplanGo.Users (
 	UNIQUE KEY (Email)
 );
 
-CREATE TABLE IF NOT EXISTS CSplanGo.AuthKeys (
+CREATE TABLE IF NOT EXISTS AuthKeys (
 	UserID bigint unsigned NOT NULL,
 	AuthKey blob NOT NULL,
 	HashParams json NOT NULL,
 	PRIMARY KEY (UserID),
-	FOREIGN KEY (UserID) REFERENCES CSplanGo.Users(ID)
+	FOREIGN KEY (UserID) REFERENCES Users(ID)
 );
 
-CREATE TABLE IF NOT EXISTS CSplanGo.TOTP (
+CREATE TABLE IF NOT EXISTS TOTP (
 	UserID bigint unsigned NOT NULL,
 	_Secret blob NOT NULL,
 	BackupCodes json NOT NULL,
 	PRIMARY KEY (UserID),
-	FOREIGN KEY (UserID) REFERENCES CSplanGo.Users(ID)
+	FOREIGN KEY (UserID) REFERENCES Users(ID)
 );
 
 -- User authentication and session information
-CREATE TABLE IF NOT EXISTS CSplanGo.Sessions (
+CREATE TABLE IF NOT EXISTS Sessions (
 	ID bigint unsigned NOT NULL,
 	UserID bigint unsigned NOT NULL,
 	Token tinyblob NOT NULL,
@@ -36,29 +34,29 @@ CREATE TABLE IF NOT EXISTS CSplanGo.Sessions (
 	LastUsed bigint unsigned NOT NULL DEFAULT UNIX_TIMESTAMP(),
 	DeviceInfo tinytext NOT NULL DEFAULT '',
 	PRIMARY KEY (ID),
-	FOREIGN KEY (UserID) REFERENCES CSplanGo.Users(ID)
+	FOREIGN KEY (UserID) REFERENCES Users(ID)
 );
 
 -- User privacy settings
-CREATE TABLE IF NOT EXISTS CSplanGo.Settings (
+CREATE TABLE IF NOT EXISTS Settings (
 	UserID bigint unsigned NOT NULL,
 	EnableIPLogging boolean NOT NULL DEFAULT 0,
 	EnableReminders boolean NOT NULL DEFAULT 0,
 	PRIMARY KEY (UserID),
-	FOREIGN KEY (UserID) REFERENCES CSplanGo.Users(ID)
+	FOREIGN KEY (UserID) REFERENCES Users(ID)
 );
 
 -- Automatically create a default set of settings when a user registers their account (all privacy releases are kept off by default)
 delimiter |
-CREATE TRIGGER IF NOT EXISTS CSplanGo.CreateSettings
-	AFTER INSERT ON CSplanGo.Users FOR EACH ROW
+CREATE TRIGGER IF NOT EXISTS CreateSettings
+	AFTER INSERT ON Users FOR EACH ROW
 	BEGIN
-		INSERT INTO CSplanGo.Settings (UserID) VALUES (NEW.ID);
+		INSERT INTO Settings (UserID) VALUES (NEW.ID);
 	END |
 delimiter ;
 
 -- Tokens used for a user to confirm their account's deletion
-CREATE TABLE IF NOT EXISTS CSplanGo.DeleteTokens (
+CREATE TABLE IF NOT EXISTS DeleteTokens (
 	UserID bigint unsigned NOT NULL,
 	Token tinytext NOT NULL,
 	_Timestamp bigint unsigned NOT NULL DEFAULT UNIX_TIMESTAMP(),
@@ -66,40 +64,40 @@ CREATE TABLE IF NOT EXISTS CSplanGo.DeleteTokens (
 );
 
 -- Challenge's used to authenticate users
-CREATE TABLE IF NOT EXISTS CSplanGo.Challenges (
+CREATE TABLE IF NOT EXISTS Challenges (
   ID bigint unsigned NOT NULL,
   UserID bigint unsigned NOT NULL,
   _Data blob NOT NULL,
   Failed boolean NOT NULL DEFAULT 0,
   _Timestamp bigint unsigned NOT NULL DEFAULT UNIX_TIMESTAMP(),
   PRIMARY KEY (ID),
-  FOREIGN KEY (UserID) REFERENCES CSplanGo.Users(ID)
+  FOREIGN KEY (UserID) REFERENCES Users(ID)
 );
 
 -- Cryptography management - Keys
-CREATE TABLE IF NOT EXISTS CSplanGo.CryptoKeys (
+CREATE TABLE IF NOT EXISTS CryptoKeys (
 	UserID bigint unsigned NOT NULL,
 	PublicKey blob NOT NULL,
 	PrivateKey blob NOT NULL,
 	HashSalt tinyblob NOT NULL,
 	HashParams json NOT NULL,
 	PRIMARY KEY (UserID),
-	FOREIGN KEY (UserID) REFERENCES CSplanGo.Users(ID)
+	FOREIGN KEY (UserID) REFERENCES Users(ID)
 );
 
 -- Personalization - Names + Identifiers
-CREATE TABLE IF NOT EXISTS CSplanGo.Names (
+CREATE TABLE IF NOT EXISTS Names (
 	UserID bigint unsigned NOT NULL,
 	FirstName tinyblob NOT NULL DEFAULT '',
 	LastName tinyblob NOT NULL DEFAULT '',
 	Username tinyblob NOT NULL DEFAULT '',
 	CryptoKey blob NOT NULL,
 	PRIMARY KEY (UserID),
-	FOREIGN KEY (UserID) REFERENCES CSplanGo.Users(ID)
+	FOREIGN KEY (UserID) REFERENCES Users(ID)
 );
 
 -- Todos - Todo lists for a user
-CREATE TABLE IF NOT EXISTS CSplanGo.TodoLists (
+CREATE TABLE IF NOT EXISTS TodoLists (
 	ID bigint unsigned NOT NULL,
 	UserID bigint unsigned NOT NULL,
 	Title tinyblob NOT NULL DEFAULT '',
@@ -108,33 +106,33 @@ CREATE TABLE IF NOT EXISTS CSplanGo.TodoLists (
 	_Index tinyint unsigned NOT NULL,
 	CryptoKey blob NOT NULL,
 	PRIMARY KEY (ID),
-	FOREIGN KEY (UserID) REFERENCES CSplanGo.Users(ID)
+	FOREIGN KEY (UserID) REFERENCES Users(ID)
 );
 
-CREATE TABLE IF NOT EXISTS CSplanGo.NoList (
+CREATE TABLE IF NOT EXISTS NoList (
 	UserID bigint unsigned NOT NULL,
 	Items json NOT NULL DEFAULT '[]',
 	CryptoKey blob NOT NULL DEFAULT '',
 	PRIMARY KEY (UserID),
-	FOREIGN KEY (UserID) REFERENCES CSplanGo.Users(ID)
+	FOREIGN KEY (UserID) REFERENCES Users(ID)
 );
 
-CREATE TABLE IF NOT EXISTS CSplanGo.Tags (
+CREATE TABLE IF NOT EXISTS Tags (
 	ID bigint unsigned NOT NULL,
 	UserID bigint unsigned NOT NULL,
 	Name tinyblob NOT NULL DEFAULT '',
 	Color tinyblob NOT NULL DEFAULT '',
 	CryptoKey blob NOT NULL,
 	PRIMARY KEY (ID),
-	FOREIGN KEY (UserID) REFERENCES CSplanGo.Users(ID)
+	FOREIGN KEY (UserID) REFERENCES Users(ID)
 );
 
-CREATE TABLE IF NOT EXISTS CSplanGo.Reminders (
+CREATE TABLE IF NOT EXISTS Reminders (
 	ID bigint unsigned NOT NULL,
 	UserID bigint unsigned NOT NULL,
 	Title tinyblob NOT NULL DEFAULT '',
 	RetryInterval mediumint unsigned NOT NULL DEFAULT 300 CHECK(RetryInterval <= 86400), -- How long the server should wait before retrying a failed notification in seconds (may be up to 24 hours)
 	_Timestamp bigint unsigned NOT NULL DEFAULT (UNIX_TIMESTAMP() + 300),
 	PRIMARY KEY (ID),
-	FOREIGN KEY (UserID) REFERENCES CSplanGo.Users(ID)
+	FOREIGN KEY (UserID) REFERENCES Users(ID)
 );
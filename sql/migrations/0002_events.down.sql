@@ -0,0 +1,4 @@
+DROP EVENT IF EXISTS ClearSessions;
+DROP EVENT IF EXISTS ClearDeleteTokens;
+DROP EVENT IF EXISTS ClearChallenges;
+DROP EVENT IF EXISTS ClearChallengeFails;
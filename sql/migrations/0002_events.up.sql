@@ -0,0 +1,37 @@
+-- Scheduled cleanup of expired authentication data
+
+delimiter |
+
+-- Clear sessions older than 2 weeks (and not currently in use, decided by whether the token has been active within the past hour)
+CREATE EVENT IF NOT EXISTS ClearSessions
+	ON SCHEDULE EVERY 1 MINUTE
+	DO
+		BEGIN
+			DELETE FROM Sessions WHERE UNIX_TIMESTAMP() - Created >= 14 * 24 * 60 * 60 AND UNIX_TIMESTAMP() - LastUsed >= 60 * 60;
+		END |
+
+-- Clear delete tokens older than 5 minutes
+CREATE EVENT IF NOT EXISTS ClearDeleteTokens
+	ON SCHEDULE EVERY 1 MINUTE
+	DO
+		BEGIN
+			DELETE FROM DeleteTokens WHERE UNIX_TIMESTAMP() - _Timestamp > 5 * 60;
+		END |
+
+-- Create events for the management of challenge attempts
+CREATE EVENT IF NOT EXISTS ClearChallenges
+  ON SCHEDULE EVERY 1 MINUTE
+  COMMENT "Clear abandoned challenge attempts. An attempt is considered abandoned when it has not been attempted within 1 minute of being requested."
+  DO
+    BEGIN
+      DELETE FROM Challenges WHERE FAILED = 0 AND UNIX_TIMESTAMP() - _Timestamp > 60;
+    END |
+
+CREATE EVENT IF NOT EXISTS ClearChallengeFails
+  ON SCHEDULE EVERY 1 MINUTE
+  COMMENT "Clear failed challenge attempts older than 1 hour. These are kept in the database longer for ratelimiting purposes."
+  DO
+    BEGIN
+      DELETE FROM Challenges WHERE FAILED = 1 AND UNIX_TIMESTAMP() - _Timestamp > 3600;
+    END |
+delimiter ;
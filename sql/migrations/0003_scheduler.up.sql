@@ -0,0 +1,13 @@
+-- Expired data is now cleared by the API's scheduler, which doesn't depend on the event scheduler being enabled
+DROP EVENT IF EXISTS ClearSessions;
+DROP EVENT IF EXISTS ClearDeleteTokens;
+DROP EVENT IF EXISTS ClearChallenges;
+DROP EVENT IF EXISTS ClearChallengeFails;
+
+-- Leases used to elect a single API instance to run each scheduled job
+CREATE TABLE IF NOT EXISTS JobLeases (
+	Name varchar(64) NOT NULL,
+	Holder varchar(255) NOT NULL,
+	Expires bigint unsigned NOT NULL,
+	PRIMARY KEY (Name)
+);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS Changes;
+DROP TABLE IF EXISTS ChangeSeqs;
@@ -0,0 +1,20 @@
+-- Latest change sequence number of each user
+CREATE TABLE IF NOT EXISTS ChangeSeqs (
+	UserID bigint unsigned NOT NULL,
+	Seq bigint unsigned NOT NULL,
+	PRIMARY KEY (UserID),
+	FOREIGN KEY (UserID) REFERENCES Users(ID)
+);
+
+-- Latest change to each synced resource, deleted resources are kept as tombstones
+CREATE TABLE IF NOT EXISTS Changes (
+	UserID bigint unsigned NOT NULL,
+	Kind varchar(16) NOT NULL,
+	ResourceID bigint unsigned NOT NULL, -- 0 for resources each user only has one of
+	Seq bigint unsigned NOT NULL,
+	CreatedSeq bigint unsigned NOT NULL DEFAULT 0,
+	Deleted boolean NOT NULL DEFAULT 0,
+	PRIMARY KEY (UserID, Kind, ResourceID),
+	KEY (UserID, Seq),
+	FOREIGN KEY (UserID) REFERENCES Users(ID)
+);
@@ -0,0 +1 @@
+ALTER TABLE Changes DROP COLUMN IF EXISTS SessionID;
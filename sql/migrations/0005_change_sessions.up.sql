@@ -0,0 +1,2 @@
+-- Session that made each change, sent with live change events so that clients can ignore their own changes
+ALTER TABLE Changes ADD COLUMN IF NOT EXISTS SessionID bigint unsigned NOT NULL DEFAULT 0;
@@ -0,0 +1,21 @@
+-- Items are moved back into the Items json of their list, the keys of items with their own key are lost
+ALTER TABLE TodoLists ADD COLUMN IF NOT EXISTS Items json NOT NULL DEFAULT '[]' AFTER Title;
+ALTER TABLE NoList ADD COLUMN IF NOT EXISTS Items json NOT NULL DEFAULT '[]' AFTER UserID;
+
+UPDATE TodoLists l SET Items = COALESCE((
+	SELECT JSON_ARRAYAGG(JSON_OBJECT(
+		'title', REPLACE(TO_BASE64(i.Title), '\n', ''),
+		'description', REPLACE(TO_BASE64(i.Description), '\n', ''),
+		'done', CONVERT(i.Done USING utf8mb4),
+		'tags', JSON_QUERY(i.Tags, '$')) ORDER BY i._Position, i.ID)
+	FROM TodoItems i WHERE i.UserID = l.UserID AND i.ListID = l.ID), '[]');
+
+UPDATE NoList n SET Items = COALESCE((
+	SELECT JSON_ARRAYAGG(JSON_OBJECT(
+		'title', REPLACE(TO_BASE64(i.Title), '\n', ''),
+		'description', REPLACE(TO_BASE64(i.Description), '\n', ''),
+		'done', CONVERT(i.Done USING utf8mb4),
+		'tags', JSON_QUERY(i.Tags, '$')) ORDER BY i._Position, i.ID)
+	FROM TodoItems i WHERE i.UserID = n.UserID AND i.ListID = 0), '[]');
+
+DROP TABLE IF EXISTS TodoItems;
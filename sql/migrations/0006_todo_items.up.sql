@@ -0,0 +1,42 @@
+-- Items of todo lists and nolist collections, stored individually so that they can be updated without rewriting their list
+CREATE TABLE IF NOT EXISTS TodoItems (
+	ID bigint unsigned NOT NULL,
+	UserID bigint unsigned NOT NULL,
+	ListID bigint unsigned NOT NULL, -- 0 for items in the nolist collection
+	_Position int unsigned NOT NULL,
+	Title blob NOT NULL,
+	Description blob NOT NULL,
+	Done tinyblob NOT NULL,
+	-- Tags are stored as json with each value as encrypted base64
+	Tags json NOT NULL DEFAULT '[]',
+	CryptoKey blob NOT NULL DEFAULT '', -- Empty if the item is encrypted with its list's key
+	PRIMARY KEY (ID),
+	KEY (UserID, ListID, _Position),
+	FOREIGN KEY (UserID) REFERENCES Users(ID)
+);
+
+-- Move the items of every list and nolist collection into their own rows, each item is assigned a random ID
+INSERT INTO TodoItems (ID, UserID, ListID, _Position, Title, Description, Done, Tags)
+SELECT CAST(CONV(LEFT(SHA2(CONCAT(UUID(), l.ID, i.Position), 256), 16), 16, 10) AS UNSIGNED), l.UserID, l.ID, i.Position - 1,
+	FROM_BASE64(i.Title), FROM_BASE64(i.Description), COALESCE(i.Done, ''),
+	COALESCE(JSON_EXTRACT(l.Items, CONCAT('$[', i.Position - 1, '].tags')), '[]')
+FROM TodoLists l, JSON_TABLE(l.Items, '$[*]' COLUMNS (
+	Position FOR ORDINALITY,
+	Title text PATH '$.title',
+	Description text PATH '$.description',
+	Done text PATH '$.done'
+)) AS i;
+
+INSERT INTO TodoItems (ID, UserID, ListID, _Position, Title, Description, Done, Tags)
+SELECT CAST(CONV(LEFT(SHA2(CONCAT(UUID(), n.UserID, i.Position), 256), 16), 16, 10) AS UNSIGNED), n.UserID, 0, i.Position - 1,
+	FROM_BASE64(i.Title), FROM_BASE64(i.Description), COALESCE(i.Done, ''),
+	COALESCE(JSON_EXTRACT(n.Items, CONCAT('$[', i.Position - 1, '].tags')), '[]')
+FROM NoList n, JSON_TABLE(n.Items, '$[*]' COLUMNS (
+	Position FOR ORDINALITY,
+	Title text PATH '$.title',
+	Description text PATH '$.description',
+	Done text PATH '$.done'
+)) AS i;
+
+ALTER TABLE TodoLists DROP COLUMN IF EXISTS Items;
+ALTER TABLE NoList DROP COLUMN IF EXISTS Items;
@@ -0,0 +1,8 @@
+ALTER TABLE TodoLists DROP KEY IF EXISTS UserRank;
+ALTER TABLE TodoLists ADD COLUMN IF NOT EXISTS _Index tinyint unsigned NOT NULL DEFAULT 0 AFTER Title;
+
+-- Lists are numbered in rank order, lists past the 255th share the last index
+UPDATE TodoLists l SET _Index = LEAST(255, (SELECT COUNT(*) FROM TodoLists o
+	WHERE o.UserID = l.UserID AND (o._Rank < l._Rank OR (o._Rank = l._Rank AND o.ID < l.ID))));
+
+ALTER TABLE TodoLists DROP COLUMN IF EXISTS _Rank;
@@ -0,0 +1,8 @@
+-- Todo lists are ordered by rank (a string of base 62 digits) rather than by index, so that a list can be moved without renumbering the lists after it
+ALTER TABLE TodoLists ADD COLUMN IF NOT EXISTS _Rank varbinary(255) NOT NULL DEFAULT '' AFTER Title;
+
+-- Existing indexes become fixed width base 36 ranks (which sort the same way), followed by a digit so that no rank ends with '0'
+UPDATE TodoLists SET _Rank = CONCAT(LPAD(CONV(_Index, 10, 36), 2, '0'), 'V');
+
+ALTER TABLE TodoLists DROP COLUMN IF EXISTS _Index;
+ALTER TABLE TodoLists ADD KEY IF NOT EXISTS UserRank (UserID, _Rank, ID);
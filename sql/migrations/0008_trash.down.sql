@@ -0,0 +1,9 @@
+-- Trashed resources would reappear without the column, so they're purged
+DELETE FROM TodoItems WHERE ListID IN (SELECT ID FROM TodoLists WHERE Trashed > 0);
+DELETE FROM TodoLists WHERE Trashed > 0;
+DELETE FROM Tags WHERE Trashed > 0;
+
+ALTER TABLE Tags DROP KEY IF EXISTS Trashed;
+ALTER TABLE Tags DROP COLUMN IF EXISTS Trashed;
+ALTER TABLE TodoLists DROP KEY IF EXISTS Trashed;
+ALTER TABLE TodoLists DROP COLUMN IF EXISTS Trashed;
@@ -0,0 +1,5 @@
+-- Deleted todo lists and tags are moved to the trash (marked with the time they were deleted) until they're restored or purged
+ALTER TABLE TodoLists ADD COLUMN IF NOT EXISTS Trashed bigint unsigned NOT NULL DEFAULT 0;
+ALTER TABLE TodoLists ADD KEY IF NOT EXISTS Trashed (Trashed);
+ALTER TABLE Tags ADD COLUMN IF NOT EXISTS Trashed bigint unsigned NOT NULL DEFAULT 0;
+ALTER TABLE Tags ADD KEY IF NOT EXISTS Trashed (Trashed);
@@ -0,0 +1,243 @@
+// Package migrations - Versioned schema migrations, embedded in the binary and tracked in the schema_version table
+package migrations
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+//go:embed *.sql
+var files embed.FS
+
+// Migration filenames are formatted as 0001_name.up.sql and 0001_name.down.sql
+var filenameRegex = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// Migration - A single versioned change to the schema
+type Migration struct {
+	Version uint
+	Name    string
+	Up      string
+	Down    string
+}
+
+// State - A migration along with whether (and when) it has been applied
+type State struct {
+	Migration
+	Applied   bool
+	AppliedAt uint
+}
+
+const createVersionTable = `CREATE TABLE IF NOT EXISTS schema_version (
+	Version int unsigned NOT NULL,
+	Name varchar(255) NOT NULL,
+	Applied bigint unsigned NOT NULL,
+	PRIMARY KEY (Version)
+)`
+
+// All - Parse every embedded migration, ordered by version
+func All() ([]Migration, error) {
+	entries, err := files.ReadDir(".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[uint]*Migration)
+	for _, entry := range entries {
+		match := filenameRegex.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("Invalid migration filename '%s'", entry.Name())
+		}
+		version, _ := strconv.ParseUint(match[1], 10, 0)
+		contents, err := files.ReadFile(entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		m := byVersion[uint(version)]
+		if m == nil {
+			m = &Migration{
+				Version: uint(version),
+				Name:    match[2]}
+			byVersion[uint(version)] = m
+		} else if m.Name != match[2] {
+			return nil, fmt.Errorf("Conflicting names for migration %d ('%s' and '%s')", version, m.Name, match[2])
+		}
+		if match[3] == "up" {
+			m.Up = string(contents)
+		} else {
+			m.Down = string(contents)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if len(m.Up) == 0 {
+			return nil, fmt.Errorf("Migration %d is missing an up script", m.Version)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// Latest - Return the version of the newest embedded migration
+func Latest() uint {
+	migrations, err := All()
+	if err != nil || len(migrations) == 0 {
+		return 0
+	}
+	return migrations[len(migrations)-1].Version
+}
+
+// Version - Return the version of the newest migration applied to db (0 if none have been applied)
+func Version(db *sqlx.DB) (version uint, e error) {
+	if _, e = db.Exec(createVersionTable); e != nil {
+		return 0, e
+	}
+	var max sql.NullInt64
+	e = db.Get(&max, "SELECT MAX(Version) FROM schema_version")
+	return uint(max.Int64), e
+}
+
+// Status - Return every embedded migration along with whether it has been applied to db
+func Status(db *sqlx.DB) ([]State, error) {
+	migrations, err := All()
+	if err != nil {
+		return nil, err
+	}
+	if _, err = db.Exec(createVersionTable); err != nil {
+		return nil, err
+	}
+
+	applied := make(map[uint]uint)
+	rows, err := db.Query("SELECT Version, Applied FROM schema_version")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var version, at uint
+		if err = rows.Scan(&version, &at); err != nil {
+			return nil, err
+		}
+		applied[version] = at
+	}
+
+	states := make([]State, len(migrations))
+	for i, m := range migrations {
+		at, ok := applied[m.Version]
+		states[i] = State{
+			Migration: m,
+			Applied:   ok,
+			AppliedAt: at}
+	}
+	return states, nil
+}
+
+// Up - Apply every pending migration in order, returning the migrations that were applied
+func Up(db *sqlx.DB) (applied []Migration, e error) {
+	states, err := Status(db)
+	if err != nil {
+		return nil, err
+	}
+	for _, state := range states {
+		if state.Applied {
+			continue
+		}
+		err = run(db, state.Migration.Up, func(tx *sqlx.Tx) error {
+			_, err := tx.Exec("INSERT INTO schema_version (Version, Name, Applied) VALUES (?, ?, ?)",
+				state.Version, state.Name, time.Now().Unix())
+			return err
+		})
+		if err != nil {
+			return applied, fmt.Errorf("Migration %d (%s) failed: %s", state.Version, state.Name, err)
+		}
+		applied = append(applied, state.Migration)
+	}
+	return applied, nil
+}
+
+// Down - Roll back the newest n applied migrations, returning the migrations that were rolled back
+func Down(db *sqlx.DB, n int) (rolledBack []Migration, e error) {
+	states, err := Status(db)
+	if err != nil {
+		return nil, err
+	}
+	for i := len(states) - 1; i >= 0 && len(rolledBack) < n; i-- {
+		state := states[i]
+		if !state.Applied {
+			continue
+		}
+		if len(state.Down) == 0 {
+			return rolledBack, fmt.Errorf("Migration %d (%s) can't be rolled back", state.Version, state.Name)
+		}
+		err = run(db, state.Migration.Down, func(tx *sqlx.Tx) error {
+			_, err := tx.Exec("DELETE FROM schema_version WHERE Version = ?", state.Version)
+			return err
+		})
+		if err != nil {
+			return rolledBack, fmt.Errorf("Rollback of migration %d (%s) failed: %s", state.Version, state.Name, err)
+		}
+		rolledBack = append(rolledBack, state.Migration)
+	}
+	return rolledBack, nil
+}
+
+// run - Run a migration script and record the result in a single transaction
+// MariaDB implicitly commits after most DDL statements, so only DML within a script is truly covered by the transaction
+func run(db *sqlx.DB, script string, record func(tx *sqlx.Tx) error) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, statement := range SplitStatements(script) {
+		if _, err = tx.Exec(statement); err != nil {
+			return err
+		}
+	}
+	if err = record(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// SplitStatements - Split a script into individual statements, honoring DELIMITER commands the same way the mariadb client does
+func SplitStatements(script string) []string {
+	delimiter := ";"
+	var statements []string
+	var current strings.Builder
+	for _, line := range strings.Split(script, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(strings.ToLower(trimmed), "delimiter ") {
+			delimiter = strings.TrimSpace(trimmed[len("delimiter "):])
+			continue
+		}
+		// Skip blank lines and comments between statements
+		if current.Len() == 0 && (len(trimmed) == 0 || strings.HasPrefix(trimmed, "--")) {
+			continue
+		}
+		current.WriteString(line)
+		current.WriteString("\n")
+
+		if strings.HasSuffix(trimmed, delimiter) {
+			statement := strings.TrimSpace(current.String())
+			statements = append(statements, strings.TrimSpace(strings.TrimSuffix(statement, delimiter)))
+			current.Reset()
+		}
+	}
+	if statement := strings.TrimSpace(current.String()); len(statement) > 0 {
+		statements = append(statements, statement)
+	}
+	return statements
+}
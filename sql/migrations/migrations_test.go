@@ -0,0 +1,50 @@
+package migrations
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAll(t *testing.T) {
+	migrations, err := All()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, m := range migrations {
+		if m.Version != uint(i+1) {
+			t.Errorf("Expected migration versions to be sequential, found %d at position %d", m.Version, i)
+		}
+		if len(m.Down) == 0 {
+			t.Errorf("Migration %d (%s) is missing a down script", m.Version, m.Name)
+		}
+	}
+	if Latest() != uint(len(migrations)) {
+		t.Errorf("Expected latest version %d, got %d", len(migrations), Latest())
+	}
+}
+
+func TestSplitStatements(t *testing.T) {
+	script := `-- Leading comment
+CREATE TABLE A (
+	ID int -- trailing comment
+);
+
+delimiter |
+CREATE TRIGGER B
+	AFTER INSERT ON A FOR EACH ROW
+	BEGIN
+		INSERT INTO C VALUES (NEW.ID);
+	END |
+delimiter ;
+DROP TABLE D;`
+	statements := SplitStatements(script)
+	if len(statements) != 3 {
+		t.Fatalf("Expected 3 statements, got %d: %q", len(statements), statements)
+	}
+	if !strings.HasSuffix(statements[1], "END") {
+		t.Errorf("Expected trigger body to be kept intact, got %q", statements[1])
+	}
+	if statements[2] != "DROP TABLE D" {
+		t.Errorf("Unexpected final statement %q", statements[2])
+	}
+}